@@ -0,0 +1,146 @@
+package datagrams
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Requirements are the properties a send needs from its datagram protocol. The
+// zero value requires nothing and selects the smallest protocol, Raw.
+type Requirements uint8
+
+const (
+	// RequireRepliable includes the sender's identity, so the receiver can reply.
+	RequireRepliable Requirements = 1 << iota
+
+	// RequireAuthenticated signs the datagram with the sender's destination.
+	// Authenticated datagrams are also repliable.
+	RequireAuthenticated
+
+	// RequireReplayProtection binds the signature to the receiving destination,
+	// so the datagram cannot be replayed to others. Replay-protected datagrams are
+	// also authenticated.
+	RequireReplayProtection
+
+	// RequireSmallest requires nothing beyond the smallest envelope.
+	RequireSmallest Requirements = 0
+)
+
+// String returns the requirements as a "|"-separated list, or "smallest".
+func (r Requirements) String() string {
+	if r == RequireSmallest {
+		return "smallest"
+	}
+	var names []string
+	for _, flag := range []struct {
+		req  Requirements
+		name string
+	}{
+		{RequireRepliable, "repliable"},
+		{RequireAuthenticated, "authenticated"},
+		{RequireReplayProtection, "replay-protected"},
+	} {
+		if r&flag.req != 0 {
+			names = append(names, flag.name)
+			r &^= flag.req
+		}
+	}
+	if r != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint8(r)))
+	}
+	return strings.Join(names, "|")
+}
+
+// SelectProtocol returns the cheapest protocol that satisfies req:
+//   - Raw (0 bytes) when nothing is required
+//   - Datagram3 (34 bytes) for repliable datagrams
+//   - Datagram1 (455 bytes for Ed25519) for authenticated datagrams
+//   - Datagram2 (457 bytes for Ed25519) for replay-protected datagrams, and for
+//     authenticated datagrams when offline is set, since Datagram1 cannot carry
+//     offline signatures
+func SelectProtocol(req Requirements, offline bool) uint8 {
+	switch {
+	case req&RequireReplayProtection != 0:
+		return ProtocolDatagram2
+	case req&RequireAuthenticated != 0:
+		if offline {
+			return ProtocolDatagram2
+		}
+		return ProtocolDatagram1
+	case req&RequireRepliable != 0:
+		return ProtocolDatagram3
+	default:
+		return ProtocolRaw
+	}
+}
+
+// NewAutoDatagramConn creates a DatagramConn in auto mode: it picks the protocol
+// of each send from its Requirements with SelectProtocol, and receives Raw,
+// Datagram1, Datagram2 and Datagram3 alike, parsing each datagram by the
+// protocol it arrived with (reported in ReceiveResult.Protocol).
+//
+// defaults are the Requirements of SendTo, SendToWithOptions and WriteTo, whose
+// protocol Protocol and MaxPayloadSize report. Use SendToWithRequirements for
+// other requirements.
+//
+// Sessions with offline keys, and connections signing with an OfflineSigner,
+// use Datagram2 for authenticated sends. HasSenderDestination reports false,
+// since Datagram3 senders are identified by hash only.
+//
+// Example:
+//
+//	conn, err := datagrams.NewAutoDatagramConn(session, 8080, datagrams.RequireRepliable)
+//	conn.SendTo(status, peer, 8080) // Datagram3
+//	conn.SendToWithRequirements(command, peer, 8080, datagrams.RequireReplayProtection) // Datagram2
+func NewAutoDatagramConn(session I2CPSession, localPort uint16, defaults Requirements) (*DatagramConn, error) {
+	if session == nil {
+		return nil, fmt.Errorf("session cannot be nil")
+	}
+	conn, err := NewDatagramConnWithProtocols(session, localPort, SelectProtocol(defaults, session.IsOffline()), autoProtocols...)
+	if err != nil {
+		return nil, err
+	}
+	conn.auto = true
+	conn.defaults = defaults
+	return conn, nil
+}
+
+// autoProtocols are the protocols auto-mode connections receive.
+var autoProtocols = []uint8{ProtocolRaw, ProtocolDatagram1, ProtocolDatagram2, ProtocolDatagram3}
+
+// IsAuto reports whether the connection was created by NewAutoDatagramConn.
+func (d *DatagramConn) IsAuto() bool {
+	return d.auto
+}
+
+// ProtocolFor returns the protocol this connection uses for sends with the given
+// requirements: SelectProtocol, accounting for offline keys of the session or
+// installed Signer.
+func (d *DatagramConn) ProtocolFor(req Requirements) uint8 {
+	return SelectProtocol(req, d.offline())
+}
+
+// offline reports whether authenticated sends must carry an offline signature.
+func (d *DatagramConn) offline() bool {
+	d.mu.RLock()
+	key := d.signingKey
+	d.mu.RUnlock()
+	if key != nil {
+		return key.offline != nil
+	}
+	return d.session.IsOffline()
+}
+
+// SendToWithRequirements sends a datagram as the cheapest protocol satisfying
+// req (see ProtocolFor). It is only available in auto mode, since receivers on a
+// fixed-protocol connection parse every datagram as their own protocol.
+//
+// Returns an error if the connection is not in auto mode, and otherwise under
+// the same conditions as [DatagramConn.SendTo].
+func (d *DatagramConn) SendToWithRequirements(payload []byte, destinationB64 string, port uint16, req Requirements) error {
+	if !d.auto {
+		return fmt.Errorf("requirements need an auto-mode connection (NewAutoDatagramConn)")
+	}
+	return d.Send(context.Background(), payload, &I2PAddr{Destination: destinationB64, Port: port}, &SendOptions{Protocol: d.ProtocolFor(req)})
+}
@@ -0,0 +1,128 @@
+package datagrams
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSelectProtocol tests that the cheapest satisfying protocol is selected.
+func TestSelectProtocol(t *testing.T) {
+	tests := []struct {
+		req     Requirements
+		offline bool
+		want    uint8
+	}{
+		{RequireSmallest, false, ProtocolRaw},
+		{RequireSmallest, true, ProtocolRaw},
+		{RequireRepliable, false, ProtocolDatagram3},
+		{RequireAuthenticated, false, ProtocolDatagram1},
+		{RequireAuthenticated | RequireRepliable, false, ProtocolDatagram1},
+		{RequireAuthenticated, true, ProtocolDatagram2},
+		{RequireReplayProtection, false, ProtocolDatagram2},
+		{RequireReplayProtection | RequireRepliable, true, ProtocolDatagram2},
+	}
+	for _, tt := range tests {
+		if got := SelectProtocol(tt.req, tt.offline); got != tt.want {
+			t.Errorf("SelectProtocol(%s, offline=%v) = %d, want %d", tt.req, tt.offline, got, tt.want)
+		}
+	}
+
+	if s := (RequireRepliable | RequireReplayProtection | 0x80).String(); s != "repliable|replay-protected|0x80" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+// autoPair returns a sender and a receiver in auto mode routed to each other,
+// with the receiver's session.
+func autoPair(t *testing.T, policy VerifyPolicy) (sender, receiver *DatagramConn, sr *mockSession) {
+	t.Helper()
+	sender, receiver, _, sr = dialPair(t,
+		func(session I2CPSession, port uint16) (*DatagramConn, error) {
+			return NewAutoDatagramConn(session, port, RequireRepliable)
+		},
+		func(session I2CPSession, port uint16) (*DatagramConn, error) {
+			return NewAutoDatagramConn(session, port, RequireSmallest)
+		})
+	if err := receiver.SetVerifyPolicy(policy); err != nil {
+		t.Fatalf("SetVerifyPolicy() failed: %v", err)
+	}
+	return sender, receiver, sr
+}
+
+// TestAutoDatagramConn tests per-send protocol selection and receiving every
+// protocol on one connection, with inline and parallel verification.
+func TestAutoDatagramConn(t *testing.T) {
+	for _, policy := range []VerifyPolicy{{Mode: VerifyInline}, {Mode: VerifyParallel, Workers: 2, BatchSize: 4}} {
+		t.Run(policy.Mode.String(), func(t *testing.T) {
+			sender, receiver, sr := autoPair(t, policy)
+			if !sender.IsAuto() || sender.Protocol() != ProtocolDatagram3 || sender.HasSenderDestination() {
+				t.Errorf("auto conn reports protocol %d, IsAuto %v, HasSenderDestination %v", sender.Protocol(), sender.IsAuto(), sender.HasSenderDestination())
+			}
+			if sender.MaxPayloadSize() != MaxI2NPSize-MinDatagram3Overhead {
+				t.Errorf("MaxPayloadSize() = %d, want the Datagram3 limit", sender.MaxPayloadSize())
+			}
+			dest := sr.Destination().Base64()
+
+			sends := []struct {
+				req           Requirements
+				protocol      uint8
+				authenticated bool
+			}{
+				{RequireRepliable, ProtocolDatagram3, false}, // the default, sent with SendTo
+				{RequireSmallest, ProtocolRaw, false},
+				{RequireAuthenticated, ProtocolDatagram1, true},
+				{RequireReplayProtection, ProtocolDatagram2, true},
+			}
+			for i, s := range sends {
+				var err error
+				if i == 0 {
+					err = sender.SendTo([]byte(s.req.String()), dest, pairPortB)
+				} else {
+					err = sender.SendToWithRequirements([]byte(s.req.String()), dest, pairPortB, s.req)
+				}
+				if err != nil {
+					t.Fatalf("send %s failed: %v", s.req, err)
+				}
+			}
+			// Parallel verification may reorder datagrams
+			received := make(map[string]*ReceiveResult)
+			for range sends {
+				result := receiveWithin(t, receiver, 5*time.Second)
+				received[string(result.Payload)] = result
+			}
+			for _, s := range sends {
+				result, ok := received[s.req.String()]
+				if !ok {
+					t.Errorf("%s datagram not received", s.req)
+				} else if result.Protocol != s.protocol || result.Authenticated != s.authenticated {
+					t.Errorf("%s datagram received as protocol %d (authenticated %v), want %d (%v)",
+						s.req, result.Protocol, result.Authenticated, s.protocol, s.authenticated)
+				}
+			}
+		})
+	}
+}
+
+// TestAutoDatagramConn_Offline tests that offline-key sessions authenticate
+// with Datagram2, and that fixed-protocol conns refuse per-send requirements.
+func TestAutoDatagramConn_Offline(t *testing.T) {
+	session := newMockSession()
+	session.offline = true
+	conn, err := NewAutoDatagramConn(session, 9200, RequireAuthenticated)
+	if err != nil {
+		t.Fatalf("NewAutoDatagramConn() failed: %v", err)
+	}
+	defer conn.Close()
+	if conn.Protocol() != ProtocolDatagram2 || conn.ProtocolFor(RequireAuthenticated) != ProtocolDatagram2 {
+		t.Errorf("offline session authenticates with protocol %d, want Datagram2", conn.Protocol())
+	}
+
+	fixed, err := NewDatagramConn(newMockSession(), 9201)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	defer fixed.Close()
+	if err := fixed.SendToWithRequirements([]byte("x"), validDestinationB64(), 1, RequireRepliable); err == nil {
+		t.Error("SendToWithRequirements() succeeded on a fixed-protocol conn")
+	}
+}
@@ -0,0 +1,396 @@
+package datagrams
+
+import (
+	"fmt"
+)
+
+// Envelope is a decoded Datagram1 (17), Datagram2 (19) or Datagram3 (20) envelope.
+//
+// The envelope codec works on bytes alone and does not need an I2CP session, so it can
+// be used by packet analyzers, SAM bridges and test vector generators. Decoding and
+// verification are separate steps: [UnmarshalEnvelope] only checks the structure, and
+// [Envelope.Verify] checks the signatures of authenticated envelopes.
+//
+// Wire formats (see SPEC.md):
+//
+//	Datagram1: from + signature + payload
+//	Datagram2: from + flags + [options] + [offline_signature] + payload + signature
+//	Datagram3: fromhash + flags + [options] + payload
+//
+// Example:
+//
+//	env, err := datagrams.UnmarshalEnvelope(datagrams.ProtocolDatagram2, data)
+//	if err != nil {
+//	    return err // malformed
+//	}
+//	if err := env.Verify(myDestHash); err != nil {
+//	    return err // forged, tampered, or addressed to someone else
+//	}
+//	fmt.Printf("%x sent %q\n", env.SenderHash, env.Payload)
+type Envelope struct {
+	// Protocol is the I2CP protocol number: ProtocolDatagram1, ProtocolDatagram2
+	// or ProtocolDatagram3.
+	Protocol uint8
+
+	// Flags is the 2-byte flags field (Datagram2 and Datagram3). Zero for Datagram1.
+	// The low 4 bits hold the version; see Version.
+	Flags uint16
+
+	// Sender is the sender destination in wire format (Datagram1 and Datagram2).
+	// Nil for Datagram3, which only carries SenderHash.
+	Sender []byte
+
+	// SenderHash is the SHA-256 hash of the sender destination. Carried directly by
+	// Datagram3 and computed from Sender for Datagram1 and Datagram2.
+	SenderHash [32]byte
+
+	// Options holds the I2P Mapping options (Datagram2 and Datagram3), or nil.
+	Options *Options
+
+	// OfflineSignature is the offline signature block authorizing a transient key
+	// (Datagram2 only), or nil.
+	OfflineSignature *OfflineSignature
+
+	// Signature is the envelope signature (Datagram1 and Datagram2).
+	Signature []byte
+
+	// Payload is the application data.
+	Payload []byte
+
+	// sender is Sender parsed from its wire format.
+	sender *wireDestination
+
+	// signedFields are the Datagram2 flags, options and offline signature bytes
+	// exactly as received, which are covered by the signature.
+	signedFields []byte
+}
+
+// SenderKey is the sender identity used to marshal authenticated envelopes:
+// a destination in wire format and the Signer holding its signing key, or a
+// transient key when the Signer is an OfflineSigner.
+type SenderKey struct {
+	key *envelopeKey
+}
+
+// NewSenderKey creates a SenderKey from a wire format destination and its Signer.
+// Returns an error if the destination is malformed or the Signer does not match it.
+func NewSenderKey(destination []byte, signer Signer) (*SenderKey, error) {
+	dest, n, err := parseWireDestination(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender destination: %w", err)
+	}
+	if n != len(destination) {
+		return nil, fmt.Errorf("invalid sender destination: %d trailing bytes", len(destination)-n)
+	}
+	key, err := newEnvelopeKey(dest, signer)
+	if err != nil {
+		return nil, err
+	}
+	return &SenderKey{key: key}, nil
+}
+
+// Hash returns the SHA-256 hash of the sender destination.
+func (k *SenderKey) Hash() [32]byte {
+	return k.key.dest.hash()
+}
+
+// UnmarshalEnvelope decodes an envelope of the given protocol without verifying it.
+// Call Verify before trusting the sender of a Datagram1 or Datagram2 envelope.
+// Options are parsed with the default OptionsLimits.
+//
+// The returned Envelope's byte slices alias data.
+func UnmarshalEnvelope(protocol uint8, data []byte) (*Envelope, error) {
+	switch protocol {
+	case ProtocolDatagram1:
+		return unmarshalDatagram1(data, parseWireDestination)
+	case ProtocolDatagram2:
+		return unmarshalDatagram2(data, parseWireDestination, OptionsLimits{})
+	case ProtocolDatagram3:
+		return unmarshalDatagram3(data, OptionsLimits{})
+	default:
+		return nil, fmt.Errorf("unsupported envelope protocol: %d", protocol)
+	}
+}
+
+// Version returns the envelope format version: 1, 2 or 3.
+func (e *Envelope) Version() uint8 {
+	if e.Protocol == ProtocolDatagram1 {
+		return 0x01
+	}
+	return uint8(e.Flags & 0x0F)
+}
+
+// IsAuthenticated reports whether the envelope carries a signature (Datagram1 and Datagram2).
+func (e *Envelope) IsAuthenticated() bool {
+	return e.Protocol == ProtocolDatagram1 || e.Protocol == ProtocolDatagram2
+}
+
+// SenderSigType returns the signature type of the sender destination.
+// Returns 0 for Datagram3, which does not carry the destination.
+func (e *Envelope) SenderSigType() uint16 {
+	if e.sender == nil {
+		return 0
+	}
+	return e.sender.sigType
+}
+
+// Verify checks the signature of an authenticated envelope.
+//
+// For Datagram2 the signature covers the hash of the intended recipient, so targetHash
+// must be the hash of the receiving destination; an envelope addressed to another
+// destination fails verification. If an offline signature is present, it must be
+// unexpired and signed by the sender destination. targetHash is ignored for Datagram1.
+//
+// Datagram3 envelopes are not signed and always return an error.
+func (e *Envelope) Verify(targetHash [32]byte) error {
+	checks, err := e.signatureChecks(targetHash)
+	if err != nil {
+		return err
+	}
+	for _, check := range checks {
+		if !check.verify() {
+			return check.failure
+		}
+	}
+	return nil
+}
+
+// signatureChecks performs the checks of Verify that need no signature verification
+// and returns the signatures still to verify, in the order Verify checks them.
+// Splitting the two lets DatagramConn verify signatures in batches.
+func (e *Envelope) signatureChecks(targetHash [32]byte) ([]signatureCheck, error) {
+	if e.IsAuthenticated() && e.sender == nil {
+		return nil, fmt.Errorf("envelope was not produced by UnmarshalEnvelope")
+	}
+
+	switch e.Protocol {
+	case ProtocolDatagram1:
+		// Per I2P spec: Ed25519 and ML-DSA sign the payload directly (not the hash)
+		return []signatureCheck{{
+			sigType:   e.sender.sigType,
+			publicKey: e.sender.signingPublicKey,
+			message:   e.Payload,
+			signature: e.Signature,
+			failure:   errDatagram1Signature,
+		}}, nil
+
+	case ProtocolDatagram2:
+		checks := make([]signatureCheck, 0, 2)
+		sigType, publicKey := e.sender.sigType, e.sender.signingPublicKey
+		if e.OfflineSignature != nil {
+			if e.OfflineSignature.IsExpired() {
+				return nil, fmt.Errorf("Datagram2 offline signature has expired (expired at %s)", e.OfflineSignature.Expires)
+			}
+			checks = append(checks, signatureCheck{
+				sigType:   sigType,
+				publicKey: publicKey,
+				message:   e.OfflineSignature.signedData(),
+				signature: e.OfflineSignature.Signature,
+				failure:   errDatagram2OfflineAuthorization,
+			})
+			sigType, publicKey = e.OfflineSignature.TransientSigType, e.OfflineSignature.TransientPublicKey
+		}
+		checks = append(checks, signatureCheck{
+			sigType:   sigType,
+			publicKey: publicKey,
+			message:   buildDatagram2VerifyData(targetHash, e.signedFields[:2], e.signedFields[2:], nil, e.Payload),
+			signature: e.Signature,
+			failure:   errDatagram2Signature,
+		})
+		return checks, nil
+
+	case ProtocolDatagram3:
+		return nil, fmt.Errorf("Datagram3 envelopes are not signed")
+
+	default:
+		return nil, fmt.Errorf("unsupported envelope protocol: %d", e.Protocol)
+	}
+}
+
+// Marshal encodes the envelope's Protocol, Payload and Options.
+//
+// Datagram1 and Datagram2 envelopes are signed with key, which also supplies the sender
+// destination and any offline signature; the Sender, Flags, OfflineSignature and
+// Signature fields are ignored. For Datagram2, targetHash is the hash of the recipient
+// destination. Datagram1 cannot carry options or offline signatures.
+//
+// Datagram3 envelopes are not signed: key may be nil, in which case SenderHash is used.
+func (e *Envelope) Marshal(key *SenderKey, targetHash [32]byte) ([]byte, error) {
+	switch e.Protocol {
+	case ProtocolDatagram1:
+		if key == nil {
+			return nil, fmt.Errorf("Datagram1 requires a sender key")
+		}
+		if e.Options != nil && !e.Options.IsEmpty() {
+			return nil, fmt.Errorf("Datagram1 does not support options")
+		}
+		return encodeDatagram1(e.Payload, key.key)
+
+	case ProtocolDatagram2:
+		if key == nil {
+			return nil, fmt.Errorf("Datagram2 requires a sender key")
+		}
+		return encodeDatagram2(e.Payload, key.key, targetHash, e.Options)
+
+	case ProtocolDatagram3:
+		fromHash := e.SenderHash
+		if key != nil {
+			fromHash = key.Hash()
+		}
+		return encodeDatagram3(e.Payload, fromHash, e.Options)
+
+	default:
+		return nil, fmt.Errorf("unsupported envelope protocol: %d", e.Protocol)
+	}
+}
+
+// destinationParser parses a destination at the start of data, returning it and the
+// number of bytes consumed. parseWireDestination and destinationCache.parse qualify.
+type destinationParser func(data []byte) (*wireDestination, int, error)
+
+// unmarshalDatagram1 decodes from + signature + payload.
+func unmarshalDatagram1(data []byte, parseDest destinationParser) (*Envelope, error) {
+	// Minimum size: Ed25519DestinationSize (391) + Ed25519SignatureLength (64) = 455 bytes
+	if len(data) < MinDatagram1Overhead {
+		return nil, fmt.Errorf("Datagram1 envelope too short: %d bytes (need at least %d)", len(data), MinDatagram1Overhead)
+	}
+
+	// Parse destination from the envelope; the consumed length depends on the sigtype
+	sender, destLen, err := parseDest(data)
+	if err != nil {
+		return nil, fmt.Errorf("Datagram1 failed to parse destination: %w", err)
+	}
+
+	// Check if there's enough data for signature + at least empty payload
+	sigLen := sender.signatureLength()
+	if len(data) < destLen+sigLen {
+		return nil, fmt.Errorf("Datagram1 envelope too short after destination: %d bytes remaining (need at least %d for signature)", len(data)-destLen, sigLen)
+	}
+
+	return &Envelope{
+		Protocol:   ProtocolDatagram1,
+		Sender:     data[:destLen],
+		SenderHash: sender.hash(),
+		Signature:  data[destLen : destLen+sigLen],
+		Payload:    data[destLen+sigLen:],
+		sender:     sender,
+	}, nil
+}
+
+// unmarshalDatagram2 decodes from + flags + [options] + [offline_sig] + payload + signature.
+// Options are parsed strictly within limits.
+func unmarshalDatagram2(data []byte, parseDest destinationParser, limits OptionsLimits) (*Envelope, error) {
+	if len(data) < MinDatagram2Overhead {
+		return nil, fmt.Errorf("Datagram2 envelope too short: %d bytes (need at least %d)", len(data), MinDatagram2Overhead)
+	}
+
+	// Parse destination from the envelope; the consumed length depends on the sigtype
+	sender, destLen, err := parseDest(data)
+	if err != nil {
+		return nil, fmt.Errorf("Datagram2 failed to parse destination: %w", err)
+	}
+
+	// The destination's signature length is a lower bound; an offline transient key
+	// may use a different sigtype, which is checked again once the block is parsed.
+	sigLen := sender.signatureLength()
+	if len(data) < destLen+2+sigLen {
+		return nil, fmt.Errorf("Datagram2 envelope too short after destination: have %d bytes remaining, need at least %d (flags: 2, signature: %d)", len(data)-destLen, 2+sigLen, sigLen)
+	}
+
+	// Extract and validate flags
+	flags := data[destLen : destLen+2]
+	hasOptions, hasOfflineSig, err := parseDatagram2Flags(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &Envelope{
+		Protocol:   ProtocolDatagram2,
+		Flags:      uint16(flags[0])<<8 | uint16(flags[1]),
+		Sender:     data[:destLen],
+		SenderHash: sender.hash(),
+		sender:     sender,
+	}
+	offset := destLen + 2
+
+	// Parse options if present
+	if hasOptions {
+		if len(data)-offset < 2 {
+			return nil, fmt.Errorf("Datagram2 envelope too short for options size field at offset %d: have %d bytes, need at least 2", offset, len(data)-offset)
+		}
+		opts, optLen, optErr := OptionsFromBytesStrict(data[offset:], limits)
+		if optErr != nil {
+			return nil, fmt.Errorf("Datagram2 failed to parse options: %w", optErr)
+		}
+		offset += optLen
+		env.Options = opts
+	}
+
+	// Parse offline signature if present; its authorization is checked by Verify
+	if hasOfflineSig {
+		offlineSig, offLen, offErr := OfflineSignatureFromBytes(data[offset:], sender.sigType)
+		if offErr != nil {
+			return nil, fmt.Errorf("Datagram2 failed to parse offline signature: %w", offErr)
+		}
+		offset += offLen
+		env.OfflineSignature = offlineSig
+		sigLen = signatureLengthForSigType(offlineSig.TransientSigType)
+	}
+
+	// Split payload and signature (signature is at end)
+	if len(data)-offset < sigLen {
+		return nil, fmt.Errorf("Datagram2 envelope too short for signature at offset %d: have %d bytes, need %d", offset, len(data)-offset, sigLen)
+	}
+	payloadEnd := len(data) - sigLen
+	env.signedFields = data[destLen:offset]
+	env.Payload = data[offset:payloadEnd]
+	env.Signature = data[payloadEnd:]
+	return env, nil
+}
+
+// unmarshalDatagram3 decodes fromhash + flags + [options] + payload.
+// Options are parsed strictly within limits.
+func unmarshalDatagram3(data []byte, limits OptionsLimits) (*Envelope, error) {
+	if len(data) < MinDatagram3Overhead {
+		return nil, fmt.Errorf("Datagram3 envelope too short: %d bytes, need at least %d", len(data), MinDatagram3Overhead)
+	}
+
+	env := &Envelope{Protocol: ProtocolDatagram3}
+	copy(env.SenderHash[:], data[0:32])
+
+	// Extract flags per I2P Datagram specification:
+	// Per spec: "flags :: (2 bytes) Bit order: 15 14 ... 3 2 1 0"
+	// - High byte (index 32): reserved, currently unused (bits 8-15)
+	// - Low byte (index 33): contains version (bits 0-3), options flag (bit 4), bits 5-7 reserved
+	// See: https://geti2p.net/spec/datagrams#datagram3
+	env.Flags = uint16(data[32])<<8 | uint16(data[33])
+
+	// Validate reserved bits (5-15) are zero per spec:
+	// "Bits 15-5: unused, set to 0 for compatibility with future uses"
+	reservedMask := uint16(0xFFE0)
+	if env.Flags&reservedMask != 0 {
+		return nil, fmt.Errorf("Datagram3 has non-zero reserved flag bits: 0x%04x (reserved bits: 0x%04x)", env.Flags, env.Flags&reservedMask)
+	}
+
+	if version := env.Version(); version != 0x03 {
+		return nil, fmt.Errorf("invalid Datagram3 version: 0x%x (expected 0x03)", version)
+	}
+
+	offset := 34
+
+	// Parse options if present (I2P Mapping format: 2-byte size + key=value; pairs)
+	if env.Flags&0x10 != 0 {
+		if len(data)-offset < 2 {
+			return nil, fmt.Errorf("Datagram3 envelope too short for options size field at offset %d: have %d bytes, need at least 2", offset, len(data)-offset)
+		}
+		opts, optLen, optErr := OptionsFromBytesStrict(data[offset:], limits)
+		if optErr != nil {
+			return nil, fmt.Errorf("Datagram3 failed to parse options: %w", optErr)
+		}
+		offset += optLen
+		env.Options = opts
+	}
+
+	env.Payload = data[offset:]
+	return env, nil
+}
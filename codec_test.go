@@ -0,0 +1,289 @@
+package datagrams
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/mldsa"
+	"testing"
+	"time"
+)
+
+// openEnvelope unmarshals and verifies an envelope the way the receive path does,
+// with targetHash the recipient's destination hash (ignored for Datagram1).
+func openEnvelope(protocol uint8, data []byte, targetHash [32]byte) (*Envelope, error) {
+	env, err := UnmarshalEnvelope(protocol, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.Verify(targetHash); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// newTestSenderKey creates a SenderKey for an Ed25519 destination with crypto type NONE,
+// built from bytes alone without an I2CP session.
+func newTestSenderKey(t *testing.T) (*SenderKey, []byte, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	dest, err := encodeWireDestination(SigTypeEd25519, cryptoTypeNone, nil, pub)
+	if err != nil {
+		t.Fatalf("encodeWireDestination() failed: %v", err)
+	}
+	key, err := NewSenderKey(dest, mustSigner(t, priv))
+	if err != nil {
+		t.Fatalf("NewSenderKey() failed: %v", err)
+	}
+	return key, dest, priv
+}
+
+// TestEnvelope_Datagram1RoundTrip tests marshaling, unmarshaling and verifying Datagram1.
+func TestEnvelope_Datagram1RoundTrip(t *testing.T) {
+	key, dest, _ := newTestSenderKey(t)
+
+	data, err := (&Envelope{Protocol: ProtocolDatagram1, Payload: []byte("hello")}).Marshal(key, [32]byte{})
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+
+	env, err := UnmarshalEnvelope(ProtocolDatagram1, data)
+	if err != nil {
+		t.Fatalf("UnmarshalEnvelope() failed: %v", err)
+	}
+	if env.Version() != 1 || !env.IsAuthenticated() {
+		t.Errorf("Version() = %d, IsAuthenticated() = %v", env.Version(), env.IsAuthenticated())
+	}
+	if !bytes.Equal(env.Sender, dest) {
+		t.Error("Sender does not match the marshaled destination")
+	}
+	if env.SenderHash != key.Hash() {
+		t.Error("SenderHash mismatch")
+	}
+	if env.SenderSigType() != SigTypeEd25519 {
+		t.Errorf("SenderSigType() = %d, want %d", env.SenderSigType(), SigTypeEd25519)
+	}
+	if len(env.Signature) != ed25519.SignatureSize {
+		t.Errorf("Signature length = %d, want %d", len(env.Signature), ed25519.SignatureSize)
+	}
+	if string(env.Payload) != "hello" {
+		t.Errorf("Payload = %q, want %q", env.Payload, "hello")
+	}
+	if err := env.Verify([32]byte{}); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+
+	// Unmarshal accepts a tampered envelope; only Verify rejects it
+	data[len(data)-1] ^= 0xFF
+	env, err = UnmarshalEnvelope(ProtocolDatagram1, data)
+	if err != nil {
+		t.Fatalf("UnmarshalEnvelope() of tampered envelope failed: %v", err)
+	}
+	if err := env.Verify([32]byte{}); err == nil {
+		t.Error("Verify() should fail for tampered payload")
+	}
+}
+
+// TestEnvelope_Datagram2RoundTrip tests Datagram2 with options, including recipient binding.
+func TestEnvelope_Datagram2RoundTrip(t *testing.T) {
+	key, _, _ := newTestSenderKey(t)
+	target := [32]byte{0xAA}
+	opts := NewOptions(map[string]string{"k": "v"})
+
+	in := &Envelope{Protocol: ProtocolDatagram2, Payload: []byte("payload"), Options: opts}
+	data, err := in.Marshal(key, target)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+
+	env, err := UnmarshalEnvelope(ProtocolDatagram2, data)
+	if err != nil {
+		t.Fatalf("UnmarshalEnvelope() failed: %v", err)
+	}
+	if env.Version() != 2 {
+		t.Errorf("Version() = %d, want 2", env.Version())
+	}
+	if env.Flags != 0x0012 {
+		t.Errorf("Flags = 0x%04x, want 0x0012", env.Flags)
+	}
+	if env.Options.Get("k") != "v" {
+		t.Errorf("Options[k] = %q, want %q", env.Options.Get("k"), "v")
+	}
+	if env.OfflineSignature != nil {
+		t.Error("unexpected offline signature")
+	}
+	if string(env.Payload) != "payload" {
+		t.Errorf("Payload = %q, want %q", env.Payload, "payload")
+	}
+	if err := env.Verify(target); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+	if err := env.Verify([32]byte{0xBB}); err == nil {
+		t.Error("Verify() should fail for another recipient")
+	}
+}
+
+// TestEnvelope_Datagram2Offline tests that Verify checks the offline authorization.
+func TestEnvelope_Datagram2Offline(t *testing.T) {
+	_, dest, destPriv := newTestSenderKey(t)
+	transient, _ := mldsa.GenerateKey(mldsa.MLDSA44())
+	offline := &OfflineSignature{
+		Expires:            time.Now().Add(time.Hour),
+		TransientSigType:   SigTypeMLDSA44,
+		TransientPublicKey: transient.PublicKey().Bytes(),
+	}
+	offline.Signature = ed25519.Sign(destPriv, offline.signedData())
+
+	offSigner, err := NewOfflineSigner(mustSigner(t, transient), offline)
+	if err != nil {
+		t.Fatalf("NewOfflineSigner() failed: %v", err)
+	}
+	key, err := NewSenderKey(dest, offSigner)
+	if err != nil {
+		t.Fatalf("NewSenderKey() failed: %v", err)
+	}
+
+	target := [32]byte{1}
+	data, err := (&Envelope{Protocol: ProtocolDatagram2, Payload: []byte("pq")}).Marshal(key, target)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	env, err := UnmarshalEnvelope(ProtocolDatagram2, data)
+	if err != nil {
+		t.Fatalf("UnmarshalEnvelope() failed: %v", err)
+	}
+	if env.OfflineSignature == nil || env.OfflineSignature.TransientSigType != SigTypeMLDSA44 {
+		t.Fatal("expected ML-DSA-44 offline signature")
+	}
+	if len(env.Signature) != mldsa.MLDSA44SignatureSize {
+		t.Errorf("Signature length = %d, want %d", len(env.Signature), mldsa.MLDSA44SignatureSize)
+	}
+	if err := env.Verify(target); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+
+	// Corrupting the authorization is caught by Verify, not Unmarshal
+	env.OfflineSignature.Signature[0] ^= 0xFF
+	if err := env.Verify(target); err == nil {
+		t.Error("Verify() should fail for an invalid offline authorization")
+	}
+
+	// Datagram1 cannot carry the offline block
+	if _, err := (&Envelope{Protocol: ProtocolDatagram1}).Marshal(key, target); err == nil {
+		t.Error("expected Datagram1 Marshal to reject an offline key")
+	}
+}
+
+// TestEnvelope_Datagram3RoundTrip tests the unsigned Datagram3 format.
+func TestEnvelope_Datagram3RoundTrip(t *testing.T) {
+	hash := [32]byte{1, 2, 3}
+	in := &Envelope{
+		Protocol:   ProtocolDatagram3,
+		SenderHash: hash,
+		Options:    NewOptions(map[string]string{"a": "b"}),
+		Payload:    []byte("light"),
+	}
+	data, err := in.Marshal(nil, [32]byte{})
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+
+	env, err := UnmarshalEnvelope(ProtocolDatagram3, data)
+	if err != nil {
+		t.Fatalf("UnmarshalEnvelope() failed: %v", err)
+	}
+	if env.SenderHash != hash || env.Sender != nil {
+		t.Error("expected hash-only sender")
+	}
+	if env.Version() != 3 || env.IsAuthenticated() {
+		t.Errorf("Version() = %d, IsAuthenticated() = %v", env.Version(), env.IsAuthenticated())
+	}
+	if env.Options.Get("a") != "b" || string(env.Payload) != "light" {
+		t.Errorf("unexpected contents: options=%v payload=%q", env.Options.ToMap(), env.Payload)
+	}
+	if err := env.Verify([32]byte{}); err == nil {
+		t.Error("Verify() should fail for unsigned Datagram3")
+	}
+
+	// A sender key overrides SenderHash
+	key, _, _ := newTestSenderKey(t)
+	data, _ = in.Marshal(key, [32]byte{})
+	if env, _ := UnmarshalEnvelope(ProtocolDatagram3, data); env.SenderHash != key.Hash() {
+		t.Error("expected SenderHash from sender key")
+	}
+}
+
+// TestEnvelope_Errors tests malformed input and invalid marshal requests.
+func TestEnvelope_Errors(t *testing.T) {
+	key, dest, _ := newTestSenderKey(t)
+
+	if _, err := UnmarshalEnvelope(ProtocolRaw, []byte("raw")); err == nil {
+		t.Error("expected error for Raw protocol")
+	}
+	for _, protocol := range []uint8{ProtocolDatagram1, ProtocolDatagram2, ProtocolDatagram3} {
+		if _, err := UnmarshalEnvelope(protocol, make([]byte, 10)); err == nil {
+			t.Errorf("expected error for short protocol %d envelope", protocol)
+		}
+	}
+
+	withOpts := &Envelope{Protocol: ProtocolDatagram1, Options: NewOptions(map[string]string{"k": "v"})}
+	if _, err := withOpts.Marshal(key, [32]byte{}); err == nil {
+		t.Error("expected error for Datagram1 with options")
+	}
+	if _, err := (&Envelope{Protocol: ProtocolDatagram2}).Marshal(nil, [32]byte{}); err == nil {
+		t.Error("expected error for Datagram2 without key")
+	}
+
+	if err := (&Envelope{Protocol: ProtocolDatagram1}).Verify([32]byte{}); err == nil {
+		t.Error("expected error verifying an envelope that was not unmarshaled")
+	}
+
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	if _, err := NewSenderKey(dest, mustSigner(t, otherPriv)); err == nil {
+		t.Error("expected error for signer not matching destination")
+	}
+	if _, err := NewSenderKey(append(dest, 0), mustSigner(t, otherPriv)); err == nil {
+		t.Error("expected error for trailing destination bytes")
+	}
+}
+
+// TestEnvelope_SessionInterop tests that codec envelopes are accepted by DatagramConn.
+func TestEnvelope_SessionInterop(t *testing.T) {
+	sender := newMockSession()
+	receiver := newMockSession()
+
+	senderDest, err := localWireDestination(sender.Destination())
+	if err != nil {
+		t.Fatalf("localWireDestination() failed: %v", err)
+	}
+	keyPair, _ := sender.SigningKeyPair()
+	key, err := NewSenderKey(senderDest.raw, mustSigner(t, keyPair.PrivateKey()))
+	if err != nil {
+		t.Fatalf("NewSenderKey() failed: %v", err)
+	}
+
+	data, err := (&Envelope{Protocol: ProtocolDatagram2, Payload: []byte("interop")}).Marshal(key, targetHashOf(t, receiver))
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+
+	conn, err := NewDatagramConnWithProtocol(receiver, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+	conn.injectMessage(data, nil, ProtocolDatagram2, 9000, 8080)
+
+	payload, from, _, err := conn.ReceiveFrom()
+	if err != nil {
+		t.Fatalf("ReceiveFrom() failed: %v", err)
+	}
+	if string(payload) != "interop" {
+		t.Errorf("payload = %q, want %q", payload, "interop")
+	}
+	if from == nil || from.Base64() != sender.Destination().Base64() {
+		t.Error("sender destination mismatch")
+	}
+}
@@ -36,7 +36,8 @@ type I2CPSession interface {
 	SendMessageWithContext(ctx context.Context, destination *i2cp.Destination, protocol uint8, srcPort, destPort uint16, payload *i2cp.Stream, nonce uint32) error
 
 	// SigningKeyPair returns the Ed25519 signing key pair for this session.
-	// This is used to sign authenticated datagrams (Datagram1, Datagram2).
+	// This is used to sign authenticated datagrams (Datagram1, Datagram2) unless
+	// a Signer is installed with DatagramConn.SetSigner.
 	// Returns error if no signing key pair is available.
 	SigningKeyPair() (*i2cp.Ed25519KeyPair, error)
 }
@@ -93,7 +94,7 @@ const (
 	OptimalMaxSize = 4 * 1024 // 4 KB
 
 	// Ed25519SignatureLength is the fixed signature length for Ed25519 (64 bytes).
-	// go-i2cp exclusively uses Ed25519, so this is constant.
+	// Other sigtypes have their own lengths; ML-DSA signatures are 2420-4627 bytes.
 	Ed25519SignatureLength = 64
 
 	// Ed25519DestinationSize is the wire format size for an Ed25519 destination.
@@ -108,7 +109,8 @@ const (
 	// destination(391) + signature(64) = 455 bytes
 	//
 	// This is the minimum because the destination size can vary with certificate type.
-	// For go-i2cp's Ed25519-only destinations, this is also the exact overhead.
+	// ML-DSA destinations and signatures raise the overhead to 3739-7226 bytes;
+	// MaxPayloadSize computes the exact overhead from the local key types.
 	MinDatagram1Overhead = Ed25519DestinationSize + Ed25519SignatureLength // 455
 
 	// MinDatagram2Overhead is the minimum envelope overhead for Datagram2 with Ed25519.
@@ -117,6 +119,7 @@ const (
 	// Actual overhead may be larger when:
 	// - Options field is present (adds 2+ bytes for mapping)
 	// - Offline signature is present (adds ~102 bytes for Ed25519)
+	// - The destination or transient key uses ML-DSA (adds 3.3-6.8 KB)
 	MinDatagram2Overhead = Ed25519DestinationSize + 2 + Ed25519SignatureLength // 457
 
 	// MinDatagram3Overhead is the minimum envelope overhead for Datagram3.
@@ -153,6 +156,10 @@ type DatagramConn struct {
 	// This is our identity in the I2P network and is included in authenticated datagrams.
 	localDest *i2cp.Destination
 
+	// localWire is localDest parsed from its wire format. Its length and sigtype
+	// determine the exact overhead of authenticated envelopes we send.
+	localWire *wireDestination
+
 	// localPort is the UDP port number this connection is bound to.
 	// Used for source port in outgoing packets and filtering incoming packets.
 	localPort uint16
@@ -161,6 +168,17 @@ type DatagramConn struct {
 	// Determines packet format and overhead. Default is ProtocolRaw (18).
 	protocol uint8
 
+	// protocols are the protocols received datagrams may use, each parsed as its
+	// own. Contains protocol; immutable after construction.
+	protocols []uint8
+
+	// auto is set on connections created by NewAutoDatagramConn, which choose the
+	// protocol of each send from its Requirements. Immutable after construction.
+	auto bool
+
+	// defaults are the Requirements of sends without their own in auto mode.
+	defaults Requirements
+
 	// mu protects concurrent access to handlers and closed state.
 	// RWMutex allows multiple concurrent readers (receives) with exclusive writers (register/close).
 	mu sync.RWMutex
@@ -168,7 +186,7 @@ type DatagramConn struct {
 	// handlers maps destination ports to callback functions for incoming messages.
 	// Enables port-based routing within a single I2CP session.
 	// Protected by mu for thread-safe registration/unregistration.
-	handlers map[uint16]func([]byte, *i2cp.Destination)
+	handlers map[uint16]func(*ReceiveResult)
 
 	// closed tracks whether Close() has been called.
 	// Once closed, all operations return net.ErrClosed.
@@ -201,6 +219,52 @@ type DatagramConn struct {
 	// Messages are placed here by I2CP callbacks (Phase 3) or test injection.
 	// ReceiveFrom() blocks on this channel.
 	recvQueue chan *receivedDatagram
+
+	// signingKey signs authenticated envelopes when a Signer is installed with
+	// SetSigner. Nil means the session's signing key pair is used; protected by mu.
+	signingKey *envelopeKey
+
+	// replayCache rejects replayed Datagram1/Datagram2 envelopes when set.
+	// Nil by default; protected by mu.
+	replayCache *ReplayCache
+
+	// codecs supplies EnvelopeCodecs for application-defined protocol numbers.
+	// DefaultEnvelopeCodecRegistry unless replaced; protected by mu.
+	codecs *EnvelopeCodecRegistry
+
+	// verifyPolicy selects inline, parallel or no signature verification.
+	// Protected by mu.
+	verifyPolicy VerifyPolicy
+
+	// verifyQueue feeds the VerifyParallel workers, which forward verified
+	// datagrams to recvQueue. verifyCancel stops the current workers.
+	verifyQueue  chan *receivedDatagram
+	verifyCancel context.CancelFunc
+
+	// destCache caches parsed sender destinations; nil disables it. Protected by mu.
+	destCache *destinationCache
+
+	// optionsLimits bounds Datagram2 and Datagram3 options sent and received.
+	// Defaults filled in; protected by mu.
+	optionsLimits OptionsLimits
+
+	// pacer rate-limits sends when set with SetPacer. Nil by default; protected by mu.
+	pacer *Pacer
+
+	// pings matches echo replies to pending Pings once the first Ping registers
+	// the reply port; protected by mu. pingMu serializes that registration.
+	pings  *pingRegistry
+	pingMu sync.Mutex
+
+	// deliveries holds the pending Deliveries of SendTracked.
+	deliveries deliveryTracker
+
+	// sendQueue backs SendAsync once SetSendQueue or the first SendAsync creates
+	// it; protected by mu.
+	sendQueue *sendQueue
+
+	// verifyCounters backs VerifyStats.
+	verifyCounters verifyCounters
 }
 
 // receivedDatagram represents an incoming datagram with metadata.
@@ -211,17 +275,40 @@ type receivedDatagram struct {
 	protocol uint8
 	srcPort  uint16
 	destPort uint16
+
+	// receivedAt is when the datagram was queued for receive.
+	receivedAt time.Time
+
+	// processed is set by VerifyParallel workers, which run the receive pipeline
+	// ahead of time and store its outcome in result and err.
+	processed bool
+	result    *ReceiveResult
+	err       error
+}
+
+// arrivalTime returns when the datagram was queued, or now for datagrams built
+// without a timestamp.
+func (m *receivedDatagram) arrivalTime() time.Time {
+	if m.receivedAt.IsZero() {
+		return time.Now()
+	}
+	return m.receivedAt
 }
 
 // ReceiveResult contains the complete result of receiving a datagram,
 // including optional fields like protocol-specific options.
 //
-// This struct is returned by [DatagramConn.ReceiveFromWithOptions] to provide
-// access to all datagram information including the options field which is
-// supported by Datagram2 (protocol 19) and Datagram3 (protocol 20).
+// ReceiveResult is the canonical form of a received datagram. Every receive path
+// decodes datagrams through the same pipeline; [DatagramConn.ReceiveFromWithOptions]
+// returns it whole, while ReceiveFrom, ReceiveFromWithAddr, ReadFrom and port
+// handlers return views of it.
 //
 // For protocols that don't support options (Raw, Datagram1), the Options
 // field will be nil.
+//
+// Access control should check Authenticated before trusting the sender fields:
+// Datagram3 sender hashes and Raw sender metadata can be spoofed, and Datagram1/
+// Datagram2 senders are only verified under VerifyInline and VerifyParallel.
 type ReceiveResult struct {
 	// Payload is the application data extracted from the datagram.
 	Payload []byte
@@ -229,6 +316,8 @@ type ReceiveResult struct {
 	// From is the sender's full I2P destination for authenticated protocols.
 	// For Datagram3 (protocol 20), this is nil because only the sender's hash
 	// is available. Use FromHash or FromAddr.DestinationHash instead.
+	// It is also nil for senders whose sigtype go-i2cp cannot represent (such as
+	// ML-DSA); FromAddr still carries their Base64 destination and hash.
 	From *i2cp.Destination
 
 	// FromHash is the SHA-256 hash of the sender's destination.
@@ -236,6 +325,7 @@ type ReceiveResult struct {
 	FromHash [32]byte
 
 	// FromAddr is the sender address as an I2PAddr for net.Addr compatibility.
+	// It is never nil; for Raw datagrams without sender metadata only Port is set.
 	FromAddr *I2PAddr
 
 	// SrcPort is the source port from the datagram header.
@@ -246,6 +336,27 @@ type ReceiveResult struct {
 	// This is nil for protocols that don't support options or when no options
 	// were included in the received datagram.
 	Options *Options
+
+	// Protocol is the I2CP protocol number the datagram was decoded as.
+	Protocol uint8
+
+	// Authenticated reports whether the sender's signature was verified. It is true
+	// only for Datagram1 and Datagram2 received under VerifyInline or VerifyParallel;
+	// under VerifyNone the sender fields are taken from the envelope unchecked.
+	Authenticated bool
+
+	// SigType is the signature type of the key that signed the datagram: the
+	// transient key's when OfflineSignature is set, otherwise the sender
+	// destination's. Zero for unsigned protocols (Raw, Datagram3, custom codecs).
+	SigType uint16
+
+	// OfflineSignature is the Datagram2 offline signature block through which the
+	// sender's destination authorized the signing key, or nil. Its Expires field
+	// is when that authorization ends.
+	OfflineSignature *OfflineSignature
+
+	// ReceivedAt is when the datagram arrived at the connection.
+	ReceivedAt time.Time
 }
 
 // NewDatagramConn creates a new DatagramConn bound to the specified local port.
@@ -281,6 +392,13 @@ func NewDatagramConn(session I2CPSession, localPort uint16) (*DatagramConn, erro
 //   - ProtocolDatagram1 (17): Repliable, authenticated, ~455 bytes overhead (Ed25519)
 //   - ProtocolDatagram2 (19): Repliable, authenticated with replay prevention, ~457+ bytes overhead (Ed25519)
 //   - ProtocolDatagram3 (20): Repliable, non-authenticated, ~34 bytes overhead
+//   - Any other number except 6, framed by the EnvelopeCodec registered for it
+//     (see EnvelopeCodecRegistry). The codec is looked up on each send and receive,
+//     so it may be registered after the connection is created.
+//
+// The connection receives only this protocol: datagrams that arrive with another
+// protocol number fail to receive rather than being misparsed. Use
+// NewDatagramConnWithProtocols to receive several.
 //
 // Protocol selection guide:
 //   - Use Raw for high-performance, trusted communication
@@ -314,19 +432,31 @@ func NewDatagramConnWithProtocol(session I2CPSession, localPort uint16, protocol
 		return nil, fmt.Errorf("session has no destination")
 	}
 
+	localWire, err := localWireDestination(localDest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session destination: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	conn := &DatagramConn{
 		session:            session,
 		localDest:          localDest,
+		localWire:          localWire,
 		localPort:          localPort,
 		protocol:           protocol,
-		handlers:           make(map[uint16]func([]byte, *i2cp.Destination)),
+		protocols:          []uint8{protocol},
+		handlers:           make(map[uint16]func(*ReceiveResult)),
 		closed:             false,
 		ctx:                ctx,
 		cancel:             cancel,
 		recvQueue:          make(chan *receivedDatagram, 100), // Buffer 100 datagrams
 		receiveLoopStarted: false,
+		codecs:             DefaultEnvelopeCodecRegistry,
+		verifyPolicy:       VerifyPolicy{Mode: VerifyInline},
+		verifyQueue:        make(chan *receivedDatagram, 100),
+		destCache:          newDestinationCache(DefaultDestinationCacheSize),
+		optionsLimits:      OptionsLimits{}.withDefaults(),
 	}
 
 	return conn, nil
@@ -344,8 +474,13 @@ func NewDatagramConnWithProtocol(session I2CPSession, localPort uint16, protocol
 // return net.ErrClosed. Close() is idempotent - calling it multiple times
 // is safe and only the first call has effect.
 //
+// Datagrams queued with SendAsync are sent before the connection closes; use
+// Shutdown to bound that wait.
+//
 // Close() is safe to call concurrently with other operations.
 func (d *DatagramConn) Close() error {
+	d.flushSendQueue(context.Background())
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -355,9 +490,11 @@ func (d *DatagramConn) Close() error {
 
 	d.closed = true
 	d.cancel() // Cancel context to stop receive loop
+	d.deliveries.close()
+	sendQueue := d.sendQueue
 
 	// Clear handlers to help GC
-	d.handlers = make(map[uint16]func([]byte, *i2cp.Destination))
+	d.handlers = make(map[uint16]func(*ReceiveResult))
 
 	// Must release lock here: handler goroutines and receiveLoop may need RLock,
 	// and wg.Wait() blocks until they complete. This is safe because d.closed is
@@ -365,6 +502,11 @@ func (d *DatagramConn) Close() error {
 	// and return early. Re-acquire after Wait() to satisfy the deferred Unlock() above.
 	d.mu.Unlock()
 
+	// Stop the send workers of a queue created while the flush above ran
+	if sendQueue != nil {
+		sendQueue.flush(context.Background())
+	}
+
 	// Wait for receiveLoop and all handler goroutines to complete (graceful shutdown)
 	// This ensures no goroutines are accessing recvQueue when we close it
 	d.wg.Wait()
@@ -460,8 +602,12 @@ func (d *DatagramConn) SetWriteDeadline(t time.Time) error {
 }
 
 // Protocol returns the I2P datagram protocol type (17, 18, 19, or 20).
-// This allows inspecting which protocol the connection is using.
+// This allows inspecting which protocol the connection is using. In auto mode it
+// is the protocol of sends with the connection's default Requirements.
 func (d *DatagramConn) Protocol() uint8 {
+	if d.auto {
+		return d.ProtocolFor(d.defaults)
+	}
 	return d.protocol
 }
 
@@ -471,7 +617,9 @@ func (d *DatagramConn) Protocol() uint8 {
 // the sender's 32-byte hash, not the full destination. In this case, applications should
 // use ReceiveFromWithAddr() instead to get the sender's hash via I2PAddr.DestinationHash.
 //
-// For all other protocols (Raw, Datagram1, Datagram2), this returns true.
+// For all other protocols (Raw, Datagram1, Datagram2), this returns true. Connections
+// that receive several protocols (NewDatagramConnWithProtocols, NewAutoDatagramConn)
+// return false if Datagram3 is among them.
 //
 // Example usage:
 //
@@ -483,7 +631,7 @@ func (d *DatagramConn) Protocol() uint8 {
 //	    // Use addr.DestinationHash to identify the sender
 //	}
 func (d *DatagramConn) HasSenderDestination() bool {
-	return d.protocol != ProtocolDatagram3
+	return !d.AcceptsProtocol(ProtocolDatagram3)
 }
 
 // Session returns the underlying I2CP session.
@@ -492,6 +640,89 @@ func (d *DatagramConn) Session() I2CPSession {
 	return d.session
 }
 
+// SetSigner installs the Signer used for authenticated datagrams (Datagram1, Datagram2),
+// so the destination's private key does not have to be held by the I2CP session.
+// Pass nil to sign with the session's SigningKeyPair again (the default).
+//
+// The Signer must hold the signing key of the session's destination. An OfflineSigner
+// holds a transient key instead, and its offline signature must be signed by the
+// destination and unexpired. Offline signers enable Datagram2 sending for offline
+// sessions; Datagram1 sends fail because Datagram1 cannot carry offline signatures.
+// Once the offline signature expires, Datagram2 sends fail with
+// ErrOfflineSignatureExpired until a new OfflineSigner is installed.
+//
+// Returns an error if the Signer does not match the session's destination, or
+// ErrOfflineSignatureExpired if its offline signature has already expired.
+func (d *DatagramConn) SetSigner(signer Signer) error {
+	var key *envelopeKey
+	if signer != nil {
+		var err error
+		if key, err = newEnvelopeKey(d.localWire, signer); err != nil {
+			return fmt.Errorf("invalid signer: %w", err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.signingKey = key
+	return nil
+}
+
+// SetReplayCache installs a replay cache for authenticated datagrams.
+// Once set, Datagram1 and Datagram2 envelopes that were already received within
+// the cache window are rejected with ErrReplayedDatagram after signature
+// verification. Pass nil to disable replay detection (the default).
+//
+// The same cache may be shared by several connections to track replays across them.
+//
+// Example:
+//
+//	conn.SetReplayCache(datagrams.NewReplayCache(datagrams.ReplayCacheConfig{
+//	    Window: 10 * time.Minute,
+//	}))
+func (d *DatagramConn) SetReplayCache(cache *ReplayCache) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.replayCache = cache
+}
+
+// SetEnvelopeCodecRegistry replaces the registry consulted for application-defined
+// protocol numbers. A nil registry restores DefaultEnvelopeCodecRegistry.
+func (d *DatagramConn) SetEnvelopeCodecRegistry(registry *EnvelopeCodecRegistry) {
+	if registry == nil {
+		registry = DefaultEnvelopeCodecRegistry
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.codecs = registry
+}
+
+// SetOptionsLimits bounds the Datagram2 and Datagram3 options this connection
+// accepts and sends. Received envelopes whose options exceed the limits fail to
+// receive with ErrInvalidOptions, and sends with such options fail before anything
+// is sent, so peers sharing the same limits accept everything the other sends.
+// Zero fields use DefaultMaxOptionsEntries and DefaultMaxOptionsSize.
+func (d *DatagramConn) SetOptionsLimits(limits OptionsLimits) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.optionsLimits = limits.withDefaults()
+}
+
+// OptionsLimits returns the connection's options limits, with defaults filled in.
+func (d *DatagramConn) OptionsLimits() OptionsLimits {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.optionsLimits
+}
+
+// envelopeCodec returns the codec registered for an application-defined protocol.
+func (d *DatagramConn) envelopeCodec(protocol uint8) (EnvelopeCodec, bool) {
+	d.mu.RLock()
+	codecs := d.codecs
+	d.mu.RUnlock()
+	return codecs.Lookup(protocol)
+}
+
 // MaxPayloadSize returns the maximum payload size for this connection's protocol type.
 // This accounts for protocol-specific overhead in the I2NP message.
 //
@@ -500,26 +731,40 @@ func (d *DatagramConn) Session() I2CPSession {
 //   - Datagram3 (20): 64KB - 34 bytes (fromhash + flags)
 //   - Datagram1 (17): 64KB - 455 bytes (dest(391) + signature(64))
 //   - Datagram2 (19): 64KB - 457 bytes (dest(391) + flags(2) + signature(64))
+//   - Custom protocols: 64KB - the registered EnvelopeCodec's Overhead
 //
-// Note: The I2P spec uses "387+" for destination size (serialized format) and "40+"
-// for signature (DSA_SHA1). This library uses Ed25519 exclusively, so:
-//   - Destination wire format: 391 bytes (pubKey(256) + signingPubKey(128) + cert(7))
-//   - Signature: 64 bytes (Ed25519)
+// For Datagram1 and Datagram2 the overhead is computed from the local destination's
+// actual wire size and signature type rather than the Ed25519 constants, so the limit
+// stays exact for larger destinations such as ML-DSA (3739-7226 bytes of overhead).
 //
 // For reliable delivery, limit payloads to RecommendedMaxSize (~10KB) or less due to
 // I2NP fragmentation into 1KB tunnel messages. Drop probability increases exponentially
 // with message size.
 func (d *DatagramConn) MaxPayloadSize() int {
-	switch d.protocol {
+	return d.maxPayloadSize(d.Protocol())
+}
+
+// maxPayloadSize returns the largest payload this connection can send as protocol.
+func (d *DatagramConn) maxPayloadSize(protocol uint8) int {
+	switch protocol {
 	case ProtocolRaw:
 		return MaxI2NPSize // No overhead for raw datagrams
 	case ProtocolDatagram3:
 		return MaxI2NPSize - MinDatagram3Overhead // fromhash(32) + flags(2) = 34
 	case ProtocolDatagram1:
-		return MaxI2NPSize - MinDatagram1Overhead // dest(391) + signature(64) = 455
+		return MaxI2NPSize - datagram1Overhead(d.localWire) // dest + signature (455 for Ed25519)
 	case ProtocolDatagram2:
-		return MaxI2NPSize - MinDatagram2Overhead // dest(391) + flags(2) + signature(64) = 457
+		d.mu.RLock()
+		key := d.signingKey
+		d.mu.RUnlock()
+		if key != nil {
+			return MaxI2NPSize - datagram2Overhead(d.localWire, key.offline) // plus offline block when signing with a transient key
+		}
+		return MaxI2NPSize - datagram2Overhead(d.localWire, nil) // dest + flags + signature (457 for Ed25519)
 	default:
+		if codec, ok := d.envelopeCodec(protocol); ok {
+			return MaxI2NPSize - codec.Overhead()
+		}
 		return MaxI2NPSize // Conservative fallback
 	}
 }
@@ -535,127 +780,25 @@ func (d *DatagramConn) MaxPayloadSize() int {
 //
 // The destination parameter should be a valid I2P destination string (base64 encoded).
 // The port parameter is used for application-level routing within I2P.
+// SendTo is [DatagramConn.Send] without SendOptions; use Send for a context or
+// per-message settings.
 //
 // Returns an error if:
 //   - The connection is closed
 //   - The payload exceeds MaxPayloadSize()
-//   - The write deadline has expired
+//   - The write deadline has expired, including while waiting for the Pacer
+//   - The Pacer rejects the send (ErrRateLimited)
 //   - The underlying I2CP session fails to send
 func (d *DatagramConn) SendTo(payload []byte, destinationB64 string, port uint16) error {
-	d.mu.RLock()
-	closed := d.closed
-	deadline := d.writeDeadline
-	protocol := d.protocol
-	session := d.session
-	localPort := d.localPort
-	d.mu.RUnlock()
-
-	if closed {
-		return net.ErrClosed
-	}
-
-	// Validate payload size
-	maxSize := d.MaxPayloadSize()
-	if len(payload) > maxSize {
-		return fmt.Errorf("payload size %d exceeds maximum %d for protocol %d", len(payload), maxSize, protocol)
-	}
-
-	// Check write deadline
-	if !deadline.IsZero() && time.Now().After(deadline) {
-		return fmt.Errorf("write deadline exceeded")
-	}
-
-	// Parse destination from base64 string
-	// Note: go-i2cp NewDestinationFromBase64 requires a Crypto object
-	// For now, we create a default one. In production, this should be
-	// passed from the session or connection config.
-	crypto := i2cp.NewCrypto()
-	dest, err := i2cp.NewDestinationFromBase64(destinationB64, crypto)
-	if err != nil {
-		return fmt.Errorf("invalid destination: %w", err)
-	}
-
-	// Construct protocol-specific envelope
-	var envelope []byte
-	switch protocol {
-	case ProtocolRaw:
-		// Raw datagrams have no envelope, send payload directly
-		envelope = payload
-
-	case ProtocolDatagram3:
-		// Datagram3: fromhash(32) + flags(2) + payload
-		localDest := session.Destination()
-
-		// Compute SHA-256 hash of the local destination
-		fromHash, err := destinationHash(localDest)
-		if err != nil {
-			return fmt.Errorf("failed to compute destination hash: %w", err)
-		}
-
-		envelope = make([]byte, 32+2+len(payload))
-		copy(envelope[0:32], fromHash[:])
-
-		// flags: version 0x03 (bits 3-0), no options (bit 4 = 0)
-		envelope[32] = 0x00
-		envelope[33] = 0x03
-
-		copy(envelope[34:], payload)
-
-	case ProtocolDatagram1:
-		// Datagram1: from dest(387+) + signature(40+) + payload
-		var err error
-		envelope, err = buildDatagram1Envelope(payload, session)
-		if err != nil {
-			return fmt.Errorf("failed to build Datagram1 envelope: %w", err)
-		}
-
-	case ProtocolDatagram2:
-		// Datagram2: from dest(387+) + flags(2) + options(optional) + offline_sig(optional) + payload + signature(40+)
-		// Compute target destination hash (needed for replay prevention)
-		targetDestHash, err := destinationHash(dest)
-		if err != nil {
-			return fmt.Errorf("failed to compute target destination hash: %w", err)
-		}
-
-		envelope, err = buildDatagram2Envelope(payload, session, targetDestHash)
-		if err != nil {
-			return fmt.Errorf("failed to build Datagram2 envelope: %w", err)
-		}
-
-	default:
-		return fmt.Errorf("unsupported protocol: %d", protocol)
-	}
-
-	// Send via I2CP
-	// Note: I2CP NewStream takes a byte slice
-	stream := i2cp.NewStream(envelope)
-
-	// Use context with timeout if write deadline is set
-	if !deadline.IsZero() {
-		timeout := time.Until(deadline)
-		if timeout <= 0 {
-			return fmt.Errorf("write deadline exceeded")
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-
-		err = session.SendMessageWithContext(ctx, dest, protocol, localPort, port, stream, 0)
-	} else {
-		err = session.SendMessage(dest, protocol, localPort, port, stream, 0)
-	}
-
-	if err != nil {
-		return fmt.Errorf("failed to send datagram: %w", err)
-	}
-
-	return nil
+	return d.Send(context.Background(), payload, &I2PAddr{Destination: destinationB64, Port: port}, nil)
 }
 
 // SendToWithOptions sends a datagram with optional I2P Mapping options.
 //
 // This method extends [DatagramConn.SendTo] by allowing the inclusion of options
 // in the datagram envelope. Options are only supported by Datagram2 (protocol 19)
-// and Datagram3 (protocol 20). For other protocols, the options parameter is ignored.
+// and Datagram3 (protocol 20), and by custom protocols whose EnvelopeCodec carries
+// them. For other protocols, the options parameter is ignored.
 //
 // Options can contain arbitrary key/value pairs encoded as an I2P Mapping structure.
 // Common use cases include application-specific metadata, routing hints, or version info.
@@ -675,109 +818,11 @@ func (d *DatagramConn) SendTo(payload []byte, destinationB64 string, port uint16
 //   - The connection is closed
 //   - The payload exceeds the maximum size for the protocol type
 //   - The destination string is invalid
-//   - The write deadline has expired
+//   - The write deadline has expired, including while waiting for the Pacer
+//   - The Pacer rejects the send (ErrRateLimited)
 //   - The underlying I2CP session fails to send
 func (d *DatagramConn) SendToWithOptions(payload []byte, destinationB64 string, port uint16, options *Options) error {
-	d.mu.RLock()
-	closed := d.closed
-	deadline := d.writeDeadline
-	protocol := d.protocol
-	session := d.session
-	localPort := d.localPort
-	d.mu.RUnlock()
-
-	if closed {
-		return net.ErrClosed
-	}
-
-	// Calculate max payload accounting for options overhead
-	maxSize := d.MaxPayloadSize()
-	optionsOverhead := 0
-	if options != nil && !options.IsEmpty() {
-		optionsOverhead = options.Len()
-	}
-	effectiveMax := maxSize - optionsOverhead
-	if effectiveMax < 0 {
-		return fmt.Errorf("options too large: %d bytes, maximum payload %d", optionsOverhead, maxSize)
-	}
-	if len(payload) > effectiveMax {
-		return fmt.Errorf("payload size %d exceeds maximum %d for protocol %d with options (%d bytes)", len(payload), effectiveMax, protocol, optionsOverhead)
-	}
-
-	// Check write deadline
-	if !deadline.IsZero() && time.Now().After(deadline) {
-		return fmt.Errorf("write deadline exceeded")
-	}
-
-	// Parse destination from base64 string
-	crypto := i2cp.NewCrypto()
-	dest, err := i2cp.NewDestinationFromBase64(destinationB64, crypto)
-	if err != nil {
-		return fmt.Errorf("invalid destination: %w", err)
-	}
-
-	// Construct protocol-specific envelope
-	var envelope []byte
-	switch protocol {
-	case ProtocolRaw:
-		// Raw datagrams don't support options, send payload directly
-		envelope = payload
-
-	case ProtocolDatagram3:
-		// Datagram3: fromhash(32) + flags(2) + [options] + payload
-		var buildErr error
-		envelope, buildErr = buildDatagram3EnvelopeWithOptions(payload, session, options)
-		if buildErr != nil {
-			return fmt.Errorf("failed to build Datagram3 envelope: %w", buildErr)
-		}
-
-	case ProtocolDatagram1:
-		// Datagram1 doesn't support options
-		var buildErr error
-		envelope, buildErr = buildDatagram1Envelope(payload, session)
-		if buildErr != nil {
-			return fmt.Errorf("failed to build Datagram1 envelope: %w", buildErr)
-		}
-
-	case ProtocolDatagram2:
-		// Datagram2: from dest(387+) + flags(2) + [options] + [offline_sig] + payload + signature(40+)
-		targetDestHash, err := destinationHash(dest)
-		if err != nil {
-			return fmt.Errorf("failed to compute target destination hash: %w", err)
-		}
-
-		var buildErr error
-		envelope, buildErr = buildDatagram2EnvelopeWithOptions(payload, session, targetDestHash, options)
-		if buildErr != nil {
-			return fmt.Errorf("failed to build Datagram2 envelope: %w", buildErr)
-		}
-
-	default:
-		return fmt.Errorf("unsupported protocol: %d", protocol)
-	}
-
-	// Send via I2CP
-	stream := i2cp.NewStream(envelope)
-
-	// Use context with timeout if write deadline is set
-	if !deadline.IsZero() {
-		timeout := time.Until(deadline)
-		if timeout <= 0 {
-			return fmt.Errorf("write deadline exceeded")
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-
-		err = session.SendMessageWithContext(ctx, dest, protocol, localPort, port, stream, 0)
-	} else {
-		err = session.SendMessage(dest, protocol, localPort, port, stream, 0)
-	}
-
-	if err != nil {
-		return fmt.Errorf("failed to send datagram: %w", err)
-	}
-
-	return nil
+	return d.Send(context.Background(), payload, &I2PAddr{Destination: destinationB64, Port: port}, &SendOptions{Options: options})
 }
 
 // ReceiveFrom receives a datagram and returns the payload, sender destination, and source port.
@@ -811,41 +856,11 @@ func (d *DatagramConn) SendToWithOptions(payload []byte, destinationB64 string,
 //   - The envelope is malformed
 //   - Signature verification fails (Datagram1/2)
 func (d *DatagramConn) ReceiveFrom() ([]byte, *i2cp.Destination, uint16, error) {
-	d.mu.RLock()
-	closed := d.closed
-	deadline := d.readDeadline
-	protocol := d.protocol
-	d.mu.RUnlock()
-
-	if closed {
-		return nil, nil, 0, net.ErrClosed
-	}
-
-	// Set up deadline timeout if specified
-	var timer *time.Timer
-	var timeoutChan <-chan time.Time
-	if !deadline.IsZero() {
-		timeout := time.Until(deadline)
-		if timeout <= 0 {
-			return nil, nil, 0, fmt.Errorf("read deadline exceeded")
-		}
-		timer = time.NewTimer(timeout)
-		defer timer.Stop()
-		timeoutChan = timer.C
-	}
-
-	// Block until message received, deadline, or context cancelled
-	select {
-	case msg := <-d.recvQueue:
-		// Parse protocol-specific envelope
-		return d.parseEnvelope(msg, protocol)
-
-	case <-timeoutChan:
-		return nil, nil, 0, fmt.Errorf("read deadline exceeded")
-
-	case <-d.ctx.Done():
-		return nil, nil, 0, net.ErrClosed
+	result, err := d.receive()
+	if err != nil {
+		return nil, nil, 0, err
 	}
+	return result.Payload, result.From, result.SrcPort, nil
 }
 
 // ReceiveFromWithAddr receives a datagram and returns the payload, sender address, and an error.
@@ -865,41 +880,11 @@ func (d *DatagramConn) ReceiveFrom() ([]byte, *i2cp.Destination, uint16, error)
 //   - The read deadline has expired
 //   - The envelope is malformed
 func (d *DatagramConn) ReceiveFromWithAddr() ([]byte, *I2PAddr, error) {
-	d.mu.RLock()
-	closed := d.closed
-	deadline := d.readDeadline
-	protocol := d.protocol
-	d.mu.RUnlock()
-
-	if closed {
-		return nil, nil, net.ErrClosed
-	}
-
-	// Set up deadline timeout if specified
-	var timer *time.Timer
-	var timeoutChan <-chan time.Time
-	if !deadline.IsZero() {
-		timeout := time.Until(deadline)
-		if timeout <= 0 {
-			return nil, nil, fmt.Errorf("read deadline exceeded")
-		}
-		timer = time.NewTimer(timeout)
-		defer timer.Stop()
-		timeoutChan = timer.C
-	}
-
-	// Block until message received, deadline, or context cancelled
-	select {
-	case msg := <-d.recvQueue:
-		// Parse protocol-specific envelope and return as I2PAddr
-		return d.parseEnvelopeToAddr(msg, protocol)
-
-	case <-timeoutChan:
-		return nil, nil, fmt.Errorf("read deadline exceeded")
-
-	case <-d.ctx.Done():
-		return nil, nil, net.ErrClosed
+	result, err := d.receive()
+	if err != nil {
+		return nil, nil, err
 	}
+	return result.Payload, result.FromAddr, nil
 }
 
 // ReceiveFromWithOptions receives a datagram and returns a ReceiveResult containing
@@ -935,10 +920,16 @@ func (d *DatagramConn) ReceiveFromWithAddr() ([]byte, *I2PAddr, error) {
 //   - The envelope is malformed
 //   - Signature verification fails (Datagram1/2)
 func (d *DatagramConn) ReceiveFromWithOptions() (*ReceiveResult, error) {
+	return d.receive()
+}
+
+// receive blocks until a datagram is received, the read deadline expires, or the
+// connection is closed, and runs the datagram through processDatagram.
+// All receive methods are views of the ReceiveResult it returns.
+func (d *DatagramConn) receive() (*ReceiveResult, error) {
 	d.mu.RLock()
 	closed := d.closed
 	deadline := d.readDeadline
-	protocol := d.protocol
 	d.mu.RUnlock()
 
 	if closed {
@@ -960,9 +951,11 @@ func (d *DatagramConn) ReceiveFromWithOptions() (*ReceiveResult, error) {
 
 	// Block until message received, deadline, or context cancelled
 	select {
-	case msg := <-d.recvQueue:
-		// Parse protocol-specific envelope with options
-		return d.parseEnvelopeWithOptions(msg, protocol)
+	case msg, ok := <-d.recvQueue:
+		if !ok {
+			return nil, net.ErrClosed // Queue closed by Close
+		}
+		return d.processReceived(msg)
 
 	case <-timeoutChan:
 		return nil, fmt.Errorf("read deadline exceeded")
@@ -972,333 +965,222 @@ func (d *DatagramConn) ReceiveFromWithOptions() (*ReceiveResult, error) {
 	}
 }
 
-// parseEnvelope extracts the payload and sender information from a protocol-specific envelope.
-func (d *DatagramConn) parseEnvelope(msg *receivedDatagram, protocol uint8) ([]byte, *i2cp.Destination, uint16, error) {
-	switch protocol {
-	case ProtocolRaw:
-		// Raw datagrams have no envelope, payload is direct
-		return msg.payload, msg.from, msg.srcPort, nil
-
-	case ProtocolDatagram3:
-		// Datagram3: fromhash(32) + flags(2) + [options] + payload
-		// See SPEC.md for format details
-		if len(msg.payload) < 34 {
-			return nil, nil, 0, fmt.Errorf("Datagram3 envelope too short: %d bytes", len(msg.payload))
-		}
-
-		// Extract fromhash (first 32 bytes) - SHA-256 hash of sender's destination
-		fromHash := msg.payload[0:32]
-
-		// Extract flags per I2P Datagram specification:
-		// Per spec: "flags :: (2 bytes) Bit order: 15 14 ... 3 2 1 0"
-		// - High byte (index 32): reserved, currently unused (bits 8-15)
-		// - Low byte (index 33): contains version (bits 0-3), options flag (bit 4), bits 5-7 reserved
-		// See: https://geti2p.net/spec/datagrams#datagram3
-		highFlags := msg.payload[32]
-		lowFlags := msg.payload[33]
-
-		// Validate reserved bits (5-15) are zero per spec:
-		// "Bits 15-5: unused, set to 0 for compatibility with future uses"
-		// High byte is entirely reserved; low byte bits 5-7 are reserved.
-		reservedMask := uint16(0xFFE0) // bits 5-15
-		flagsValue := uint16(highFlags)<<8 | uint16(lowFlags)
-		if flagsValue&reservedMask != 0 {
-			return nil, nil, 0, fmt.Errorf("Datagram3 has non-zero reserved flag bits: 0x%04x (reserved bits: 0x%04x)", flagsValue, flagsValue&reservedMask)
-		}
-
-		version := lowFlags & 0x0F
-		hasOptions := (lowFlags & 0x10) != 0
-
-		// Verify version bits (should be 0x03 for Datagram3)
-		if version != 0x03 {
-			return nil, nil, 0, fmt.Errorf("invalid Datagram3 version: 0x%x (expected 0x03)", version)
-		}
-
-		// Start of payload (after fromhash + flags)
-		offset := 34
-
-		// Parse options if present (I2P Mapping format: 2-byte size + key=value; pairs)
-		if hasOptions {
-			if len(msg.payload)-offset < 2 {
-				return nil, nil, 0, fmt.Errorf("Datagram3 envelope too short for options size field at offset %d: have %d bytes, need at least 2", offset, len(msg.payload)-offset)
-			}
-			opts, optLen, optErr := OptionsFromBytes(msg.payload[offset:])
-			if optErr != nil {
-				return nil, nil, 0, fmt.Errorf("Datagram3 failed to parse options: %w", optErr)
-			}
-			offset += optLen
-			// Options are parsed but not exposed in return value (could be added later)
-			_ = opts
-		}
-
-		// Extract payload (everything after offset)
-		payload := msg.payload[offset:]
-
-		// Datagram3 only provides sender's hash, not full destination.
-		// Return nil destination since no valid destination data is available.
-		// Use ReceiveFromWithAddr() to get the sender's hash via I2PAddr.DestinationHash.
-		_ = fromHash // Hash is available via ReceiveFromWithAddr()
-
-		return payload, nil, msg.srcPort, nil
-
-	case ProtocolDatagram1:
-		// Datagram1: from dest(387+) + signature(40+) + payload
-		payload, from, err := parseDatagram1Envelope(msg.payload, d.session)
-		if err != nil {
-			return nil, nil, 0, fmt.Errorf("failed to parse Datagram1 envelope: %w", err)
-		}
-		return payload, from, msg.srcPort, nil
-
-	case ProtocolDatagram2:
-		// Datagram2: from dest(387+) + flags(2) + options(optional) + offline_sig(optional) + payload + signature(40+)
-		payload, from, err := parseDatagram2Envelope(msg.payload, d.session)
-		if err != nil {
-			return nil, nil, 0, fmt.Errorf("failed to parse Datagram2 envelope: %w", err)
-		}
-		return payload, from, msg.srcPort, nil
+// processDatagram is the single receive pipeline: it decodes and verifies the
+// protocol-specific envelope, applies the replay cache, and returns the canonical
+// ReceiveResult. ReceiveFrom, ReceiveFromWithAddr, ReceiveFromWithOptions, ReadFrom
+// and port handlers all present views of this result.
+//
+// FromAddr is never nil. Its Destination is set whenever the full sender destination
+// is known, and its DestinationHash whenever the sender's hash is known.
+func (d *DatagramConn) processDatagram(msg *receivedDatagram, protocol uint8) (*ReceiveResult, error) {
+	if msg.processed {
+		return msg.result, msg.err
+	}
 
-	default:
-		return nil, nil, 0, fmt.Errorf("unsupported protocol for receive: %d", protocol)
+	result := &ReceiveResult{
+		SrcPort:    msg.srcPort,
+		Protocol:   protocol,
+		ReceivedAt: msg.arrivalTime(),
 	}
-}
 
-// parseEnvelopeToAddr extracts the payload and sender information from a protocol-specific envelope,
-// returning the sender info as an I2PAddr for more complete representation including destination hash.
-//
-// This is particularly important for Datagram3 where only the sender's hash is available,
-// not the full destination. The I2PAddr will have IsHashOnly() return true in this case.
-func (d *DatagramConn) parseEnvelopeToAddr(msg *receivedDatagram, protocol uint8) ([]byte, *I2PAddr, error) {
 	switch protocol {
 	case ProtocolRaw:
-		// Raw datagrams have no envelope, payload is direct
-		// Note: Raw datagrams are not repliable - no sender info available
-		addr := &I2PAddr{
-			Port: msg.srcPort,
-		}
-		// If we have sender info (from I2CP metadata), populate it
+		// Raw datagrams have no envelope, payload is direct, no options.
+		// Sender info is only available from I2CP metadata.
+		result.Payload = msg.payload
+		result.From = msg.from
+		result.FromAddr = &I2PAddr{Port: msg.srcPort}
 		if msg.from != nil {
-			addr.Destination = msg.from.Base64()
+			result.FromAddr.Destination = msg.from.Base64()
 			if h, err := destinationHash(msg.from); err == nil {
-				addr.DestinationHash = h
+				result.FromHash = h
+				result.FromAddr.DestinationHash = h
 			}
 		}
-		return msg.payload, addr, nil
+		return result, nil
 
 	case ProtocolDatagram3:
 		// Datagram3: fromhash(32) + flags(2) + [options] + payload
 		// See SPEC.md and https://geti2p.net/spec/datagrams#datagram3 for format details
-		if len(msg.payload) < 34 {
-			return nil, nil, fmt.Errorf("Datagram3 envelope too short: %d bytes, need at least 34", len(msg.payload))
-		}
-
-		// Extract fromhash (first 32 bytes) - SHA-256 hash of sender's destination
-		var fromHash [32]byte
-		copy(fromHash[:], msg.payload[0:32])
-
-		// Extract flags per I2P Datagram specification:
-		// Per spec: "flags :: (2 bytes) Bit order: 15 14 ... 3 2 1 0"
-		// - High byte (index 32): reserved, currently unused
-		// - Low byte (index 33): contains version (bits 0-3) and options flag (bit 4)
-		// See: https://geti2p.net/spec/datagrams#datagram3
-		lowFlags := msg.payload[33]
-		version := lowFlags & 0x0F
-		hasOptions := (lowFlags & 0x10) != 0
-
-		// Verify version bits (should be 0x03 for Datagram3)
-		if version != 0x03 {
-			return nil, nil, fmt.Errorf("invalid Datagram3 version: 0x%x (expected 0x03)", version)
-		}
-
-		// Start of payload (after fromhash + flags)
-		offset := 34
-
-		// Parse options if present (I2P Mapping format: 2-byte size + key=value; pairs)
-		if hasOptions {
-			if len(msg.payload)-offset < 2 {
-				return nil, nil, fmt.Errorf("Datagram3 envelope too short for options size field at offset %d: have %d bytes, need at least 2", offset, len(msg.payload)-offset)
-			}
-			opts, optLen, optErr := OptionsFromBytes(msg.payload[offset:])
-			if optErr != nil {
-				return nil, nil, fmt.Errorf("Datagram3 failed to parse options: %w", optErr)
-			}
-			offset += optLen
-			// Options are parsed but not exposed in return value (could be added later)
-			_ = opts
+		env, err := unmarshalDatagram3(msg.payload, d.OptionsLimits())
+		if err != nil {
+			return nil, err
 		}
 
-		// Extract payload (everything after offset)
-		payload := msg.payload[offset:]
-
-		// Return I2PAddr with hash-only sender identification
-		// Datagram3 protocol only provides the hash, not the full destination
-		// Applications needing the full destination must look it up from netdb or cache
-		addr := &I2PAddr{
-			Destination:     "", // Not available in Datagram3 protocol
-			DestinationHash: fromHash,
+		// Datagram3 only provides the sender's hash, not the full destination.
+		// Applications needing the full destination must look it up from netdb or cache.
+		result.Payload = env.Payload
+		result.Options = env.Options
+		result.FromHash = env.SenderHash
+		result.FromAddr = &I2PAddr{
+			DestinationHash: env.SenderHash,
 			Port:            msg.srcPort,
 		}
+		return result, nil
 
-		return payload, addr, nil
+	case ProtocolDatagram1, ProtocolDatagram2:
+		// Datagram1: from + signature + payload
+		// Datagram2: from + flags + [options] + [offline_sig] + payload + signature
+		d.mu.RLock()
+		verify := d.verifyPolicy.Mode != VerifyNone
+		d.mu.RUnlock()
 
-	case ProtocolDatagram1:
-		// Datagram1: from dest(387+) + signature(40+) + payload
-		payload, from, err := parseDatagram1Envelope(msg.payload, d.session)
+		env, checks, err := d.unmarshalAuthenticated(msg.payload, protocol, verify)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to parse Datagram1 envelope: %w", err)
-		}
-
-		addr := &I2PAddr{
-			Port: msg.srcPort,
+			return nil, fmt.Errorf("failed to parse %s envelope: %w", datagramName(protocol), err)
 		}
-		if from != nil {
-			addr.Destination = from.Base64()
-			if h, err := destinationHash(from); err == nil {
-				addr.DestinationHash = h
+		for _, check := range checks {
+			if !check.verify() {
+				d.verifyCounters.failed.Add(1)
+				return nil, fmt.Errorf("failed to parse %s envelope: %w", datagramName(protocol), check.failure)
 			}
 		}
-		return payload, addr, nil
-
-	case ProtocolDatagram2:
-		// Datagram2: from dest(387+) + flags(2) + options(optional) + offline_sig(optional) + payload + signature(40+)
-		payload, from, err := parseDatagram2Envelope(msg.payload, d.session)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to parse Datagram2 envelope: %w", err)
+		if verify {
+			d.verifyCounters.verified.Add(1)
+		} else {
+			d.verifyCounters.unverified.Add(1)
 		}
+		return d.authenticatedResult(msg, env, protocol, verify)
 
-		addr := &I2PAddr{
-			Port: msg.srcPort,
+	default:
+		codec, ok := d.envelopeCodec(protocol)
+		if !ok {
+			return nil, unsupportedProtocolError(protocol)
 		}
-		if from != nil {
-			addr.Destination = from.Base64()
-			if h, err := destinationHash(from); err == nil {
-				addr.DestinationHash = h
-			}
+		decoded, err := codec.Decode(msg.payload, &CodecContext{
+			Protocol:  protocol,
+			LocalHash: d.localWire.hash(),
+			SrcPort:   msg.srcPort,
+			DestPort:  msg.destPort,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode protocol %d envelope: %w", protocol, err)
 		}
-		return payload, addr, nil
 
-	default:
-		return nil, nil, fmt.Errorf("unsupported protocol for receive: %d", protocol)
-	}
-}
-
-// parseEnvelopeWithOptions extracts all datagram fields including options.
-// This method returns a complete ReceiveResult with all available information.
-func (d *DatagramConn) parseEnvelopeWithOptions(msg *receivedDatagram, protocol uint8) (*ReceiveResult, error) {
-	result := &ReceiveResult{
-		SrcPort: msg.srcPort,
-	}
-
-	switch protocol {
-	case ProtocolRaw:
-		// Raw datagrams have no envelope, payload is direct, no options
-		result.Payload = msg.payload
+		// Sender info comes from I2CP metadata, as for Raw, and from the codec's hash
+		result.Payload = decoded.Payload
+		result.Options = decoded.Options
 		result.From = msg.from
+		result.FromAddr = &I2PAddr{Port: msg.srcPort}
 		if msg.from != nil {
-			result.FromAddr = &I2PAddr{
-				Destination: msg.from.Base64(),
-				Port:        msg.srcPort,
-			}
+			result.FromAddr.Destination = msg.from.Base64()
 			if h, err := destinationHash(msg.from); err == nil {
 				result.FromHash = h
-				result.FromAddr.DestinationHash = result.FromHash
 			}
 		}
-		return result, nil
-
-	case ProtocolDatagram3:
-		// Datagram3: fromhash(32) + flags(2) + [options] + payload
-		if len(msg.payload) < 34 {
-			return nil, fmt.Errorf("Datagram3 envelope too short: %d bytes, need at least 34", len(msg.payload))
+		if decoded.SenderHash != ([32]byte{}) {
+			result.FromHash = decoded.SenderHash
 		}
+		result.FromAddr.DestinationHash = result.FromHash
+		return result, nil
+	}
+}
 
-		// Extract fromhash (first 32 bytes)
-		copy(result.FromHash[:], msg.payload[0:32])
-
-		// Extract flags
-		highFlags := msg.payload[32]
-		lowFlags := msg.payload[33]
-
-		// Validate reserved bits (5-15) are zero
-		reservedMask := uint16(0xFFE0)
-		flagsValue := uint16(highFlags)<<8 | uint16(lowFlags)
-		if flagsValue&reservedMask != 0 {
-			return nil, fmt.Errorf("Datagram3 has non-zero reserved flag bits: 0x%04x", flagsValue)
-		}
+// encodeCustom frames a payload for an application-defined protocol with its
+// registered EnvelopeCodec.
+func (d *DatagramConn) encodeCustom(protocol uint8, payload []byte, dest *i2cp.Destination, port uint16, options *Options) ([]byte, error) {
+	codec, ok := d.envelopeCodec(protocol)
+	if !ok {
+		return nil, unsupportedProtocolError(protocol)
+	}
+	remoteHash, err := destinationHash(dest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute target destination hash: %w", err)
+	}
+	envelope, err := codec.Encode(payload, &CodecContext{
+		Protocol:   protocol,
+		LocalHash:  d.localWire.hash(),
+		RemoteHash: remoteHash,
+		SrcPort:    d.localPort,
+		DestPort:   port,
+		Options:    options,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode protocol %d envelope: %w", protocol, err)
+	}
+	if len(envelope) > MaxI2NPSize {
+		return nil, fmt.Errorf("protocol %d envelope size %d exceeds maximum %d", protocol, len(envelope), MaxI2NPSize)
+	}
+	return envelope, nil
+}
 
-		version := lowFlags & 0x0F
-		hasOptions := (lowFlags & 0x10) != 0
+// authenticatedResult completes the pipeline for a decoded Datagram1/Datagram2
+// envelope: it applies the replay cache and fills in the sender fields.
+func (d *DatagramConn) authenticatedResult(msg *receivedDatagram, env *Envelope, protocol uint8, authenticated bool) (*ReceiveResult, error) {
+	if err := d.checkReplay(env.sender, env.Signature, env.Options); err != nil {
+		return nil, err
+	}
+	from, err := env.sender.i2cpDestination()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s envelope: %w", datagramName(protocol), err)
+	}
+	addr := senderAddr(env.sender, msg.srcPort)
+	sigType := env.sender.sigType
+	if env.OfflineSignature != nil {
+		sigType = env.OfflineSignature.TransientSigType
+	}
+	return &ReceiveResult{
+		Payload:          env.Payload,
+		From:             from,
+		FromHash:         addr.DestinationHash,
+		FromAddr:         addr,
+		SrcPort:          msg.srcPort,
+		Options:          env.Options,
+		Protocol:         protocol,
+		Authenticated:    authenticated,
+		SigType:          sigType,
+		OfflineSignature: env.OfflineSignature,
+		ReceivedAt:       msg.arrivalTime(),
+	}, nil
+}
 
-		if version != 0x03 {
-			return nil, fmt.Errorf("invalid Datagram3 version: 0x%x (expected 0x03)", version)
-		}
+// buildDatagram1 builds a Datagram1 envelope signed by the installed Signer,
+// or by the session's signing key pair when none is set.
+func (d *DatagramConn) buildDatagram1(payload []byte) ([]byte, error) {
+	d.mu.RLock()
+	key := d.signingKey
+	d.mu.RUnlock()
 
-		offset := 34
+	if key == nil {
+		return buildDatagram1Envelope(payload, d.session)
+	}
+	return encodeDatagram1(payload, key)
+}
 
-		// Parse options if present
-		if hasOptions {
-			if len(msg.payload)-offset < 2 {
-				return nil, fmt.Errorf("Datagram3 envelope too short for options at offset %d", offset)
-			}
-			opts, optLen, optErr := OptionsFromBytes(msg.payload[offset:])
-			if optErr != nil {
-				return nil, fmt.Errorf("Datagram3 failed to parse options: %w", optErr)
-			}
-			offset += optLen
-			result.Options = opts
-		}
+// buildDatagram2 builds a Datagram2 envelope signed by the installed Signer,
+// or by the session's signing key pair when none is set.
+func (d *DatagramConn) buildDatagram2(payload []byte, targetDestHash [32]byte, options *Options) ([]byte, error) {
+	d.mu.RLock()
+	key := d.signingKey
+	d.mu.RUnlock()
 
-		result.Payload = msg.payload[offset:]
-		result.From = nil // Datagram3 only has hash, not full destination
-		result.FromAddr = &I2PAddr{
-			Destination:     "",
-			DestinationHash: result.FromHash,
-			Port:            msg.srcPort,
-		}
-		return result, nil
+	if key == nil {
+		return buildDatagram2EnvelopeWithOptions(payload, d.session, targetDestHash, options)
+	}
+	return encodeDatagram2(payload, key, targetDestHash, options)
+}
 
-	case ProtocolDatagram1:
-		// Datagram1 doesn't support options
-		payload, from, err := parseDatagram1Envelope(msg.payload, d.session)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse Datagram1 envelope: %w", err)
-		}
-		result.Payload = payload
-		result.From = from
-		if from != nil {
-			result.FromAddr = &I2PAddr{
-				Destination: from.Base64(),
-				Port:        msg.srcPort,
-			}
-			if h, err := destinationHash(from); err == nil {
-				result.FromHash = h
-				result.FromAddr.DestinationHash = result.FromHash
-			}
-		}
-		return result, nil
+// checkReplay consults the replay cache, if one is installed, for a verified
+// Datagram1/Datagram2 envelope. Returns nil when no cache is configured.
+func (d *DatagramConn) checkReplay(sender *wireDestination, signature []byte, options *Options) error {
+	d.mu.RLock()
+	cache := d.replayCache
+	d.mu.RUnlock()
 
-	case ProtocolDatagram2:
-		// Datagram2: parse with options support
-		payload, from, opts, err := parseDatagram2EnvelopeWithOptions(msg.payload, d.session)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse Datagram2 envelope: %w", err)
-		}
-		result.Payload = payload
-		result.From = from
-		result.Options = opts
-		if from != nil {
-			result.FromAddr = &I2PAddr{
-				Destination: from.Base64(),
-				Port:        msg.srcPort,
-			}
-			if h, err := destinationHash(from); err == nil {
-				result.FromHash = h
-				result.FromAddr.DestinationHash = result.FromHash
-			}
-		}
-		return result, nil
+	if cache == nil {
+		return nil
+	}
+	return cache.check(sender.hash(), signature, options)
+}
 
-	default:
-		return nil, fmt.Errorf("unsupported protocol for receive: %d", protocol)
+// senderAddr builds the I2PAddr for an authenticated sender parsed from an envelope.
+// The Base64 destination and hash come from the wire format, so they are populated
+// for every sigtype, including ML-DSA senders that *i2cp.Destination cannot represent.
+func senderAddr(sender *wireDestination, srcPort uint16) *I2PAddr {
+	return &I2PAddr{
+		Destination:     sender.base64(),
+		DestinationHash: sender.hash(),
+		Port:            srcPort,
 	}
 }
 
@@ -1322,30 +1204,19 @@ func (d *DatagramConn) parseEnvelopeWithOptions(msg *receivedDatagram, protocol
 //   - The read deadline has expired
 //   - The underlying receive operation fails
 func (d *DatagramConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
-	// Call ReceiveFrom to get the datagram
-	payload, from, srcPort, err := d.ReceiveFrom()
+	result, err := d.receive()
 	if err != nil {
 		return 0, nil, err
 	}
 
 	// Copy payload into provided buffer
-	n = copy(p, payload)
-
-	// Convert I2P destination + port to I2PAddr
-	// Use Base64() to get the sender's base64 address string.
-	// This allows applications to reply to the sender.
-	var destStr string
-	if from != nil {
-		destStr = from.Base64()
-	}
-	i2pAddr := &I2PAddr{
-		Destination: destStr,
-		Port:        srcPort,
-	}
+	n = copy(p, result.Payload)
 
+	// FromAddr carries the sender's Base64 destination when known, so applications
+	// can reply, and the destination hash for hash-only senders (Datagram3).
 	// If buffer was too small, we still return the bytes copied (not an error)
 	// This matches the behavior of net.UDPConn and other PacketConn implementations
-	return n, i2pAddr, nil
+	return n, result.FromAddr, nil
 }
 
 // WriteTo writes a packet with payload p to addr.
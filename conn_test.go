@@ -1,11 +1,15 @@
 package datagrams
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/mldsa"
 	"crypto/sha256"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -22,6 +26,7 @@ func (f fakeAddr) String() string  { return "fake:0" }
 // mockSession implements I2CPSession for testing.
 // This allows us to test DatagramConn without requiring a real I2P router.
 type mockSession struct {
+	mu           sync.Mutex // serializes sends from concurrent goroutines
 	dest         *i2cp.Destination
 	closed       bool
 	offline      bool // Simulates session with offline keys (LS2)
@@ -29,7 +34,12 @@ type mockSession struct {
 	lastSrcPort  uint16
 	lastDestPort uint16
 	lastPayload  []byte
+	lastNonce    uint32
 	sendError    error
+
+	// deliver, if set, is called with every sent message, letting tests route
+	// datagrams to the receiving DatagramConn (see routeTo).
+	deliver func(protocol uint8, srcPort, destPort uint16, payload []byte)
 }
 
 func (m *mockSession) Destination() *i2cp.Destination {
@@ -45,6 +55,8 @@ func (m *mockSession) IsOffline() bool {
 }
 
 func (m *mockSession) SendMessage(destination *i2cp.Destination, protocol uint8, srcPort, destPort uint16, payload *i2cp.Stream, nonce uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.sendError != nil {
 		return m.sendError
 	}
@@ -52,10 +64,16 @@ func (m *mockSession) SendMessage(destination *i2cp.Destination, protocol uint8,
 	m.lastSrcPort = srcPort
 	m.lastDestPort = destPort
 	m.lastPayload = payload.Bytes()
+	m.lastNonce = nonce
+	if m.deliver != nil {
+		m.deliver(protocol, srcPort, destPort, m.lastPayload)
+	}
 	return nil
 }
 
 func (m *mockSession) SendMessageWithContext(ctx context.Context, destination *i2cp.Destination, protocol uint8, srcPort, destPort uint16, payload *i2cp.Stream, nonce uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.sendError != nil {
 		return m.sendError
 	}
@@ -63,6 +81,10 @@ func (m *mockSession) SendMessageWithContext(ctx context.Context, destination *i
 	m.lastSrcPort = srcPort
 	m.lastDestPort = destPort
 	m.lastPayload = payload.Bytes()
+	m.lastNonce = nonce
+	if m.deliver != nil {
+		m.deliver(protocol, srcPort, destPort, m.lastPayload)
+	}
 	return nil
 }
 
@@ -97,6 +119,71 @@ func validDestinationB64() string {
 	return dest.Base64()
 }
 
+const (
+	// pairPortA and pairPortB are the local ports of the conns connPair returns.
+	pairPortA uint16 = 9000
+	pairPortB uint16 = 9001
+)
+
+// connPair returns a conn over protoA and a conn over protoB, each on its own
+// session and routed to the other, with their sessions. The conns are closed
+// when the test ends.
+func connPair(t *testing.T, protoA, protoB uint8) (a, b *DatagramConn, sa, sb *mockSession) {
+	t.Helper()
+	return dialPair(t,
+		func(session I2CPSession, port uint16) (*DatagramConn, error) {
+			return NewDatagramConnWithProtocol(session, port, protoA)
+		},
+		func(session I2CPSession, port uint16) (*DatagramConn, error) {
+			return NewDatagramConnWithProtocol(session, port, protoB)
+		})
+}
+
+// dialPair is connPair with the conns created by newA and newB.
+func dialPair(t *testing.T, newA, newB func(I2CPSession, uint16) (*DatagramConn, error)) (a, b *DatagramConn, sa, sb *mockSession) {
+	t.Helper()
+	sa, sb = newMockSession(), newMockSession()
+	var err error
+	if a, err = newA(sa, pairPortA); err != nil {
+		t.Fatalf("creating conn failed: %v", err)
+	}
+	if b, err = newB(sb, pairPortB); err != nil {
+		a.Close()
+		t.Fatalf("creating conn failed: %v", err)
+	}
+	routeTo(sa, b)
+	routeTo(sb, a)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b, sa, sb
+}
+
+// routeTo makes every datagram sent on session arrive at the conn among conns
+// receiving its protocol and listening on its destination port, either as its
+// local port or through a registered handler.
+func routeTo(session *mockSession, conns ...*DatagramConn) {
+	session.deliver = func(protocol uint8, srcPort, destPort uint16, payload []byte) {
+		for _, conn := range conns {
+			if conn.AcceptsProtocol(protocol) && listensOn(conn, destPort) {
+				conn.injectMessage(append([]byte(nil), payload...), nil, protocol, srcPort, destPort)
+			}
+		}
+	}
+}
+
+// listensOn reports whether conn receives datagrams sent to port.
+func listensOn(conn *DatagramConn, port uint16) bool {
+	if conn.localPort == port {
+		return true
+	}
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	_, ok := conn.handlers[port]
+	return ok
+}
+
 // TestNewDatagramConn verifies basic connection creation.
 func TestNewDatagramConn(t *testing.T) {
 	session := newMockSession()
@@ -900,7 +987,7 @@ func TestReceiveFrom_Datagram3(t *testing.T) {
 	// Construct Datagram3 envelope: fromhash(32) + flags(2) + payload
 	payload := []byte("test message")
 	destStream := i2cp.NewStream(nil)
-	fromDest.WriteToStream(destStream)
+	fromDest.WriteToMessage(destStream)
 	fromHash := sha256.Sum256(destStream.Bytes())
 
 	envelope := make([]byte, 32+2+len(payload))
@@ -1225,19 +1312,18 @@ func TestDatagram1Envelope_Roundtrip(t *testing.T) {
 			}
 
 			// Parse the envelope
-			parsedPayload, parsedFrom, err := parseDatagram1Envelope(envelope, session)
+			env, err := openEnvelope(ProtocolDatagram1, envelope, [32]byte{})
 			if err != nil {
-				t.Fatalf("parseDatagram1Envelope() failed: %v", err)
+				t.Fatalf("openEnvelope() failed: %v", err)
 			}
 
 			// Verify payload matches
-			if string(parsedPayload) != string(tc.payload) {
-				t.Errorf("payload mismatch: got %q, want %q", parsedPayload, tc.payload)
+			if string(env.Payload) != string(tc.payload) {
+				t.Errorf("payload mismatch: got %q, want %q", env.Payload, tc.payload)
 			}
 
 			// Verify sender destination matches session destination
-			expectedDest := session.Destination().Base64()
-			if parsedFrom.Base64() != expectedDest {
+			if env.sender.hash() != targetHashOf(t, session) {
 				t.Error("sender destination mismatch")
 			}
 		})
@@ -1250,11 +1336,11 @@ func TestDatagram2Envelope_Roundtrip(t *testing.T) {
 	session := newMockSession()
 
 	// Compute target destination hash from session's own destination (self-send scenario)
-	// Uses WriteToStream for canonical serialization, matching the implementation
+	// Uses WriteToMessage (wire format) for the hash, matching the implementation
 	localDest := session.Destination()
 	destStream := i2cp.NewStream(nil)
-	if err := localDest.WriteToStream(destStream); err != nil {
-		t.Fatalf("WriteToStream() failed: %v", err)
+	if err := localDest.WriteToMessage(destStream); err != nil {
+		t.Fatalf("WriteToMessage() failed: %v", err)
 	}
 	targetHash := sha256.Sum256(destStream.Bytes())
 
@@ -1272,9 +1358,9 @@ func TestDatagram2Envelope_Roundtrip(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Build the envelope
-			envelope, err := buildDatagram2Envelope(tc.payload, session, targetHash)
+			envelope, err := buildDatagram2EnvelopeWithOptions(tc.payload, session, targetHash, nil)
 			if err != nil {
-				t.Fatalf("buildDatagram2Envelope() failed: %v", err)
+				t.Fatalf("buildDatagram2EnvelopeWithOptions() failed: %v", err)
 			}
 
 			// Verify minimum envelope size
@@ -1283,19 +1369,18 @@ func TestDatagram2Envelope_Roundtrip(t *testing.T) {
 			}
 
 			// Parse the envelope (session is both sender and receiver in this test)
-			parsedPayload, parsedFrom, err := parseDatagram2Envelope(envelope, session)
+			env, err := openEnvelope(ProtocolDatagram2, envelope, targetHash)
 			if err != nil {
-				t.Fatalf("parseDatagram2Envelope() failed: %v", err)
+				t.Fatalf("openEnvelope() failed: %v", err)
 			}
 
 			// Verify payload matches
-			if string(parsedPayload) != string(tc.payload) {
-				t.Errorf("payload mismatch: got %q, want %q", parsedPayload, tc.payload)
+			if string(env.Payload) != string(tc.payload) {
+				t.Errorf("payload mismatch: got %q, want %q", env.Payload, tc.payload)
 			}
 
 			// Verify sender destination matches session destination
-			expectedDest := session.Destination().Base64()
-			if parsedFrom.Base64() != expectedDest {
+			if env.sender.hash() != targetHash {
 				t.Error("sender destination mismatch")
 			}
 		})
@@ -1307,11 +1392,11 @@ func TestDatagram2Envelope_RoundtripWithOptions(t *testing.T) {
 	session := newMockSession()
 
 	// Compute target destination hash
-	// Uses WriteToStream for canonical serialization, matching the implementation
+	// Uses WriteToMessage (wire format) for the hash, matching the implementation
 	localDest := session.Destination()
 	destStream := i2cp.NewStream(nil)
-	if err := localDest.WriteToStream(destStream); err != nil {
-		t.Fatalf("WriteToStream() failed: %v", err)
+	if err := localDest.WriteToMessage(destStream); err != nil {
+		t.Fatalf("WriteToMessage() failed: %v", err)
 	}
 	targetHash := sha256.Sum256(destStream.Bytes())
 
@@ -1330,19 +1415,21 @@ func TestDatagram2Envelope_RoundtripWithOptions(t *testing.T) {
 	}
 
 	// Parse the envelope
-	parsedPayload, parsedFrom, err := parseDatagram2Envelope(envelope, session)
+	env, err := openEnvelope(ProtocolDatagram2, envelope, targetHash)
 	if err != nil {
-		t.Fatalf("parseDatagram2Envelope() failed: %v", err)
+		t.Fatalf("openEnvelope() failed: %v", err)
 	}
 
-	// Verify payload matches
-	if string(parsedPayload) != string(payload) {
-		t.Errorf("payload mismatch: got %q, want %q", parsedPayload, payload)
+	// Verify payload and options match
+	if string(env.Payload) != string(payload) {
+		t.Errorf("payload mismatch: got %q, want %q", env.Payload, payload)
+	}
+	if env.Options.Get("to.port") != "9090" {
+		t.Errorf("options[to.port] = %q, want %q", env.Options.Get("to.port"), "9090")
 	}
 
 	// Verify sender destination
-	expectedDest := session.Destination().Base64()
-	if parsedFrom.Base64() != expectedDest {
+	if env.sender.hash() != targetHash {
 		t.Error("sender destination mismatch")
 	}
 }
@@ -1353,27 +1440,27 @@ func TestDatagram2Envelope_ReplayPrevention(t *testing.T) {
 	session := newMockSession()
 
 	// Create a different target destination (simulating sending to someone else)
-	// Uses WriteToStream for canonical hash computation, matching the implementation
+	// Uses WriteToMessage (wire format) for the hash, matching the implementation
 	crypto := i2cp.NewCrypto()
 	otherDest, _ := i2cp.NewDestination(crypto)
 	otherStream := i2cp.NewStream(nil)
-	if err := otherDest.WriteToStream(otherStream); err != nil {
-		t.Fatalf("WriteToStream() failed: %v", err)
+	if err := otherDest.WriteToMessage(otherStream); err != nil {
+		t.Fatalf("WriteToMessage() failed: %v", err)
 	}
 	otherHash := sha256.Sum256(otherStream.Bytes())
 
 	payload := []byte("replay test")
 
 	// Build envelope for OTHER destination
-	envelope, err := buildDatagram2Envelope(payload, session, otherHash)
+	envelope, err := buildDatagram2EnvelopeWithOptions(payload, session, otherHash, nil)
 	if err != nil {
-		t.Fatalf("buildDatagram2Envelope() failed: %v", err)
+		t.Fatalf("buildDatagram2EnvelopeWithOptions() failed: %v", err)
 	}
 
 	// Try to parse as if WE received it (our hash doesn't match)
-	_, _, err = parseDatagram2Envelope(envelope, session)
+	_, err = openEnvelope(ProtocolDatagram2, envelope, targetHashOf(t, session))
 	if err == nil {
-		t.Error("parseDatagram2Envelope() should fail for wrong target destination (replay prevention)")
+		t.Error("openEnvelope() should fail for wrong target destination (replay prevention)")
 	}
 
 	if err != nil && !strings.Contains(err.Error(), "signature verification failed") {
@@ -1388,23 +1475,23 @@ func TestDatagram2Envelope_ReservedFlagBits(t *testing.T) {
 	session := newMockSession()
 
 	// Compute target destination hash from session's own destination
-	// Uses WriteToStream for canonical hash computation, matching the implementation
+	// Uses WriteToMessage (wire format) for the hash, matching the implementation
 	localDest := session.Destination()
 	hashStream := i2cp.NewStream(nil)
-	if err := localDest.WriteToStream(hashStream); err != nil {
-		t.Fatalf("WriteToStream() failed: %v", err)
+	if err := localDest.WriteToMessage(hashStream); err != nil {
+		t.Fatalf("WriteToMessage() failed: %v", err)
 	}
 	targetHash := sha256.Sum256(hashStream.Bytes())
 
 	// Build a valid Datagram2 envelope first
 	payload := []byte("test payload")
-	envelope, err := buildDatagram2Envelope(payload, session, targetHash)
+	envelope, err := buildDatagram2EnvelopeWithOptions(payload, session, targetHash, nil)
 	if err != nil {
-		t.Fatalf("buildDatagram2Envelope() failed: %v", err)
+		t.Fatalf("buildDatagram2EnvelopeWithOptions() failed: %v", err)
 	}
 
 	// Find flags position (after destination)
-	// Envelope uses WriteToMessage for destination wire format (different from hash computation)
+	// Envelope uses WriteToMessage for the destination wire format (same bytes that are hashed)
 	destStream := i2cp.NewStream(nil)
 	if err := localDest.WriteToMessage(destStream); err != nil {
 		t.Fatalf("WriteToMessage() failed: %v", err)
@@ -1434,9 +1521,9 @@ func TestDatagram2Envelope_ReservedFlagBits(t *testing.T) {
 			testEnvelope[destLen+1] = tc.lowByte
 
 			// Attempt to parse - should fail due to reserved bits
-			_, _, err := parseDatagram2Envelope(testEnvelope, session)
+			_, err := openEnvelope(ProtocolDatagram2, testEnvelope, targetHash)
 			if err == nil {
-				t.Error("parseDatagram2Envelope() should fail for non-zero reserved bits")
+				t.Error("openEnvelope() should fail for non-zero reserved bits")
 			}
 			if err != nil && !strings.Contains(err.Error(), "reserved flag bits") {
 				t.Errorf("unexpected error message: %v (expected 'reserved flag bits')", err)
@@ -1446,9 +1533,9 @@ func TestDatagram2Envelope_ReservedFlagBits(t *testing.T) {
 
 	// Verify that valid envelope still parses successfully
 	t.Run("valid flags", func(t *testing.T) {
-		_, _, err := parseDatagram2Envelope(envelope, session)
+		_, err := openEnvelope(ProtocolDatagram2, envelope, targetHash)
 		if err != nil {
-			t.Errorf("parseDatagram2Envelope() failed for valid envelope: %v", err)
+			t.Errorf("openEnvelope() failed for valid envelope: %v", err)
 		}
 	})
 }
@@ -1504,9 +1591,9 @@ func TestDatagram3Envelope_ReservedFlagBits(t *testing.T) {
 			}
 
 			// Attempt to parse - should fail due to reserved bits
-			_, _, _, err := conn.parseEnvelope(receivedDg, ProtocolDatagram3)
+			_, err := conn.processDatagram(receivedDg, ProtocolDatagram3)
 			if err == nil {
-				t.Error("parseEnvelope() should fail for non-zero reserved bits")
+				t.Error("processDatagram() should fail for non-zero reserved bits")
 			}
 			if err != nil && !strings.Contains(err.Error(), "reserved flag bits") {
 				t.Errorf("unexpected error message: %v (expected 'reserved flag bits')", err)
@@ -1521,9 +1608,9 @@ func TestDatagram3Envelope_ReservedFlagBits(t *testing.T) {
 			from:     &i2cp.Destination{},
 			protocol: ProtocolDatagram3,
 		}
-		_, _, _, err := conn.parseEnvelope(receivedDg, ProtocolDatagram3)
+		_, err := conn.processDatagram(receivedDg, ProtocolDatagram3)
 		if err != nil {
-			t.Errorf("parseEnvelope() failed for valid envelope: %v", err)
+			t.Errorf("processDatagram() failed for valid envelope: %v", err)
 		}
 	})
 }
@@ -1571,7 +1658,7 @@ func TestDatagram3Envelope_Roundtrip(t *testing.T) {
 	// Verify hash is correct (matches our local destination)
 	localDest := session.Destination()
 	localStream := i2cp.NewStream(nil)
-	localDest.WriteToStream(localStream)
+	localDest.WriteToMessage(localStream)
 	expectedHash := sha256.Sum256(localStream.Bytes())
 
 	var gotHash [32]byte
@@ -1608,14 +1695,14 @@ func TestEnvelope_MaxPayloadSize(t *testing.T) {
 			maxPayload: MaxI2NPSize - MinDatagram2Overhead,
 			overhead:   MinDatagram2Overhead,
 			buildEnvelope: func(payload []byte) ([]byte, error) {
-				// Uses WriteToStream for canonical hash computation, matching the implementation
+				// Uses WriteToMessage (wire format) for the hash, matching the implementation
 				localDest := session.Destination()
 				destStream := i2cp.NewStream(nil)
-				if err := localDest.WriteToStream(destStream); err != nil {
+				if err := localDest.WriteToMessage(destStream); err != nil {
 					return nil, err
 				}
 				targetHash := sha256.Sum256(destStream.Bytes())
-				return buildDatagram2Envelope(payload, session, targetHash)
+				return buildDatagram2EnvelopeWithOptions(payload, session, targetHash, nil)
 			},
 		},
 	}
@@ -1743,7 +1830,7 @@ func TestReadFrom_Datagram3(t *testing.T) {
 
 	// Compute fromhash
 	destStream := i2cp.NewStream(nil)
-	fromDest.WriteToStream(destStream)
+	fromDest.WriteToMessage(destStream)
 	fromHash := sha256.Sum256(destStream.Bytes())
 
 	// Build envelope
@@ -3063,7 +3150,7 @@ func TestReceiveFromWithAddr_Datagram3(t *testing.T) {
 	// Construct Datagram3 envelope: fromhash(32) + flags(2) + payload
 	payload := []byte("test message")
 	destStream := i2cp.NewStream(nil)
-	fromDest.WriteToStream(destStream)
+	fromDest.WriteToMessage(destStream)
 	fromHash := sha256.Sum256(destStream.Bytes())
 
 	envelope := make([]byte, 32+2+len(payload))
@@ -3135,7 +3222,7 @@ func TestReceiveFromWithAddr_Datagram3_WithOptions(t *testing.T) {
 
 	// Compute hash
 	destStream := i2cp.NewStream(nil)
-	fromDest.WriteToStream(destStream)
+	fromDest.WriteToMessage(destStream)
 	fromHash := sha256.Sum256(destStream.Bytes())
 
 	// Create options (empty options with 2-byte size = 0)
@@ -3766,15 +3853,15 @@ func TestReceiveFromWithAddr_Datagram2(t *testing.T) {
 	// Compute target destination hash (self-send scenario)
 	localDest := session.Destination()
 	destStream := i2cp.NewStream(nil)
-	if err := localDest.WriteToStream(destStream); err != nil {
-		t.Fatalf("WriteToStream() failed: %v", err)
+	if err := localDest.WriteToMessage(destStream); err != nil {
+		t.Fatalf("WriteToMessage() failed: %v", err)
 	}
 	targetHash := sha256.Sum256(destStream.Bytes())
 
 	// Build a valid Datagram2 envelope
-	envelope, err := buildDatagram2Envelope(payload, session, targetHash)
+	envelope, err := buildDatagram2EnvelopeWithOptions(payload, session, targetHash, nil)
 	if err != nil {
-		t.Fatalf("buildDatagram2Envelope() failed: %v", err)
+		t.Fatalf("buildDatagram2EnvelopeWithOptions() failed: %v", err)
 	}
 
 	// Inject the envelope
@@ -3913,15 +4000,15 @@ func TestReceiveFromWithOptions_Datagram2(t *testing.T) {
 	// Compute target hash (self-send)
 	localDest := session.Destination()
 	destStream := i2cp.NewStream(nil)
-	if err := localDest.WriteToStream(destStream); err != nil {
-		t.Fatalf("WriteToStream() failed: %v", err)
+	if err := localDest.WriteToMessage(destStream); err != nil {
+		t.Fatalf("WriteToMessage() failed: %v", err)
 	}
 	targetHash := sha256.Sum256(destStream.Bytes())
 
 	// Build Datagram2 without options
-	envelope, err := buildDatagram2Envelope(payload, session, targetHash)
+	envelope, err := buildDatagram2EnvelopeWithOptions(payload, session, targetHash, nil)
 	if err != nil {
-		t.Fatalf("buildDatagram2Envelope() failed: %v", err)
+		t.Fatalf("buildDatagram2EnvelopeWithOptions() failed: %v", err)
 	}
 
 	err = conn.injectMessage(envelope, session.dest, ProtocolDatagram2, 9090, 8080)
@@ -3962,8 +4049,8 @@ func TestReceiveFromWithOptions_Datagram2_WithOptions(t *testing.T) {
 	// Compute target hash (self-send)
 	localDest := session.Destination()
 	destStream := i2cp.NewStream(nil)
-	if err := localDest.WriteToStream(destStream); err != nil {
-		t.Fatalf("WriteToStream() failed: %v", err)
+	if err := localDest.WriteToMessage(destStream); err != nil {
+		t.Fatalf("WriteToMessage() failed: %v", err)
 	}
 	targetHash := sha256.Sum256(destStream.Bytes())
 
@@ -3999,3 +4086,548 @@ func TestReceiveFromWithOptions_Datagram2_WithOptions(t *testing.T) {
 		t.Error("expected From destination for Datagram2")
 	}
 }
+
+// TestDatagram2Envelope_MLDSA tests building and parsing Datagram2 envelopes whose
+// sender destination uses each ML-DSA sigtype.
+func TestDatagram2Envelope_MLDSA(t *testing.T) {
+	receiver := newMockSession()
+	targetHash, err := destinationHash(receiver.Destination())
+	if err != nil {
+		t.Fatalf("destinationHash() failed: %v", err)
+	}
+	options := NewOptions(map[string]string{"app": "pq"})
+	payload := []byte("post-quantum payload")
+
+	for _, sigType := range []uint16{SigTypeMLDSA44, SigTypeMLDSA65, SigTypeMLDSA87} {
+		t.Run(fmt.Sprintf("sigtype %d", sigType), func(t *testing.T) {
+			key, _ := newMLDSAEnvelopeKey(t, sigType)
+
+			envelope, err := encodeDatagram2(payload, key, targetHash, options)
+			if err != nil {
+				t.Fatalf("encodeDatagram2() failed: %v", err)
+			}
+			wantLen := datagram2Overhead(key.dest, nil) + options.Len() + len(payload)
+			if len(envelope) != wantLen {
+				t.Errorf("envelope length = %d, want %d", len(envelope), wantLen)
+			}
+
+			d2, err := openEnvelope(ProtocolDatagram2, envelope, targetHash)
+			if err != nil {
+				t.Fatalf("openEnvelope() failed: %v", err)
+			}
+			if !bytes.Equal(d2.Payload, payload) {
+				t.Errorf("payload = %q, want %q", d2.Payload, payload)
+			}
+			if d2.sender.hash() != key.dest.hash() {
+				t.Error("sender hash mismatch")
+			}
+			if d2.Options.Get("app") != "pq" {
+				t.Errorf("options[app] = %q, want %q", d2.Options.Get("app"), "pq")
+			}
+
+			// The *i2cp.Destination view is unavailable for ML-DSA senders
+			from, err := d2.sender.i2cpDestination()
+			if err != nil {
+				t.Fatalf("i2cpDestination() failed: %v", err)
+			}
+			if from != nil {
+				t.Error("expected nil *i2cp.Destination for ML-DSA sender")
+			}
+
+			// Sent to another destination, the signature must not verify
+			if _, err := openEnvelope(ProtocolDatagram2, envelope, targetHashOf(t, newMockSession())); err == nil {
+				t.Error("expected verification failure at a different recipient")
+			}
+		})
+	}
+}
+
+// TestDatagram2Envelope_OfflineMLDSATransient tests an Ed25519 destination that
+// signs through an offline-authorized ML-DSA-44 transient key.
+func TestDatagram2Envelope_OfflineMLDSATransient(t *testing.T) {
+	sender := newMockSession()
+	receiver := newMockSession()
+	targetHash, _ := destinationHash(receiver.Destination())
+
+	destKeys, err := sender.SigningKeyPair()
+	if err != nil {
+		t.Fatalf("SigningKeyPair() failed: %v", err)
+	}
+	transient, err := mldsa.GenerateKey(mldsa.MLDSA44())
+	if err != nil {
+		t.Fatalf("failed to generate transient key: %v", err)
+	}
+	offline := &OfflineSignature{
+		Expires:            time.Now().Add(time.Hour),
+		TransientSigType:   SigTypeMLDSA44,
+		TransientPublicKey: transient.PublicKey().Bytes(),
+	}
+	if offline.Signature, err = destKeys.Sign(offline.signedData()); err != nil {
+		t.Fatalf("failed to sign offline block: %v", err)
+	}
+
+	dest, err := localWireDestination(sender.Destination())
+	if err != nil {
+		t.Fatalf("localWireDestination() failed: %v", err)
+	}
+	key := &envelopeKey{
+		dest:    dest,
+		offline: offline,
+		signer:  mustSigner(t, transient),
+	}
+
+	envelope, err := encodeDatagram2([]byte("hello"), key, targetHash, nil)
+	if err != nil {
+		t.Fatalf("encodeDatagram2() failed: %v", err)
+	}
+	if want := datagram2Overhead(dest, offline) + 5; len(envelope) != want {
+		t.Errorf("envelope length = %d, want %d", len(envelope), want)
+	}
+
+	d2, err := openEnvelope(ProtocolDatagram2, envelope, targetHash)
+	if err != nil {
+		t.Fatalf("openEnvelope() failed: %v", err)
+	}
+	if d2.OfflineSignature == nil || d2.OfflineSignature.TransientSigType != SigTypeMLDSA44 {
+		t.Error("expected ML-DSA-44 offline signature in result")
+	}
+	if string(d2.Payload) != "hello" {
+		t.Errorf("payload = %q, want %q", d2.Payload, "hello")
+	}
+
+	// Datagram1 never carries offline signatures
+	if _, err := encodeDatagram1([]byte("hello"), key); err == nil {
+		t.Error("expected Datagram1 to reject an offline key")
+	}
+}
+
+// TestDatagram2Envelope_MLDSADestinationEd25519Transient tests an ML-DSA-65
+// destination authorizing an Ed25519 transient key.
+func TestDatagram2Envelope_MLDSADestinationEd25519Transient(t *testing.T) {
+	receiver := newMockSession()
+	targetHash, _ := destinationHash(receiver.Destination())
+
+	destKey, destPriv := newMLDSAEnvelopeKey(t, SigTypeMLDSA65)
+	transientPub, transientPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate transient key: %v", err)
+	}
+	offline := &OfflineSignature{
+		Expires:            time.Now().Add(time.Hour),
+		TransientSigType:   SigTypeEd25519,
+		TransientPublicKey: transientPub,
+	}
+	if offline.Signature, err = destPriv.Sign(nil, offline.signedData(), nil); err != nil {
+		t.Fatalf("failed to sign offline block: %v", err)
+	}
+
+	key := &envelopeKey{
+		dest:    destKey.dest,
+		offline: offline,
+		signer:  mustSigner(t, transientPriv),
+	}
+	envelope, err := encodeDatagram2([]byte("payload"), key, targetHash, nil)
+	if err != nil {
+		t.Fatalf("encodeDatagram2() failed: %v", err)
+	}
+	if _, err := openEnvelope(ProtocolDatagram2, envelope, targetHash); err != nil {
+		t.Fatalf("openEnvelope() failed: %v", err)
+	}
+
+	// An authorization signed by a different ML-DSA key must be rejected
+	_, otherPriv := newMLDSAEnvelopeKey(t, SigTypeMLDSA65)
+	forged := *offline
+	if forged.Signature, err = otherPriv.Sign(nil, offline.signedData(), nil); err != nil {
+		t.Fatalf("failed to sign forged block: %v", err)
+	}
+	key.offline = &forged
+	envelope, err = encodeDatagram2([]byte("payload"), key, targetHash, nil)
+	if err != nil {
+		t.Fatalf("encodeDatagram2() failed: %v", err)
+	}
+	if _, err := openEnvelope(ProtocolDatagram2, envelope, targetHash); err == nil {
+		t.Error("expected forged offline authorization to be rejected")
+	}
+}
+
+// TestDatagram1Envelope_MLDSA tests Datagram1 envelopes from an ML-DSA-87 destination.
+func TestDatagram1Envelope_MLDSA(t *testing.T) {
+	key, _ := newMLDSAEnvelopeKey(t, SigTypeMLDSA87)
+
+	envelope, err := encodeDatagram1([]byte("legacy"), key)
+	if err != nil {
+		t.Fatalf("encodeDatagram1() failed: %v", err)
+	}
+	// SPEC.md: destination + signature is 7226 bytes for ML-DSA-87
+	if overhead := datagram1Overhead(key.dest); overhead != 7226 {
+		t.Errorf("datagram1Overhead() = %d, want 7226", overhead)
+	}
+	if len(envelope) != 7226+len("legacy") {
+		t.Errorf("envelope length = %d, want %d", len(envelope), 7226+len("legacy"))
+	}
+
+	d1, err := openEnvelope(ProtocolDatagram1, envelope, [32]byte{})
+	if err != nil {
+		t.Fatalf("openEnvelope() failed: %v", err)
+	}
+	if string(d1.Payload) != "legacy" {
+		t.Errorf("payload = %q, want %q", d1.Payload, "legacy")
+	}
+	if d1.sender.sigType != SigTypeMLDSA87 {
+		t.Errorf("sender sigType = %d, want %d", d1.sender.sigType, SigTypeMLDSA87)
+	}
+
+	envelope[len(envelope)-1] ^= 0xFF
+	if _, err := openEnvelope(ProtocolDatagram1, envelope, [32]byte{}); err == nil {
+		t.Error("expected tampered payload to fail verification")
+	}
+}
+
+// TestReceiveFromWithAddr_Datagram2_MLDSA tests that ML-DSA senders are reported
+// with their Base64 destination and hash even though go-i2cp cannot represent them.
+func TestReceiveFromWithAddr_Datagram2_MLDSA(t *testing.T) {
+	session := newMockSession()
+	conn, err := NewDatagramConnWithProtocol(session, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	key, _ := newMLDSAEnvelopeKey(t, SigTypeMLDSA44)
+	targetHash, _ := destinationHash(session.Destination())
+	envelope, err := encodeDatagram2([]byte("pq"), key, targetHash, nil)
+	if err != nil {
+		t.Fatalf("encodeDatagram2() failed: %v", err)
+	}
+	if err := conn.injectMessage(envelope, nil, ProtocolDatagram2, 9000, 8080); err != nil {
+		t.Fatalf("injectMessage() failed: %v", err)
+	}
+
+	payload, addr, err := conn.ReceiveFromWithAddr()
+	if err != nil {
+		t.Fatalf("ReceiveFromWithAddr() failed: %v", err)
+	}
+	if string(payload) != "pq" {
+		t.Errorf("payload = %q, want %q", payload, "pq")
+	}
+	if addr.Destination != key.dest.base64() {
+		t.Error("expected Base64 destination of ML-DSA sender")
+	}
+	if addr.DestinationHash != key.dest.hash() {
+		t.Error("expected destination hash of ML-DSA sender")
+	}
+	if addr.Port != 9000 {
+		t.Errorf("port = %d, want 9000", addr.Port)
+	}
+}
+
+// TestDatagram2Overhead_KeyTypes tests overhead accounting for different key types.
+func TestDatagram2Overhead_KeyTypes(t *testing.T) {
+	session := newMockSession()
+	conn, err := NewDatagramConnWithProtocol(session, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	if got := datagram2Overhead(conn.localWire, nil); got != MinDatagram2Overhead {
+		t.Errorf("Ed25519 overhead = %d, want %d", got, MinDatagram2Overhead)
+	}
+
+	offline := &OfflineSignature{
+		TransientSigType:   SigTypeMLDSA44,
+		TransientPublicKey: make([]byte, 1312),
+		Signature:          make([]byte, 64),
+	}
+	// dest(391) + flags(2) + offline(6+1312+64) + ML-DSA-44 signature(2420)
+	if got, want := datagram2Overhead(conn.localWire, offline), 391+2+1382+2420; got != want {
+		t.Errorf("offline ML-DSA-44 overhead = %d, want %d", got, want)
+	}
+
+	key, _ := newMLDSAEnvelopeKey(t, SigTypeMLDSA44)
+	// SPEC.md: destination + signature is 3739 bytes for ML-DSA-44, plus 2 bytes of flags
+	if got := datagram2Overhead(key.dest, nil); got != 3741 {
+		t.Errorf("ML-DSA-44 overhead = %d, want 3741", got)
+	}
+}
+
+// pipelineCase is one row of the cross-protocol receive pipeline matrix.
+type pipelineCase struct {
+	name     string
+	protocol uint8
+	options  *Options
+}
+
+// pipelineCases covers every receivable protocol, with and without options.
+var pipelineCases = []pipelineCase{
+	{"Raw", ProtocolRaw, nil},
+	{"Datagram1", ProtocolDatagram1, nil},
+	{"Datagram2", ProtocolDatagram2, nil},
+	{"Datagram2 with options", ProtocolDatagram2, NewOptions(map[string]string{"k": "v"})},
+	{"Datagram3", ProtocolDatagram3, nil},
+	{"Datagram3 with options", ProtocolDatagram3, NewOptions(map[string]string{"k": "v"})},
+}
+
+// buildPipelineEnvelope builds an envelope from sender addressed to receiver.
+func buildPipelineEnvelope(t *testing.T, tc pipelineCase, sender, receiver *mockSession, payload []byte) []byte {
+	t.Helper()
+	var envelope []byte
+	var err error
+	switch tc.protocol {
+	case ProtocolRaw:
+		envelope = payload
+	case ProtocolDatagram1:
+		envelope, err = buildDatagram1Envelope(payload, sender)
+	case ProtocolDatagram2:
+		envelope, err = buildDatagram2EnvelopeWithOptions(payload, sender, targetHashOf(t, receiver), tc.options)
+	case ProtocolDatagram3:
+		envelope, err = buildDatagram3EnvelopeWithOptions(payload, sender, tc.options)
+	}
+	if err != nil {
+		t.Fatalf("failed to build %s envelope: %v", tc.name, err)
+	}
+	return envelope
+}
+
+// TestReceivePipeline_Matrix tests that ReceiveFrom, ReceiveFromWithAddr,
+// ReceiveFromWithOptions, ReadFrom and port handlers present identical views of
+// the same datagram for every protocol.
+func TestReceivePipeline_Matrix(t *testing.T) {
+	for _, tc := range pipelineCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sender := newMockSession()
+			receiver := newMockSession()
+			payload := []byte("matrix payload")
+			envelope := buildPipelineEnvelope(t, tc, sender, receiver, payload)
+
+			conn, err := NewDatagramConnWithProtocol(receiver, 8080, tc.protocol)
+			if err != nil {
+				t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+			}
+			defer conn.Close()
+
+			// Raw datagrams only know the sender from I2CP metadata
+			var meta *i2cp.Destination
+			if tc.protocol == ProtocolRaw {
+				meta = sender.Destination()
+			}
+			inject := func() {
+				t.Helper()
+				if err := conn.injectMessage(envelope, meta, tc.protocol, 9000, 8080); err != nil {
+					t.Fatalf("injectMessage() failed: %v", err)
+				}
+			}
+
+			inject()
+			want, err := conn.ReceiveFromWithOptions()
+			if err != nil {
+				t.Fatalf("ReceiveFromWithOptions() failed: %v", err)
+			}
+			if !bytes.Equal(want.Payload, payload) {
+				t.Errorf("Payload = %q, want %q", want.Payload, payload)
+			}
+			if want.FromHash != targetHashOf(t, sender) {
+				t.Error("FromHash does not match sender")
+			}
+			if want.FromAddr == nil || want.FromAddr.DestinationHash != want.FromHash || want.FromAddr.Port != 9000 {
+				t.Errorf("FromAddr = %+v, inconsistent with FromHash and SrcPort", want.FromAddr)
+			}
+			if tc.protocol == ProtocolDatagram3 {
+				if want.From != nil || want.FromAddr.Destination != "" {
+					t.Error("Datagram3 must be hash-only")
+				}
+			} else if want.From == nil || want.FromAddr.Destination != sender.Destination().Base64() {
+				t.Error("expected full sender destination")
+			}
+			if (tc.options != nil) != (want.Options != nil) {
+				t.Errorf("Options = %v, want present=%v", want.Options, tc.options != nil)
+			}
+			authenticated := tc.protocol == ProtocolDatagram1 || tc.protocol == ProtocolDatagram2
+			wantSigType := uint16(0)
+			if authenticated {
+				wantSigType = SigTypeEd25519
+			}
+			if want.Protocol != tc.protocol || want.Authenticated != authenticated || want.SigType != wantSigType {
+				t.Errorf("Protocol = %d, Authenticated = %v, SigType = %d", want.Protocol, want.Authenticated, want.SigType)
+			}
+			if want.OfflineSignature != nil || want.ReceivedAt.IsZero() || time.Since(want.ReceivedAt) > time.Minute {
+				t.Errorf("OfflineSignature = %v, ReceivedAt = %v", want.OfflineSignature, want.ReceivedAt)
+			}
+
+			inject()
+			p, from, port, err := conn.ReceiveFrom()
+			if err != nil {
+				t.Fatalf("ReceiveFrom() failed: %v", err)
+			}
+			sameFrom := (from == nil) == (want.From == nil) && (from == nil || from.Base64() == want.From.Base64())
+			if !bytes.Equal(p, want.Payload) || !sameFrom || port != want.SrcPort {
+				t.Error("ReceiveFrom() view differs from ReceiveResult")
+			}
+
+			inject()
+			p, addr, err := conn.ReceiveFromWithAddr()
+			if err != nil {
+				t.Fatalf("ReceiveFromWithAddr() failed: %v", err)
+			}
+			if !bytes.Equal(p, want.Payload) || !addr.Equal(want.FromAddr) || addr.DestinationHash != want.FromAddr.DestinationHash {
+				t.Error("ReceiveFromWithAddr() view differs from ReceiveResult")
+			}
+
+			inject()
+			buf := make([]byte, 128)
+			n, netAddr, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("ReadFrom() failed: %v", err)
+			}
+			readAddr, ok := netAddr.(*I2PAddr)
+			if !ok || !bytes.Equal(buf[:n], want.Payload) || !readAddr.Equal(want.FromAddr) || readAddr.DestinationHash != want.FromHash {
+				t.Error("ReadFrom() view differs from ReceiveResult")
+			}
+
+			got := make(chan []byte, 1)
+			if err := conn.RegisterPort(8080, func(p []byte, _ *i2cp.Destination) { got <- p }); err != nil {
+				t.Fatalf("RegisterPort() failed: %v", err)
+			}
+			inject()
+			select {
+			case p := <-got:
+				if !bytes.Equal(p, want.Payload) {
+					t.Errorf("handler payload = %q, want %q", p, want.Payload)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("handler not called")
+			}
+		})
+	}
+}
+
+// TestReceivePipeline_MalformedMatrix tests that every receive path rejects the same
+// malformed envelopes, and that handlers never see them.
+func TestReceivePipeline_MalformedMatrix(t *testing.T) {
+	malformed := map[string]struct {
+		protocol uint8
+		build    func(valid []byte) []byte
+	}{
+		"Datagram1 tampered":        {ProtocolDatagram1, func(v []byte) []byte { v[len(v)-1] ^= 0xFF; return v }},
+		"Datagram2 tampered":        {ProtocolDatagram2, func(v []byte) []byte { v[len(v)-1] ^= 0xFF; return v }},
+		"Datagram2 truncated":       {ProtocolDatagram2, func(v []byte) []byte { return v[:100] }},
+		"Datagram3 reserved bits":   {ProtocolDatagram3, func(v []byte) []byte { v[32] = 0x01; return v }},
+		"Datagram3 bad version":     {ProtocolDatagram3, func(v []byte) []byte { v[33] = 0x02; return v }},
+		"Datagram3 truncated":       {ProtocolDatagram3, func(v []byte) []byte { return v[:20] }},
+		"Datagram3 options overrun": {ProtocolDatagram3, func(v []byte) []byte { v[33] |= 0x10; v[34], v[35] = 0xFF, 0xFF; return v }},
+	}
+
+	for name, m := range malformed {
+		t.Run(name, func(t *testing.T) {
+			sender := newMockSession()
+			receiver := newMockSession()
+			tc := pipelineCase{name: name, protocol: m.protocol}
+
+			conn, err := NewDatagramConnWithProtocol(receiver, 8080, m.protocol)
+			if err != nil {
+				t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+			}
+			defer conn.Close()
+
+			inject := func() {
+				t.Helper()
+				envelope := m.build(buildPipelineEnvelope(t, tc, sender, receiver, []byte("payload")))
+				if err := conn.injectMessage(envelope, nil, m.protocol, 9000, 8080); err != nil {
+					t.Fatalf("injectMessage() failed: %v", err)
+				}
+			}
+
+			inject()
+			if _, _, _, err := conn.ReceiveFrom(); err == nil {
+				t.Error("ReceiveFrom() accepted malformed envelope")
+			}
+			inject()
+			if _, _, err := conn.ReceiveFromWithAddr(); err == nil {
+				t.Error("ReceiveFromWithAddr() accepted malformed envelope")
+			}
+			inject()
+			if _, err := conn.ReceiveFromWithOptions(); err == nil {
+				t.Error("ReceiveFromWithOptions() accepted malformed envelope")
+			}
+			inject()
+			if _, _, err := conn.ReadFrom(make([]byte, 64)); err == nil {
+				t.Error("ReadFrom() accepted malformed envelope")
+			}
+
+			called := make(chan struct{}, 1)
+			conn.RegisterPort(8080, func([]byte, *i2cp.Destination) { called <- struct{}{} })
+			inject()
+			select {
+			case <-called:
+				t.Error("handler called for malformed envelope")
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// TestReceiveResult_OfflineMetadata tests that offline Datagram2 senders are reported
+// with the transient sigtype and the offline signature block.
+func TestReceiveResult_OfflineMetadata(t *testing.T) {
+	sender := newMockSession()
+	receiver := newMockSession()
+
+	out, err := NewDatagramConnWithProtocol(sender, 9000, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer out.Close()
+
+	transient, _ := mldsa.GenerateKey(mldsa.MLDSA44())
+	offline := &OfflineSignature{
+		Expires:            time.Now().Add(time.Hour).Truncate(time.Second),
+		TransientSigType:   SigTypeMLDSA44,
+		TransientPublicKey: transient.PublicKey().Bytes(),
+	}
+	destKeys, _ := sender.SigningKeyPair()
+	offline.Signature, _ = destKeys.Sign(offline.signedData())
+	offSigner, err := NewOfflineSigner(mustSigner(t, transient), offline)
+	if err != nil {
+		t.Fatalf("NewOfflineSigner() failed: %v", err)
+	}
+	if err := out.SetSigner(offSigner); err != nil {
+		t.Fatalf("SetSigner() failed: %v", err)
+	}
+	if err := out.SendTo([]byte("offline"), receiver.Destination().Base64(), 8080); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+
+	in, err := NewDatagramConnWithProtocol(receiver, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer in.Close()
+	before := time.Now()
+	in.injectMessage(sender.lastPayload, nil, ProtocolDatagram2, 9000, 8080)
+
+	result, err := in.ReceiveFromWithOptions()
+	if err != nil {
+		t.Fatalf("ReceiveFromWithOptions() failed: %v", err)
+	}
+	if !result.Authenticated || result.Protocol != ProtocolDatagram2 || result.SigType != SigTypeMLDSA44 {
+		t.Errorf("Authenticated = %v, Protocol = %d, SigType = %d", result.Authenticated, result.Protocol, result.SigType)
+	}
+	if result.OfflineSignature == nil || !result.OfflineSignature.Expires.Equal(offline.Expires) {
+		t.Errorf("OfflineSignature = %+v, want expiry %v", result.OfflineSignature, offline.Expires)
+	}
+	if result.ReceivedAt.Before(before) {
+		t.Errorf("ReceivedAt = %v, before injection at %v", result.ReceivedAt, before)
+	}
+
+	// Unverified delivery keeps the metadata but is not authenticated
+	if err := in.SetVerifyPolicy(VerifyPolicy{Mode: VerifyNone}); err != nil {
+		t.Fatalf("SetVerifyPolicy() failed: %v", err)
+	}
+	in.injectMessage(sender.lastPayload, nil, ProtocolDatagram2, 9000, 8080)
+	if result, err = in.ReceiveFromWithOptions(); err != nil {
+		t.Fatalf("ReceiveFromWithOptions() failed: %v", err)
+	}
+	if result.Authenticated || result.SigType != SigTypeMLDSA44 || result.OfflineSignature == nil {
+		t.Errorf("Authenticated = %v, SigType = %d, OfflineSignature = %v", result.Authenticated, result.SigType, result.OfflineSignature)
+	}
+}
@@ -0,0 +1,100 @@
+package datagrams
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// DefaultDestinationCacheSize is the number of sender destinations a DatagramConn
+// remembers, so repeat senders of Datagram1/Datagram2 skip re-parsing.
+const DefaultDestinationCacheSize = 1024
+
+// destinationCache is an LRU cache of parsed sender destinations keyed by the
+// SHA-256 hash of their wire format. A nil *destinationCache parses every time.
+type destinationCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[[32]byte]*list.Element
+	order   *list.List // front is most recently used; values are *wireDestination
+	hits    uint64
+	misses  uint64
+}
+
+// newDestinationCache creates a cache holding up to max destinations.
+// Returns nil, which disables caching, if max is not positive.
+func newDestinationCache(max int) *destinationCache {
+	if max <= 0 {
+		return nil
+	}
+	return &destinationCache{
+		max:     max,
+		entries: make(map[[32]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// parse has the semantics of parseWireDestination, returning a cached
+// *wireDestination when the same destination bytes were seen before.
+// The length is read from the certificate header, so a hit costs one SHA-256.
+func (c *destinationCache) parse(data []byte) (*wireDestination, int, error) {
+	if c == nil || len(data) < destinationKeyAreaSize+3 {
+		return parseWireDestination(data)
+	}
+	total := destinationKeyAreaSize + 3 + int(binary.BigEndian.Uint16(data[destinationKeyAreaSize+1:destinationKeyAreaSize+3]))
+	if len(data) < total {
+		return parseWireDestination(data)
+	}
+	key := sha256.Sum256(data[:total])
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.hits++
+		c.mu.Unlock()
+		return elem.Value.(*wireDestination), total, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	w, n, err := parseWireDestination(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	w.hashOnce.Do(func() { w.hashValue = key })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		// Another goroutine parsed the same destination concurrently
+		return elem.Value.(*wireDestination), n, nil
+	}
+	c.entries[key] = c.order.PushFront(w)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*wireDestination).hash())
+	}
+	return w, n, nil
+}
+
+// stats returns the number of cache hits and misses.
+func (c *destinationCache) stats() (hits, misses uint64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// len returns the number of cached destinations.
+func (c *destinationCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,74 @@
+package datagrams
+
+import (
+	"testing"
+
+	i2cp "github.com/go-i2p/go-i2cp"
+)
+
+// wireBytesOf returns the wire format of a new Ed25519 destination.
+func wireBytesOf(t *testing.T) []byte {
+	t.Helper()
+	dest, _ := i2cp.NewDestination(i2cp.NewCrypto())
+	w, err := localWireDestination(dest)
+	if err != nil {
+		t.Fatalf("localWireDestination() failed: %v", err)
+	}
+	return w.raw
+}
+
+// TestDestinationCache tests hits, LRU eviction and error passthrough.
+func TestDestinationCache(t *testing.T) {
+	c := newDestinationCache(2)
+	a, b, d := wireBytesOf(t), wireBytesOf(t), wireBytesOf(t)
+
+	first, n, err := c.parse(append(a, "trailing payload"...))
+	if err != nil || n != len(a) {
+		t.Fatalf("parse() = %d, %v", n, err)
+	}
+	second, _, _ := c.parse(a)
+	if first != second {
+		t.Error("repeat parse should return the cached destination")
+	}
+
+	c.parse(b) // cache: b, a
+	c.parse(a) // cache: a, b
+	c.parse(d) // evicts b
+	if c.len() != 2 {
+		t.Errorf("len() = %d, want 2", c.len())
+	}
+	if hits, misses := c.stats(); hits != 2 || misses != 3 {
+		t.Errorf("stats() = %d hits, %d misses, want 2 and 3", hits, misses)
+	}
+	c.parse(b)
+	if _, misses := c.stats(); misses != 4 {
+		t.Errorf("evicted destination should miss, misses = %d", misses)
+	}
+
+	if _, _, err := c.parse(a[:100]); err == nil {
+		t.Error("expected error for truncated destination")
+	}
+	if _, _, err := c.parse(a[:len(a)-1]); err == nil {
+		t.Error("expected error for truncated certificate")
+	}
+}
+
+// TestDestinationCache_Disabled tests that a nil cache parses every time.
+func TestDestinationCache_Disabled(t *testing.T) {
+	c := newDestinationCache(0)
+	if c != nil {
+		t.Fatal("newDestinationCache(0) should return nil")
+	}
+	raw := wireBytesOf(t)
+	first, _, err := c.parse(raw)
+	if err != nil {
+		t.Fatalf("parse() failed: %v", err)
+	}
+	second, _, _ := c.parse(raw)
+	if first == second {
+		t.Error("disabled cache should not share destinations")
+	}
+	if first.hash() != second.hash() || c.len() != 0 {
+		t.Error("unexpected disabled cache state")
+	}
+}
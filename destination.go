@@ -0,0 +1,258 @@
+package datagrams
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/go-i2p/common/base64"
+	i2cp "github.com/go-i2p/go-i2cp"
+)
+
+// Destination wire format constants.
+//
+// A destination is serialized as a 384-byte key area followed by a certificate:
+//
+//	+----+----+----+----+----+----+----+----+
+//	| crypto public key | padding | signing  |
+//	~   public key (384 bytes total)         ~
+//	+----+----+----+----+----+----+----+----+
+//	|type| length  | sigtype | crypto  | excess key data ...
+//	+----+----+----+----+----+----+----+----+
+const (
+	// destinationKeyAreaSize is the size of the combined crypto + signing key area.
+	destinationKeyAreaSize = 384
+
+	// certTypeNull is the NULL certificate (legacy DSA-SHA1 + ElGamal destinations).
+	certTypeNull = 0
+
+	// certTypeKey is the KEY certificate carrying sigtype, crypto type and excess key data.
+	certTypeKey = 5
+
+	// cryptoTypeNone (255) marks a destination without an encryption key.
+	// Reserved by Proposal 169 for post-quantum destinations, where the whole
+	// key area is available for the signing public key.
+	cryptoTypeNone = 255
+)
+
+// cryptoPublicKeyLength returns the length of the encryption public key stored in
+// the destination key area for a crypto type. Returns -1 for unknown types.
+func cryptoPublicKeyLength(cryptoType uint16) int {
+	switch cryptoType {
+	case 0: // ElGamal
+		return 256
+	case 1: // P256
+		return 64
+	case 2: // P384
+		return 96
+	case 3: // P521
+		return 132
+	case 4, 5, 6, 7: // X25519 and MLKEM-X25519 hybrids
+		return 32
+	case cryptoTypeNone:
+		return 0
+	default:
+		return -1
+	}
+}
+
+// wireDestination is a destination parsed directly from its I2CP wire format.
+//
+// Unlike *i2cp.Destination, which only supports Ed25519, wireDestination handles
+// any signature type with a known key length, including ML-DSA destinations whose
+// signing keys overflow the 384-byte key area into the KEY certificate.
+type wireDestination struct {
+	// raw is the exact wire encoding, used for hashing and re-encoding.
+	raw []byte
+
+	// sigType is the signature type of the signing public key.
+	sigType uint16
+
+	// cryptoType is the encryption key type declared by the certificate.
+	cryptoType uint16
+
+	// signingPublicKey is the reassembled signing public key.
+	signingPublicKey []byte
+
+	// hashOnce and i2cpOnce memoize hash and i2cpDestination, since cached
+	// destinations are shared by every datagram from the same sender.
+	hashOnce  sync.Once
+	hashValue [32]byte
+	i2cpOnce  sync.Once
+	i2cpDest  *i2cp.Destination
+	i2cpErr   error
+}
+
+// parseWireDestination parses a destination from the start of data.
+// Returns the destination and the number of bytes consumed.
+//
+// Signing key placement follows the I2P common structures rules: the crypto public key
+// sits at the start of the key area and the signing key is right-aligned at its end.
+// When the signing key is larger than the space left after the crypto key, the first
+// part fills that space and the remainder is carried as excess data in the KEY
+// certificate. For ML-DSA destinations (crypto type NONE) this gives the 1319-2599 byte
+// destinations described in SPEC.md's PQ section.
+func parseWireDestination(data []byte) (*wireDestination, int, error) {
+	if len(data) < destinationKeyAreaSize+3 {
+		return nil, 0, fmt.Errorf("destination too short: %d bytes (need at least %d)", len(data), destinationKeyAreaSize+3)
+	}
+
+	certType := data[destinationKeyAreaSize]
+	certLen := int(binary.BigEndian.Uint16(data[destinationKeyAreaSize+1 : destinationKeyAreaSize+3]))
+	total := destinationKeyAreaSize + 3 + certLen
+	if len(data) < total {
+		return nil, 0, fmt.Errorf("destination certificate truncated: need %d bytes, have %d", total, len(data))
+	}
+	certPayload := data[destinationKeyAreaSize+3 : total]
+
+	var sigType, cryptoType uint16
+	var excess []byte
+	switch certType {
+	case certTypeNull:
+		sigType, cryptoType = SigTypeDSASHA1, 0
+	case certTypeKey:
+		if len(certPayload) < 4 {
+			return nil, 0, fmt.Errorf("KEY certificate too short: %d bytes (need at least 4)", len(certPayload))
+		}
+		sigType = binary.BigEndian.Uint16(certPayload[0:2])
+		cryptoType = binary.BigEndian.Uint16(certPayload[2:4])
+		excess = certPayload[4:]
+	default:
+		return nil, 0, fmt.Errorf("unsupported destination certificate type: %d", certType)
+	}
+
+	keyLen := publicKeyLengthForSigType(sigType)
+	if keyLen == 0 {
+		return nil, 0, fmt.Errorf("unsupported destination sigtype: %d", sigType)
+	}
+	cryptoLen := cryptoPublicKeyLength(cryptoType)
+	if cryptoLen < 0 {
+		return nil, 0, fmt.Errorf("unsupported destination crypto type: %d", cryptoType)
+	}
+
+	available := destinationKeyAreaSize - cryptoLen
+	signingKey := make([]byte, keyLen)
+	if keyLen <= available {
+		copy(signingKey, data[destinationKeyAreaSize-keyLen:destinationKeyAreaSize])
+	} else {
+		overflow := keyLen - available
+		if len(excess) < overflow {
+			return nil, 0, fmt.Errorf("KEY certificate missing %d bytes of excess signing key data", overflow-len(excess))
+		}
+		copy(signingKey, data[cryptoLen:destinationKeyAreaSize])
+		copy(signingKey[available:], excess[:overflow])
+	}
+
+	raw := make([]byte, total)
+	copy(raw, data[:total])
+
+	return &wireDestination{
+		raw:              raw,
+		sigType:          sigType,
+		cryptoType:       cryptoType,
+		signingPublicKey: signingKey,
+	}, total, nil
+}
+
+// encodeWireDestination builds the wire format of a destination with a KEY certificate.
+// The inverse of parseWireDestination; cryptoPublicKey may be nil for crypto type NONE.
+func encodeWireDestination(sigType, cryptoType uint16, cryptoPublicKey, signingPublicKey []byte) ([]byte, error) {
+	if keyLen := publicKeyLengthForSigType(sigType); keyLen == 0 || keyLen != len(signingPublicKey) {
+		return nil, fmt.Errorf("signing public key length %d does not match sigtype %d", len(signingPublicKey), sigType)
+	}
+	cryptoLen := cryptoPublicKeyLength(cryptoType)
+	if cryptoLen < 0 || cryptoLen != len(cryptoPublicKey) {
+		return nil, fmt.Errorf("crypto public key length %d does not match crypto type %d", len(cryptoPublicKey), cryptoType)
+	}
+
+	available := destinationKeyAreaSize - cryptoLen
+	var excess []byte
+	keyArea := make([]byte, destinationKeyAreaSize)
+	copy(keyArea, cryptoPublicKey)
+	if len(signingPublicKey) <= available {
+		copy(keyArea[destinationKeyAreaSize-len(signingPublicKey):], signingPublicKey)
+	} else {
+		copy(keyArea[cryptoLen:], signingPublicKey[:available])
+		excess = signingPublicKey[available:]
+	}
+
+	certLen := 4 + len(excess)
+	out := make([]byte, 0, destinationKeyAreaSize+3+certLen)
+	out = append(out, keyArea...)
+	out = append(out, certTypeKey)
+	out = binary.BigEndian.AppendUint16(out, uint16(certLen))
+	out = binary.BigEndian.AppendUint16(out, sigType)
+	out = binary.BigEndian.AppendUint16(out, cryptoType)
+	out = append(out, excess...)
+	return out, nil
+}
+
+// parseDestinationB64 parses a destination from its I2P base64 encoding.
+func parseDestinationB64(b64 string) (*wireDestination, error) {
+	data, err := base64.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination encoding: %w", err)
+	}
+	w, n, err := parseWireDestination(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, fmt.Errorf("destination has %d trailing bytes", len(data)-n)
+	}
+	return w, nil
+}
+
+// hash returns the SHA-256 hash of the destination's wire format.
+func (w *wireDestination) hash() [32]byte {
+	w.hashOnce.Do(func() {
+		w.hashValue = sha256.Sum256(w.raw)
+	})
+	return w.hashValue
+}
+
+// base64 returns the I2P base64 encoding of the destination's wire format.
+func (w *wireDestination) base64() string {
+	return base64.EncodeToString(w.raw)
+}
+
+// signatureLength returns the length of signatures made by this destination's key.
+func (w *wireDestination) signatureLength() int {
+	return signatureLengthForSigType(w.sigType)
+}
+
+// verify checks a signature made directly by the destination's signing key.
+func (w *wireDestination) verify(message, signature []byte) bool {
+	return verifySignatureForSigType(w.sigType, w.signingPublicKey, message, signature)
+}
+
+// i2cpDestination converts the destination to an *i2cp.Destination.
+// go-i2cp only represents Ed25519 destinations, so this returns nil for other sigtypes.
+// The result is memoized.
+func (w *wireDestination) i2cpDestination() (*i2cp.Destination, error) {
+	if w.sigType != SigTypeEd25519 {
+		return nil, nil
+	}
+	w.i2cpOnce.Do(func() {
+		w.i2cpDest, w.i2cpErr = i2cp.NewDestinationFromMessage(i2cp.NewStream(w.raw), i2cp.NewCrypto())
+		if w.i2cpErr != nil {
+			w.i2cpErr = fmt.Errorf("failed to parse Ed25519 destination: %w", w.i2cpErr)
+		}
+	})
+	return w.i2cpDest, w.i2cpErr
+}
+
+// localWireDestination serializes an *i2cp.Destination and parses it into a
+// wireDestination, so local and remote destinations share one representation.
+func localWireDestination(dest *i2cp.Destination) (*wireDestination, error) {
+	if dest == nil {
+		return nil, fmt.Errorf("session has no destination")
+	}
+	stream := i2cp.NewStream(nil)
+	if err := dest.WriteToMessage(stream); err != nil {
+		return nil, fmt.Errorf("failed to serialize destination: %w", err)
+	}
+	w, _, err := parseWireDestination(stream.Bytes())
+	return w, err
+}
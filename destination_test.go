@@ -0,0 +1,144 @@
+package datagrams
+
+import (
+	"bytes"
+	"crypto/mldsa"
+	"testing"
+
+	i2cp "github.com/go-i2p/go-i2cp"
+)
+
+// newMLDSAEnvelopeKey creates an envelopeKey for a fresh ML-DSA destination
+// (crypto type NONE) of the given sigtype.
+func newMLDSAEnvelopeKey(t *testing.T, sigType uint16) (*envelopeKey, *mldsa.PrivateKey) {
+	t.Helper()
+	params, ok := mldsaParameters(sigType)
+	if !ok {
+		t.Fatalf("sigtype %d is not ML-DSA", sigType)
+	}
+	priv, err := mldsa.GenerateKey(params)
+	if err != nil {
+		t.Fatalf("failed to generate ML-DSA key: %v", err)
+	}
+	raw, err := encodeWireDestination(sigType, cryptoTypeNone, nil, priv.PublicKey().Bytes())
+	if err != nil {
+		t.Fatalf("encodeWireDestination() failed: %v", err)
+	}
+	dest, _, err := parseWireDestination(raw)
+	if err != nil {
+		t.Fatalf("parseWireDestination() failed: %v", err)
+	}
+	return &envelopeKey{dest: dest, signer: mustSigner(t, priv)}, priv
+}
+
+// TestParseWireDestination_Ed25519 tests that go-i2cp destinations parse and
+// re-encode to identical bytes with the same hash.
+func TestParseWireDestination_Ed25519(t *testing.T) {
+	dest, err := i2cp.NewDestination(i2cp.NewCrypto())
+	if err != nil {
+		t.Fatalf("failed to create destination: %v", err)
+	}
+	stream := i2cp.NewStream(nil)
+	if err := dest.WriteToMessage(stream); err != nil {
+		t.Fatalf("WriteToMessage() failed: %v", err)
+	}
+	wire := stream.Bytes()
+
+	parsed, consumed, err := parseWireDestination(append(append([]byte{}, wire...), 0x01, 0x02))
+	if err != nil {
+		t.Fatalf("parseWireDestination() failed: %v", err)
+	}
+	if consumed != Ed25519DestinationSize {
+		t.Errorf("consumed = %d, want %d", consumed, Ed25519DestinationSize)
+	}
+	if parsed.sigType != SigTypeEd25519 {
+		t.Errorf("sigType = %d, want %d", parsed.sigType, SigTypeEd25519)
+	}
+	if !bytes.Equal(parsed.raw, wire) {
+		t.Error("raw bytes mismatch")
+	}
+	if parsed.hash() != dest.Hash() {
+		t.Error("hash does not match go-i2cp Destination.Hash()")
+	}
+	if parsed.base64() != dest.Base64() {
+		t.Error("base64 does not match go-i2cp Destination.Base64()")
+	}
+
+	converted, err := parsed.i2cpDestination()
+	if err != nil {
+		t.Fatalf("i2cpDestination() failed: %v", err)
+	}
+	if converted == nil || converted.Base64() != dest.Base64() {
+		t.Error("converted destination mismatch")
+	}
+}
+
+// TestParseWireDestination_MLDSA tests encoding and parsing ML-DSA destinations,
+// whose signing keys overflow into the KEY certificate.
+func TestParseWireDestination_MLDSA(t *testing.T) {
+	testCases := []struct {
+		name    string
+		sigType uint16
+		wantLen int
+	}{
+		{"ML-DSA-44", SigTypeMLDSA44, 1319},
+		{"ML-DSA-65", SigTypeMLDSA65, 1959},
+		{"ML-DSA-87", SigTypeMLDSA87, 2599},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, priv := newMLDSAEnvelopeKey(t, tc.sigType)
+			if len(key.dest.raw) != tc.wantLen {
+				t.Errorf("destination length = %d, want %d", len(key.dest.raw), tc.wantLen)
+			}
+			if !bytes.Equal(key.dest.signingPublicKey, priv.PublicKey().Bytes()) {
+				t.Error("signing public key mismatch after roundtrip")
+			}
+			if key.dest.cryptoType != cryptoTypeNone {
+				t.Errorf("cryptoType = %d, want %d", key.dest.cryptoType, cryptoTypeNone)
+			}
+			converted, err := key.dest.i2cpDestination()
+			if err != nil || converted != nil {
+				t.Errorf("i2cpDestination() = %v, %v; want nil, nil for ML-DSA", converted, err)
+			}
+		})
+	}
+}
+
+// TestParseWireDestination_Errors tests rejection of malformed destinations.
+func TestParseWireDestination_Errors(t *testing.T) {
+	key, _ := newMLDSAEnvelopeKey(t, SigTypeMLDSA44)
+	valid := key.dest.raw
+
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", make([]byte, 100)},
+		{"truncated certificate", valid[:len(valid)-1]},
+		{"unsupported certificate type", append(append(make([]byte, 384), 0x03), 0x00, 0x00)},
+		{"short KEY certificate", append(append(make([]byte, 384), certTypeKey), 0x00, 0x02, 0x00, 0x07)},
+		{"unknown sigtype", append(append(make([]byte, 384), certTypeKey), 0x00, 0x04, 0x00, 0x63, 0x00, 0x00)},
+		{"unknown crypto type", append(append(make([]byte, 384), certTypeKey), 0x00, 0x04, 0x00, 0x07, 0x00, 0x63)},
+		{"missing excess key data", append(append(make([]byte, 384), certTypeKey), 0x00, 0x04, 0x00, 0x0C, 0x00, 0xFF)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := parseWireDestination(tc.data); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// TestEncodeWireDestination_KeyLengthMismatch tests that keys must match their sigtype.
+func TestEncodeWireDestination_KeyLengthMismatch(t *testing.T) {
+	if _, err := encodeWireDestination(SigTypeMLDSA44, cryptoTypeNone, nil, make([]byte, 32)); err == nil {
+		t.Error("expected error for wrong signing key length")
+	}
+	if _, err := encodeWireDestination(SigTypeEd25519, 0, make([]byte, 32), make([]byte, 32)); err == nil {
+		t.Error("expected error for wrong crypto key length")
+	}
+}
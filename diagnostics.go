@@ -0,0 +1,371 @@
+package datagrams
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Well-known ports for the diagnostic services, after the echo (RFC 862) and
+// discard (RFC 863) services. Services may be mounted on any port.
+const (
+	EchoPort    uint16 = 7
+	DiscardPort uint16 = 9
+)
+
+// DefaultPingReplyPort is the port Ping receives echo replies on.
+const DefaultPingReplyPort uint16 = 65533
+
+// pingMagic starts ping frames: magic(4) + id(8) + reply port(2) + reply
+// destination. The reply destination is the pinger's wire destination on
+// protocols that do not carry one (Raw, Datagram3), and empty otherwise. Echo
+// replies are the frame without the reply destination.
+var pingMagic = []byte("i2pP")
+
+const pingHeaderSize = 4 + 8 + 2
+
+// WhoAmIReportSize is the size of a whoami report: hash(32) + protocol(1) +
+// flags(1) + source port(2).
+const WhoAmIReportSize = 32 + 1 + 1 + 2
+
+// whoamiAuthenticated flags reports of authenticated datagrams.
+const whoamiAuthenticated = 0x01
+
+// replyTo returns the wire destination conn must include in requests so peers
+// can reply, or nil if its datagrams carry the sender destination.
+func replyTo(conn *DatagramConn) []byte {
+	if conn.Protocol() != ProtocolRaw && conn.Protocol() != ProtocolDatagram3 {
+		return nil
+	}
+	return conn.localWire.raw
+}
+
+// replyDestination returns the destination to reply to a request whose payload
+// ends in the reply destination at offset, preferring the sender destination
+// the datagram carried. Returns "" if there is none, or if a Datagram3 sender's
+// hash does not match the reply destination.
+func replyDestination(result *ReceiveResult, offset int) string {
+	if result.FromAddr.Destination != "" {
+		return result.FromAddr.Destination
+	}
+	if len(result.Payload) <= offset {
+		return ""
+	}
+	dest, n, err := parseWireDestination(result.Payload[offset:])
+	if err != nil || offset+n != len(result.Payload) {
+		return ""
+	}
+	// Datagram3 senders are identified by hash; reply only to that destination
+	if result.FromAddr.HasDestinationHash() && dest.hash() != result.FromAddr.DestinationHash {
+		return ""
+	}
+	return dest.base64()
+}
+
+// EchoStats holds the counters of an EchoService.
+type EchoStats struct {
+	// Echoed counts datagrams echoed, and Bytes their echoed payload bytes.
+	Echoed uint64
+	Bytes  uint64
+
+	// Truncated counts echoed datagrams cut to the payload cap.
+	Truncated uint64
+
+	// Unreplyable counts datagrams dropped because the sender was unknown.
+	Unreplyable uint64
+}
+
+// EchoService echoes datagrams back to their sender.
+//
+// Datagrams are sent back unchanged to the sender's destination and source
+// port, cut to the payload cap if one is set. Ping frames are answered on their
+// reply port and destination instead, so Ping works over every protocol.
+// Datagrams from unknown senders (Raw without I2CP sender metadata, Datagram3)
+// are dropped unless they are ping frames.
+//
+// Mount it with RegisterResultHandler, preferably on a port other than the
+// conn's local port, so two echo services cannot echo each other:
+//
+//	echo := datagrams.NewEchoService(conn, 1024)
+//	conn.RegisterResultHandler(datagrams.EchoPort, echo.Handle)
+type EchoService struct {
+	conn       *DatagramConn
+	maxPayload int
+
+	echoed, bytes, truncated, unreplyable atomic.Uint64
+}
+
+// NewEchoService creates an EchoService that replies on conn. maxPayload caps
+// echoed payloads; zero or less echoes up to the conn's MaxPayloadSize.
+func NewEchoService(conn *DatagramConn, maxPayload int) *EchoService {
+	return &EchoService{conn: conn, maxPayload: maxPayload}
+}
+
+// Handle echoes one datagram. It is a port handler for RegisterResultHandler.
+func (e *EchoService) Handle(result *ReceiveResult) {
+	if len(result.Payload) >= pingHeaderSize && bytes.HasPrefix(result.Payload, pingMagic) {
+		dest := replyDestination(result, pingHeaderSize)
+		if dest == "" {
+			e.unreplyable.Add(1)
+			return
+		}
+		reply := result.Payload[:pingHeaderSize]
+		port := binary.BigEndian.Uint16(reply[12:14])
+		if e.conn.SendTo(reply, dest, port) == nil {
+			e.echoed.Add(1)
+			e.bytes.Add(uint64(len(reply)))
+		}
+		return
+	}
+
+	dest := result.FromAddr.Destination
+	if dest == "" {
+		e.unreplyable.Add(1)
+		return
+	}
+	payload := result.Payload
+	limit := e.conn.MaxPayloadSize()
+	if e.maxPayload > 0 {
+		limit = min(limit, e.maxPayload)
+	}
+	truncated := len(payload) > limit
+	if truncated {
+		payload = payload[:limit]
+	}
+	if e.conn.SendTo(payload, dest, result.SrcPort) == nil {
+		e.echoed.Add(1)
+		e.bytes.Add(uint64(len(payload)))
+		if truncated {
+			e.truncated.Add(1)
+		}
+	}
+}
+
+// Stats returns a snapshot of the echo counters.
+func (e *EchoService) Stats() EchoStats {
+	return EchoStats{
+		Echoed:      e.echoed.Load(),
+		Bytes:       e.bytes.Load(),
+		Truncated:   e.truncated.Load(),
+		Unreplyable: e.unreplyable.Load(),
+	}
+}
+
+// DiscardStats holds the counters of a DiscardService.
+type DiscardStats struct {
+	Datagrams uint64
+	Bytes     uint64
+}
+
+// DiscardService drops datagrams, counting them. It is useful as a sink for
+// throughput tests:
+//
+//	var discard datagrams.DiscardService
+//	conn.RegisterResultHandler(datagrams.DiscardPort, discard.Handle)
+type DiscardService struct {
+	datagrams, bytes atomic.Uint64
+}
+
+// Handle counts and drops one datagram. It is a port handler for
+// RegisterResultHandler.
+func (s *DiscardService) Handle(result *ReceiveResult) {
+	s.datagrams.Add(1)
+	s.bytes.Add(uint64(len(result.Payload)))
+}
+
+// Stats returns a snapshot of the discard counters.
+func (s *DiscardService) Stats() DiscardStats {
+	return DiscardStats{Datagrams: s.datagrams.Load(), Bytes: s.bytes.Load()}
+}
+
+// WhoAmIReport is what a WhoAmIService saw of a request.
+type WhoAmIReport struct {
+	// Hash is the sender's destination hash, zero if the datagram did not
+	// identify the sender.
+	Hash [32]byte
+
+	// Protocol is the protocol the request arrived on.
+	Protocol uint8
+
+	// Authenticated reports whether the request's signature was verified.
+	Authenticated bool
+
+	// SrcPort is the request's source port.
+	SrcPort uint16
+}
+
+// MarshalBinary encodes the report as sent by WhoAmIService.
+func (r *WhoAmIReport) MarshalBinary() ([]byte, error) {
+	b := make([]byte, WhoAmIReportSize)
+	copy(b, r.Hash[:])
+	b[32] = r.Protocol
+	if r.Authenticated {
+		b[33] = whoamiAuthenticated
+	}
+	binary.BigEndian.PutUint16(b[34:36], r.SrcPort)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a report received from a WhoAmIService.
+func (r *WhoAmIReport) UnmarshalBinary(data []byte) error {
+	if len(data) != WhoAmIReportSize {
+		return fmt.Errorf("whoami report is %d bytes, want %d", len(data), WhoAmIReportSize)
+	}
+	copy(r.Hash[:], data)
+	r.Protocol = data[32]
+	r.Authenticated = data[33]&whoamiAuthenticated != 0
+	r.SrcPort = binary.BigEndian.Uint16(data[34:36])
+	return nil
+}
+
+// WhoAmIService replies to each request with a WhoAmIReport of how the request
+// arrived: the sender hash and protocol the server saw. Requests are empty, or
+// hold the requester's wire destination on protocols that do not carry one (Raw,
+// Datagram3). Replies go to the request's source port:
+//
+//	whoami := datagrams.NewWhoAmIService(conn)
+//	conn.RegisterResultHandler(9010, whoami.Handle)
+type WhoAmIService struct {
+	conn   *DatagramConn
+	served atomic.Uint64
+}
+
+// NewWhoAmIService creates a WhoAmIService that replies on conn.
+func NewWhoAmIService(conn *DatagramConn) *WhoAmIService {
+	return &WhoAmIService{conn: conn}
+}
+
+// Handle answers one request. It is a port handler for RegisterResultHandler.
+func (w *WhoAmIService) Handle(result *ReceiveResult) {
+	dest := replyDestination(result, 0)
+	if dest == "" {
+		return
+	}
+	report := WhoAmIReport{
+		Hash:          result.FromAddr.DestinationHash,
+		Protocol:      result.Protocol,
+		Authenticated: result.Authenticated,
+		SrcPort:       result.SrcPort,
+	}
+	b, _ := report.MarshalBinary()
+	if w.conn.SendTo(b, dest, result.SrcPort) == nil {
+		w.served.Add(1)
+	}
+}
+
+// Served returns the number of reports sent.
+func (w *WhoAmIService) Served() uint64 {
+	return w.served.Load()
+}
+
+// pingRegistry matches echo replies to pending Pings.
+type pingRegistry struct {
+	nextID atomic.Uint64
+
+	mu      sync.Mutex
+	pending map[uint64]chan *ReceiveResult
+}
+
+// Ping sends a ping frame to the EchoService at addr and returns the round-trip
+// time of its echo. A zero addr.Port pings EchoPort. Ping waits until ctx ends
+// and does not resend, so ctx should carry a timeout.
+//
+// The first Ping registers DefaultPingReplyPort on the conn to receive echoes,
+// and fails if it is already registered. On Raw and Datagram3, ping frames
+// carry the conn's destination so the echo service can reply.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+//	defer cancel()
+//	rtt, err := conn.Ping(ctx, &datagrams.I2PAddr{Destination: peerB64})
+func (d *DatagramConn) Ping(ctx context.Context, addr *I2PAddr) (time.Duration, error) {
+	if addr == nil || addr.Destination == "" {
+		return 0, fmt.Errorf("ping: address has no destination")
+	}
+	pings, err := d.pingRegistry()
+	if err != nil {
+		return 0, err
+	}
+	port := addr.Port
+	if port == 0 {
+		port = EchoPort
+	}
+
+	id := pings.nextID.Add(1)
+	ch := make(chan *ReceiveResult, 1)
+	pings.mu.Lock()
+	pings.pending[id] = ch
+	pings.mu.Unlock()
+	defer func() {
+		pings.mu.Lock()
+		delete(pings.pending, id)
+		pings.mu.Unlock()
+	}()
+
+	ret := replyTo(d)
+	frame := make([]byte, pingHeaderSize, pingHeaderSize+len(ret))
+	copy(frame, pingMagic)
+	binary.BigEndian.PutUint64(frame[4:12], id)
+	binary.BigEndian.PutUint16(frame[12:14], DefaultPingReplyPort)
+	frame = append(frame, ret...)
+
+	sentAt := time.Now()
+	if err := d.SendTo(frame, addr.Destination, port); err != nil {
+		return 0, fmt.Errorf("ping: %w", err)
+	}
+	select {
+	case result := <-ch:
+		return max(result.ReceivedAt.Sub(sentAt), 0), nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("ping: %w", ctx.Err())
+	case <-d.ctx.Done():
+		return 0, net.ErrClosed
+	}
+}
+
+// pingRegistry returns the conn's pending pings, registering the reply port on
+// first use.
+func (d *DatagramConn) pingRegistry() (*pingRegistry, error) {
+	d.mu.RLock()
+	pings := d.pings
+	d.mu.RUnlock()
+	if pings != nil {
+		return pings, nil
+	}
+
+	d.pingMu.Lock()
+	defer d.pingMu.Unlock()
+	if d.pings != nil {
+		return d.pings, nil
+	}
+	pings = &pingRegistry{pending: make(map[uint64]chan *ReceiveResult)}
+	pings.nextID.Store(uint64(time.Now().UnixNano()))
+	if err := d.RegisterResultHandler(DefaultPingReplyPort, pings.handle); err != nil {
+		return nil, fmt.Errorf("ping: %w", err)
+	}
+	d.mu.Lock()
+	d.pings = pings
+	d.mu.Unlock()
+	return pings, nil
+}
+
+// handle delivers an echo reply to its Ping.
+func (p *pingRegistry) handle(result *ReceiveResult) {
+	if len(result.Payload) != pingHeaderSize || !bytes.HasPrefix(result.Payload, pingMagic) {
+		return
+	}
+	id := binary.BigEndian.Uint64(result.Payload[4:12])
+	p.mu.Lock()
+	ch, ok := p.pending[id]
+	delete(p.pending, id)
+	p.mu.Unlock()
+	if ok {
+		ch <- result
+	}
+}
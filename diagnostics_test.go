@@ -0,0 +1,192 @@
+package datagrams
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// receiveWithin receives one datagram on conn or fails the test.
+func receiveWithin(t *testing.T, conn *DatagramConn, timeout time.Duration) *ReceiveResult {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	result, err := conn.ReceiveFromWithOptions()
+	if err != nil {
+		t.Fatalf("ReceiveFromWithOptions() failed: %v", err)
+	}
+	return result
+}
+
+// TestPing tests that Ping reaches an EchoService over every protocol.
+func TestPing(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		protocol uint8
+	}{
+		{"raw", ProtocolRaw},
+		{"datagram1", ProtocolDatagram1},
+		{"datagram2", ProtocolDatagram2},
+		{"datagram3", ProtocolDatagram3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server, _, ss := connPair(t, tc.protocol, tc.protocol)
+			echo := NewEchoService(server, 0)
+			if err := server.RegisterResultHandler(EchoPort, echo.Handle); err != nil {
+				t.Fatalf("RegisterResultHandler() failed: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			for i := 0; i < 3; i++ {
+				if rtt, err := client.Ping(ctx, &I2PAddr{Destination: ss.Destination().Base64()}); err != nil || rtt < 0 {
+					t.Fatalf("Ping() = %v, %v", rtt, err)
+				}
+			}
+			if stats := echo.Stats(); stats.Echoed != 3 || stats.Unreplyable != 0 {
+				t.Errorf("echo stats = %+v, want 3 echoed", stats)
+			}
+		})
+	}
+}
+
+// TestPing_Timeout tests that Ping ends with its context when nothing answers,
+// and that its reply port is registered once.
+func TestPing_Timeout(t *testing.T) {
+	client, _, _, ss := connPair(t, ProtocolDatagram2, ProtocolDatagram2)
+	addr := &I2PAddr{Destination: ss.Destination().Base64(), Port: 4242}
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := client.Ping(ctx, addr)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Ping() error = %v, want DeadlineExceeded", err)
+		}
+	}
+	if err := client.RegisterResultHandler(DefaultPingReplyPort, func(*ReceiveResult) {}); err == nil {
+		t.Error("RegisterResultHandler() on the ping reply port succeeded")
+	}
+	if _, err := client.Ping(context.Background(), &I2PAddr{}); err == nil {
+		t.Error("Ping() without a destination succeeded")
+	}
+
+	other, err := NewDatagramConnWithProtocol(newMockSession(), 9102, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer other.Close()
+	other.RegisterResultHandler(DefaultPingReplyPort, func(*ReceiveResult) {})
+	if _, err := other.Ping(context.Background(), addr); err == nil {
+		t.Error("Ping() succeeded with its reply port taken")
+	}
+}
+
+// TestEchoService tests verbatim echoes, the payload cap and unknown senders.
+func TestEchoService(t *testing.T) {
+	client, server, _, ss := connPair(t, ProtocolDatagram2, ProtocolDatagram2)
+	echo := NewEchoService(server, 5)
+	if err := server.RegisterResultHandler(EchoPort, echo.Handle); err != nil {
+		t.Fatalf("RegisterResultHandler() failed: %v", err)
+	}
+	dest := ss.Destination().Base64()
+
+	for _, payload := range []string{"hey", "hello, world"} {
+		if err := client.SendTo([]byte(payload), dest, EchoPort); err != nil {
+			t.Fatalf("SendTo() failed: %v", err)
+		}
+		result := receiveWithin(t, client, 5*time.Second)
+		if want := payload[:min(len(payload), 5)]; string(result.Payload) != want || result.SrcPort != pairPortB {
+			t.Errorf("echo = %q from port %d, want %q from %d", result.Payload, result.SrcPort, want, pairPortB)
+		}
+	}
+	if stats := echo.Stats(); stats.Echoed != 2 || stats.Truncated != 1 || stats.Bytes != 8 {
+		t.Errorf("echo stats = %+v", stats)
+	}
+
+	echo.Handle(&ReceiveResult{Payload: []byte("anonymous"), FromAddr: &I2PAddr{}})
+	if stats := echo.Stats(); stats.Unreplyable != 1 {
+		t.Errorf("Unreplyable = %d, want 1", stats.Unreplyable)
+	}
+}
+
+// TestDiscardService tests that discarded datagrams are counted.
+func TestDiscardService(t *testing.T) {
+	client, server, _, ss := connPair(t, ProtocolRaw, ProtocolRaw)
+	var discard DiscardService
+	if err := server.RegisterResultHandler(DiscardPort, discard.Handle); err != nil {
+		t.Fatalf("RegisterResultHandler() failed: %v", err)
+	}
+	for _, payload := range []string{"a", "bcd", "efghij"} {
+		if err := client.SendTo([]byte(payload), ss.Destination().Base64(), DiscardPort); err != nil {
+			t.Fatalf("SendTo() failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for discard.Stats().Datagrams < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := discard.Stats(); stats.Datagrams != 3 || stats.Bytes != 10 {
+		t.Errorf("discard stats = %+v, want 3 datagrams of 10 bytes", stats)
+	}
+}
+
+// TestWhoAmIService tests reports over authenticated and hash-only protocols.
+func TestWhoAmIService(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		protocol      uint8
+		authenticated bool
+	}{
+		{"datagram2", ProtocolDatagram2, true},
+		{"datagram3", ProtocolDatagram3, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server, sc, ss := connPair(t, tc.protocol, tc.protocol)
+			whoami := NewWhoAmIService(server)
+			if err := server.RegisterResultHandler(9010, whoami.Handle); err != nil {
+				t.Fatalf("RegisterResultHandler() failed: %v", err)
+			}
+			if err := client.SendTo(replyTo(client), ss.Destination().Base64(), 9010); err != nil {
+				t.Fatalf("SendTo() failed: %v", err)
+			}
+			result := receiveWithin(t, client, 5*time.Second)
+
+			var report WhoAmIReport
+			if err := report.UnmarshalBinary(result.Payload); err != nil {
+				t.Fatalf("UnmarshalBinary() failed: %v", err)
+			}
+			want := WhoAmIReport{Hash: targetHashOf(t, sc), Protocol: tc.protocol, Authenticated: tc.authenticated, SrcPort: pairPortA}
+			if report != want {
+				t.Errorf("report = %+v, want %+v", report, want)
+			}
+			if whoami.Served() != 1 {
+				t.Errorf("Served() = %d, want 1", whoami.Served())
+			}
+		})
+	}
+}
+
+// TestWhoAmIService_Spoofed tests that Datagram3 requests naming a destination
+// other than their sender are not answered.
+func TestWhoAmIService_Spoofed(t *testing.T) {
+	_, server, _, _ := connPair(t, ProtocolDatagram3, ProtocolDatagram3)
+	whoami := NewWhoAmIService(server)
+	other, err := parseDestinationB64(validDestinationB64())
+	if err != nil {
+		t.Fatalf("parseDestinationB64() failed: %v", err)
+	}
+	whoami.Handle(&ReceiveResult{
+		Payload:  other.raw,
+		Protocol: ProtocolDatagram3,
+		FromAddr: &I2PAddr{DestinationHash: [32]byte{1}},
+	})
+	if whoami.Served() != 0 {
+		t.Error("answered a request for another destination")
+	}
+
+	var report WhoAmIReport
+	if err := report.UnmarshalBinary(bytes.Repeat([]byte{0}, WhoAmIReportSize-1)); err == nil {
+		t.Error("UnmarshalBinary() accepted a short report")
+	}
+}
@@ -10,14 +10,85 @@ import (
 // destinationHash computes the SHA-256 hash of an I2P destination's wire format.
 // This is used for destination hash fields in Datagram3, signature verification in Datagram2,
 // and populating I2PAddr.DestinationHash in receive paths.
+//
+// The wire format (WriteToMessage) is hashed so that a destination parsed from a
+// peer's Base64 address and the same destination holding its private keys produce
+// the same hash, and so that Ed25519 and ML-DSA destinations are hashed alike.
 func destinationHash(dest *i2cp.Destination) ([32]byte, error) {
 	destStream := i2cp.NewStream(nil)
-	if err := dest.WriteToStream(destStream); err != nil {
+	if err := dest.WriteToMessage(destStream); err != nil {
 		return [32]byte{}, fmt.Errorf("failed to serialize destination for hash: %w", err)
 	}
 	return sha256.Sum256(destStream.Bytes()), nil
 }
 
+// envelopeKey describes the sender identity used to build authenticated envelopes.
+//
+// The signing key is either the destination's own key or, when offline is set,
+// a transient key authorized by the destination through an offline signature.
+type envelopeKey struct {
+	// dest is the sender destination included in the envelope.
+	dest *wireDestination
+
+	// offline is the offline signature block authorizing the transient key.
+	// Nil when the destination's own key signs.
+	offline *OfflineSignature
+
+	// signer holds the signing key: the destination's key, or the transient key
+	// when offline is set.
+	signer Signer
+}
+
+// sigType returns the signature type of the key that signs envelopes.
+func (k *envelopeKey) sigType() uint16 {
+	if k.offline != nil {
+		return k.offline.TransientSigType
+	}
+	return k.dest.sigType
+}
+
+// sign produces a signature over message with the signing key.
+func (k *envelopeKey) sign(message []byte) ([]byte, error) {
+	return signMessage(k.signer, message)
+}
+
+// signatureLength returns the length of envelope signatures made by this key.
+func (k *envelopeKey) signatureLength() int {
+	return signatureLengthForSigType(k.sigType())
+}
+
+// sessionEnvelopeKey builds an envelopeKey from the session's destination and
+// Ed25519 signing key pair.
+func sessionEnvelopeKey(session I2CPSession) (*envelopeKey, error) {
+	keyPair, err := session.SigningKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing key pair: %w", err)
+	}
+
+	dest, err := localWireDestination(session.Destination())
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelopeKey{dest: dest, signer: &sessionSigner{keyPair: keyPair}}, nil
+}
+
+// datagram1Overhead returns the exact Datagram1 envelope overhead for a sender
+// destination: destination + signature.
+func datagram1Overhead(dest *wireDestination) int {
+	return len(dest.raw) + dest.signatureLength()
+}
+
+// datagram2Overhead returns the exact Datagram2 envelope overhead for a sender
+// destination, excluding options: destination + flags + [offline_sig] + signature.
+// When offline is non-nil the signature is made by the transient key.
+func datagram2Overhead(dest *wireDestination, offline *OfflineSignature) int {
+	if offline != nil {
+		return len(dest.raw) + 2 + offline.Len() + signatureLengthForSigType(offline.TransientSigType)
+	}
+	return len(dest.raw) + 2 + dest.signatureLength()
+}
+
 // buildDatagram1Envelope constructs a Datagram1 envelope with signature.
 // Format: from destination (391+ bytes wire format) + signature (64 bytes for Ed25519) + payload
 //
@@ -37,31 +108,31 @@ func buildDatagram1Envelope(payload []byte, session I2CPSession) ([]byte, error)
 		return nil, fmt.Errorf("Datagram1 does not support offline signatures (LS2 offline keys); use Datagram2 (protocol %d) instead", ProtocolDatagram2)
 	}
 
-	// Get the session's signing key pair
-	keyPair, err := session.SigningKeyPair()
+	key, err := sessionEnvelopeKey(session)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get signing key pair: %w", err)
-	}
-
-	// Get the local destination to include in the envelope
-	localDest := session.Destination()
-	if localDest == nil {
-		return nil, fmt.Errorf("session has no destination")
+		return nil, err
 	}
+	return encodeDatagram1(payload, key)
+}
 
-	// Serialize the destination using wire format (WriteToMessage)
-	// Wire format: pubKey(256) + signingPubKey(128) + certificate = 391+ bytes
-	destStream := i2cp.NewStream(nil)
-	if err := localDest.WriteToMessage(destStream); err != nil {
-		return nil, fmt.Errorf("failed to serialize destination: %w", err)
+// encodeDatagram1 builds a Datagram1 envelope for an explicit sender key.
+// Format: from destination + signature + payload
+//
+// Ed25519 and ML-DSA both sign the payload directly (not its hash).
+func encodeDatagram1(payload []byte, key *envelopeKey) ([]byte, error) {
+	if key.offline != nil {
+		return nil, fmt.Errorf("Datagram1 does not support offline signatures (LS2 offline keys); use Datagram2 (protocol %d) instead", ProtocolDatagram2)
 	}
-	destBytes := destStream.Bytes()
 
-	// Sign the payload (Ed25519 signs directly, not the hash)
-	signature, err := keyPair.Sign(payload)
+	signature, err := key.sign(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign payload: %w", err)
 	}
+	if len(signature) != key.signatureLength() {
+		return nil, fmt.Errorf("signature length %d does not match sigtype %d (expected %d)", len(signature), key.sigType(), key.signatureLength())
+	}
+
+	destBytes := key.dest.raw
 
 	// Build envelope: destination + signature + payload
 	envelope := make([]byte, len(destBytes)+len(signature)+len(payload))
@@ -72,108 +143,46 @@ func buildDatagram1Envelope(payload []byte, session I2CPSession) ([]byte, error)
 	return envelope, nil
 }
 
-// parseDatagram1Envelope extracts and verifies a Datagram1 envelope.
-// Format: from destination (391+ bytes wire format) + signature (64 bytes for Ed25519) + payload
-//
-// The signature is verified using the sender's public key embedded in the destination.
-// Returns the payload, from destination, and any error (including signature verification failure).
-func parseDatagram1Envelope(data []byte, session I2CPSession) (payload []byte, from *i2cp.Destination, err error) {
-	// Minimum size: Ed25519DestinationSize (391) + Ed25519SignatureLength (64) = 455 bytes
-	if len(data) < MinDatagram1Overhead {
-		return nil, nil, fmt.Errorf("Datagram1 envelope too short: %d bytes (need at least %d)", len(data), MinDatagram1Overhead)
-	}
-
-	// Parse destination from the envelope using wire format reader
-	crypto := i2cp.NewCrypto()
-	stream := i2cp.NewStream(data)
-	from, err = i2cp.NewDestinationFromMessage(stream, crypto)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Datagram1 failed to parse destination: %w", err)
-	}
-
-	// Calculate how many bytes were consumed by the destination (wire format)
-	destStream := i2cp.NewStream(nil)
-	if err := from.WriteToMessage(destStream); err != nil {
-		return nil, nil, fmt.Errorf("Datagram1 failed to serialize destination: %w", err)
-	}
-	destLen := destStream.Len()
-
-	// Check if there's enough data for signature + at least empty payload
-	if len(data) < destLen+Ed25519SignatureLength {
-		return nil, nil, fmt.Errorf("Datagram1 envelope too short after destination: %d bytes remaining (need at least %d for signature)", len(data)-destLen, Ed25519SignatureLength)
-	}
-
-	// Extract Ed25519 signature (fixed 64 bytes)
-	signature := data[destLen : destLen+Ed25519SignatureLength]
-	payload = data[destLen+Ed25519SignatureLength:]
-
-	// Verify signature using the sender's destination public key
-	// Per I2P spec: Ed25519 signs the payload directly (not the hash)
-	if !from.VerifySignature(payload, signature) {
-		return nil, nil, fmt.Errorf("Datagram1 signature verification failed")
-	}
-
-	return payload, from, nil
-}
-
-// buildDatagram2Envelope constructs a Datagram2 envelope with signature and replay prevention.
-// Format: from destination (391+ bytes wire format) + flags (2 bytes) + [options] + payload + signature (64 bytes)
-//
-// The signature is computed over: target_dest_hash (32 bytes, not in datagram) + flags + [options] + payload
-//
-// Per I2P specification, the target destination hash provides replay prevention - the same
-// signed payload sent to different destinations will have different valid signatures.
-//
-// This implementation supports Datagram2 with optional options (I2P Mapping format).
-// Options may be nil or empty for datagrams without options.
-// Returns the complete envelope or an error if signing fails.
-func buildDatagram2Envelope(payload []byte, session I2CPSession, targetDestHash [32]byte) ([]byte, error) {
-	return buildDatagram2EnvelopeWithOptions(payload, session, targetDestHash, nil)
-}
-
 // buildDatagram2EnvelopeWithOptions constructs a Datagram2 envelope with optional options field.
 // Options may be nil or empty to omit the options field.
 //
-// NOTE: Datagram2 is designed to support offline signatures (LS2 offline keys), but this
-// implementation does not yet support SENDING with offline signatures. The go-i2cp library
-// would need to expose the transient signing key for this to be implemented.
-// Receiving Datagram2 with offline signatures IS supported.
+// NOTE: Datagram2 is designed to support offline signatures (LS2 offline keys), but
+// sessions do not expose their transient signing key, so this function cannot sign for
+// an offline session. encodeDatagram2 builds offline-signed envelopes when given an
+// envelopeKey built from an OfflineSigner (see DatagramConn.SetSigner).
 func buildDatagram2EnvelopeWithOptions(payload []byte, session I2CPSession, targetDestHash [32]byte, options *Options) ([]byte, error) {
 	// Per I2P specification, Datagram2 supports offline signatures (unlike Datagram1 which does not).
-	// However, this implementation cannot currently construct the offline signature block for
-	// SENDING because go-i2cp does not expose the transient signing key.
-	// Receiving/parsing offline signatures IS implemented.
+	// However, the session cannot supply the transient signing key needed to SEND with one.
 	// See: https://geti2p.net/spec/datagrams#datagram2
 	if session.IsOffline() {
-		return nil, fmt.Errorf("Datagram2 sending with offline signatures (LS2 offline keys) is not yet supported; " +
-			"go-i2cp would need to expose the transient signing key for this feature")
+		return nil, fmt.Errorf("Datagram2 sending for offline sessions (LS2 offline keys) requires the transient key, " +
+			"which go-i2cp does not expose; install an OfflineSigner with DatagramConn.SetSigner")
 	}
 
-	// Get the session's signing key pair
-	keyPair, err := session.SigningKeyPair()
+	key, err := sessionEnvelopeKey(session)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get signing key pair: %w", err)
-	}
-
-	// Get the local destination to include in the envelope
-	localDest := session.Destination()
-	if localDest == nil {
-		return nil, fmt.Errorf("session has no destination")
+		return nil, err
 	}
+	return encodeDatagram2(payload, key, targetDestHash, options)
+}
 
-	// Serialize the destination using wire format (WriteToMessage)
-	// Wire format: pubKey(256) + signingPubKey(128) + certificate = 391+ bytes
-	destStream := i2cp.NewStream(nil)
-	if err := localDest.WriteToMessage(destStream); err != nil {
-		return nil, fmt.Errorf("failed to serialize destination: %w", err)
+// encodeDatagram2 builds a Datagram2 envelope for an explicit sender key.
+// Format: from destination + flags (2 bytes) + [options] + [offline_sig] + payload + signature
+//
+// When key.offline is set, the offline signature flag is raised, the offline signature
+// block is included, and the envelope is signed by the transient key.
+func encodeDatagram2(payload []byte, key *envelopeKey, targetDestHash [32]byte, options *Options) ([]byte, error) {
+	// Signers outlive their offline signature on long-lived connections
+	if key.offline != nil && key.offline.IsExpired() {
+		return nil, fmt.Errorf("%w (expired at %s)", ErrOfflineSignatureExpired, key.offline.Expires)
 	}
-	destBytes := destStream.Bytes()
+	destBytes := key.dest.raw
 
 	// Build flags (2 bytes): version 0x02, options flag if options present
 	// Bit order: 15 14 ... 3 2 1 0
 	// Bits 3-0: Version = 0x02
 	// Bit 4: Options flag = 1 if options present
-	// Bit 5: Offline signature flag = 0 (not supported for sending)
+	// Bit 5: Offline signature flag = 1 if signed by a transient key
 	lowFlags := byte(0x02) // version 0x02
 	var optionsBytes []byte
 	if options != nil && !options.IsEmpty() {
@@ -184,48 +193,37 @@ func buildDatagram2EnvelopeWithOptions(payload []byte, session I2CPSession, targ
 			return nil, fmt.Errorf("failed to serialize options: %w", optErr)
 		}
 	}
+	var offlineSigBytes []byte
+	if key.offline != nil {
+		lowFlags |= 0x20 // set offline signature flag
+		offlineSigBytes = key.offline.Bytes()
+	}
 	flags := []byte{0x00, lowFlags} // high byte = 0, low byte = version + flags
 
-	// Build data to sign: targetDestHash + flags + options + payload
+	// Build data to sign: targetDestHash + flags + options + offline_sig + payload
 	// Per spec: "The signature is over the following fields:
 	// 1. Prelude: The 32-byte hash of the target destination (not included in the datagram)
 	// 2. flags
 	// 3. options (if present)
-	// 4. offline_signature (if present) - not present in this implementation
+	// 4. offline_signature (if present)
 	// 5. payload"
-	toSign := make([]byte, 32+2+len(optionsBytes)+len(payload))
-	offset := 0
-	copy(toSign[offset:], targetDestHash[:])
-	offset += 32
-	copy(toSign[offset:], flags)
-	offset += 2
-	if len(optionsBytes) > 0 {
-		copy(toSign[offset:], optionsBytes)
-		offset += len(optionsBytes)
-	}
-	copy(toSign[offset:], payload)
+	toSign := buildDatagram2VerifyData(targetDestHash, flags, optionsBytes, offlineSigBytes, payload)
 
-	// Sign with Ed25519 (always signs directly, not the hash)
-	signature, err := keyPair.Sign(toSign)
+	// Ed25519 and ML-DSA sign the data directly, not its hash
+	signature, err := key.sign(toSign)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign payload: %w", err)
 	}
+	if len(signature) != key.signatureLength() {
+		return nil, fmt.Errorf("signature length %d does not match sigtype %d (expected %d)", len(signature), key.sigType(), key.signatureLength())
+	}
 
-	// Build envelope: destination + flags + options + payload + signature
+	// Build envelope: destination + flags + options + offline_sig + payload + signature
 	// Note: signature is at the END for Datagram2 (unlike Datagram1 where it's in the middle)
-	envelope := make([]byte, len(destBytes)+2+len(optionsBytes)+len(payload)+len(signature))
-	envOffset := 0
-	copy(envelope[envOffset:], destBytes)
-	envOffset += len(destBytes)
-	copy(envelope[envOffset:], flags)
-	envOffset += 2
-	if len(optionsBytes) > 0 {
-		copy(envelope[envOffset:], optionsBytes)
-		envOffset += len(optionsBytes)
-	}
-	copy(envelope[envOffset:], payload)
-	envOffset += len(payload)
-	copy(envelope[envOffset:], signature)
+	envelope := make([]byte, 0, len(destBytes)+len(toSign)-32+len(signature))
+	envelope = append(envelope, destBytes...)
+	envelope = append(envelope, toSign[32:]...)
+	envelope = append(envelope, signature...)
 
 	return envelope, nil
 }
@@ -248,7 +246,12 @@ func buildDatagram3EnvelopeWithOptions(payload []byte, session I2CPSession, opti
 	if err != nil {
 		return nil, fmt.Errorf("failed to compute destination hash: %w", err)
 	}
+	return encodeDatagram3(payload, fromHash, options)
+}
 
+// encodeDatagram3 builds a Datagram3 envelope for an explicit sender hash.
+// Format: fromhash(32) + flags(2) + [options] + payload
+func encodeDatagram3(payload []byte, fromHash [32]byte, options *Options) ([]byte, error) {
 	// Build flags (2 bytes): version 0x03, options flag if options present
 	// Bit order: 15 14 ... 3 2 1 0
 	// Bits 3-0: Version = 0x03
@@ -302,28 +305,6 @@ func parseDatagram2Flags(flags []byte) (hasOptions, hasOfflineSig bool, err erro
 	return hasOptions, hasOfflineSig, nil
 }
 
-// parseDatagram2OfflineSig parses, validates, and verifies an offline signature block.
-// Returns the OfflineSignature, raw bytes, bytes consumed, and any error.
-func parseDatagram2OfflineSig(data []byte, offset int, from *i2cp.Destination) (*OfflineSignature, []byte, int, error) {
-	destSigType := uint16(7) // Ed25519
-
-	offlineSig, offLen, offErr := OfflineSignatureFromBytes(data[offset:], destSigType)
-	if offErr != nil {
-		return nil, nil, 0, fmt.Errorf("Datagram2 failed to parse offline signature: %w", offErr)
-	}
-
-	if offlineSig.IsExpired() {
-		return nil, nil, 0, fmt.Errorf("Datagram2 offline signature has expired (expired at %s)", offlineSig.Expires)
-	}
-
-	if verifyErr := offlineSig.Verify(from); verifyErr != nil {
-		return nil, nil, 0, fmt.Errorf("Datagram2 offline signature authorization failed: %w", verifyErr)
-	}
-
-	rawBytes := data[offset : offset+offLen]
-	return offlineSig, rawBytes, offLen, nil
-}
-
 // buildDatagram2VerifyData constructs the data that was signed for Datagram2 verification.
 // Per I2P spec: targetDestHash + flags + options + offline_sig + payload
 func buildDatagram2VerifyData(localDestHash [32]byte, flags, optionsBytes, offlineSigBytes, payload []byte) []byte {
@@ -344,122 +325,3 @@ func buildDatagram2VerifyData(localDestHash [32]byte, flags, optionsBytes, offli
 	copy(toVerify[offset:], payload)
 	return toVerify
 }
-
-// verifyDatagram2Signature verifies the signature on a Datagram2 envelope.
-// Uses the transient key if an offline signature is present, otherwise the sender's destination key.
-func verifyDatagram2Signature(toVerify, signature []byte, from *i2cp.Destination, offlineSig *OfflineSignature) error {
-	var valid bool
-	if offlineSig != nil {
-		valid = offlineSig.VerifyPayloadSignature(toVerify, signature)
-	} else {
-		valid = from.VerifySignature(toVerify, signature)
-	}
-	if !valid {
-		return fmt.Errorf("Datagram2 signature verification failed (possible replay attack or wrong recipient)")
-	}
-	return nil
-}
-
-// parseDatagram2Envelope extracts and verifies a Datagram2 envelope with replay prevention.
-// Format: from destination (391+ bytes wire format) + flags (2 bytes) + [options] + [offline_sig] + payload + signature (64 bytes)
-//
-// The signature must verify against: receiver_dest_hash + flags + options + offline_sig + payload
-// This provides replay prevention - datagrams sent to different destinations will fail verification.
-func parseDatagram2Envelope(data []byte, session I2CPSession) (payload []byte, from *i2cp.Destination, err error) {
-	payload, from, _, err = parseDatagram2EnvelopeWithOptions(data, session)
-	return payload, from, err
-}
-
-// parseDatagram2EnvelopeWithOptions extracts and verifies a Datagram2 envelope, returning options.
-// Format: from destination (391+ bytes wire format) + flags (2 bytes) + [options] + [offline_sig] + payload + signature (64 bytes)
-//
-// The signature must verify against: receiver_dest_hash + flags + options + offline_sig + payload
-// This provides replay prevention - datagrams sent to different destinations will fail verification.
-//
-// Returns the payload, from destination, parsed options (if present), and any error.
-func parseDatagram2EnvelopeWithOptions(data []byte, session I2CPSession) (payload []byte, from *i2cp.Destination, options *Options, err error) {
-	if len(data) < MinDatagram2Overhead {
-		return nil, nil, nil, fmt.Errorf("Datagram2 envelope too short: %d bytes (need at least %d)", len(data), MinDatagram2Overhead)
-	}
-
-	// Parse destination from the envelope using wire format reader
-	crypto := i2cp.NewCrypto()
-	stream := i2cp.NewStream(data)
-	from, err = i2cp.NewDestinationFromMessage(stream, crypto)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Datagram2 failed to parse destination: %w", err)
-	}
-
-	// Calculate how many bytes were consumed by the destination
-	destStream := i2cp.NewStream(nil)
-	if err := from.WriteToMessage(destStream); err != nil {
-		return nil, nil, nil, fmt.Errorf("Datagram2 failed to serialize destination: %w", err)
-	}
-	destLen := destStream.Len()
-
-	if len(data) < destLen+2+Ed25519SignatureLength {
-		return nil, nil, nil, fmt.Errorf("Datagram2 envelope too short after destination: have %d bytes remaining, need at least %d (flags: 2, signature: %d)", len(data)-destLen, 2+Ed25519SignatureLength, Ed25519SignatureLength)
-	}
-
-	// Extract and validate flags
-	flags := data[destLen : destLen+2]
-	hasOptions, hasOfflineSig, flagErr := parseDatagram2Flags(flags)
-	if flagErr != nil {
-		return nil, nil, nil, flagErr
-	}
-
-	offset := destLen + 2
-	var optionsBytes []byte
-	var offlineSigBytes []byte
-
-	// Parse options if present
-	if hasOptions {
-		if len(data)-offset < 2 {
-			return nil, nil, nil, fmt.Errorf("Datagram2 envelope too short for options size field at offset %d: have %d bytes, need at least 2", offset, len(data)-offset)
-		}
-		opts, optLen, optErr := OptionsFromBytes(data[offset:])
-		if optErr != nil {
-			return nil, nil, nil, fmt.Errorf("Datagram2 failed to parse options: %w", optErr)
-		}
-		optionsBytes = data[offset : offset+optLen]
-		offset += optLen
-		options = opts
-	}
-
-	// Parse and verify offline signature if present
-	var offlineSig *OfflineSignature
-	if hasOfflineSig {
-		var offLen int
-		offlineSig, offlineSigBytes, offLen, err = parseDatagram2OfflineSig(data, offset, from)
-		if err != nil {
-			return nil, nil, nil, err
-		}
-		offset += offLen
-	}
-
-	// Split payload and signature (signature is at end)
-	if len(data)-offset < Ed25519SignatureLength {
-		return nil, nil, nil, fmt.Errorf("Datagram2 envelope too short for signature at offset %d: have %d bytes, need %d", offset, len(data)-offset, Ed25519SignatureLength)
-	}
-	payloadEnd := len(data) - Ed25519SignatureLength
-	payload = data[offset:payloadEnd]
-	signature := data[payloadEnd:]
-
-	// Compute local destination hash for replay prevention
-	localDest := session.Destination()
-	if localDest == nil {
-		return nil, nil, nil, fmt.Errorf("session has no destination for verification")
-	}
-	localDestHash, err := destinationHash(localDest)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Datagram2 failed to compute local destination hash: %w", err)
-	}
-
-	// Build verification data and verify signature
-	toVerify := buildDatagram2VerifyData(localDestHash, flags, optionsBytes, offlineSigBytes, payload)
-	if err := verifyDatagram2Signature(toVerify, signature, from, offlineSig); err != nil {
-		return nil, nil, nil, err
-	}
-
-	return payload, from, options, nil
-}
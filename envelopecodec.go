@@ -0,0 +1,145 @@
+package datagrams
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrEnvelopeCodecConflict is returned when a protocol number already has a codec.
+var ErrEnvelopeCodecConflict = errors.New("envelope codec already registered")
+
+// CodecContext describes the datagram being encoded or decoded by an EnvelopeCodec.
+type CodecContext struct {
+	// Protocol is the I2CP protocol number of the datagram.
+	Protocol uint8
+
+	// LocalHash is the SHA-256 hash of the local destination.
+	LocalHash [32]byte
+
+	// RemoteHash is the hash of the recipient when encoding. It is zero when decoding.
+	RemoteHash [32]byte
+
+	// SrcPort and DestPort are the I2CP ports of the datagram.
+	SrcPort  uint16
+	DestPort uint16
+
+	// Options are the options passed to SendToWithOptions when encoding, or nil.
+	// Codecs that cannot carry options should ignore them, as Raw does.
+	Options *Options
+}
+
+// DecodedDatagram is the result of EnvelopeCodec.Decode.
+type DecodedDatagram struct {
+	// Payload is the application data.
+	Payload []byte
+
+	// SenderHash is the sender's destination hash, if the framing carries one.
+	// A zero hash means unknown.
+	SenderHash [32]byte
+
+	// Options are the decoded options, or nil.
+	Options *Options
+}
+
+// EnvelopeCodec frames payloads for an application-defined protocol number.
+//
+// SPEC.md allows any protocol number other than 6 (streaming). DatagramConn handles
+// Raw, Datagram1, Datagram2 and Datagram3 itself; for any other number it looks up
+// an EnvelopeCodec in its EnvelopeCodecRegistry on every send and receive.
+// Implementations must be safe for concurrent use.
+type EnvelopeCodec interface {
+	// Overhead returns the maximum number of bytes Encode adds to a payload without
+	// options. MaxPayloadSize subtracts it from MaxI2NPSize.
+	Overhead() int
+
+	// Encode wraps payload in the codec's envelope.
+	Encode(payload []byte, ctx *CodecContext) ([]byte, error)
+
+	// Decode extracts the payload from a received envelope. Errors cause the
+	// datagram to be rejected like a malformed built-in envelope.
+	Decode(data []byte, ctx *CodecContext) (*DecodedDatagram, error)
+}
+
+// passthroughCodec sends payloads unchanged, like ProtocolRaw.
+type passthroughCodec struct{}
+
+func (passthroughCodec) Overhead() int { return 0 }
+
+func (passthroughCodec) Encode(payload []byte, _ *CodecContext) ([]byte, error) {
+	return payload, nil
+}
+
+func (passthroughCodec) Decode(data []byte, _ *CodecContext) (*DecodedDatagram, error) {
+	return &DecodedDatagram{Payload: data}, nil
+}
+
+// PassthroughCodec is an EnvelopeCodec with Raw semantics: payloads are sent
+// unchanged and options are ignored. Register it for a custom protocol number to
+// separate application traffic from ProtocolRaw without adding overhead.
+var PassthroughCodec EnvelopeCodec = passthroughCodec{}
+
+// EnvelopeCodecRegistry maps application-defined protocol numbers to EnvelopeCodecs.
+// An EnvelopeCodecRegistry is safe for concurrent use.
+type EnvelopeCodecRegistry struct {
+	mu     sync.RWMutex
+	codecs map[uint8]EnvelopeCodec
+}
+
+// NewEnvelopeCodecRegistry creates an empty registry.
+func NewEnvelopeCodecRegistry() *EnvelopeCodecRegistry {
+	return &EnvelopeCodecRegistry{codecs: make(map[uint8]EnvelopeCodec)}
+}
+
+// DefaultEnvelopeCodecRegistry is used by every DatagramConn unless another registry
+// is installed with DatagramConn.SetEnvelopeCodecRegistry.
+var DefaultEnvelopeCodecRegistry = NewEnvelopeCodecRegistry()
+
+// Register installs codec for protocol.
+//
+// Returns an error if protocol is ProtocolStreaming or one of the built-in datagram
+// protocols, or an error wrapping ErrEnvelopeCodecConflict if protocol already has a codec.
+//
+// Example:
+//
+//	const ProtocolTelemetry = 200
+//	err := datagrams.DefaultEnvelopeCodecRegistry.Register(ProtocolTelemetry, datagrams.PassthroughCodec)
+func (r *EnvelopeCodecRegistry) Register(protocol uint8, codec EnvelopeCodec) error {
+	if codec == nil {
+		return fmt.Errorf("envelope codec for protocol %d is nil", protocol)
+	}
+	switch protocol {
+	case ProtocolStreaming:
+		return fmt.Errorf("protocol %d (streaming) is reserved and cannot be used for datagrams", protocol)
+	case ProtocolRaw, ProtocolDatagram1, ProtocolDatagram2, ProtocolDatagram3:
+		return fmt.Errorf("protocol %d is built in and cannot have a custom codec", protocol)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.codecs[protocol]; ok {
+		return fmt.Errorf("%w: protocol %d", ErrEnvelopeCodecConflict, protocol)
+	}
+	r.codecs[protocol] = codec
+	return nil
+}
+
+// Unregister removes the codec for protocol, if any.
+func (r *EnvelopeCodecRegistry) Unregister(protocol uint8) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.codecs, protocol)
+}
+
+// Lookup returns the codec registered for protocol.
+func (r *EnvelopeCodecRegistry) Lookup(protocol uint8) (EnvelopeCodec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	codec, ok := r.codecs[protocol]
+	return codec, ok
+}
+
+// unsupportedProtocolError reports a protocol number without a registered codec.
+func unsupportedProtocolError(protocol uint8) error {
+	return fmt.Errorf("unsupported protocol: %d (no EnvelopeCodec registered)", protocol)
+}
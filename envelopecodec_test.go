@@ -0,0 +1,163 @@
+package datagrams
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// hashTagCodec is a test EnvelopeCodec framing payloads as
+// senderHash(32) + options Mapping + payload.
+type hashTagCodec struct{}
+
+func (hashTagCodec) Overhead() int { return 32 + 2 }
+
+func (hashTagCodec) Encode(payload []byte, ctx *CodecContext) ([]byte, error) {
+	opts, err := ctx.Options.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	out := append(ctx.LocalHash[:], opts...)
+	return append(out, payload...), nil
+}
+
+func (hashTagCodec) Decode(data []byte, _ *CodecContext) (*DecodedDatagram, error) {
+	if len(data) < 34 {
+		return nil, fmt.Errorf("envelope too short: %d bytes", len(data))
+	}
+	decoded := &DecodedDatagram{}
+	copy(decoded.SenderHash[:], data[:32])
+	opts, n, err := OptionsFromBytesStrict(data[32:], OptionsLimits{})
+	if err != nil {
+		return nil, err
+	}
+	if !opts.IsEmpty() {
+		decoded.Options = opts
+	}
+	decoded.Payload = data[32+n:]
+	return decoded, nil
+}
+
+// TestEnvelopeCodecRegistry_Register tests registration rules.
+func TestEnvelopeCodecRegistry_Register(t *testing.T) {
+	r := NewEnvelopeCodecRegistry()
+
+	if err := r.Register(200, PassthroughCodec); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if err := r.Register(200, hashTagCodec{}); !errors.Is(err, ErrEnvelopeCodecConflict) {
+		t.Errorf("duplicate Register() error = %v, want ErrEnvelopeCodecConflict", err)
+	}
+	for _, protocol := range []uint8{ProtocolStreaming, ProtocolRaw, ProtocolDatagram1, ProtocolDatagram2, ProtocolDatagram3} {
+		if err := r.Register(protocol, PassthroughCodec); err == nil {
+			t.Errorf("Register(%d) should fail for a reserved or built-in protocol", protocol)
+		}
+	}
+	if err := r.Register(201, nil); err == nil {
+		t.Error("Register() should fail for a nil codec")
+	}
+
+	if codec, ok := r.Lookup(200); !ok || codec != PassthroughCodec {
+		t.Errorf("Lookup(200) = %v, %v", codec, ok)
+	}
+	r.Unregister(200)
+	if _, ok := r.Lookup(200); ok {
+		t.Error("Lookup() found an unregistered codec")
+	}
+}
+
+// TestDatagramConn_CustomProtocol_Passthrough tests Raw-like framing on a custom protocol.
+func TestDatagramConn_CustomProtocol_Passthrough(t *testing.T) {
+	registry := NewEnvelopeCodecRegistry()
+	sender := newMockSession()
+	conn, err := NewDatagramConnWithProtocol(sender, 8080, 200)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetEnvelopeCodecRegistry(registry)
+
+	// Without a codec the protocol cannot be used
+	err = conn.SendTo([]byte("x"), validDestinationB64(), 9000)
+	if err == nil || !strings.Contains(err.Error(), "unsupported protocol") {
+		t.Fatalf("SendTo() error = %v, want unsupported protocol", err)
+	}
+
+	// Registering after the connection was created takes effect immediately
+	if err := registry.Register(200, PassthroughCodec); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if conn.MaxPayloadSize() != MaxI2NPSize {
+		t.Errorf("MaxPayloadSize() = %d, want %d", conn.MaxPayloadSize(), MaxI2NPSize)
+	}
+	if err := conn.SendTo([]byte("plain"), validDestinationB64(), 9000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	if sender.lastProtocol != 200 || string(sender.lastPayload) != "plain" {
+		t.Errorf("sent protocol %d payload %q", sender.lastProtocol, sender.lastPayload)
+	}
+
+	from := newMockSession().Destination()
+	conn.injectMessage([]byte("plain"), from, 200, 9000, 8080)
+	_, addr, err := conn.ReceiveFromWithAddr()
+	if err != nil {
+		t.Fatalf("ReceiveFromWithAddr() failed: %v", err)
+	}
+	if addr.Destination != from.Base64() || addr.Port != 9000 {
+		t.Errorf("addr = %+v, want sender metadata", addr)
+	}
+}
+
+// TestDatagramConn_CustomProtocol_Codec tests a codec carrying a sender hash and options.
+func TestDatagramConn_CustomProtocol_Codec(t *testing.T) {
+	registry := NewEnvelopeCodecRegistry()
+	if err := registry.Register(201, hashTagCodec{}); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+
+	sender := newMockSession()
+	out, err := NewDatagramConnWithProtocol(sender, 8080, 201)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer out.Close()
+	out.SetEnvelopeCodecRegistry(registry)
+
+	if want := MaxI2NPSize - 34; out.MaxPayloadSize() != want {
+		t.Errorf("MaxPayloadSize() = %d, want %d", out.MaxPayloadSize(), want)
+	}
+	opts := NewOptions(map[string]string{"k": "v"})
+	if err := out.SendToWithOptions([]byte("framed"), validDestinationB64(), 9000, opts); err != nil {
+		t.Fatalf("SendToWithOptions() failed: %v", err)
+	}
+
+	in, err := NewDatagramConnWithProtocol(newMockSession(), 9000, 201)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer in.Close()
+	in.SetEnvelopeCodecRegistry(registry)
+
+	in.injectMessage(sender.lastPayload, nil, 201, 8080, 9000)
+	result, err := in.ReceiveFromWithOptions()
+	if err != nil {
+		t.Fatalf("ReceiveFromWithOptions() failed: %v", err)
+	}
+	if !bytes.Equal(result.Payload, []byte("framed")) {
+		t.Errorf("Payload = %q, want %q", result.Payload, "framed")
+	}
+	if result.Options.Get("k") != "v" {
+		t.Errorf("Options = %v", result.Options.ToMap())
+	}
+	if result.FromHash != targetHashOf(t, sender) || result.FromAddr.DestinationHash != result.FromHash {
+		t.Error("sender hash from codec not reported")
+	}
+
+	// Decode errors reject the datagram
+	in.injectMessage([]byte("short"), nil, 201, 8080, 9000)
+	if _, err := in.ReceiveFromWithOptions(); err == nil {
+		t.Error("expected error for malformed custom envelope")
+	}
+}
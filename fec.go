@@ -0,0 +1,529 @@
+package datagrams
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// FECOverhead is the per-datagram overhead of FECConn:
+	// group id(4) + shard index(1) + data shard count(1) = 6 bytes. Data shards
+	// carry a count of zero; parity shards carry the number of data shards in
+	// their group.
+	FECOverhead = 4 + 1 + 1
+
+	// fecLengthSize prefixes each data shard with its length inside parity, so
+	// recovered shards are trimmed to the original payload.
+	fecLengthSize = 2
+
+	// DefaultFECDataShards is the default number of datagrams per group.
+	DefaultFECDataShards = 8
+
+	// DefaultFECParityShards is the default number of parity datagrams per group.
+	DefaultFECParityShards = 2
+
+	// DefaultFECGroupTimeout is how long a sender waits by default for a group to
+	// fill before sending parity for the datagrams it has.
+	DefaultFECGroupTimeout = 50 * time.Millisecond
+
+	// DefaultFECGroupLifetime is how long a receiver keeps a group by default.
+	DefaultFECGroupLifetime = 10 * time.Second
+
+	// DefaultFECMaxGroups is the default cap on groups a receiver tracks.
+	DefaultFECMaxGroups = 1024
+)
+
+// FECRatio is the number of parity shards sent per group of data shards. Any
+// Data of the Data+Parity shards of a group recover all of its data, so a group
+// survives the loss of up to Parity datagrams.
+type FECRatio struct {
+	Data   int
+	Parity int
+}
+
+// validate checks that a group fits in one Reed-Solomon code.
+func (r FECRatio) validate() error {
+	if r.Data < 1 || r.Parity < 1 || r.Data+r.Parity > MaxFECShards {
+		return fmt.Errorf("invalid FEC ratio %d:%d: need at least 1 data and 1 parity shard, at most %d in total", r.Data, r.Parity, MaxFECShards)
+	}
+	return nil
+}
+
+// FECConfig configures an FECConn. Zero fields take their defaults.
+type FECConfig struct {
+	// Ratio is the number of data and parity datagrams per group.
+	Ratio FECRatio
+
+	// GroupTimeout is how long a sender waits for a group to fill before sending
+	// parity for the datagrams it has. It bounds the recovery latency of groups
+	// on quiet streams.
+	GroupTimeout time.Duration
+
+	// GroupLifetime is how long a receiver keeps a group waiting for shards.
+	GroupLifetime time.Duration
+
+	// MaxGroups caps the groups a receiver tracks across all senders; the oldest
+	// are dropped to stay under it.
+	MaxGroups int
+}
+
+func (c FECConfig) withDefaults() FECConfig {
+	if c.Ratio.Data <= 0 {
+		c.Ratio.Data = DefaultFECDataShards
+	}
+	if c.Ratio.Parity <= 0 {
+		c.Ratio.Parity = DefaultFECParityShards
+	}
+	if c.GroupTimeout <= 0 {
+		c.GroupTimeout = DefaultFECGroupTimeout
+	}
+	if c.GroupLifetime <= 0 {
+		c.GroupLifetime = DefaultFECGroupLifetime
+	}
+	if c.MaxGroups <= 0 {
+		c.MaxGroups = DefaultFECMaxGroups
+	}
+	return c
+}
+
+// FECStats reports activity on an FECConn.
+type FECStats struct {
+	DataSent      uint64 // datagrams sent
+	ParitySent    uint64 // parity datagrams sent
+	Received      uint64 // datagrams delivered to Receive as they arrived
+	Recovered     uint64 // lost datagrams rebuilt from parity and delivered
+	Unrecoverable uint64 // datagrams lost in groups without enough shards
+	Duplicates    uint64 // duplicate datagrams ignored
+	Rejected      uint64 // datagrams dropped as malformed
+}
+
+type fecCounters struct {
+	dataSent, paritySent, received, recovered, unrecoverable, duplicates,
+	rejected atomic.Uint64
+}
+
+// fecSendGroup is the group being filled for one peer.
+type fecSendGroup struct {
+	id     uint32
+	shards [][]byte // length-prefixed payloads
+	timer  *time.Timer
+}
+
+// fecGroupKey identifies a group from one sender.
+type fecGroupKey struct {
+	dest  string
+	hash  [32]byte
+	port  uint16
+	group uint32
+}
+
+// fecRecvGroup is a group being received.
+type fecRecvGroup struct {
+	key           fecGroupKey
+	data          map[int][]byte // length-prefixed data shards by index
+	parity        map[int][]byte // parity shards by index past count
+	count         int            // data shards in the group, zero until a parity shard arrives
+	done          bool           // every data shard was delivered or recovered
+	authenticated bool
+	started       time.Time
+	elem          *list.Element // position in FECConn.order
+}
+
+// FECConn adds forward error correction to a DatagramConn.
+//
+// Datagrams to each peer are grouped Ratio.Data at a time. Each is sent at once
+// with a group id and index, and when the group fills, or after GroupTimeout,
+// Ratio.Parity Reed-Solomon parity datagrams follow. Receivers deliver datagrams
+// as they arrive and rebuild lost ones as soon as any Data shards of the group
+// have arrived, trading bandwidth for the tunnel round trips a retransmission
+// would cost. Recovered datagrams are delivered after later ones from their group.
+//
+// For messages larger than one datagram, FragmentConfig.FEC adds parity
+// fragments to each message instead.
+//
+// FECConn owns the DatagramConn, which must not be read elsewhere, and closes it
+// on Close.
+type FECConn struct {
+	conn   *DatagramConn
+	config FECConfig
+
+	mu      sync.Mutex
+	sending map[reliablePeerKey]*fecSendGroup
+	groups  map[fecGroupKey]*fecRecvGroup
+	order   *list.List // receive groups, oldest first
+
+	incoming chan *ReceiveResult
+	counters fecCounters
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewFECConn wraps conn in an FECConn. A nil config uses defaults. Returns an
+// error if the ratio does not fit in one Reed-Solomon group.
+func NewFECConn(conn *DatagramConn, config *FECConfig) (*FECConn, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("conn cannot be nil")
+	}
+	var cfg FECConfig
+	if config != nil {
+		cfg = *config
+	}
+	cfg = cfg.withDefaults()
+	if err := cfg.Ratio.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &FECConn{
+		conn:     conn,
+		config:   cfg,
+		sending:  make(map[reliablePeerKey]*fecSendGroup),
+		groups:   make(map[fecGroupKey]*fecRecvGroup),
+		order:    list.New(),
+		incoming: make(chan *ReceiveResult, 100),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	f.wg.Add(2)
+	go pumpConn(&f.wg, conn, f.handle)
+	go tickUntil(f.ctx, &f.wg, cfg.GroupLifetime/4, f.expire)
+	return f, nil
+}
+
+// Conn returns the underlying DatagramConn.
+func (f *FECConn) Conn() *DatagramConn {
+	return f.conn
+}
+
+// MaxPayloadSize returns the largest payload SendTo accepts. Parity datagrams
+// carry the payload length, so they are two bytes larger than the largest
+// datagram in their group.
+func (f *FECConn) MaxPayloadSize() int {
+	return f.conn.MaxPayloadSize() - FECOverhead - fecLengthSize
+}
+
+// SendTo sends payload to the destination and port as the next datagram of the
+// peer's current group, and sends the group's parity once it is full.
+func (f *FECConn) SendTo(payload []byte, destinationB64 string, port uint16) error {
+	if len(payload) > f.MaxPayloadSize() {
+		return fmt.Errorf("payload size %d exceeds maximum %d", len(payload), f.MaxPayloadSize())
+	}
+	key := reliablePeerKey{dest: destinationB64, port: port}
+
+	f.mu.Lock()
+	if f.ctx.Err() != nil {
+		f.mu.Unlock()
+		return net.ErrClosed
+	}
+	g := f.sending[key]
+	if g == nil {
+		var id [4]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			f.mu.Unlock()
+			return fmt.Errorf("failed to generate group id: %w", err)
+		}
+		g = &fecSendGroup{id: binary.BigEndian.Uint32(id[:])}
+		f.sending[key] = g
+	}
+	index := len(g.shards)
+	shard := make([]byte, fecLengthSize, fecLengthSize+len(payload))
+	binary.BigEndian.PutUint16(shard, uint16(len(payload)))
+	g.shards = append(g.shards, append(shard, payload...))
+	frame := fecFrame(g.id, index, 0, payload)
+
+	var parity [][]byte
+	if len(g.shards) == f.config.Ratio.Data {
+		parity = f.finishLocked(key, g)
+	} else if g.timer == nil {
+		g.timer = time.AfterFunc(f.config.GroupTimeout, func() { f.flush(key, g) })
+	}
+	f.mu.Unlock()
+
+	if err := f.conn.SendTo(frame, destinationB64, port); err != nil {
+		return err
+	}
+	f.counters.dataSent.Add(1)
+	f.sendParity(parity, key)
+	return nil
+}
+
+// flush sends parity for a group that did not fill within GroupTimeout.
+func (f *FECConn) flush(key reliablePeerKey, g *fecSendGroup) {
+	f.mu.Lock()
+	if f.sending[key] != g || f.ctx.Err() != nil {
+		f.mu.Unlock()
+		return
+	}
+	parity := f.finishLocked(key, g)
+	f.mu.Unlock()
+	f.sendParity(parity, key)
+}
+
+// finishLocked closes a send group and returns its parity frames. f.mu must be held.
+func (f *FECConn) finishLocked(key reliablePeerKey, g *fecSendGroup) [][]byte {
+	delete(f.sending, key)
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	count := len(g.shards)
+	rs, err := newReedSolomon(count, f.config.Ratio.Parity)
+	if err != nil {
+		return nil
+	}
+	parity := rs.encode(padShards(g.shards))
+	frames := make([][]byte, len(parity))
+	for i, shard := range parity {
+		frames[i] = fecFrame(g.id, count+i, count, shard)
+	}
+	return frames
+}
+
+func (f *FECConn) sendParity(frames [][]byte, key reliablePeerKey) {
+	for _, frame := range frames {
+		if f.conn.SendTo(frame, key.dest, key.port) == nil {
+			f.counters.paritySent.Add(1)
+		}
+	}
+}
+
+// handle records a received shard, delivers data shards, and recovers lost ones
+// once enough shards of the group have arrived.
+func (f *FECConn) handle(result *ReceiveResult) {
+	frame := result.Payload
+	if len(frame) < FECOverhead {
+		f.counters.rejected.Add(1)
+		return
+	}
+	index := int(frame[4])
+	count := int(frame[5])
+	body := frame[FECOverhead:]
+	parity := count > 0
+	if parity && (index < count || len(body) < fecLengthSize) {
+		f.counters.rejected.Add(1)
+		return
+	}
+	key := fecGroupKey{
+		dest:  result.FromAddr.Destination,
+		hash:  result.FromAddr.DestinationHash,
+		port:  result.SrcPort,
+		group: binary.BigEndian.Uint32(frame[0:4]),
+	}
+
+	f.mu.Lock()
+	g := f.groups[key]
+	if g == nil {
+		g = &fecRecvGroup{
+			key:           key,
+			data:          make(map[int][]byte),
+			parity:        make(map[int][]byte),
+			authenticated: true,
+			started:       time.Now(),
+		}
+		g.elem = f.order.PushBack(g)
+		f.groups[key] = g
+		for f.order.Len() > f.config.MaxGroups {
+			f.dropLocked(f.order.Front().Value.(*fecRecvGroup))
+		}
+	}
+	if parity {
+		if (g.count != 0 && g.count != count) || g.parity[index] != nil || g.done {
+			f.mu.Unlock()
+			f.counters.duplicates.Add(1)
+			return
+		}
+		g.count = count
+		g.parity[index] = body
+	} else {
+		if _, dup := g.data[index]; dup || g.done || (g.count != 0 && index >= g.count) {
+			f.mu.Unlock()
+			f.counters.duplicates.Add(1)
+			return
+		}
+		shard := make([]byte, fecLengthSize, fecLengthSize+len(body))
+		binary.BigEndian.PutUint16(shard, uint16(len(body)))
+		g.data[index] = append(shard, body...)
+	}
+	g.authenticated = g.authenticated && result.Authenticated
+	recovered := f.recoverLocked(g)
+	authenticated := g.authenticated
+	f.mu.Unlock()
+
+	if !parity {
+		f.deliver(result, body, result.Authenticated, &f.counters.received)
+	}
+	for _, payload := range recovered {
+		f.deliver(result, payload, authenticated, &f.counters.recovered)
+	}
+}
+
+// recoverLocked returns the payloads of a group's lost data shards once they can
+// be rebuilt. f.mu must be held.
+func (f *FECConn) recoverLocked(g *fecRecvGroup) [][]byte {
+	if g.count == 0 || g.done {
+		return nil
+	}
+	if len(g.data) == g.count {
+		g.done = true
+		return nil
+	}
+	if len(g.data)+len(g.parity) < g.count {
+		return nil
+	}
+	// Parity rows do not depend on the sender's parity count, so a code covering
+	// the highest parity index received decodes the group.
+	size, total := 0, 0
+	for index, shard := range g.parity {
+		size = len(shard)
+		total = max(total, index+1)
+	}
+	rs, err := newReedSolomon(g.count, total-g.count)
+	if err != nil {
+		return nil
+	}
+	shards := make([][]byte, total)
+	for index, shard := range g.data {
+		if len(shard) > size {
+			return nil // parity from a different sender configuration
+		}
+		shards[index] = padShard(shard, size)
+	}
+	for index, shard := range g.parity {
+		if len(shard) != size {
+			return nil
+		}
+		shards[index] = shard
+	}
+	if rs.reconstruct(shards) != nil {
+		return nil
+	}
+	g.done = true
+
+	var recovered [][]byte
+	for index := 0; index < g.count; index++ {
+		if _, ok := g.data[index]; ok {
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(shards[index]))
+		if n > size-fecLengthSize {
+			continue
+		}
+		recovered = append(recovered, shards[index][fecLengthSize:fecLengthSize+n])
+	}
+	return recovered
+}
+
+// deliver queues a payload with the metadata of the datagram that produced it.
+func (f *FECConn) deliver(result *ReceiveResult, payload []byte, authenticated bool, counter *atomic.Uint64) {
+	delivered := *result
+	delivered.Payload = payload
+	delivered.Authenticated = authenticated
+	select {
+	case f.incoming <- &delivered:
+		counter.Add(1)
+	case <-f.ctx.Done():
+	}
+}
+
+// dropLocked forgets a group, counting data shards it lost. f.mu must be held.
+func (f *FECConn) dropLocked(g *fecRecvGroup) {
+	delete(f.groups, g.key)
+	f.order.Remove(g.elem)
+	if !g.done && g.count > len(g.data) {
+		f.counters.unrecoverable.Add(uint64(g.count - len(g.data)))
+	}
+}
+
+// expire drops groups older than GroupLifetime.
+func (f *FECConn) expire(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for e := f.order.Front(); e != nil; {
+		g := e.Value.(*fecRecvGroup)
+		e = e.Next()
+		if now.Sub(g.started) < f.config.GroupLifetime {
+			break
+		}
+		f.dropLocked(g)
+	}
+}
+
+// Receive blocks until a datagram arrives or is recovered, ctx is done, or the
+// connection is closed (net.ErrClosed). A recovered datagram has the metadata of
+// the datagram that completed its group, and is Authenticated only if every
+// datagram of the group received so far was.
+func (f *FECConn) Receive(ctx context.Context) (*ReceiveResult, error) {
+	select {
+	case result := <-f.incoming:
+		return result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-f.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Stats returns the connection's counters.
+func (f *FECConn) Stats() FECStats {
+	return FECStats{
+		DataSent:      f.counters.dataSent.Load(),
+		ParitySent:    f.counters.paritySent.Load(),
+		Received:      f.counters.received.Load(),
+		Recovered:     f.counters.recovered.Load(),
+		Unrecoverable: f.counters.unrecoverable.Load(),
+		Duplicates:    f.counters.duplicates.Load(),
+		Rejected:      f.counters.rejected.Load(),
+	}
+}
+
+// Close discards unfinished groups without sending their parity and closes the
+// underlying DatagramConn.
+func (f *FECConn) Close() error {
+	f.mu.Lock()
+	f.cancel()
+	for _, g := range f.sending {
+		if g.timer != nil {
+			g.timer.Stop()
+		}
+	}
+	f.mu.Unlock()
+	err := f.conn.Close()
+	f.wg.Wait()
+	return err
+}
+
+// fecFrame builds an FECConn datagram.
+func fecFrame(group uint32, index, count int, body []byte) []byte {
+	frame := make([]byte, FECOverhead, FECOverhead+len(body))
+	binary.BigEndian.PutUint32(frame[0:4], group)
+	frame[4] = byte(index)
+	frame[5] = byte(count)
+	return append(frame, body...)
+}
+
+// padShards returns copies of shards zero-padded to the longest.
+func padShards(shards [][]byte) [][]byte {
+	size := 0
+	for _, shard := range shards {
+		size = max(size, len(shard))
+	}
+	padded := make([][]byte, len(shards))
+	for i, shard := range shards {
+		padded[i] = padShard(shard, size)
+	}
+	return padded
+}
+
+// padShard returns shard zero-padded to size.
+func padShard(shard []byte, size int) []byte {
+	padded := make([]byte, size)
+	copy(padded, shard)
+	return padded
+}
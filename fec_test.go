@@ -0,0 +1,122 @@
+package datagrams
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fecPair returns a sending and a receiving FECConn over Raw, with the sender's session.
+func fecPair(t *testing.T, config *FECConfig) (sender, receiver *FECConn, session *mockSession) {
+	t.Helper()
+	cs, cr, session, _ := connPair(t, ProtocolRaw, ProtocolRaw)
+	var err error
+	if sender, err = NewFECConn(cs, config); err != nil {
+		t.Fatalf("NewFECConn() failed: %v", err)
+	}
+	if receiver, err = NewFECConn(cr, config); err != nil {
+		t.Fatalf("NewFECConn() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		sender.Close()
+		receiver.Close()
+	})
+	return sender, receiver, session
+}
+
+// receiveAll receives n payloads and returns them sorted.
+func receiveAll(t *testing.T, f *FECConn, n int) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var payloads []string
+	for len(payloads) < n {
+		result, err := f.Receive(ctx)
+		if err != nil {
+			t.Fatalf("Receive() failed after %v: %v", payloads, err)
+		}
+		payloads = append(payloads, string(result.Payload))
+	}
+	sort.Strings(payloads)
+	return payloads
+}
+
+// TestFECConn_Recover tests rebuilding lost datagrams of a full group.
+func TestFECConn_Recover(t *testing.T) {
+	sender, receiver, session := fecPair(t, &FECConfig{Ratio: FECRatio{Data: 4, Parity: 2}})
+	dropSends(session, func(n int, _ []byte) bool { return n == 2 || n == 3 })
+
+	dest := validDestinationB64()
+	for i := 1; i <= 4; i++ {
+		if err := sender.SendTo([]byte(fmt.Sprintf("datagram %d%s", i, make([]byte, i))), dest, pairPortB); err != nil {
+			t.Fatalf("SendTo() failed: %v", err)
+		}
+	}
+	got := receiveAll(t, receiver, 4)
+	for i, payload := range got {
+		if want := fmt.Sprintf("datagram %d%s", i+1, make([]byte, i+1)); payload != want {
+			t.Errorf("payload %d = %q, want %q", i, payload, want)
+		}
+	}
+	if stats := receiver.Stats(); stats.Received != 2 || stats.Recovered != 2 {
+		t.Errorf("receiver stats = %+v", stats)
+	}
+	if stats := sender.Stats(); stats.DataSent != 4 || stats.ParitySent != 2 {
+		t.Errorf("sender stats = %+v", stats)
+	}
+}
+
+// TestFECConn_GroupTimeout tests that parity for a partial group is sent after
+// GroupTimeout.
+func TestFECConn_GroupTimeout(t *testing.T) {
+	sender, receiver, session := fecPair(t, &FECConfig{Ratio: FECRatio{Data: 4, Parity: 1}, GroupTimeout: 10 * time.Millisecond})
+	dropSends(session, func(n int, _ []byte) bool { return n == 1 })
+
+	dest := validDestinationB64()
+	for _, payload := range []string{"a", "bc"} {
+		if err := sender.SendTo([]byte(payload), dest, pairPortB); err != nil {
+			t.Fatalf("SendTo() failed: %v", err)
+		}
+	}
+	if got := receiveAll(t, receiver, 2); got[0] != "a" || got[1] != "bc" {
+		t.Errorf("received %q", got)
+	}
+	if stats := receiver.Stats(); stats.Recovered != 1 {
+		t.Errorf("receiver stats = %+v", stats)
+	}
+}
+
+// TestFECConn_Unrecoverable tests accounting for groups with too few shards.
+func TestFECConn_Unrecoverable(t *testing.T) {
+	sender, receiver, session := fecPair(t, &FECConfig{Ratio: FECRatio{Data: 4, Parity: 2}, GroupLifetime: 20 * time.Millisecond})
+	dropSends(session, func(n int, _ []byte) bool { return n <= 3 })
+
+	dest := validDestinationB64()
+	for i := 0; i < 4; i++ {
+		if err := sender.SendTo([]byte{byte(i)}, dest, pairPortB); err != nil {
+			t.Fatalf("SendTo() failed: %v", err)
+		}
+	}
+	receiveAll(t, receiver, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for receiver.Stats().Unrecoverable == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := receiver.Stats(); stats.Unrecoverable != 3 || stats.Recovered != 0 {
+		t.Errorf("receiver stats = %+v", stats)
+	}
+}
+
+// TestNewFECConn_Ratio tests ratio validation.
+func TestNewFECConn_Ratio(t *testing.T) {
+	conn, err := NewDatagramConn(newMockSession(), 9000)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := NewFECConn(conn, &FECConfig{Ratio: FECRatio{Data: 200, Parity: 100}}); err == nil {
+		t.Error("expected error for a group larger than MaxFECShards")
+	}
+}
@@ -0,0 +1,719 @@
+package datagrams
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fragment frame types.
+const (
+	// fragmentData is type(1) + message id(4) + index(2) + count(2) + fragment.
+	fragmentData byte = 1
+	// fragmentNack is type(1) + message id(4) + missing indices (2 each).
+	fragmentNack byte = 2
+	// fragmentParity is type(1) + message id(4) + parity index(2) + data fragment
+	// count(2) + message length(4) + parity.
+	fragmentParity byte = 3
+)
+
+const (
+	// FragmentHeaderSize is the per-fragment overhead of FragmentConn:
+	// type(1) + message id(4) + index(2) + count(2) = 9 bytes.
+	FragmentHeaderSize = 1 + 4 + 2 + 2
+
+	// fragmentParityHeaderSize is the header of a parity fragment.
+	fragmentParityHeaderSize = FragmentHeaderSize + 4
+
+	// fragmentPartialCost approximates the memory of a partial message beyond its
+	// fragment bytes (struct, map entry and list element), and fragmentSlotCost
+	// that of each fragment slot. Both count against MaxReassemblyBytes, so empty
+	// fragments of many messages cannot buffer state for free.
+	fragmentPartialCost = 256
+	fragmentSlotCost    = 24
+
+	// DefaultFragmentSize is the default number of message bytes per fragment.
+	// With a Datagram2 envelope a fragment fits in two tunnel messages.
+	DefaultFragmentSize = 1024
+
+	// DefaultMaxFragments is the default maximum number of fragments per message.
+	DefaultMaxFragments = 64
+
+	// DefaultReassemblyTimeout is how long a partial message is kept by default.
+	DefaultReassemblyTimeout = 30 * time.Second
+
+	// DefaultMaxReassemblyBytes is the default cap on fragment bytes buffered for
+	// partial messages across all senders.
+	DefaultMaxReassemblyBytes = 4 << 20
+
+	// DefaultNackDelay is how long a partial message waits by default without new
+	// fragments before its missing fragments are re-requested.
+	DefaultNackDelay = time.Second
+
+	// DefaultNackRetries is the default number of NACKs sent per partial message.
+	DefaultNackRetries = 2
+)
+
+// FragmentConfig configures a FragmentConn. Zero fields take their defaults.
+type FragmentConfig struct {
+	// FragmentSize is the number of message bytes per fragment. Each fragment is
+	// sent as one datagram of FragmentSize+FragmentHeaderSize bytes, so choose it to
+	// fill one, two or three tunnel messages after the protocol envelope, for example
+	// conn.PayloadBudget(2, nil) - FragmentHeaderSize.
+	FragmentSize int
+
+	// MaxFragments caps the fragments per message, so a message can be at most
+	// FragmentSize*MaxFragments bytes. Receivers drop fragments of larger messages.
+	MaxFragments int
+
+	// ReassemblyTimeout is how long a receiver keeps a partial message.
+	ReassemblyTimeout time.Duration
+
+	// MaxReassemblyBytes caps the memory a receiver buffers for partial messages:
+	// their fragment bytes plus a fixed cost per message and per fragment slot.
+	// The oldest partial messages are dropped to stay under it. It also bounds the
+	// recently completed messages remembered to drop late duplicates.
+	MaxReassemblyBytes int
+
+	// Nack enables re-requesting missing fragments. Senders keep sent fragments
+	// for ReassemblyTimeout to answer NACKs, and receivers send NACKs to the
+	// sender's destination, so the protocol must report one (Datagram1/Datagram2).
+	Nack bool
+
+	// NackDelay is how long a partial message waits without new fragments before
+	// a NACK is sent.
+	NackDelay time.Duration
+
+	// NackRetries is the number of NACKs sent per partial message.
+	NackRetries int
+
+	// FEC adds FEC.Parity Reed-Solomon parity fragments per FEC.Data fragments of
+	// each message (rounded up), so receivers rebuild a message from any of its
+	// fragments as numerous as its data fragments. MaxFragments is lowered so a
+	// message and its parity fit in MaxFECShards. The zero value disables FEC;
+	// receivers always accept parity fragments.
+	FEC FECRatio
+}
+
+// parityFragments returns the number of parity fragments for a message of count
+// data fragments.
+func (c FragmentConfig) parityFragments(count int) int {
+	if c.FEC.Parity <= 0 {
+		return 0
+	}
+	return (count*c.FEC.Parity + c.FEC.Data - 1) / c.FEC.Data
+}
+
+func (c FragmentConfig) withDefaults() FragmentConfig {
+	if c.FragmentSize <= 0 {
+		c.FragmentSize = DefaultFragmentSize
+	}
+	if c.MaxFragments <= 0 {
+		c.MaxFragments = DefaultMaxFragments
+	}
+	if c.MaxFragments > 0xFFFF {
+		c.MaxFragments = 0xFFFF
+	}
+	if c.ReassemblyTimeout <= 0 {
+		c.ReassemblyTimeout = DefaultReassemblyTimeout
+	}
+	if c.MaxReassemblyBytes <= 0 {
+		c.MaxReassemblyBytes = DefaultMaxReassemblyBytes
+	}
+	if c.NackDelay <= 0 {
+		c.NackDelay = DefaultNackDelay
+	}
+	if c.NackRetries <= 0 {
+		c.NackRetries = DefaultNackRetries
+	}
+	if c.FEC.Parity > 0 {
+		for c.MaxFragments > 1 && c.MaxFragments+c.parityFragments(c.MaxFragments) > MaxFECShards {
+			c.MaxFragments--
+		}
+	}
+	return c
+}
+
+// FragmentStats reports activity on a FragmentConn.
+type FragmentStats struct {
+	MessagesSent    uint64 // messages sent
+	FragmentsSent   uint64 // fragments sent, excluding resends
+	Reassembled     uint64 // messages delivered to Receive
+	Duplicates      uint64 // duplicate fragments ignored
+	Expired         uint64 // partial messages dropped after ReassemblyTimeout
+	Evicted         uint64 // partial messages dropped to stay under MaxReassemblyBytes
+	Rejected        uint64 // fragments dropped as malformed or over the limits
+	NacksSent       uint64 // NACKs sent
+	FragmentsResent uint64 // fragments resent in answer to NACKs
+	ParitySent      uint64 // parity fragments sent
+	Recovered       uint64 // messages completed by rebuilding lost fragments from parity
+}
+
+type fragmentCounters struct {
+	messagesSent, fragmentsSent, reassembled, duplicates, expired, evicted,
+	rejected, nacksSent, fragmentsResent, paritySent, recovered atomic.Uint64
+}
+
+// fragmentKey identifies a message from one sender.
+type fragmentKey struct {
+	dest  string
+	hash  [32]byte
+	port  uint16
+	msgID uint32
+}
+
+// fragmentPartial is a message being reassembled.
+type fragmentPartial struct {
+	key           fragmentKey
+	fragments     [][]byte
+	have          int
+	size          int
+	parity        map[int][]byte // parity fragments by index
+	paritySize    int            // bytes of parity fragments
+	cost          int            // fixed cost charged to FragmentConn.buffered
+	length        int            // message length from parity fragments, -1 if unknown
+	authenticated bool
+	addr          *I2PAddr
+	started       time.Time
+	lastUpdate    time.Time
+	nacks         int
+	elem          *list.Element // position in FragmentConn.order
+}
+
+// fragmentSent is a sent message retained to answer NACKs.
+type fragmentSent struct {
+	fragments [][]byte // complete frames
+	expires   time.Time
+}
+
+// FragmentConn splits messages larger than one datagram into fragments and
+// reassembles them on receipt.
+//
+// Large datagrams are carried in many tunnel messages, and losing any one loses
+// the datagram, so loss grows exponentially with size. FragmentConn instead sends
+// a message as fragments of FragmentSize bytes, each tagged with a random message
+// id, its index and the fragment count. Receivers reassemble fragments per sender
+// and message id, ignore duplicates, and drop partial messages after
+// ReassemblyTimeout or when the buffered bytes exceed MaxReassemblyBytes. With
+// Nack enabled, receivers re-request missing fragments from the sender; with FEC
+// enabled, senders add parity fragments from which receivers rebuild lost ones.
+//
+// Delivery of a message remains all-or-nothing; combine with ReliableConn-style
+// acknowledgements at the application layer if every message must arrive.
+//
+// FragmentConn owns the DatagramConn, which must not be read elsewhere, and
+// closes it on Close.
+type FragmentConn struct {
+	conn   *DatagramConn
+	config FragmentConfig
+
+	mu        sync.Mutex
+	partials  map[fragmentKey]*fragmentPartial
+	order     *list.List // partials, oldest first
+	buffered  int
+	completed map[fragmentKey]time.Time // recently reassembled, to drop late duplicates
+	doneOrder []fragmentKey             // completed keys, oldest first
+	sent      map[fragmentKey]*fragmentSent
+
+	incoming chan *ReceiveResult
+	counters fragmentCounters
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewFragmentConn wraps conn in a FragmentConn. A nil config uses defaults.
+// Returns an error if a fragment does not fit in one datagram on conn.
+func NewFragmentConn(conn *DatagramConn, config *FragmentConfig) (*FragmentConn, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("conn cannot be nil")
+	}
+	var cfg FragmentConfig
+	if config != nil {
+		cfg = *config
+	}
+	cfg = cfg.withDefaults()
+	if cfg.FEC.Parity > 0 {
+		if err := cfg.FEC.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if max := conn.MaxPayloadSize() - fragmentParityHeaderSize; cfg.FEC.Parity > 0 && cfg.FragmentSize > max {
+		return nil, fmt.Errorf("fragment size %d exceeds maximum %d for this connection with FEC", cfg.FragmentSize, max)
+	}
+	if max := conn.MaxPayloadSize() - FragmentHeaderSize; cfg.FragmentSize > max {
+		return nil, fmt.Errorf("fragment size %d exceeds maximum %d for this connection", cfg.FragmentSize, max)
+	}
+
+	interval := cfg.ReassemblyTimeout
+	if cfg.Nack && cfg.NackDelay < interval {
+		interval = cfg.NackDelay
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &FragmentConn{
+		conn:      conn,
+		config:    cfg,
+		partials:  make(map[fragmentKey]*fragmentPartial),
+		order:     list.New(),
+		completed: make(map[fragmentKey]time.Time),
+		sent:      make(map[fragmentKey]*fragmentSent),
+		incoming:  make(chan *ReceiveResult, 100),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	f.wg.Add(2)
+	go pumpConn(&f.wg, conn, f.handle)
+	go tickUntil(f.ctx, &f.wg, interval/2, f.tick)
+	return f, nil
+}
+
+// Conn returns the underlying DatagramConn.
+func (f *FragmentConn) Conn() *DatagramConn {
+	return f.conn
+}
+
+// MaxMessageSize returns the largest message SendTo accepts.
+func (f *FragmentConn) MaxMessageSize() int {
+	return f.config.FragmentSize * f.config.MaxFragments
+}
+
+// SendTo splits payload into fragments and sends them to the destination and port.
+// An empty payload is sent as a single empty fragment.
+func (f *FragmentConn) SendTo(payload []byte, destinationB64 string, port uint16) error {
+	if len(payload) > f.MaxMessageSize() {
+		return fmt.Errorf("message size %d exceeds maximum %d", len(payload), f.MaxMessageSize())
+	}
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return fmt.Errorf("failed to generate message id: %w", err)
+	}
+	msgID := binary.BigEndian.Uint32(id[:])
+
+	count := (len(payload) + f.config.FragmentSize - 1) / f.config.FragmentSize
+	if count == 0 {
+		count = 1
+	}
+	frames := make([][]byte, count)
+	for i := range frames {
+		chunk := payload[i*f.config.FragmentSize : min((i+1)*f.config.FragmentSize, len(payload))]
+		frame := make([]byte, FragmentHeaderSize, FragmentHeaderSize+len(chunk))
+		frame[0] = fragmentData
+		binary.BigEndian.PutUint32(frame[1:5], msgID)
+		binary.BigEndian.PutUint16(frame[5:7], uint16(i))
+		binary.BigEndian.PutUint16(frame[7:9], uint16(count))
+		frames[i] = append(frame, chunk...)
+	}
+
+	var parity [][]byte
+	if n := f.config.parityFragments(count); n > 0 {
+		parity = fragmentParityFrames(frames, msgID, len(payload), n)
+	}
+
+	if f.config.Nack && count > 1 {
+		key := fragmentKey{dest: destinationB64, port: port, msgID: msgID}
+		f.mu.Lock()
+		f.sent[key] = &fragmentSent{fragments: frames, expires: time.Now().Add(f.config.ReassemblyTimeout)}
+		f.mu.Unlock()
+	}
+
+	for _, frame := range frames {
+		if err := f.conn.SendTo(frame, destinationB64, port); err != nil {
+			return err
+		}
+		f.counters.fragmentsSent.Add(1)
+	}
+	for _, frame := range parity {
+		if err := f.conn.SendTo(frame, destinationB64, port); err != nil {
+			return err
+		}
+		f.counters.paritySent.Add(1)
+	}
+	f.counters.messagesSent.Add(1)
+	return nil
+}
+
+// fragmentParityFrames builds n parity fragments over the data fragment frames
+// of a message of length bytes.
+func fragmentParityFrames(frames [][]byte, msgID uint32, length, n int) [][]byte {
+	rs, err := newReedSolomon(len(frames), n)
+	if err != nil {
+		return nil
+	}
+	size := len(frames[0]) - FragmentHeaderSize
+	shards := make([][]byte, len(frames))
+	for i, frame := range frames {
+		shards[i] = padShard(frame[FragmentHeaderSize:], size)
+	}
+	parity := make([][]byte, n)
+	for i, shard := range rs.encode(shards) {
+		frame := make([]byte, fragmentParityHeaderSize, fragmentParityHeaderSize+size)
+		frame[0] = fragmentParity
+		binary.BigEndian.PutUint32(frame[1:5], msgID)
+		binary.BigEndian.PutUint16(frame[5:7], uint16(i))
+		binary.BigEndian.PutUint16(frame[7:9], uint16(len(frames)))
+		binary.BigEndian.PutUint32(frame[9:13], uint32(length))
+		parity[i] = append(frame, shard...)
+	}
+	return parity
+}
+
+// handle dispatches a received datagram by frame type.
+func (f *FragmentConn) handle(result *ReceiveResult) {
+	if len(result.Payload) < 5 {
+		f.counters.rejected.Add(1)
+		return
+	}
+	switch result.Payload[0] {
+	case fragmentData:
+		f.handleFragment(result)
+	case fragmentNack:
+		f.handleNack(result)
+	case fragmentParity:
+		f.handleParity(result)
+	default:
+		f.counters.rejected.Add(1)
+	}
+}
+
+// handleFragment adds a fragment to its partial message and delivers the message
+// once complete.
+func (f *FragmentConn) handleFragment(result *ReceiveResult) {
+	frame := result.Payload
+	if len(frame) < FragmentHeaderSize {
+		f.counters.rejected.Add(1)
+		return
+	}
+	index := int(binary.BigEndian.Uint16(frame[5:7]))
+	count := int(binary.BigEndian.Uint16(frame[7:9]))
+	data := frame[FragmentHeaderSize:]
+	if count == 0 || index >= count || count > f.config.MaxFragments || len(data) > f.config.FragmentSize {
+		f.counters.rejected.Add(1)
+		return
+	}
+
+	f.mu.Lock()
+	p, ok := f.partialLocked(result, count)
+	if !ok {
+		return
+	}
+	if p.fragments[index] != nil {
+		f.mu.Unlock()
+		f.counters.duplicates.Add(1)
+		return
+	}
+	p.fragments[index] = append([]byte(nil), data...)
+	p.have++
+	p.size += len(data)
+	p.authenticated = p.authenticated && result.Authenticated
+	p.lastUpdate = time.Now()
+	f.buffered += len(data)
+	f.settleLocked(p, result)
+}
+
+// handleParity adds a parity fragment to its partial message and delivers the
+// message once its lost fragments can be rebuilt.
+func (f *FragmentConn) handleParity(result *ReceiveResult) {
+	frame := result.Payload
+	if len(frame) < fragmentParityHeaderSize {
+		f.counters.rejected.Add(1)
+		return
+	}
+	index := int(binary.BigEndian.Uint16(frame[5:7]))
+	count := int(binary.BigEndian.Uint16(frame[7:9]))
+	length := int(binary.BigEndian.Uint32(frame[9:13]))
+	shard := frame[fragmentParityHeaderSize:]
+	if count == 0 || count > f.config.MaxFragments || count+index >= MaxFECShards || len(shard) > f.config.FragmentSize ||
+		length > count*len(shard) || (count > 1 && length <= (count-1)*len(shard)) {
+		f.counters.rejected.Add(1)
+		return
+	}
+
+	f.mu.Lock()
+	p, ok := f.partialLocked(result, count)
+	if !ok {
+		return
+	}
+	if _, dup := p.parity[index]; dup || (p.length >= 0 && p.length != length) {
+		f.mu.Unlock()
+		f.counters.duplicates.Add(1)
+		return
+	}
+	if p.parity == nil {
+		p.parity = make(map[int][]byte)
+	}
+	p.parity[index] = append([]byte(nil), shard...)
+	p.paritySize += len(shard)
+	p.length = length
+	p.authenticated = p.authenticated && result.Authenticated
+	p.lastUpdate = time.Now()
+	f.buffered += len(shard)
+	f.settleLocked(p, result)
+}
+
+// partialLocked returns the partial message a fragment belongs to, creating it if
+// needed. f.mu must be held; it is released if the fragment is to be dropped.
+func (f *FragmentConn) partialLocked(result *ReceiveResult, count int) (*fragmentPartial, bool) {
+	key := fragmentKey{
+		dest:  result.FromAddr.Destination,
+		hash:  result.FromAddr.DestinationHash,
+		port:  result.SrcPort,
+		msgID: binary.BigEndian.Uint32(result.Payload[1:5]),
+	}
+	if _, done := f.completed[key]; done {
+		f.mu.Unlock()
+		f.counters.duplicates.Add(1)
+		return nil, false
+	}
+	p, ok := f.partials[key]
+	if !ok {
+		now := time.Now()
+		p = &fragmentPartial{
+			key:           key,
+			fragments:     make([][]byte, count),
+			length:        -1,
+			cost:          fragmentPartialCost + count*fragmentSlotCost,
+			authenticated: true,
+			addr:          result.FromAddr,
+			started:       now,
+		}
+		p.elem = f.order.PushBack(p)
+		f.partials[key] = p
+		f.buffered += p.cost
+	}
+	if len(p.fragments) != count {
+		f.mu.Unlock()
+		f.counters.rejected.Add(1)
+		return nil, false
+	}
+	return p, true
+}
+
+// settleLocked delivers p if it is complete or can be recovered, and otherwise
+// evicts old partial messages to stay under MaxReassemblyBytes. It releases f.mu.
+func (f *FragmentConn) settleLocked(p *fragmentPartial, result *ReceiveResult) {
+	count := len(p.fragments)
+	recovered := p.have < count && f.recoverLocked(p)
+	if p.have < count {
+		for f.buffered > f.config.MaxReassemblyBytes && f.order.Len() > 0 {
+			f.removeLocked(f.order.Front().Value.(*fragmentPartial))
+			f.counters.evicted.Add(1)
+		}
+		f.mu.Unlock()
+		return
+	}
+
+	f.removeLocked(p)
+	f.completeLocked(p.key)
+	f.mu.Unlock()
+	if recovered {
+		f.counters.recovered.Add(1)
+	}
+
+	message := make([]byte, 0, p.size)
+	for _, fragment := range p.fragments {
+		message = append(message, fragment...)
+	}
+	delivered := *result
+	delivered.Payload = message
+	delivered.Authenticated = p.authenticated
+	select {
+	case f.incoming <- &delivered:
+		f.counters.reassembled.Add(1)
+	case <-f.ctx.Done():
+	}
+}
+
+// completeLocked remembers a reassembled message to drop its late duplicates,
+// forgetting the oldest once MaxReassemblyBytes worth of them are remembered.
+// f.mu must be held.
+func (f *FragmentConn) completeLocked(key fragmentKey) {
+	for len(f.doneOrder) > 0 && len(f.doneOrder) >= f.config.MaxReassemblyBytes/fragmentPartialCost {
+		delete(f.completed, f.doneOrder[0])
+		f.doneOrder = f.doneOrder[1:]
+	}
+	f.completed[key] = time.Now().Add(f.config.ReassemblyTimeout)
+	f.doneOrder = append(f.doneOrder, key)
+}
+
+// recoverLocked rebuilds the lost fragments of p from its parity fragments if
+// enough have arrived, and reports whether it did. f.mu must be held.
+func (f *FragmentConn) recoverLocked(p *fragmentPartial) bool {
+	count := len(p.fragments)
+	if p.have+len(p.parity) < count || p.length < 0 {
+		return false
+	}
+	size, total := 0, 0
+	for index, shard := range p.parity {
+		size = len(shard)
+		total = max(total, count+index+1)
+	}
+	rs, err := newReedSolomon(count, total-count)
+	if err != nil {
+		return false
+	}
+	shards := make([][]byte, total)
+	for index, fragment := range p.fragments {
+		if fragment != nil {
+			if len(fragment) > size {
+				return false
+			}
+			shards[index] = padShard(fragment, size)
+		}
+	}
+	for index, shard := range p.parity {
+		if len(shard) != size {
+			return false
+		}
+		shards[count+index] = shard
+	}
+	if rs.reconstruct(shards) != nil {
+		return false
+	}
+	for index, fragment := range p.fragments {
+		if fragment != nil {
+			continue
+		}
+		n := size
+		if index == count-1 {
+			n = p.length - (count-1)*size
+		}
+		p.fragments[index] = shards[index][:n]
+		p.have++
+		p.size += n
+		f.buffered += n
+	}
+	return true
+}
+
+// removeLocked drops a partial message. f.mu must be held.
+func (f *FragmentConn) removeLocked(p *fragmentPartial) {
+	if _, ok := f.partials[p.key]; !ok {
+		return
+	}
+	delete(f.partials, p.key)
+	f.order.Remove(p.elem)
+	f.buffered -= p.size + p.paritySize + p.cost
+}
+
+// handleNack resends the fragments a receiver reports missing, each at most once
+// per NACK.
+func (f *FragmentConn) handleNack(result *ReceiveResult) {
+	frame := result.Payload
+	key := fragmentKey{dest: result.FromAddr.Destination, port: result.SrcPort, msgID: binary.BigEndian.Uint32(frame[1:5])}
+	f.mu.Lock()
+	sent, ok := f.sent[key]
+	f.mu.Unlock()
+	if !ok || key.dest == "" {
+		return
+	}
+	resent := make([]bool, len(sent.fragments))
+	for i := 5; i+2 <= len(frame); i += 2 {
+		index := int(binary.BigEndian.Uint16(frame[i : i+2]))
+		if index < len(sent.fragments) && !resent[index] {
+			resent[index] = true
+			f.conn.SendTo(sent.fragments[index], key.dest, key.port)
+			f.counters.fragmentsResent.Add(1)
+		}
+	}
+}
+
+// tick expires partial messages and retained fragments, and sends due NACKs.
+func (f *FragmentConn) tick(now time.Time) {
+	type nack struct {
+		frame []byte
+		addr  *I2PAddr
+	}
+	var nacks []nack
+
+	f.mu.Lock()
+	for e := f.order.Front(); e != nil; {
+		p := e.Value.(*fragmentPartial)
+		e = e.Next()
+		if now.Sub(p.started) >= f.config.ReassemblyTimeout {
+			f.removeLocked(p)
+			f.counters.expired.Add(1)
+			continue
+		}
+		if f.config.Nack && p.addr.Destination != "" && p.nacks < f.config.NackRetries && now.Sub(p.lastUpdate) >= f.config.NackDelay {
+			p.nacks++
+			p.lastUpdate = now
+			frame := make([]byte, 5, 5+2*(len(p.fragments)-p.have))
+			frame[0] = fragmentNack
+			binary.BigEndian.PutUint32(frame[1:5], p.key.msgID)
+			for i, fragment := range p.fragments {
+				if fragment == nil {
+					frame = binary.BigEndian.AppendUint16(frame, uint16(i))
+				}
+			}
+			nacks = append(nacks, nack{frame: frame, addr: p.addr})
+		}
+	}
+	n := 0
+	for n < len(f.doneOrder) && now.After(f.completed[f.doneOrder[n]]) {
+		delete(f.completed, f.doneOrder[n])
+		n++
+	}
+	if n > 0 {
+		// Copy down instead of reslicing so the backing array does not grow unbounded
+		f.doneOrder = append(f.doneOrder[:0], f.doneOrder[n:]...)
+	}
+	for key, sent := range f.sent {
+		if now.After(sent.expires) {
+			delete(f.sent, key)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, n := range nacks {
+		if f.conn.SendTo(n.frame, n.addr.Destination, n.addr.Port) == nil {
+			f.counters.nacksSent.Add(1)
+		}
+	}
+}
+
+// Receive blocks until a complete message is reassembled, ctx is done, or the
+// connection is closed (net.ErrClosed). The result's metadata comes from the
+// fragment that completed the message; Authenticated is true only if every
+// fragment was authenticated.
+func (f *FragmentConn) Receive(ctx context.Context) (*ReceiveResult, error) {
+	select {
+	case result := <-f.incoming:
+		return result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-f.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Stats returns the connection's counters.
+func (f *FragmentConn) Stats() FragmentStats {
+	return FragmentStats{
+		MessagesSent:    f.counters.messagesSent.Load(),
+		FragmentsSent:   f.counters.fragmentsSent.Load(),
+		Reassembled:     f.counters.reassembled.Load(),
+		Duplicates:      f.counters.duplicates.Load(),
+		Expired:         f.counters.expired.Load(),
+		Evicted:         f.counters.evicted.Load(),
+		Rejected:        f.counters.rejected.Load(),
+		NacksSent:       f.counters.nacksSent.Load(),
+		FragmentsResent: f.counters.fragmentsResent.Load(),
+		ParitySent:      f.counters.paritySent.Load(),
+		Recovered:       f.counters.recovered.Load(),
+	}
+}
+
+// Close discards partial messages and closes the underlying DatagramConn.
+func (f *FragmentConn) Close() error {
+	f.cancel()
+	err := f.conn.Close()
+	f.wg.Wait()
+	return err
+}
@@ -0,0 +1,298 @@
+package datagrams
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// fragmentFrame builds a FragmentConn data frame.
+func fragmentFrame(msgID uint32, index, count uint16, data string) []byte {
+	frame := make([]byte, FragmentHeaderSize)
+	frame[0] = fragmentData
+	binary.BigEndian.PutUint32(frame[1:5], msgID)
+	binary.BigEndian.PutUint16(frame[5:7], index)
+	binary.BigEndian.PutUint16(frame[7:9], count)
+	return append(frame, data...)
+}
+
+// newFragmentReceiver returns a FragmentConn over Raw and a function injecting frames into it.
+func newFragmentReceiver(t *testing.T, config *FragmentConfig) (*FragmentConn, func([]byte)) {
+	t.Helper()
+	conn, err := NewDatagramConn(newMockSession(), 4000)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	f, err := NewFragmentConn(conn, config)
+	if err != nil {
+		t.Fatalf("NewFragmentConn() failed: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f, func(frame []byte) { conn.injectMessage(frame, nil, ProtocolRaw, 4001, 4000) }
+}
+
+func receiveMessage(t *testing.T, f *FragmentConn, timeout time.Duration) (*ReceiveResult, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return f.Receive(ctx)
+}
+
+// TestFragmentConn_RoundTrip tests splitting and reassembling a message.
+func TestFragmentConn_RoundTrip(t *testing.T) {
+	receiver, _ := newFragmentReceiver(t, &FragmentConfig{FragmentSize: 1000})
+	session := newMockSession()
+	conn, err := NewDatagramConn(session, 4001)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	sender, err := NewFragmentConn(conn, &FragmentConfig{FragmentSize: 1000})
+	if err != nil {
+		t.Fatalf("NewFragmentConn() failed: %v", err)
+	}
+	defer sender.Close()
+	routeTo(session, receiver.Conn())
+
+	message := bytes.Repeat([]byte("0123456789"), 250)
+	if err := sender.SendTo(message, validDestinationB64(), 4000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	if len(session.lastPayload) != FragmentHeaderSize+500 {
+		t.Errorf("last fragment is %d bytes, want %d", len(session.lastPayload), FragmentHeaderSize+500)
+	}
+	result, err := receiveMessage(t, receiver, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+	if !bytes.Equal(result.Payload, message) {
+		t.Errorf("reassembled %d bytes, want %d", len(result.Payload), len(message))
+	}
+
+	if err := sender.SendTo(nil, validDestinationB64(), 4000); err != nil {
+		t.Fatalf("SendTo(empty) failed: %v", err)
+	}
+	if result, err := receiveMessage(t, receiver, 5*time.Second); err != nil || len(result.Payload) != 0 {
+		t.Errorf("Receive() = %v, %v, want empty message", result, err)
+	}
+	if err := sender.SendTo(make([]byte, sender.MaxMessageSize()+1), validDestinationB64(), 4000); err == nil {
+		t.Error("expected error for oversized message")
+	}
+	if stats := sender.Stats(); stats.MessagesSent != 2 || stats.FragmentsSent != 4 {
+		t.Errorf("sender stats = %+v", stats)
+	}
+}
+
+// TestFragmentConn_Reassembly tests out-of-order and duplicate fragments.
+func TestFragmentConn_Reassembly(t *testing.T) {
+	f, inject := newFragmentReceiver(t, nil)
+	inject(fragmentFrame(1, 2, 3, "c"))
+	inject(fragmentFrame(1, 0, 3, "a"))
+	inject(fragmentFrame(1, 0, 3, "a"))
+	inject(fragmentFrame(2, 0, 1, "other"))
+	inject(fragmentFrame(1, 1, 3, "b"))
+	inject(fragmentFrame(1, 1, 3, "b")) // after completion
+
+	for _, want := range []string{"other", "abc"} {
+		result, err := receiveMessage(t, f, 5*time.Second)
+		if err != nil || string(result.Payload) != want {
+			t.Fatalf("Receive() = %v, %v, want %q", result, err, want)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if stats := f.Stats(); stats.Reassembled != 2 || stats.Duplicates != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestFragmentConn_Limits tests rejection, eviction and expiry of partial messages.
+func TestFragmentConn_Limits(t *testing.T) {
+	f, inject := newFragmentReceiver(t, &FragmentConfig{
+		FragmentSize:       4,
+		MaxFragments:       3,
+		MaxReassemblyBytes: 2*fragmentPartialCost + 5*fragmentSlotCost + 8, // messages 2 and 3
+		ReassemblyTimeout:  40 * time.Millisecond,
+	})
+	inject(fragmentFrame(1, 0, 4, "a"))     // too many fragments
+	inject(fragmentFrame(1, 0, 2, "abcde")) // fragment too large
+	inject(fragmentFrame(1, 2, 2, "a"))     // index out of range
+	inject([]byte{fragmentData, 0, 0})      // truncated
+	inject(fragmentFrame(2, 0, 3, "aaaa"))
+	inject(fragmentFrame(2, 1, 2, "aaaa")) // count mismatch
+	inject(fragmentFrame(3, 0, 2, "bbbb"))
+	inject(fragmentFrame(4, 0, 2, "cccc")) // over the limit, evicts message 2
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Stats().Expired < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := f.Stats()
+	if stats.Rejected != 5 || stats.Evicted != 1 || stats.Expired != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	f.mu.Lock()
+	if len(f.partials) != 0 || f.buffered != 0 {
+		t.Errorf("%d partials with %d bytes remain", len(f.partials), f.buffered)
+	}
+	f.mu.Unlock()
+}
+
+// TestFragmentConn_EmptyFragments tests that partial messages holding no bytes
+// still count against MaxReassemblyBytes, and that completed messages are bounded.
+func TestFragmentConn_EmptyFragments(t *testing.T) {
+	limit := 10 * (fragmentPartialCost + 2*fragmentSlotCost)
+	f, inject := newFragmentReceiver(t, &FragmentConfig{MaxReassemblyBytes: limit})
+	for id := uint32(1); id <= 1000; id++ {
+		inject(fragmentFrame(id, 0, 2, ""))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Stats().Evicted < 990 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.partials) != 10 || f.buffered > limit {
+		t.Errorf("%d partials buffering %d bytes, want 10 within %d", len(f.partials), f.buffered, limit)
+	}
+
+	for id := uint32(1); id <= 1000; id++ {
+		f.completeLocked(fragmentKey{msgID: id})
+	}
+	if max := limit / fragmentPartialCost; len(f.completed) > max || len(f.doneOrder) != len(f.completed) {
+		t.Errorf("%d completed messages remembered (%d ordered), want at most %d", len(f.completed), len(f.doneOrder), max)
+	}
+}
+
+// TestFragmentConn_NackDuplicates tests that a NACK repeating an index resends
+// the fragment once.
+func TestFragmentConn_NackDuplicates(t *testing.T) {
+	conn, err := NewDatagramConn(newMockSession(), 4000)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	f, err := NewFragmentConn(conn, &FragmentConfig{FragmentSize: 4, Nack: true})
+	if err != nil {
+		t.Fatalf("NewFragmentConn() failed: %v", err)
+	}
+	defer f.Close()
+	dest := validDestinationB64()
+	if err := f.SendTo([]byte("two frags"), dest, 4001); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	var msgID uint32
+	f.mu.Lock()
+	for key := range f.sent {
+		msgID = key.msgID
+	}
+	f.mu.Unlock()
+
+	nack := binary.BigEndian.AppendUint32([]byte{fragmentNack}, msgID)
+	for i := 0; i < 1000; i++ {
+		nack = binary.BigEndian.AppendUint16(nack, 0)
+	}
+	nack = binary.BigEndian.AppendUint16(nack, 2)
+	f.handleNack(&ReceiveResult{Payload: nack, SrcPort: 4001, FromAddr: &I2PAddr{Destination: dest}})
+	if stats := f.Stats(); stats.FragmentsResent != 2 {
+		t.Errorf("FragmentsResent = %d, want 2", stats.FragmentsResent)
+	}
+}
+
+// TestFragmentConn_Nack tests re-requesting a lost fragment.
+func TestFragmentConn_Nack(t *testing.T) {
+	config := &FragmentConfig{FragmentSize: 100, Nack: true, NackDelay: 20 * time.Millisecond}
+	sa, sb := newMockSession(), newMockSession()
+	ca, err := NewDatagramConnWithProtocol(sa, 4001, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	cb, err := NewDatagramConnWithProtocol(sb, 4000, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	routeTo(sa, cb)
+	routeTo(sb, ca)
+	dropSends(sa, func(n int, _ []byte) bool { return n == 2 }) // fragment 1
+	sender, err := NewFragmentConn(ca, config)
+	if err != nil {
+		t.Fatalf("NewFragmentConn() failed: %v", err)
+	}
+	defer sender.Close()
+	receiver, err := NewFragmentConn(cb, config)
+	if err != nil {
+		t.Fatalf("NewFragmentConn() failed: %v", err)
+	}
+	defer receiver.Close()
+
+	message := bytes.Repeat([]byte("x"), 250)
+	if err := sender.SendTo(message, sb.Destination().Base64(), 4000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	result, err := receiveMessage(t, receiver, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+	if !bytes.Equal(result.Payload, message) || !result.Authenticated {
+		t.Errorf("Receive() = %d bytes, Authenticated = %v", len(result.Payload), result.Authenticated)
+	}
+	if stats := receiver.Stats(); stats.NacksSent != 1 {
+		t.Errorf("receiver stats = %+v", stats)
+	}
+	if stats := sender.Stats(); stats.FragmentsResent != 1 {
+		t.Errorf("sender stats = %+v", stats)
+	}
+}
+
+// TestNewFragmentConn_FragmentSize tests that fragments must fit in one datagram.
+func TestNewFragmentConn_FragmentSize(t *testing.T) {
+	conn, err := NewDatagramConnWithProtocol(newMockSession(), 4000, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := NewFragmentConn(conn, &FragmentConfig{FragmentSize: conn.MaxPayloadSize()}); err == nil {
+		t.Error("expected error for fragment size exceeding the datagram limit")
+	}
+}
+
+// TestFragmentConn_FEC tests rebuilding lost fragments from parity fragments.
+func TestFragmentConn_FEC(t *testing.T) {
+	config := &FragmentConfig{FragmentSize: 100, FEC: FECRatio{Data: 4, Parity: 2}}
+	receiver, _ := newFragmentReceiver(t, config)
+	session := newMockSession()
+	conn, err := NewDatagramConn(session, 4001)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	sender, err := NewFragmentConn(conn, config)
+	if err != nil {
+		t.Fatalf("NewFragmentConn() failed: %v", err)
+	}
+	defer sender.Close()
+	routeTo(session, receiver.Conn())
+	dropSends(session, func(n int, _ []byte) bool { return n == 2 || n == 4 }) // fragments 1 and 3
+
+	message := bytes.Repeat([]byte("0123456"), 50)
+	if err := sender.SendTo(message, validDestinationB64(), 4000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	result, err := receiveMessage(t, receiver, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+	if !bytes.Equal(result.Payload, message) {
+		t.Errorf("recovered %d bytes, want %d", len(result.Payload), len(message))
+	}
+	if stats := sender.Stats(); stats.FragmentsSent != 4 || stats.ParitySent != 2 {
+		t.Errorf("sender stats = %+v", stats)
+	}
+	if stats := receiver.Stats(); stats.Recovered != 1 || stats.Reassembled != 1 {
+		t.Errorf("receiver stats = %+v", stats)
+	}
+	if sender.config.MaxFragments != 64 {
+		t.Errorf("MaxFragments = %d, want 64", sender.config.MaxFragments)
+	}
+	if f, _ := NewFragmentConn(conn, &FragmentConfig{MaxFragments: 1000, FEC: FECRatio{Data: 1, Parity: 1}}); f.config.MaxFragments != 128 {
+		t.Errorf("MaxFragments with 1:1 FEC = %d, want 128", f.config.MaxFragments)
+	}
+}
@@ -1,14 +1,14 @@
 module github.com/go-i2p/go-datagrams
 
-go 1.26.3
+go 1.27.0
 
 require (
+	filippo.io/edwards25519 v1.2.0
 	github.com/go-i2p/common v0.1.60000-0.20260701134558-e5f5cf65a7f5
 	github.com/go-i2p/go-i2cp v0.1.60000-0.20260701134816-aa86eb2db4a5
 )
 
 require (
-	filippo.io/edwards25519 v1.2.0 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/go-i2p/crypto v0.1.60000-0.20260701135847-3ade996b68a0 // indirect
 	github.com/go-i2p/logger v0.1.60000-0.20260701134448-2648c3b0e040 // indirect
@@ -0,0 +1,399 @@
+package datagrams
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Probe frame types.
+const (
+	// probeRequest is type(1) + probe id(8) + reply destination. The reply
+	// destination is the prober's wire destination on protocols that do not
+	// carry one (Raw, Datagram3), and empty otherwise.
+	probeRequest byte = 1
+	// probeResponse is type(1) + probe id(8).
+	probeResponse byte = 2
+)
+
+const (
+	// probeHeaderSize is the size of a probe response and of a request without a
+	// reply destination.
+	probeHeaderSize = 1 + 8
+
+	// DefaultProbePort is the port HealthMonitors send probes to and answer them on.
+	DefaultProbePort uint16 = 65534
+
+	// DefaultProbeInterval is how often each watched peer is probed by default.
+	DefaultProbeInterval = 30 * time.Second
+
+	// DefaultProbeTimeout is how long a probe waits for its response by default
+	// before it counts as lost.
+	DefaultProbeTimeout = 10 * time.Second
+
+	// DefaultDownAfter is the default number of consecutive lost probes after
+	// which a peer is reported down.
+	DefaultDownAfter = 3
+)
+
+// HealthConfig configures a HealthMonitor. Zero fields take their defaults.
+type HealthConfig struct {
+	// Port is the port probes are sent to and answered on. Peers must use the same.
+	Port uint16
+
+	// Interval is how often each watched peer is probed.
+	Interval time.Duration
+
+	// Timeout is how long a probe waits for its response before it counts as lost.
+	Timeout time.Duration
+
+	// DownAfter is the number of consecutive lost probes after which an up peer
+	// is reported down.
+	DownAfter int
+
+	// OnUp is called when a peer answers a probe while not up, and OnDown when an
+	// up peer loses DownAfter probes in a row. They are called without locks held
+	// from the monitor's goroutines and should not block.
+	OnUp   func(PeerStats)
+	OnDown func(PeerStats)
+}
+
+func (c HealthConfig) withDefaults() HealthConfig {
+	if c.Port == 0 {
+		c.Port = DefaultProbePort
+	}
+	if c.Interval <= 0 {
+		c.Interval = DefaultProbeInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultProbeTimeout
+	}
+	if c.DownAfter <= 0 {
+		c.DownAfter = DefaultDownAfter
+	}
+	return c
+}
+
+// PeerStats is the health of one watched peer.
+type PeerStats struct {
+	// Addr is the peer's destination and probe port.
+	Addr *I2PAddr
+
+	// Up reports whether the peer answered its recent probes. Peers start down
+	// until their first response.
+	Up bool
+
+	// RTT is the smoothed round-trip time and Jitter its mean deviation
+	// (RFC 3550), both zero until the first response.
+	RTT    time.Duration
+	Jitter time.Duration
+
+	// Loss is an exponentially weighted moving average of the fraction of probes
+	// lost, weighting each new probe by 1/8.
+	Loss float64
+
+	// Sent, Received and Lost count probes.
+	Sent     uint64
+	Received uint64
+	Lost     uint64
+
+	// ConsecutiveLost counts probes lost since the last response.
+	ConsecutiveLost int
+
+	// LastSeen is when the last response arrived.
+	LastSeen time.Time
+}
+
+// healthPeer is the state of one watched peer.
+type healthPeer struct {
+	stats   PeerStats
+	lastRTT time.Duration
+}
+
+// healthProbe is a probe waiting for its response.
+type healthProbe struct {
+	peer   *healthPeer
+	hash   [32]byte
+	sentAt time.Time
+	timer  *time.Timer
+}
+
+// HealthMonitor probes peer destinations and estimates their round-trip time,
+// jitter and loss.
+//
+// Each watched peer is sent a probe on Port every Interval; the peer's
+// HealthMonitor answers it. Responses update the peer's smoothed RTT and jitter,
+// and probes without a response within Timeout count as lost. Peers are reported
+// up on their first response and down after DownAfter consecutive losses.
+//
+// A HealthMonitor handles Port on its DatagramConn with RegisterResultHandler and
+// leaves other ports to the application. Every HealthMonitor also answers
+// probes, so peers that only need to be reachable can run one without watching
+// anyone. Probes carry the prober's destination on Raw and Datagram3, whose
+// datagrams do not, so the peer can reply.
+type HealthMonitor struct {
+	conn    *DatagramConn
+	config  HealthConfig
+	replyTo []byte // local destination for protocols without a sender destination
+	nextID  atomic.Uint64
+
+	mu      sync.Mutex
+	peers   map[[32]byte]*healthPeer
+	pending map[uint64]*healthProbe
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewHealthMonitor starts a HealthMonitor on conn. A nil config uses defaults.
+// Returns an error if the probe port is already registered on conn.
+func NewHealthMonitor(conn *DatagramConn, config *HealthConfig) (*HealthMonitor, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("conn cannot be nil")
+	}
+	var cfg HealthConfig
+	if config != nil {
+		cfg = *config
+	}
+	cfg = cfg.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &HealthMonitor{
+		conn:    conn,
+		config:  cfg,
+		peers:   make(map[[32]byte]*healthPeer),
+		pending: make(map[uint64]*healthProbe),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	h.replyTo = replyTo(conn)
+	h.nextID.Store(uint64(time.Now().UnixNano()))
+	if err := conn.RegisterResultHandler(cfg.Port, h.handle); err != nil {
+		cancel()
+		return nil, err
+	}
+	h.wg.Add(1)
+	go tickUntil(h.ctx, &h.wg, cfg.Interval, h.probeAll)
+	return h, nil
+}
+
+// Watch starts probing the destination, beginning immediately. Watching a
+// destination twice has no effect.
+func (h *HealthMonitor) Watch(destinationB64 string) error {
+	dest, err := parseDestinationB64(destinationB64)
+	if err != nil {
+		return err
+	}
+	hash := dest.hash()
+	h.mu.Lock()
+	peer, exists := h.peers[hash]
+	if !exists {
+		peer = &healthPeer{stats: PeerStats{Addr: &I2PAddr{Destination: destinationB64, DestinationHash: hash, Port: h.config.Port}}}
+		h.peers[hash] = peer
+	}
+	h.mu.Unlock()
+	if !exists {
+		h.probe(hash, peer, time.Now())
+	}
+	return nil
+}
+
+// Unwatch stops probing the destination and forgets its statistics.
+func (h *HealthMonitor) Unwatch(destinationB64 string) {
+	dest, err := parseDestinationB64(destinationB64)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.peers, dest.hash())
+}
+
+// PeerStats returns the health of the watched peer at addr, identified by its
+// DestinationHash or else its Destination. The second result is false if the
+// peer is not watched.
+func (h *HealthMonitor) PeerStats(addr *I2PAddr) (PeerStats, bool) {
+	if addr == nil {
+		return PeerStats{}, false
+	}
+	hash := addr.DestinationHash
+	if !addr.HasDestinationHash() {
+		dest, err := parseDestinationB64(addr.Destination)
+		if err != nil {
+			return PeerStats{}, false
+		}
+		hash = dest.hash()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	peer, ok := h.peers[hash]
+	if !ok {
+		return PeerStats{}, false
+	}
+	return peer.stats, true
+}
+
+// Peers returns the health of every watched peer.
+func (h *HealthMonitor) Peers() []PeerStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := make([]PeerStats, 0, len(h.peers))
+	for _, peer := range h.peers {
+		stats = append(stats, peer.stats)
+	}
+	return stats
+}
+
+// probeAll probes every watched peer.
+func (h *HealthMonitor) probeAll(now time.Time) {
+	h.mu.Lock()
+	peers := make(map[[32]byte]*healthPeer, len(h.peers))
+	for hash, peer := range h.peers {
+		peers[hash] = peer
+	}
+	h.mu.Unlock()
+	for hash, peer := range peers {
+		h.probe(hash, peer, now)
+	}
+}
+
+// probe sends one probe to peer and arms its timeout.
+func (h *HealthMonitor) probe(hash [32]byte, peer *healthPeer, now time.Time) {
+	id := h.nextID.Add(1)
+	frame := make([]byte, probeHeaderSize, probeHeaderSize+len(h.replyTo))
+	frame[0] = probeRequest
+	binary.BigEndian.PutUint64(frame[1:9], id)
+	frame = append(frame, h.replyTo...)
+
+	p := &healthProbe{peer: peer, hash: hash, sentAt: now}
+	h.mu.Lock()
+	if h.ctx.Err() != nil {
+		h.mu.Unlock()
+		return
+	}
+	h.pending[id] = p
+	peer.stats.Sent++
+	p.timer = time.AfterFunc(h.config.Timeout, func() { h.lost(id) })
+	addr := peer.stats.Addr
+	h.mu.Unlock()
+
+	// A failed send is detected as a lost probe
+	h.conn.SendTo(frame, addr.Destination, addr.Port)
+}
+
+// lost records a probe that timed out.
+func (h *HealthMonitor) lost(id uint64) {
+	h.mu.Lock()
+	p, ok := h.pending[id]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.pending, id)
+	stats := &p.peer.stats
+	stats.Lost++
+	stats.ConsecutiveLost++
+	stats.Loss += (1 - stats.Loss) / 8
+	down := stats.Up && stats.ConsecutiveLost >= h.config.DownAfter && h.peers[p.hash] == p.peer
+	if down {
+		stats.Up = false
+	}
+	snapshot := *stats
+	h.mu.Unlock()
+
+	if down && h.config.OnDown != nil {
+		h.config.OnDown(snapshot)
+	}
+}
+
+// handle answers probe requests and records probe responses.
+func (h *HealthMonitor) handle(result *ReceiveResult) {
+	if len(result.Payload) < probeHeaderSize {
+		return
+	}
+	switch result.Payload[0] {
+	case probeRequest:
+		h.answer(result)
+	case probeResponse:
+		h.record(result)
+	}
+}
+
+// answer replies to a probe request on the probe port of its sender.
+func (h *HealthMonitor) answer(result *ReceiveResult) {
+	dest := replyDestination(result, probeHeaderSize)
+	if dest == "" {
+		return
+	}
+	response := make([]byte, probeHeaderSize)
+	response[0] = probeResponse
+	copy(response[1:], result.Payload[1:probeHeaderSize])
+	h.conn.SendTo(response, dest, h.config.Port)
+}
+
+// record updates a peer's estimates with a probe response.
+func (h *HealthMonitor) record(result *ReceiveResult) {
+	id := binary.BigEndian.Uint64(result.Payload[1:9])
+	now := result.ReceivedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	h.mu.Lock()
+	p, ok := h.pending[id]
+	if !ok || (result.FromAddr.HasDestinationHash() && result.FromAddr.DestinationHash != p.hash) {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.pending, id)
+	p.timer.Stop()
+
+	peer := p.peer
+	stats := &peer.stats
+	rtt := max(now.Sub(p.sentAt), 0)
+	if stats.Received == 0 {
+		stats.RTT = rtt
+	} else {
+		stats.RTT += (rtt - stats.RTT) / 8
+		delta := rtt - peer.lastRTT
+		if delta < 0 {
+			delta = -delta
+		}
+		stats.Jitter += (delta - stats.Jitter) / 16
+	}
+	peer.lastRTT = rtt
+	stats.Received++
+	stats.ConsecutiveLost = 0
+	stats.Loss -= stats.Loss / 8
+	stats.LastSeen = now
+	up := !stats.Up && h.peers[p.hash] == peer
+	if up {
+		stats.Up = true
+	}
+	snapshot := *stats
+	h.mu.Unlock()
+
+	if up && h.config.OnUp != nil {
+		h.config.OnUp(snapshot)
+	}
+}
+
+// Close stops probing and unregisters the probe port. The DatagramConn stays open.
+func (h *HealthMonitor) Close() error {
+	h.mu.Lock()
+	h.cancel()
+	for id, p := range h.pending {
+		p.timer.Stop()
+		delete(h.pending, id)
+	}
+	h.mu.Unlock()
+	h.wg.Wait()
+	if err := h.conn.UnregisterPort(h.config.Port); err != nil && !h.conn.IsClosed() {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package datagrams
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthPair returns a prober and a responder HealthMonitor over protocol routed
+// to each other, with their sessions.
+func healthPair(t *testing.T, protocol uint8, config *HealthConfig) (prober, responder *HealthMonitor, sp, sr *mockSession) {
+	t.Helper()
+	cp, cr, sp, sr := connPair(t, protocol, protocol)
+	var err error
+	if prober, err = NewHealthMonitor(cp, config); err != nil {
+		t.Fatalf("NewHealthMonitor() failed: %v", err)
+	}
+	if responder, err = NewHealthMonitor(cr, &HealthConfig{Port: config.Port}); err != nil {
+		t.Fatalf("NewHealthMonitor() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		prober.Close()
+		responder.Close()
+	})
+	return prober, responder, sp, sr
+}
+
+// waitPeer waits for a peer state change reported on ch.
+func waitPeer(t *testing.T, ch <-chan PeerStats, what string) PeerStats {
+	t.Helper()
+	select {
+	case stats := <-ch:
+		return stats
+	case <-time.After(5 * time.Second):
+		t.Fatalf("peer never reported %s", what)
+		return PeerStats{}
+	}
+}
+
+// TestHealthMonitor_UpDown tests that a peer comes up on its first response and
+// goes down after consecutive lost probes.
+func TestHealthMonitor_UpDown(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		protocol uint8
+	}{
+		{"datagram2", ProtocolDatagram2},
+		{"raw", ProtocolRaw},
+		{"datagram3", ProtocolDatagram3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			up, down := make(chan PeerStats, 4), make(chan PeerStats, 4)
+			config := &HealthConfig{
+				Interval:  20 * time.Millisecond,
+				Timeout:   50 * time.Millisecond,
+				DownAfter: 2,
+				OnUp:      func(s PeerStats) { up <- s },
+				OnDown:    func(s PeerStats) { down <- s },
+			}
+			prober, _, _, sr := healthPair(t, tc.protocol, config)
+			var cut atomic.Bool
+			dropSends(sr, func(int, []byte) bool { return cut.Load() })
+			dest := sr.Destination().Base64()
+			if err := prober.Watch(dest); err != nil {
+				t.Fatalf("Watch() failed: %v", err)
+			}
+
+			stats := waitPeer(t, up, "up")
+			if !stats.Up || stats.Received == 0 || stats.Addr.Destination != dest || stats.Addr.Port != DefaultProbePort {
+				t.Errorf("OnUp stats = %+v", stats)
+			}
+
+			cut.Store(true)
+			stats = waitPeer(t, down, "down")
+			if stats.Up || stats.ConsecutiveLost < 2 || stats.Lost < 2 || stats.Loss <= 0 {
+				t.Errorf("OnDown stats = %+v", stats)
+			}
+			if got, ok := prober.PeerStats(&I2PAddr{Destination: dest}); !ok || got.Up {
+				t.Errorf("PeerStats() = %+v, %v; want a down peer", got, ok)
+			}
+		})
+	}
+}
+
+// TestHealthMonitor_Stats tests RTT estimates, peer lookup and Unwatch.
+func TestHealthMonitor_Stats(t *testing.T) {
+	up := make(chan PeerStats, 1)
+	prober, _, _, sr := healthPair(t, ProtocolDatagram2, &HealthConfig{
+		Port:     4000,
+		Interval: 20 * time.Millisecond,
+		OnUp:     func(s PeerStats) { up <- s },
+	})
+	dest := sr.Destination().Base64()
+	if err := prober.Watch(dest); err != nil {
+		t.Fatalf("Watch() failed: %v", err)
+	}
+	if err := prober.Watch("not a destination"); err == nil {
+		t.Error("Watch() accepted an invalid destination")
+	}
+	waitPeer(t, up, "up")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, ok := prober.PeerStats(&I2PAddr{Destination: dest})
+		if !ok {
+			t.Fatal("PeerStats() did not find the watched peer")
+		}
+		if stats.Received >= 3 {
+			if stats.RTT <= 0 || stats.LastSeen.IsZero() || stats.Loss != 0 || stats.Addr.Port != 4000 {
+				t.Errorf("PeerStats() = %+v", stats)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d probes answered", stats.Received)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if peers := prober.Peers(); len(peers) != 1 {
+		t.Errorf("Peers() returned %d peers, want 1", len(peers))
+	}
+	prober.Unwatch(dest)
+	if _, ok := prober.PeerStats(&I2PAddr{Destination: dest}); ok {
+		t.Error("PeerStats() found an unwatched peer")
+	}
+}
+
+// TestHealthMonitor_Port tests that the probe port is registered exclusively and
+// released on Close.
+func TestHealthMonitor_Port(t *testing.T) {
+	conn, err := NewDatagramConnWithProtocol(newMockSession(), 9000, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	h, err := NewHealthMonitor(conn, nil)
+	if err != nil {
+		t.Fatalf("NewHealthMonitor() failed: %v", err)
+	}
+	if _, err := NewHealthMonitor(conn, nil); err == nil {
+		t.Error("second NewHealthMonitor() on the same port succeeded")
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if err := conn.RegisterResultHandler(DefaultProbePort, func(*ReceiveResult) {}); err != nil {
+		t.Errorf("RegisterResultHandler() after Close failed: %v", err)
+	}
+}
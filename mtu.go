@@ -0,0 +1,196 @@
+package datagrams
+
+import "fmt"
+
+// Tunnel message layout, from the I2P tunnel message specification.
+//
+// A tunnel message is 1028 bytes: tunnel ID(4) + IV(16) + checksum(4) + nonzero
+// padding + zero byte(1) + delivery instructions and fragments. Without padding,
+// 1003 bytes remain for delivery instructions and I2NP message fragments.
+const (
+	// TunnelMessageSize is the fixed size of a tunnel message.
+	TunnelMessageSize = 1028
+
+	// tunnelDataSize is the space for delivery instructions and fragments.
+	tunnelDataSize = TunnelMessageSize - 4 - 16 - 4 - 1 // 1003
+
+	// Outbound tunnel delivery instructions for a message routed to the far end's
+	// inbound tunnel (TUNNEL delivery): flag(1) + tunnel ID(4) + gateway hash(32) +
+	// size(2), plus message ID(4) when the message is fragmented. Follow-on
+	// fragments carry flag(1) + message ID(4) + size(2). These exceed the LOCAL
+	// instructions used by the inbound tunnel, so they bound the budget.
+	unfragmentedInstructionsSize  = 1 + 4 + 32 + 2     // 39
+	firstFragmentInstructionsSize = 1 + 4 + 32 + 4 + 2 // 43
+	followOnInstructionsSize      = 1 + 4 + 2          // 7
+)
+
+// I2NP, garlic and I2CP overheads between a tunnel fragment and the datagram,
+// following the ECIES-X25519-AEAD-Ratchet MTU calculation of Proposal 144 for an
+// existing session without LeaseSet bundling.
+const (
+	// i2npHeaderSize is the full I2NP header carried in tunnel messages:
+	// type(1) + message ID(4) + expiration(8) + size(2) + checksum(1).
+	i2npHeaderSize = 16
+
+	// garlicOverhead is the Garlic message length(4) + ratchet session tag(8) +
+	// ChaCha20/Poly1305 MAC(16).
+	garlicOverhead = 4 + 8 + 16
+
+	// cloveOverhead is the Garlic Clove block header(3) + LOCAL delivery flag(1) +
+	// short I2NP header(9) of the enclosed Data message.
+	cloveOverhead = 3 + 1 + 9
+
+	// dataMessageOverhead is the Data message length field.
+	dataMessageOverhead = 4
+
+	// gzipOverhead is the I2CP payload gzip header(10, carrying protocol and ports) +
+	// stored deflate block header(5) + CRC-32 and size trailer(8).
+	gzipOverhead = 10 + 5 + 8
+
+	// MessageOverhead is the total overhead an I2CP datagram payload gains on its
+	// way into tunnel fragments: 84 bytes.
+	MessageOverhead = i2npHeaderSize + garlicOverhead + cloveOverhead + dataMessageOverhead + gzipOverhead
+)
+
+// TunnelCapacity returns the largest I2NP message, in bytes, that fits in
+// tunnelMsgs tunnel messages. Returns 0 if tunnelMsgs is less than 1.
+func TunnelCapacity(tunnelMsgs int) int {
+	switch {
+	case tunnelMsgs < 1:
+		return 0
+	case tunnelMsgs == 1:
+		return tunnelDataSize - unfragmentedInstructionsSize // 964
+	default:
+		return tunnelDataSize - firstFragmentInstructionsSize + (tunnelMsgs-1)*(tunnelDataSize-followOnInstructionsSize) // 960 + 996 per message
+	}
+}
+
+// BudgetParams describes the datagrams a payload budget is computed for.
+type BudgetParams struct {
+	// Protocol is the I2CP protocol number. Application-defined protocols use the
+	// Overhead of their codec in DefaultEnvelopeCodecRegistry.
+	Protocol uint8
+
+	// TunnelMessages is the number of tunnel messages the datagram must fit in.
+	TunnelMessages int
+
+	// Options are the Datagram2/Datagram3 options sent with each datagram, or nil.
+	// Ignored for protocols without options.
+	Options *Options
+
+	// SigType is the sender destination's signature type, for Datagram1 and
+	// Datagram2. DSA-SHA1 is not supported for sending, so zero means Ed25519.
+	SigType uint16
+
+	// Offline is the sender's offline signature block for Datagram2, or nil.
+	// Datagrams are then signed by its transient key.
+	Offline *OfflineSignature
+}
+
+// PayloadBudget returns the largest payload whose datagram fits in
+// p.TunnelMessages tunnel messages, accounting for the protocol envelope, the
+// options, the sender's signature type and offline signature, and the I2CP,
+// I2NP, garlic and tunnel fragmentation overhead (see MessageOverhead and
+// TunnelCapacity). The budget never exceeds the 64 KB datagram limit.
+//
+// The calculation assumes an established ECIES session; the first datagrams of a
+// session also carry a LeaseSet and are larger.
+//
+// Returns an error if TunnelMessages is less than 1, the protocol has no known
+// envelope, or the envelope alone does not fit.
+func PayloadBudget(p BudgetParams) (int, error) {
+	if p.Protocol == ProtocolDatagram1 || p.Protocol == ProtocolDatagram2 {
+		sigType := p.SigType
+		if sigType == SigTypeDSASHA1 {
+			sigType = SigTypeEd25519
+		}
+		if _, ok := sigTypeTable[sigType]; !ok {
+			return 0, fmt.Errorf("unknown signature type %d", sigType)
+		}
+		return payloadBudget(p.Protocol, p.TunnelMessages, p.Options, &wireDestination{
+			raw:     make([]byte, destinationSizeForSigType(sigType)),
+			sigType: sigType,
+		}, p.Offline, DefaultEnvelopeCodecRegistry)
+	}
+	return payloadBudget(p.Protocol, p.TunnelMessages, p.Options, nil, nil, DefaultEnvelopeCodecRegistry)
+}
+
+// PayloadBudget returns the largest payload this connection can send in
+// tunnelMsgs tunnel messages with the given options (nil for none), using the
+// connection's protocol, local destination and signer. See the package-level
+// PayloadBudget for the calculation.
+//
+// Typical values for Ed25519 senders without options are:
+//
+//	Protocol    1 msg   2 msgs   3 msgs
+//	Raw           880     1872     2868
+//	Datagram3     846     1838     2834
+//	Datagram1     425     1417     2413
+//	Datagram2     423     1415     2411
+func (d *DatagramConn) PayloadBudget(tunnelMsgs int, opts *Options) (int, error) {
+	d.mu.RLock()
+	key := d.signingKey
+	codecs := d.codecs
+	d.mu.RUnlock()
+
+	var offline *OfflineSignature
+	if key != nil {
+		offline = key.offline
+	}
+	return payloadBudget(d.Protocol(), tunnelMsgs, opts, d.localWire, offline, codecs)
+}
+
+// payloadBudget computes the budget. dest and offline are only used for Datagram1/2.
+func payloadBudget(protocol uint8, tunnelMsgs int, opts *Options, dest *wireDestination, offline *OfflineSignature, codecs *EnvelopeCodecRegistry) (int, error) {
+	if tunnelMsgs < 1 {
+		return 0, fmt.Errorf("tunnel message count must be at least 1, got %d", tunnelMsgs)
+	}
+
+	optionsSize := 0
+	if opts != nil && !opts.IsEmpty() && (protocol == ProtocolDatagram2 || protocol == ProtocolDatagram3) {
+		encoded, err := opts.Bytes()
+		if err != nil {
+			return 0, err
+		}
+		optionsSize = len(encoded)
+	}
+
+	var envelope int
+	switch protocol {
+	case ProtocolRaw:
+		envelope = 0
+	case ProtocolDatagram3:
+		envelope = MinDatagram3Overhead + optionsSize
+	case ProtocolDatagram1:
+		envelope = datagram1Overhead(dest)
+	case ProtocolDatagram2:
+		envelope = datagram2Overhead(dest, offline) + optionsSize
+	case ProtocolStreaming:
+		return 0, fmt.Errorf("protocol %d (streaming) is reserved and cannot be used for datagrams", protocol)
+	default:
+		codec, ok := codecs.Lookup(protocol)
+		if !ok {
+			return 0, unsupportedProtocolError(protocol)
+		}
+		envelope = codec.Overhead()
+	}
+
+	budget := min(TunnelCapacity(tunnelMsgs)-MessageOverhead, MaxI2NPSize) - envelope
+	if budget <= 0 {
+		return 0, fmt.Errorf("%d-byte envelope does not fit in %d tunnel messages", envelope, tunnelMsgs)
+	}
+	return budget, nil
+}
+
+// destinationSizeForSigType returns the wire size of a destination with the given
+// signature type: 391 bytes when the signing key fits beside an X25519 key, and
+// otherwise a Proposal 169 destination without an encryption key whose signing
+// key overflows into the KEY certificate.
+func destinationSizeForSigType(sigType uint16) int {
+	const fixed = destinationKeyAreaSize + 7 // key area + KEY certificate with sigtype and crypto type
+	keyLen := publicKeyLengthForSigType(sigType)
+	if keyLen <= destinationKeyAreaSize-cryptoPublicKeyLength(4) {
+		return fixed
+	}
+	return fixed + max(0, keyLen-destinationKeyAreaSize)
+}
@@ -0,0 +1,112 @@
+package datagrams
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTunnelCapacity tests the I2NP capacity of one or more tunnel messages.
+func TestTunnelCapacity(t *testing.T) {
+	for n, want := range map[int]int{0: 0, 1: 964, 2: 1956, 3: 2952} {
+		if got := TunnelCapacity(n); got != want {
+			t.Errorf("TunnelCapacity(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+// TestPayloadBudget tests budgets per protocol and tunnel message count.
+func TestPayloadBudget(t *testing.T) {
+	tests := []struct {
+		protocol uint8
+		want     [3]int
+	}{
+		{ProtocolRaw, [3]int{880, 1872, 2868}},
+		{ProtocolDatagram3, [3]int{846, 1838, 2834}},
+		{ProtocolDatagram1, [3]int{425, 1417, 2413}},
+		{ProtocolDatagram2, [3]int{423, 1415, 2411}},
+	}
+	for _, tt := range tests {
+		for i, want := range tt.want {
+			got, err := PayloadBudget(BudgetParams{Protocol: tt.protocol, TunnelMessages: i + 1})
+			if err != nil || got != want {
+				t.Errorf("PayloadBudget(protocol %d, %d msgs) = %d, %v, want %d", tt.protocol, i+1, got, err, want)
+			}
+		}
+	}
+
+	if got, _ := PayloadBudget(BudgetParams{Protocol: ProtocolRaw, TunnelMessages: 100}); got != MaxI2NPSize {
+		t.Errorf("budget for 100 tunnel messages = %d, want %d", got, MaxI2NPSize)
+	}
+}
+
+// TestPayloadBudget_Envelope tests the effect of options, signature types and
+// offline signatures on the budget.
+func TestPayloadBudget_Envelope(t *testing.T) {
+	base, _ := PayloadBudget(BudgetParams{Protocol: ProtocolDatagram2, TunnelMessages: 2})
+
+	opts := NewOptions(map[string]string{"a": "b"}) // 2 + (1+1) + 1 + (1+1) + 1
+	for _, protocol := range []uint8{ProtocolDatagram2, ProtocolDatagram3} {
+		without, _ := PayloadBudget(BudgetParams{Protocol: protocol, TunnelMessages: 2})
+		with, err := PayloadBudget(BudgetParams{Protocol: protocol, TunnelMessages: 2, Options: opts})
+		if err != nil || without-with != 8 {
+			t.Errorf("protocol %d: options reduced the budget by %d, %v, want 8", protocol, without-with, err)
+		}
+	}
+	if with, _ := PayloadBudget(BudgetParams{Protocol: ProtocolDatagram1, TunnelMessages: 2, Options: opts}); with != 1417 {
+		t.Errorf("Datagram1 budget with options = %d, want options ignored", with)
+	}
+
+	offline := &OfflineSignature{
+		Expires:            time.Now().Add(time.Hour),
+		TransientSigType:   SigTypeEd25519,
+		TransientPublicKey: make([]byte, 32),
+		Signature:          make([]byte, 64),
+	}
+	if got, _ := PayloadBudget(BudgetParams{Protocol: ProtocolDatagram2, TunnelMessages: 2, Offline: offline}); base-got != offline.Len() {
+		t.Errorf("offline signature reduced the budget by %d, want %d", base-got, offline.Len())
+	}
+
+	if got, _ := PayloadBudget(BudgetParams{Protocol: ProtocolDatagram2, TunnelMessages: 2, SigType: SigTypeECDSASHA512P521}); base-got != 68 {
+		t.Errorf("P521 signatures reduced the budget by %d, want 68", base-got)
+	}
+	if _, err := PayloadBudget(BudgetParams{Protocol: ProtocolDatagram2, TunnelMessages: 1, SigType: SigTypeMLDSA44}); err == nil {
+		t.Error("expected an ML-DSA-44 Datagram2 not to fit in one tunnel message")
+	}
+	if got, err := PayloadBudget(BudgetParams{Protocol: ProtocolDatagram2, TunnelMessages: 5, SigType: SigTypeMLDSA44}); err != nil || got != 1119 {
+		t.Errorf("ML-DSA-44 budget for 5 tunnel messages = %d, %v, want 1119", got, err)
+	}
+}
+
+// TestPayloadBudget_Errors tests invalid parameters.
+func TestPayloadBudget_Errors(t *testing.T) {
+	for name, p := range map[string]BudgetParams{
+		"zero tunnel messages": {Protocol: ProtocolRaw},
+		"streaming":            {Protocol: ProtocolStreaming, TunnelMessages: 1},
+		"no codec":             {Protocol: 200, TunnelMessages: 1},
+		"unknown sigtype":      {Protocol: ProtocolDatagram1, TunnelMessages: 1, SigType: 99},
+	} {
+		if _, err := PayloadBudget(p); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// TestDatagramConn_PayloadBudget tests the budget of a connection's own datagrams.
+func TestDatagramConn_PayloadBudget(t *testing.T) {
+	conn, err := NewDatagramConnWithProtocol(newMockSession(), 4000, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	got, err := conn.PayloadBudget(2, nil)
+	if err != nil || got != 1415 {
+		t.Errorf("PayloadBudget(2, nil) = %d, %v, want 1415", got, err)
+	}
+	if got, _ := conn.PayloadBudget(2, NewOptions(map[string]string{"a": "b"})); got != 1407 {
+		t.Errorf("PayloadBudget(2, options) = %d, want 1407", got)
+	}
+	if _, err := conn.PayloadBudget(0, nil); err == nil {
+		t.Error("expected error for zero tunnel messages")
+	}
+}
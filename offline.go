@@ -1,7 +1,6 @@
 package datagrams
 
 import (
-	"crypto/ed25519"
 	"encoding/binary"
 	"fmt"
 	"time"
@@ -134,6 +133,16 @@ func (o *OfflineSignature) IsExpired() bool {
 	return time.Now().After(o.Expires)
 }
 
+// signedData returns the bytes covered by the destination's authorization signature:
+// expires (4 bytes) + transient sigtype (2 bytes) + transient public key.
+func (o *OfflineSignature) signedData() []byte {
+	data := make([]byte, 4+2+len(o.TransientPublicKey))
+	binary.BigEndian.PutUint32(data[0:4], uint32(o.Expires.Unix()))
+	binary.BigEndian.PutUint16(data[4:6], o.TransientSigType)
+	copy(data[6:], o.TransientPublicKey)
+	return data
+}
+
 // Verify verifies that the offline signature was signed by the destination's key.
 // This proves the destination authorized the transient key to sign on its behalf.
 //
@@ -147,34 +156,31 @@ func (o *OfflineSignature) IsExpired() bool {
 //
 // Returns nil if verification succeeds, error otherwise.
 //
-// Note: This library only supports Ed25519 signatures (sigtype 7). Other signature
-// types will return an error.
+// Note: *i2cp.Destination only represents Ed25519 destinations. Offline signatures
+// authorized by ML-DSA destination keys are verified by the envelope parser using
+// the key parsed from the destination's wire format.
 func (o *OfflineSignature) Verify(dest *i2cp.Destination) error {
 	if dest == nil {
 		return fmt.Errorf("destination cannot be nil")
 	}
 
-	// Build the data that was signed: expires + sigtype + transient_public_key
-	dataLen := 4 + 2 + len(o.TransientPublicKey)
-	data := make([]byte, dataLen)
-
-	// Encode expires (4 bytes, big-endian)
-	binary.BigEndian.PutUint32(data[0:4], uint32(o.Expires.Unix()))
-
-	// Encode transient sigtype (2 bytes, big-endian)
-	binary.BigEndian.PutUint16(data[4:6], o.TransientSigType)
-
-	// Copy transient public key
-	copy(data[6:], o.TransientPublicKey)
-
 	// Verify using the destination's signing key
-	if !dest.VerifySignature(data, o.Signature) {
-		return fmt.Errorf("offline signature verification failed: destination did not authorize this transient key")
+	if !dest.VerifySignature(o.signedData(), o.Signature) {
+		return errOfflineNotAuthorized
 	}
 
 	return nil
 }
 
+// verifyWithKey verifies the authorization signature against a raw destination
+// signing public key of the given signature type.
+func (o *OfflineSignature) verifyWithKey(destSigType uint16, destPublicKey []byte) error {
+	if !verifySignatureForSigType(destSigType, destPublicKey, o.signedData(), o.Signature) {
+		return errOfflineNotAuthorized
+	}
+	return nil
+}
+
 // VerifyPayloadSignature verifies a payload signature using the transient public key.
 // This should be used for Datagram2 payload verification when an offline signature is present.
 //
@@ -188,76 +194,8 @@ func (o *OfflineSignature) Verify(dest *i2cp.Destination) error {
 //
 // Returns true if the signature is valid, false otherwise.
 //
-// Note: This library only supports Ed25519 transient keys (sigtype 7). Other signature
-// types will return false.
+// Supported transient key types are Ed25519 (sigtype 7) and ML-DSA-44/65/87
+// (sigtypes 12-14). Other signature types will return false.
 func (o *OfflineSignature) VerifyPayloadSignature(message, signature []byte) bool {
-	// Only Ed25519 (sigtype 7) is supported
-	if o.TransientSigType != 7 {
-		return false
-	}
-
-	// Ed25519 public key must be 32 bytes
-	if len(o.TransientPublicKey) != ed25519.PublicKeySize {
-		return false
-	}
-
-	// Ed25519 signature must be 64 bytes
-	if len(signature) != ed25519.SignatureSize {
-		return false
-	}
-
-	// Verify using the transient public key
-	return ed25519.Verify(ed25519.PublicKey(o.TransientPublicKey), message, signature)
-}
-
-// publicKeyLengthForSigType returns the public key length for a signature type.
-// Returns 0 for unknown signature types.
-//
-// Signature types from I2P spec:
-//   - 0: DSA_SHA1 (128 bytes)
-//   - 7: Ed25519 (32 bytes)
-//   - 11: RedDSA (32 bytes)
-func publicKeyLengthForSigType(sigType uint16) int {
-	switch sigType {
-	case 0: // DSA_SHA1
-		return 128
-	case 1: // ECDSA_SHA256_P256
-		return 64
-	case 2: // ECDSA_SHA384_P384
-		return 96
-	case 3: // ECDSA_SHA512_P521
-		return 132
-	case 7: // Ed25519
-		return 32
-	case 11: // RedDSA_SHA512_Ed25519
-		return 32
-	default:
-		return 0
-	}
-}
-
-// signatureLengthForSigType returns the signature length for a signature type.
-// Returns 0 for unknown signature types.
-//
-// Signature types from I2P spec:
-//   - 0: DSA_SHA1 (40 bytes)
-//   - 7: Ed25519 (64 bytes)
-//   - 11: RedDSA (64 bytes)
-func signatureLengthForSigType(sigType uint16) int {
-	switch sigType {
-	case 0: // DSA_SHA1
-		return 40
-	case 1: // ECDSA_SHA256_P256
-		return 64
-	case 2: // ECDSA_SHA384_P384
-		return 96
-	case 3: // ECDSA_SHA512_P521
-		return 132
-	case 7: // Ed25519
-		return 64
-	case 11: // RedDSA_SHA512_Ed25519
-		return 64
-	default:
-		return 0
-	}
+	return verifySignatureForSigType(o.TransientSigType, o.TransientPublicKey, message, signature)
 }
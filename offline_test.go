@@ -3,6 +3,7 @@ package datagrams
 import (
 	"bytes"
 	"crypto/ed25519"
+	"crypto/mldsa"
 	"encoding/binary"
 	"testing"
 	"time"
@@ -260,14 +261,17 @@ func TestPublicKeyLengthForSigType(t *testing.T) {
 		sigType     uint16
 		expectedLen int
 	}{
-		{0, 128}, // DSA_SHA1
-		{1, 64},  // ECDSA_SHA256_P256
-		{2, 96},  // ECDSA_SHA384_P384
-		{3, 132}, // ECDSA_SHA512_P521
-		{7, 32},  // Ed25519
-		{11, 32}, // RedDSA_SHA512_Ed25519
-		{99, 0},  // Unknown
-		{255, 0}, // Unknown
+		{0, 128},   // DSA_SHA1
+		{1, 64},    // ECDSA_SHA256_P256
+		{2, 96},    // ECDSA_SHA384_P384
+		{3, 132},   // ECDSA_SHA512_P521
+		{7, 32},    // Ed25519
+		{11, 32},   // RedDSA_SHA512_Ed25519
+		{12, 1312}, // ML-DSA-44
+		{13, 1952}, // ML-DSA-65
+		{14, 2592}, // ML-DSA-87
+		{99, 0},    // Unknown
+		{255, 0},   // Unknown
 	}
 
 	for _, tc := range testCases {
@@ -286,14 +290,17 @@ func TestSignatureLengthForSigType(t *testing.T) {
 		sigType     uint16
 		expectedLen int
 	}{
-		{0, 40},  // DSA_SHA1
-		{1, 64},  // ECDSA_SHA256_P256
-		{2, 96},  // ECDSA_SHA384_P384
-		{3, 132}, // ECDSA_SHA512_P521
-		{7, 64},  // Ed25519
-		{11, 64}, // RedDSA_SHA512_Ed25519
-		{99, 0},  // Unknown
-		{255, 0}, // Unknown
+		{0, 40},    // DSA_SHA1
+		{1, 64},    // ECDSA_SHA256_P256
+		{2, 96},    // ECDSA_SHA384_P384
+		{3, 132},   // ECDSA_SHA512_P521
+		{7, 64},    // Ed25519
+		{11, 64},   // RedDSA_SHA512_Ed25519
+		{12, 2420}, // ML-DSA-44
+		{13, 3309}, // ML-DSA-65
+		{14, 4627}, // ML-DSA-87
+		{99, 0},    // Unknown
+		{255, 0},   // Unknown
 	}
 
 	for _, tc := range testCases {
@@ -580,3 +587,79 @@ func TestOfflineSignature_Verify_DifferentDestination(t *testing.T) {
 		t.Error("expected verification to fail against different destination")
 	}
 }
+
+// TestOfflineSignature_VerifyPayloadSignature_MLDSA tests payload verification
+// with an ML-DSA-44 transient key.
+func TestOfflineSignature_VerifyPayloadSignature_MLDSA(t *testing.T) {
+	transient, err := mldsa.GenerateKey(mldsa.MLDSA44())
+	if err != nil {
+		t.Fatalf("failed to generate transient key: %v", err)
+	}
+
+	offSig := &OfflineSignature{
+		Expires:            time.Now().Add(24 * time.Hour),
+		TransientSigType:   SigTypeMLDSA44,
+		TransientPublicKey: transient.PublicKey().Bytes(),
+		Signature:          make([]byte, 64),
+	}
+
+	message := []byte("test message for ML-DSA verification")
+	signature, err := transient.Sign(nil, message, nil)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	if !offSig.VerifyPayloadSignature(message, signature) {
+		t.Error("expected valid ML-DSA signature to verify")
+	}
+	if offSig.VerifyPayloadSignature([]byte("different message"), signature) {
+		t.Error("expected wrong message to fail verification")
+	}
+
+	// Same key bytes declared as a different ML-DSA parameter set must not verify
+	offSig.TransientSigType = SigTypeMLDSA65
+	if offSig.VerifyPayloadSignature(message, signature) {
+		t.Error("expected mismatched sigtype to fail verification")
+	}
+}
+
+// TestOfflineSignatureFromBytes_MLDSA tests parsing offline signature blocks where
+// the transient key or the authorizing destination key is ML-DSA.
+func TestOfflineSignatureFromBytes_MLDSA(t *testing.T) {
+	testCases := []struct {
+		name          string
+		transientType uint16
+		destSigType   uint16
+		wantLen       int
+	}{
+		{"ML-DSA-44 transient, Ed25519 destination", SigTypeMLDSA44, SigTypeEd25519, 6 + 1312 + 64},
+		{"Ed25519 transient, ML-DSA-87 destination", SigTypeEd25519, SigTypeMLDSA87, 6 + 32 + 4627},
+		{"ML-DSA-65 transient, ML-DSA-65 destination", SigTypeMLDSA65, SigTypeMLDSA65, 6 + 1952 + 3309},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			original := &OfflineSignature{
+				Expires:            time.Unix(time.Now().Add(time.Hour).Unix(), 0),
+				TransientSigType:   tc.transientType,
+				TransientPublicKey: bytes.Repeat([]byte{0xAB}, publicKeyLengthForSigType(tc.transientType)),
+				Signature:          bytes.Repeat([]byte{0xCD}, signatureLengthForSigType(tc.destSigType)),
+			}
+			encoded := original.Bytes()
+			if len(encoded) != tc.wantLen {
+				t.Fatalf("encoded length = %d, want %d", len(encoded), tc.wantLen)
+			}
+
+			parsed, consumed, err := OfflineSignatureFromBytes(append(encoded, 0xFF), tc.destSigType)
+			if err != nil {
+				t.Fatalf("OfflineSignatureFromBytes() failed: %v", err)
+			}
+			if consumed != tc.wantLen {
+				t.Errorf("consumed = %d, want %d", consumed, tc.wantLen)
+			}
+			if !bytes.Equal(parsed.Bytes(), encoded) {
+				t.Error("roundtrip mismatch")
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package datagrams
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrOptionKeyConflict is returned when an option key name is registered twice.
+var ErrOptionKeyConflict = errors.New("option key already registered")
+
+// OptionCodec converts typed option values to and from their Mapping string form.
+// Encoded strings must satisfy the Options limits (valid UTF-8, at most
+// MaxOptionStringLength bytes); OptionKey.Set reports violations.
+type OptionCodec[T any] interface {
+	Encode(value T) (string, error)
+	Decode(s string) (T, error)
+}
+
+// codecFuncs implements OptionCodec with a pair of functions.
+type codecFuncs[T any] struct {
+	encode func(T) (string, error)
+	decode func(string) (T, error)
+}
+
+func (c codecFuncs[T]) Encode(value T) (string, error) { return c.encode(value) }
+
+func (c codecFuncs[T]) Decode(s string) (T, error) { return c.decode(s) }
+
+// i2pBase64 is the I2P Base64 alphabet, which uses '-' and '~' instead of '+' and '/'.
+var i2pBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+// Built-in codecs for common option value types.
+var (
+	// StringCodec stores strings unchanged.
+	StringCodec OptionCodec[string] = codecFuncs[string]{
+		encode: func(v string) (string, error) { return v, nil },
+		decode: func(s string) (string, error) { return s, nil },
+	}
+
+	// IntCodec stores int64 values as decimal strings.
+	IntCodec OptionCodec[int64] = codecFuncs[int64]{
+		encode: func(v int64) (string, error) { return strconv.FormatInt(v, 10), nil },
+		decode: func(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) },
+	}
+
+	// PortCodec stores uint16 port numbers as decimal strings.
+	PortCodec OptionCodec[uint16] = codecFuncs[uint16]{
+		encode: func(v uint16) (string, error) { return strconv.FormatUint(uint64(v), 10), nil },
+		decode: func(s string) (uint16, error) {
+			v, err := strconv.ParseUint(s, 10, 16)
+			return uint16(v), err
+		},
+	}
+
+	// BoolCodec stores booleans as "true" or "false".
+	BoolCodec OptionCodec[bool] = codecFuncs[bool]{
+		encode: func(v bool) (string, error) { return strconv.FormatBool(v), nil },
+		decode: func(s string) (bool, error) {
+			switch s {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+			return false, fmt.Errorf("invalid boolean %q", s)
+		},
+	}
+
+	// DurationCodec stores durations as decimal milliseconds. Sub-millisecond
+	// precision is truncated.
+	DurationCodec OptionCodec[time.Duration] = codecFuncs[time.Duration]{
+		encode: func(v time.Duration) (string, error) { return strconv.FormatInt(v.Milliseconds(), 10), nil },
+		decode: func(s string) (time.Duration, error) {
+			ms, err := strconv.ParseInt(s, 10, 64)
+			return time.Duration(ms) * time.Millisecond, err
+		},
+	}
+
+	// UnixTimeCodec stores times as decimal Unix seconds. Sub-second precision is truncated.
+	UnixTimeCodec OptionCodec[time.Time] = codecFuncs[time.Time]{
+		encode: func(v time.Time) (string, error) { return strconv.FormatInt(v.Unix(), 10), nil },
+		decode: func(s string) (time.Time, error) {
+			secs, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(secs, 0), nil
+		},
+	}
+
+	// BytesCodec stores byte slices in I2P Base64. Values longer than 189 bytes
+	// exceed MaxOptionStringLength once encoded.
+	BytesCodec OptionCodec[[]byte] = codecFuncs[[]byte]{
+		encode: func(v []byte) (string, error) { return i2pBase64.EncodeToString(v), nil },
+		decode: func(s string) ([]byte, error) { return i2pBase64.DecodeString(s) },
+	}
+)
+
+// OptionKey is a typed option key: a Mapping key name paired with the codec for its value.
+//
+// Example:
+//
+//	var Priority = datagrams.NewOptionKey("priority", datagrams.IntCodec)
+//
+//	_ = Priority.Set(opts, 5)
+//	p, ok, err := Priority.Get(opts)
+type OptionKey[T any] struct {
+	name  string
+	codec OptionCodec[T]
+}
+
+// NewOptionKey creates an OptionKey without registering it.
+// Use RegisterOptionKey to make the name known to an OptionRegistry.
+func NewOptionKey[T any](name string, codec OptionCodec[T]) OptionKey[T] {
+	return OptionKey[T]{name: name, codec: codec}
+}
+
+// Name returns the Mapping key name.
+func (k OptionKey[T]) Name() string {
+	return k.name
+}
+
+// Get decodes the key's value from o. Returns ok=false and no error if the key is
+// absent. A value that fails to decode returns an error wrapping ErrInvalidOptions.
+func (k OptionKey[T]) Get(o *Options) (value T, ok bool, err error) {
+	if !o.Has(k.name) {
+		return value, false, nil
+	}
+	value, err = k.codec.Decode(o.Get(k.name))
+	if err != nil {
+		var zero T
+		return zero, true, fmt.Errorf("options: key %q: %v: %w", k.name, err, ErrInvalidOptions)
+	}
+	return value, true, nil
+}
+
+// Set encodes value and stores it in o under the key's name.
+func (k OptionKey[T]) Set(o *Options, value T) error {
+	s, err := k.codec.Encode(value)
+	if err != nil {
+		return fmt.Errorf("options: key %q: %v: %w", k.name, err, ErrInvalidOptions)
+	}
+	return o.Set(k.name, s)
+}
+
+// OptionInfo describes a registered option key.
+type OptionInfo struct {
+	// Name is the Mapping key name.
+	Name string
+
+	// Type is the Go type of the key's values.
+	Type reflect.Type
+
+	decode func(string) error
+}
+
+// OptionRegistry records option key names and their value types, so that
+// independent components cannot silently reuse the same key for different data.
+// An OptionRegistry is safe for concurrent use.
+type OptionRegistry struct {
+	mu   sync.RWMutex
+	keys map[string]OptionInfo
+}
+
+// NewOptionRegistry creates an empty registry.
+func NewOptionRegistry() *OptionRegistry {
+	return &OptionRegistry{keys: make(map[string]OptionInfo)}
+}
+
+// RegisterOptionKey creates an OptionKey and records it in r.
+// Returns an error wrapping ErrOptionKeyConflict if the name is already registered,
+// or ErrInvalidOptions if the name cannot be used as a Mapping key.
+func RegisterOptionKey[T any](r *OptionRegistry, name string, codec OptionCodec[T]) (OptionKey[T], error) {
+	if name == "" {
+		return OptionKey[T]{}, fmt.Errorf("options: empty key name: %w", ErrInvalidOptions)
+	}
+	if err := validateOptionString("key", name); err != nil {
+		return OptionKey[T]{}, err
+	}
+	if codec == nil {
+		return OptionKey[T]{}, fmt.Errorf("options: key %q has no codec", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.keys[name]; ok {
+		return OptionKey[T]{}, fmt.Errorf("%w: %q (%s)", ErrOptionKeyConflict, name, existing.Type)
+	}
+	r.keys[name] = OptionInfo{
+		Name: name,
+		Type: reflect.TypeFor[T](),
+		decode: func(s string) error {
+			_, err := codec.Decode(s)
+			return err
+		},
+	}
+	return NewOptionKey(name, codec), nil
+}
+
+// mustRegisterOptionKey registers a well-known key, panicking on conflict.
+func mustRegisterOptionKey[T any](r *OptionRegistry, name string, codec OptionCodec[T]) OptionKey[T] {
+	key, err := RegisterOptionKey(r, name, codec)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Lookup returns the registration for name, if any.
+func (r *OptionRegistry) Lookup(name string) (OptionInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, ok := r.keys[name]
+	return info, ok
+}
+
+// Names returns the registered key names in sorted order.
+func (r *OptionRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.keys))
+	for name := range r.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks that every registered key present in o decodes with its codec.
+// Unregistered keys are ignored. Errors wrap ErrInvalidOptions.
+func (r *OptionRegistry) Validate(o *Options) error {
+	if o.IsEmpty() {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, value := range o.values {
+		info, ok := r.keys[name]
+		if !ok {
+			continue
+		}
+		if err := info.decode(value); err != nil {
+			return fmt.Errorf("options: key %q: %v: %w", name, err, ErrInvalidOptions)
+		}
+	}
+	return nil
+}
+
+// DefaultOptionRegistry holds the well-known option keys used by this library.
+// Applications should register their own keys here too, so conflicts with the
+// library or with each other are detected.
+var DefaultOptionRegistry = NewOptionRegistry()
+
+// Well-known option keys, registered in DefaultOptionRegistry.
+var (
+	// OptionTimestamp is the sender's send time, checked by ReplayCache
+	// (key ReplayTimestampOption).
+	OptionTimestamp = mustRegisterOptionKey(DefaultOptionRegistry, ReplayTimestampOption, UnixTimeCodec)
+
+	// OptionNonce is a random value making otherwise identical datagrams distinct.
+	OptionNonce = mustRegisterOptionKey(DefaultOptionRegistry, "nonce", BytesCodec)
+
+	// OptionContentType is the media type of the payload, e.g. "application/json".
+	OptionContentType = mustRegisterOptionKey(DefaultOptionRegistry, "content-type", StringCodec)
+
+	// OptionCompression names the payload compression, e.g. "gzip".
+	OptionCompression = mustRegisterOptionKey(DefaultOptionRegistry, "compression", StringCodec)
+
+	// OptionReplyPort is the port the sender expects replies on, when it differs
+	// from the datagram's source port.
+	OptionReplyPort = mustRegisterOptionKey(DefaultOptionRegistry, "reply-port", PortCodec)
+)
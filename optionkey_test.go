@@ -0,0 +1,159 @@
+package datagrams
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOptionKey_Codecs tests that each built-in codec round-trips through Options.
+func TestOptionKey_Codecs(t *testing.T) {
+	opts := EmptyOptions()
+	now := time.Unix(1700000000, 0)
+
+	steps := []struct {
+		name string
+		set  func() error
+		get  func() (any, bool, error)
+		want any
+	}{
+		{"string", func() error { return NewOptionKey("s", StringCodec).Set(opts, "text/plain") },
+			func() (any, bool, error) { return NewOptionKey("s", StringCodec).Get(opts) }, "text/plain"},
+		{"int", func() error { return NewOptionKey("i", IntCodec).Set(opts, -42) },
+			func() (any, bool, error) { return NewOptionKey("i", IntCodec).Get(opts) }, int64(-42)},
+		{"port", func() error { return NewOptionKey("p", PortCodec).Set(opts, 65535) },
+			func() (any, bool, error) { return NewOptionKey("p", PortCodec).Get(opts) }, uint16(65535)},
+		{"bool", func() error { return NewOptionKey("b", BoolCodec).Set(opts, true) },
+			func() (any, bool, error) { return NewOptionKey("b", BoolCodec).Get(opts) }, true},
+		{"duration", func() error { return NewOptionKey("d", DurationCodec).Set(opts, 1500*time.Millisecond) },
+			func() (any, bool, error) { return NewOptionKey("d", DurationCodec).Get(opts) }, 1500 * time.Millisecond},
+		{"time", func() error { return NewOptionKey("t", UnixTimeCodec).Set(opts, now) },
+			func() (any, bool, error) { return NewOptionKey("t", UnixTimeCodec).Get(opts) }, now},
+	}
+	for _, s := range steps {
+		t.Run(s.name, func(t *testing.T) {
+			if err := s.set(); err != nil {
+				t.Fatalf("Set() failed: %v", err)
+			}
+			got, ok, err := s.get()
+			if err != nil || !ok {
+				t.Fatalf("Get() = %v, %v, %v", got, ok, err)
+			}
+			if got != s.want {
+				t.Errorf("Get() = %v, want %v", got, s.want)
+			}
+		})
+	}
+
+	nonce := NewOptionKey("n", BytesCodec)
+	if err := nonce.Set(opts, []byte{0xfb, 0xff, 0x00}); err != nil {
+		t.Fatalf("Set() bytes failed: %v", err)
+	}
+	if opts.Get("n") != "-~8A" {
+		t.Errorf("bytes encoding = %q, want I2P Base64 %q", opts.Get("n"), "-~8A")
+	}
+	if got, _, _ := nonce.Get(opts); !bytes.Equal(got, []byte{0xfb, 0xff, 0x00}) {
+		t.Errorf("Get() bytes = %x", got)
+	}
+
+	// The typed values survive encoding
+	data, err := opts.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() failed: %v", err)
+	}
+	parsed, _, err := OptionsFromBytesStrict(data, OptionsLimits{})
+	if err != nil {
+		t.Fatalf("OptionsFromBytesStrict() failed: %v", err)
+	}
+	if d, _, _ := NewOptionKey("d", DurationCodec).Get(parsed); d != 1500*time.Millisecond {
+		t.Errorf("parsed duration = %v", d)
+	}
+}
+
+// TestOptionKey_GetMissingAndMalformed tests absent keys and values that fail to decode.
+func TestOptionKey_GetMissingAndMalformed(t *testing.T) {
+	key := NewOptionKey("n", IntCodec)
+
+	if v, ok, err := key.Get(nil); v != 0 || ok || err != nil {
+		t.Errorf("Get(nil) = %v, %v, %v", v, ok, err)
+	}
+
+	opts := NewOptions(map[string]string{"n": "ten", "b": "yes", "p": "70000"})
+	if _, ok, err := key.Get(opts); !ok || !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Get() malformed = %v, %v, want ErrInvalidOptions", ok, err)
+	}
+	if _, _, err := NewOptionKey("b", BoolCodec).Get(opts); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Get() bool error = %v, want ErrInvalidOptions", err)
+	}
+	if _, _, err := NewOptionKey("p", PortCodec).Get(opts); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Get() port error = %v, want ErrInvalidOptions", err)
+	}
+
+	// Encoded values are subject to the Options limits
+	if err := NewOptionKey("big", BytesCodec).Set(opts, make([]byte, 192)); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Set() oversized bytes error = %v, want ErrInvalidOptions", err)
+	}
+}
+
+// TestOptionRegistry tests registration, conflict detection and validation.
+func TestOptionRegistry(t *testing.T) {
+	r := NewOptionRegistry()
+
+	key, err := RegisterOptionKey(r, "priority", IntCodec)
+	if err != nil {
+		t.Fatalf("RegisterOptionKey() failed: %v", err)
+	}
+	if key.Name() != "priority" {
+		t.Errorf("Name() = %q", key.Name())
+	}
+	if _, err := RegisterOptionKey(r, "priority", IntCodec); !errors.Is(err, ErrOptionKeyConflict) {
+		t.Errorf("duplicate registration error = %v, want ErrOptionKeyConflict", err)
+	}
+	if _, err := RegisterOptionKey(r, "priority", StringCodec); !errors.Is(err, ErrOptionKeyConflict) {
+		t.Errorf("conflicting type registration error = %v, want ErrOptionKeyConflict", err)
+	}
+	if _, err := RegisterOptionKey(r, "", StringCodec); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("empty name error = %v, want ErrInvalidOptions", err)
+	}
+	if _, err := RegisterOptionKey(r, strings.Repeat("k", 256), StringCodec); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("long name error = %v, want ErrInvalidOptions", err)
+	}
+
+	info, ok := r.Lookup("priority")
+	if !ok || info.Type != reflect.TypeFor[int64]() {
+		t.Errorf("Lookup() = %+v, %v", info, ok)
+	}
+	if _, ok := r.Lookup("missing"); ok {
+		t.Error("Lookup() found unregistered key")
+	}
+
+	if err := r.Validate(NewOptions(map[string]string{"priority": "3", "other": "x"})); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+	if err := r.Validate(NewOptions(map[string]string{"priority": "high"})); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Validate() error = %v, want ErrInvalidOptions", err)
+	}
+}
+
+// TestDefaultOptionRegistry tests the well-known keys.
+func TestDefaultOptionRegistry(t *testing.T) {
+	want := []string{"compression", "content-type", "nonce", "reply-port", ReplayTimestampOption}
+	if got := DefaultOptionRegistry.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+	if _, err := RegisterOptionKey(DefaultOptionRegistry, OptionNonce.Name(), StringCodec); !errors.Is(err, ErrOptionKeyConflict) {
+		t.Errorf("registering a well-known name error = %v, want ErrOptionKeyConflict", err)
+	}
+
+	// OptionTimestamp uses the format ReplayCache expects
+	opts := EmptyOptions()
+	if err := OptionTimestamp.Set(opts, time.Unix(1234, 0)); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if opts.Get(ReplayTimestampOption) != "1234" {
+		t.Errorf("timestamp = %q, want %q", opts.Get(ReplayTimestampOption), "1234")
+	}
+}
@@ -1,11 +1,78 @@
 package datagrams
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"sort"
+	"unicode/utf8"
 
 	"github.com/go-i2p/common/data"
 )
 
+const (
+	// MaxOptionStringLength is the maximum length in bytes of an option key or value.
+	// I2P Strings carry a 1-byte length prefix.
+	MaxOptionStringLength = 255
+
+	// MaxOptionsSize is the maximum size in bytes of a Mapping's contents, excluding
+	// the 2-byte size field.
+	MaxOptionsSize = 65535
+
+	// DefaultMaxOptionsEntries is the default entry limit for strictly parsed options.
+	DefaultMaxOptionsEntries = 64
+
+	// DefaultMaxOptionsSize is the default content size limit for strictly parsed options.
+	DefaultMaxOptionsSize = 8192
+)
+
+// ErrInvalidOptions is wrapped by all errors reporting options that violate the
+// Mapping format or the configured limits.
+var ErrInvalidOptions = errors.New("invalid options")
+
+// OptionsLimits bounds the options accepted by OptionsFromBytesStrict.
+// Zero fields use DefaultMaxOptionsEntries and DefaultMaxOptionsSize.
+//
+// A DatagramConn applies its OptionsLimits (see DatagramConn.SetOptionsLimits) both
+// to the Datagram2 and Datagram3 options it receives and to those it sends, so two
+// connections with the same limits never emit options the other rejects.
+type OptionsLimits struct {
+	// MaxEntries is the maximum number of key/value pairs.
+	MaxEntries int
+
+	// MaxSize is the maximum content size in bytes, excluding the 2-byte size field.
+	// Values above MaxOptionsSize are capped to it.
+	MaxSize int
+}
+
+func (l OptionsLimits) withDefaults() OptionsLimits {
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultMaxOptionsEntries
+	}
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultMaxOptionsSize
+	}
+	if l.MaxSize > MaxOptionsSize {
+		l.MaxSize = MaxOptionsSize
+	}
+	return l
+}
+
+// check reports whether options to be sent are within the limits.
+func (l OptionsLimits) check(o *Options) error {
+	if o.IsEmpty() {
+		return nil
+	}
+	l = l.withDefaults()
+	if n := len(o.values); n > l.MaxEntries {
+		return fmt.Errorf("options: %d entries exceed limit %d: %w", n, l.MaxEntries, ErrInvalidOptions)
+	}
+	if size := o.Len() - 2; size > l.MaxSize {
+		return fmt.Errorf("options: size %d exceeds limit %d: %w", size, l.MaxSize, ErrInvalidOptions)
+	}
+	return nil
+}
+
 // Options represents an I2P Mapping structure for datagram options.
 // This is a wrapper around github.com/go-i2p/common/data.Mapping that provides
 // a simpler interface for datagram-specific use cases.
@@ -14,10 +81,12 @@ import (
 //   - 2-byte size integer (total bytes that follow)
 //   - Series of String=String; pairs
 //
-// Each String is 1-byte length followed by UTF-8 data (max 255 bytes).
-// Keys and values should not exceed 255 bytes each.
+// Each String is 1-byte length followed by UTF-8 data (max 255 bytes), and the
+// contents may not exceed 65535 bytes. Set and Bytes enforce both limits.
 //
-// Per I2P spec: Mappings in signed structures must be sorted by key.
+// Per I2P spec: Mappings in signed structures must be sorted by key. Bytes always
+// produces the canonical encoding, with keys in ascending byte order, and
+// OptionsFromBytesStrict rejects anything else.
 type Options struct {
 	mapping *data.Mapping
 	values  map[string]string // cached Go map for fast access
@@ -68,11 +137,14 @@ func OptionsFromBytes(rawData []byte) (*Options, int, error) {
 	// Convert MappingValues to Go map
 	values := make(map[string]string)
 	mappingValues := mapping.Values()
-	for _, pair := range mappingValues {
+	for i, pair := range mappingValues {
 		keyStr, keyErr := pair[0].Data()
+		if keyErr != nil {
+			return nil, 0, fmt.Errorf("options: entry %d: failed to decode key: %v: %w", i, keyErr, ErrInvalidOptions)
+		}
 		valStr, valErr := pair[1].Data()
-		if keyErr != nil || valErr != nil {
-			continue // Skip invalid entries
+		if valErr != nil {
+			return nil, 0, fmt.Errorf("options: entry %d: failed to decode value: %v: %w", i, valErr, ErrInvalidOptions)
 		}
 		values[keyStr] = valStr
 	}
@@ -83,8 +155,124 @@ func OptionsFromBytes(rawData []byte) (*Options, int, error) {
 	}, consumed, nil
 }
 
+// OptionsFromBytesStrict parses an I2P Mapping, accepting only the canonical encoding
+// required for signed structures. Returns the Options, number of bytes consumed, and
+// any error.
+//
+// Unlike OptionsFromBytes, it rejects duplicate keys, keys not in ascending byte
+// order, strings that are not valid UTF-8, and contents with trailing bytes or a
+// missing separator. The size field is checked against limits before any entry is
+// decoded, so hostile input cannot cause allocation beyond limits.MaxSize.
+// All errors wrap ErrInvalidOptions.
+func OptionsFromBytesStrict(rawData []byte, limits OptionsLimits) (*Options, int, error) {
+	limits = limits.withDefaults()
+	if len(rawData) < 2 {
+		return nil, 0, fmt.Errorf("options: data too short for size field (need 2 bytes, got %d): %w", len(rawData), ErrInvalidOptions)
+	}
+	size := int(rawData[0])<<8 | int(rawData[1])
+	if size > limits.MaxSize {
+		return nil, 0, fmt.Errorf("options: size %d exceeds limit %d: %w", size, limits.MaxSize, ErrInvalidOptions)
+	}
+	if len(rawData)-2 < size {
+		return nil, 0, fmt.Errorf("options: size field %d exceeds available data %d: %w", size, len(rawData)-2, ErrInvalidOptions)
+	}
+
+	content := rawData[2 : 2+size]
+	values := make(map[string]string)
+	var prev string
+	for offset := 0; offset < len(content); {
+		if len(values) == limits.MaxEntries {
+			return nil, 0, fmt.Errorf("options: more than %d entries: %w", limits.MaxEntries, ErrInvalidOptions)
+		}
+		key, n, err := readOptionString(content[offset:], '=')
+		if err != nil {
+			return nil, 0, fmt.Errorf("options: entry %d key: %w", len(values), err)
+		}
+		offset += n
+		value, n, err := readOptionString(content[offset:], ';')
+		if err != nil {
+			return nil, 0, fmt.Errorf("options: entry %d value: %w", len(values), err)
+		}
+		offset += n
+
+		if len(values) > 0 {
+			switch {
+			case key == prev:
+				return nil, 0, fmt.Errorf("options: duplicate key %q: %w", key, ErrInvalidOptions)
+			case key < prev:
+				return nil, 0, fmt.Errorf("options: key %q not sorted after %q: %w", key, prev, ErrInvalidOptions)
+			}
+		}
+		values[key] = value
+		prev = key
+	}
+
+	return &Options{values: values}, 2 + size, nil
+}
+
+// readOptionString reads a length-prefixed UTF-8 String followed by the separator byte.
+// Returns the string and the number of bytes consumed, including the separator.
+func readOptionString(b []byte, sep byte) (string, int, error) {
+	if len(b) < 1 {
+		return "", 0, fmt.Errorf("missing length byte: %w", ErrInvalidOptions)
+	}
+	n := int(b[0])
+	if len(b) < 1+n+1 {
+		return "", 0, fmt.Errorf("string length %d exceeds remaining %d bytes: %w", n, len(b)-1, ErrInvalidOptions)
+	}
+	if b[1+n] != sep {
+		return "", 0, fmt.Errorf("expected separator %q, got 0x%02x: %w", sep, b[1+n], ErrInvalidOptions)
+	}
+	str := b[1 : 1+n]
+	if !utf8.Valid(str) {
+		return "", 0, fmt.Errorf("string is not valid UTF-8: %w", ErrInvalidOptions)
+	}
+	return string(str), 1 + n + 1, nil
+}
+
+// validateOptionString checks that s can be encoded as an I2P String.
+func validateOptionString(what, s string) error {
+	if len(s) > MaxOptionStringLength {
+		return fmt.Errorf("options: %s length %d exceeds %d bytes: %w", what, len(s), MaxOptionStringLength, ErrInvalidOptions)
+	}
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("options: %s is not valid UTF-8: %w", what, ErrInvalidOptions)
+	}
+	return nil
+}
+
+// optionEntrySize returns the encoded size of one key=value; pair.
+func optionEntrySize(key, value string) int {
+	return 1 + len(key) + 1 + 1 + len(value) + 1
+}
+
+// Validate reports whether the Options can be encoded as an I2P Mapping: every key
+// and value must be valid UTF-8 of at most MaxOptionStringLength bytes, and the
+// contents must not exceed MaxOptionsSize bytes. DatagramConn sends are further
+// bounded by the connection's OptionsLimits.
+func (o *Options) Validate() error {
+	if o == nil {
+		return nil
+	}
+	size := 0
+	for k, v := range o.values {
+		if err := validateOptionString("key", k); err != nil {
+			return err
+		}
+		if err := validateOptionString("value", v); err != nil {
+			return err
+		}
+		size += optionEntrySize(k, v)
+	}
+	if size > MaxOptionsSize {
+		return fmt.Errorf("options: size %d exceeds %d bytes: %w", size, MaxOptionsSize, ErrInvalidOptions)
+	}
+	return nil
+}
+
 // Bytes encodes the Options as an I2P Mapping.
-// Keys are sorted for signature stability (handled by common/data.Mapping).
+// Keys are sorted in ascending byte order, so the encoding is canonical and stable
+// for signatures. Returns an error if Validate fails.
 //
 // Format:
 //
@@ -104,13 +292,32 @@ func (o *Options) Bytes() ([]byte, error) {
 		return o.mapping.Data(), nil
 	}
 
-	// Build mapping from Go map using GoMapToMapping
-	mapping, err := data.GoMapToMapping(o.values)
-	if err != nil {
-		return nil, fmt.Errorf("options: failed to create mapping: %w", err)
+	if err := o.Validate(); err != nil {
+		return nil, err
 	}
 
-	return mapping.Data(), nil
+	keys := make([]string, 0, len(o.values))
+	size := 0
+	for k, v := range o.values {
+		keys = append(keys, k)
+		size += optionEntrySize(k, v)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.Grow(2 + size)
+	buf.WriteByte(byte(size >> 8))
+	buf.WriteByte(byte(size))
+	for _, k := range keys {
+		v := o.values[k]
+		buf.WriteByte(byte(len(k)))
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteByte(byte(len(v)))
+		buf.WriteString(v)
+		buf.WriteByte(';')
+	}
+	return buf.Bytes(), nil
 }
 
 // Len returns the encoded length of the Options in bytes.
@@ -148,12 +355,34 @@ func (o *Options) Get(key string) string {
 
 // Set adds or updates a key/value pair.
 // This invalidates any cached mapping serialization.
-func (o *Options) Set(key, value string) {
+//
+// Returns an error wrapping ErrInvalidOptions, leaving the Options unchanged, if the
+// key or value is not valid UTF-8 of at most MaxOptionStringLength bytes, or if the
+// encoded contents would exceed MaxOptionsSize bytes.
+func (o *Options) Set(key, value string) error {
+	if err := validateOptionString("key", key); err != nil {
+		return err
+	}
+	if err := validateOptionString("value", value); err != nil {
+		return err
+	}
 	if o.values == nil {
 		o.values = make(map[string]string)
 	}
+
+	size := optionEntrySize(key, value)
+	for k, v := range o.values {
+		if k != key {
+			size += optionEntrySize(k, v)
+		}
+	}
+	if size > MaxOptionsSize {
+		return fmt.Errorf("options: size %d exceeds %d bytes: %w", size, MaxOptionsSize, ErrInvalidOptions)
+	}
+
 	o.values[key] = value
 	o.mapping = nil // Invalidate cached mapping
+	return nil
 }
 
 // Has returns true if the key exists in the Options.
@@ -2,7 +2,10 @@ package datagrams
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestOptionsFromBytes_Empty tests parsing an empty options Mapping.
@@ -379,3 +382,180 @@ func TestOptions_NilSafety(t *testing.T) {
 		t.Errorf("nil options Bytes should return empty mapping: %x", data)
 	}
 }
+
+// mappingBytes encodes raw key/value pairs in the given order, without validation.
+func mappingBytes(pairs ...string) []byte {
+	var content []byte
+	for i := 0; i+1 < len(pairs); i += 2 {
+		content = append(content, byte(len(pairs[i])))
+		content = append(content, pairs[i]...)
+		content = append(content, '=', byte(len(pairs[i+1])))
+		content = append(content, pairs[i+1]...)
+		content = append(content, ';')
+	}
+	return append([]byte{byte(len(content) >> 8), byte(len(content))}, content...)
+}
+
+// TestOptions_Set_Validation tests that Set rejects values that cannot be encoded.
+func TestOptions_Set_Validation(t *testing.T) {
+	opts := EmptyOptions()
+	if err := opts.Set(strings.Repeat("k", MaxOptionStringLength), "v"); err != nil {
+		t.Errorf("Set() with maximum key length failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		key, value string
+	}{
+		{"key too long", strings.Repeat("k", 256), "v"},
+		{"value too long", "k", strings.Repeat("v", 256)},
+		{"invalid UTF-8 key", "\xff", "v"},
+		{"invalid UTF-8 value", "k", "\xfe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := opts.Set(tt.key, tt.value); !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("Set() error = %v, want ErrInvalidOptions", err)
+			}
+			if opts.Has(tt.key) {
+				t.Error("rejected key should not be stored")
+			}
+		})
+	}
+}
+
+// TestOptions_Set_TotalSize tests that Set enforces the 65535-byte Mapping limit.
+func TestOptions_Set_TotalSize(t *testing.T) {
+	opts := EmptyOptions()
+	value := strings.Repeat("v", 250)
+	// Each entry is 1+3+1+1+250+1 = 257 bytes, so exactly 255 entries fill 65535
+	for i := 0; i < 255; i++ {
+		if err := opts.Set(string(rune('A'+i/26))+string(rune('a'+i%26))+"x", value); err != nil {
+			t.Fatalf("Set() entry %d failed: %v", i, err)
+		}
+	}
+	if err := opts.Set("zzz", value); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Set() beyond size limit error = %v, want ErrInvalidOptions", err)
+	}
+	// Replacing an existing value does not count it twice
+	if err := opts.Set("Aax", "short"); err != nil {
+		t.Errorf("Set() replacing a value failed: %v", err)
+	}
+	if _, err := opts.Bytes(); err != nil {
+		t.Errorf("Bytes() failed: %v", err)
+	}
+}
+
+// TestOptions_Bytes_Canonical tests that Bytes sorts keys by byte order.
+func TestOptions_Bytes_Canonical(t *testing.T) {
+	opts := NewOptions(map[string]string{"b": "2", "B": "3", "a": "1", "aa": "4"})
+	got, err := opts.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() failed: %v", err)
+	}
+	if want := mappingBytes("B", "3", "a", "1", "aa", "4", "b", "2"); !bytes.Equal(got, want) {
+		t.Errorf("Bytes() = %x, want %x", got, want)
+	}
+
+	// The canonical encoding is accepted by the strict parser and re-encodes identically
+	parsed, n, err := OptionsFromBytesStrict(got, OptionsLimits{})
+	if err != nil {
+		t.Fatalf("OptionsFromBytesStrict() failed: %v", err)
+	}
+	if n != len(got) {
+		t.Errorf("consumed %d bytes, want %d", n, len(got))
+	}
+	again, _ := parsed.Bytes()
+	if !bytes.Equal(again, got) {
+		t.Errorf("re-encoded = %x, want %x", again, got)
+	}
+}
+
+// TestOptionsFromBytesStrict_Rejects tests malformed, ambiguous and oversized input.
+func TestOptionsFromBytesStrict_Rejects(t *testing.T) {
+	truncated := mappingBytes("key", "value")
+	tests := []struct {
+		name   string
+		data   []byte
+		limits OptionsLimits
+	}{
+		{"too short", []byte{0x00}, OptionsLimits{}},
+		{"size exceeds data", []byte{0x00, 0x0a}, OptionsLimits{}},
+		{"duplicate key", mappingBytes("a", "1", "a", "2"), OptionsLimits{}},
+		{"unsorted keys", mappingBytes("b", "1", "a", "2"), OptionsLimits{}},
+		{"missing equals", []byte{0x00, 0x06, 0x01, 'a', ';', 0x01, 'b', ';'}, OptionsLimits{}},
+		{"missing semicolon", []byte{0x00, 0x06, 0x01, 'a', '=', 0x01, 'b', '='}, OptionsLimits{}},
+		{"truncated entry", append([]byte{0x00, byte(len(truncated) - 3)}, truncated[2:len(truncated)-1]...), OptionsLimits{}},
+		{"trailing byte", append([]byte{0x00, byte(len(truncated) - 1)}, truncated[2:]...), OptionsLimits{}},
+		{"invalid UTF-8", mappingBytes("k", "\xff"), OptionsLimits{}},
+		{"too many entries", mappingBytes("a", "1", "b", "2", "c", "3"), OptionsLimits{MaxEntries: 2}},
+		{"too large", mappingBytes("key", strings.Repeat("v", 100)), OptionsLimits{MaxSize: 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := OptionsFromBytesStrict(tt.data, tt.limits); !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("OptionsFromBytesStrict() error = %v, want ErrInvalidOptions", err)
+			}
+		})
+	}
+
+	// The same input within limits is accepted
+	if _, _, err := OptionsFromBytesStrict(mappingBytes("a", "1", "b", "2"), OptionsLimits{MaxEntries: 2}); err != nil {
+		t.Errorf("OptionsFromBytesStrict() at entry limit failed: %v", err)
+	}
+}
+
+// TestDatagram3_RejectsUnsortedOptions tests that received envelopes use strict parsing.
+func TestDatagram3_RejectsUnsortedOptions(t *testing.T) {
+	options := mappingBytes("b", "1", "a", "2")
+	envelope := append(make([]byte, 32), 0x00, 0x13)
+	envelope = append(envelope, options...)
+	envelope = append(envelope, "payload"...)
+
+	if _, err := UnmarshalEnvelope(ProtocolDatagram3, envelope); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("UnmarshalEnvelope() error = %v, want ErrInvalidOptions", err)
+	}
+}
+
+// TestDatagramConn_OptionsLimits tests that a connection's options limits bound
+// both the options it sends and those it accepts.
+func TestDatagramConn_OptionsLimits(t *testing.T) {
+	a, b, _, sb := connPair(t, ProtocolDatagram3, ProtocolDatagram3)
+	dest := sb.Destination().Base64()
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	opts := EmptyOptions()
+	if err := opts.Set("big", strings.Repeat("v", 200)); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	small := OptionsLimits{MaxSize: 64}
+	a.SetOptionsLimits(small)
+	if err := a.SendToWithOptions([]byte("x"), dest, pairPortB, opts); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("SendToWithOptions() error = %v, want ErrInvalidOptions", err)
+	}
+
+	// A sender with larger limits is rejected by the receiver's own limits
+	a.SetOptionsLimits(OptionsLimits{})
+	b.SetOptionsLimits(small)
+	if err := a.SendToWithOptions([]byte("x"), dest, pairPortB, opts); err != nil {
+		t.Fatalf("SendToWithOptions() failed: %v", err)
+	}
+	if _, err := b.ReceiveFromWithOptions(); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("ReceiveFromWithOptions() error = %v, want ErrInvalidOptions", err)
+	}
+
+	b.SetOptionsLimits(OptionsLimits{})
+	if got := b.OptionsLimits(); got.MaxEntries != DefaultMaxOptionsEntries || got.MaxSize != DefaultMaxOptionsSize {
+		t.Errorf("OptionsLimits() = %+v, want defaults", got)
+	}
+	if err := a.SendToWithOptions([]byte("x"), dest, pairPortB, opts); err != nil {
+		t.Fatalf("SendToWithOptions() failed: %v", err)
+	}
+	result, err := b.ReceiveFromWithOptions()
+	if err != nil {
+		t.Fatalf("ReceiveFromWithOptions() failed: %v", err)
+	}
+	if got := result.Options.Get("big"); len(got) != 200 {
+		t.Errorf("received option of %d bytes, want 200", len(got))
+	}
+}
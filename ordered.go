@@ -0,0 +1,490 @@
+package datagrams
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// OrderedOverhead is the per-datagram overhead of OrderedConn:
+	// stream(4) + sequence number(4) = 8 bytes.
+	OrderedOverhead = 4 + 4
+
+	// DefaultOrderedWindow is the default reorder window in sequence numbers.
+	DefaultOrderedWindow = 64
+
+	// DefaultOrderedReorderTimeout is how long a datagram waits by default for
+	// the missing datagrams before it.
+	DefaultOrderedReorderTimeout = time.Second
+
+	// DefaultOrderedIdleTimeout is how long per-peer stream state is kept without traffic.
+	DefaultOrderedIdleTimeout = 5 * time.Minute
+
+	// DefaultOrderedMaxStreams bounds the receive streams an OrderedConn keeps.
+	DefaultOrderedMaxStreams = 4096
+
+	// orderedQueueSize is how many delivered datagrams may wait for Receive
+	// before the receive loop stops reading.
+	orderedQueueSize = 100
+)
+
+// OrderedConfig configures an OrderedConn. Zero fields take their defaults.
+type OrderedConfig struct {
+	// Window is the number of sequence numbers the reorder buffer spans. A datagram
+	// Window or more ahead of the next expected one skips the missing datagrams.
+	Window int
+
+	// ReorderTimeout is how long a buffered datagram waits for the missing
+	// datagrams before it; they are then skipped.
+	ReorderTimeout time.Duration
+
+	// IdleTimeout is how long a stream's state is kept without traffic.
+	// Receivers keep state for twice as long, so a sender always starts a new
+	// stream before its receiver forgets the old one.
+	IdleTimeout time.Duration
+
+	// MaxStreams bounds the receive streams kept; the least recently active is
+	// dropped, with its buffered datagrams, to make room for a new one. Stream
+	// ids are chosen by senders, so this bounds the state they can create.
+	// Default: DefaultOrderedMaxStreams.
+	MaxStreams int
+}
+
+func (c OrderedConfig) withDefaults() OrderedConfig {
+	if c.Window <= 0 {
+		c.Window = DefaultOrderedWindow
+	}
+	if c.ReorderTimeout <= 0 {
+		c.ReorderTimeout = DefaultOrderedReorderTimeout
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = DefaultOrderedIdleTimeout
+	}
+	if c.MaxStreams <= 0 {
+		c.MaxStreams = DefaultOrderedMaxStreams
+	}
+	return c
+}
+
+// OrderedMessage is a datagram delivered in sequence order.
+type OrderedMessage struct {
+	// Result is the received datagram; its Payload excludes the ordering header.
+	Result *ReceiveResult
+
+	// Seq is the datagram's sequence number in its sender's stream, starting at 1.
+	Seq uint32
+
+	// Gap is the number of datagrams immediately before this one that were lost,
+	// or arrived too late, and will never be delivered.
+	Gap uint32
+}
+
+// OrderedStats reports activity on an OrderedConn.
+type OrderedStats struct {
+	Delivered uint64 // datagrams delivered
+	Reordered uint64 // datagrams delivered after waiting in the reorder buffer
+	Skipped   uint64 // sequence numbers given up on, the sum of all Gaps
+	Late      uint64 // datagrams dropped because they arrived after being skipped, or twice
+}
+
+type orderedCounters struct {
+	delivered, reordered, skipped, late atomic.Uint64
+}
+
+// orderedStreamKey identifies a sender stream. The stream id separates senders
+// that share sender metadata, such as all senders of Raw datagrams.
+type orderedStreamKey struct {
+	dest   string
+	hash   [32]byte
+	port   uint16
+	stream uint32
+}
+
+// orderedSender is the send state for one peer.
+type orderedSender struct {
+	stream     uint32
+	nextSeq    uint32
+	lastActive time.Time
+}
+
+// orderedBuffered is a datagram waiting for the ones before it.
+type orderedBuffered struct {
+	result  *ReceiveResult
+	arrived time.Time
+}
+
+// orderedStream is the receive state for one sender stream.
+type orderedStream struct {
+	next       uint32 // next sequence number to deliver
+	gap        uint32 // skipped sequence numbers not yet reported in a Gap
+	buffer     map[uint32]*orderedBuffered
+	timer      *time.Timer // pending reorder timeout; nil when the buffer is empty
+	lastActive time.Time
+}
+
+// OrderedConn delivers datagrams from each sender in the order they were sent.
+//
+// Each datagram carries a per-stream sequence number. Datagrams arriving ahead of
+// the next expected one wait in a reorder buffer until the missing ones arrive,
+// until they have waited ReorderTimeout, or until the buffer would span more than
+// Window sequence numbers. Missing datagrams are then skipped and reported in
+// OrderedMessage.Gap; if they arrive later they are dropped.
+//
+// OrderedConn does not retransmit: it trades latency for order, never loss for
+// order, which suits media and telemetry. Use ReliableConn for acknowledged delivery.
+//
+// It works over any protocol. Streams are identified by the sender metadata the
+// protocol provides together with a random stream id, so Raw senders are told
+// apart by stream id alone.
+//
+// OrderedConn owns the DatagramConn, which must not be read elsewhere, and
+// closes it on Close.
+type OrderedConn struct {
+	conn   *DatagramConn
+	config OrderedConfig
+
+	mu      sync.Mutex
+	senders map[reliablePeerKey]*orderedSender
+	streams map[orderedStreamKey]*orderedStream
+
+	// ready holds delivered datagrams for Receive, in order; protected by mu.
+	// available wakes a Receive when it grows, taken the receive loop when a
+	// Receive shrinks it.
+	ready     []*OrderedMessage
+	available chan struct{}
+	taken     chan struct{}
+
+	counters orderedCounters
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewOrderedConn wraps conn in an OrderedConn. A nil config uses defaults.
+func NewOrderedConn(conn *DatagramConn, config *OrderedConfig) (*OrderedConn, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("conn cannot be nil")
+	}
+	var cfg OrderedConfig
+	if config != nil {
+		cfg = *config
+	}
+	cfg = cfg.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o := &OrderedConn{
+		conn:      conn,
+		config:    cfg,
+		senders:   make(map[reliablePeerKey]*orderedSender),
+		streams:   make(map[orderedStreamKey]*orderedStream),
+		available: make(chan struct{}, 1),
+		taken:     make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	o.wg.Add(2)
+	go pumpConn(&o.wg, conn, o.handle)
+	go tickUntil(o.ctx, &o.wg, cfg.IdleTimeout/2, o.prune)
+	return o, nil
+}
+
+// Conn returns the underlying DatagramConn.
+func (o *OrderedConn) Conn() *DatagramConn {
+	return o.conn
+}
+
+// SendTo sends payload to the destination and port with the next sequence
+// number of the stream to that peer. Delivery is not guaranteed.
+func (o *OrderedConn) SendTo(payload []byte, destinationB64 string, port uint16) error {
+	if len(payload) > o.MaxPayloadSize() {
+		return fmt.Errorf("payload size %d exceeds maximum %d", len(payload), o.MaxPayloadSize())
+	}
+	key := reliablePeerKey{dest: destinationB64, port: port}
+
+	o.mu.Lock()
+	sender, ok := o.senders[key]
+	if !ok {
+		var stream [4]byte
+		if _, err := rand.Read(stream[:]); err != nil {
+			o.mu.Unlock()
+			return fmt.Errorf("failed to generate stream id: %w", err)
+		}
+		sender = &orderedSender{stream: binary.BigEndian.Uint32(stream[:]), nextSeq: 1}
+		o.senders[key] = sender
+	}
+	sender.lastActive = time.Now()
+	frame := make([]byte, OrderedOverhead, OrderedOverhead+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], sender.stream)
+	binary.BigEndian.PutUint32(frame[4:8], sender.nextSeq)
+	sender.nextSeq++
+	o.mu.Unlock()
+
+	return o.conn.SendTo(append(frame, payload...), destinationB64, port)
+}
+
+// handle places a received datagram in its stream's reorder buffer and delivers
+// whatever became deliverable, then waits while Receive is behind.
+func (o *OrderedConn) handle(result *ReceiveResult) {
+	if len(result.Payload) < OrderedOverhead {
+		return
+	}
+	key := orderedStreamKey{
+		dest:   result.FromAddr.Destination,
+		hash:   result.FromAddr.DestinationHash,
+		port:   result.SrcPort,
+		stream: binary.BigEndian.Uint32(result.Payload[0:4]),
+	}
+	seq := binary.BigEndian.Uint32(result.Payload[4:8])
+	delivered := *result
+	delivered.Payload = result.Payload[OrderedOverhead:]
+
+	o.mu.Lock()
+	o.handleLocked(key, seq, &delivered)
+	o.mu.Unlock()
+	o.waitForReceive()
+}
+
+func (o *OrderedConn) handleLocked(key orderedStreamKey, seq uint32, delivered *ReceiveResult) {
+	now := time.Now()
+	s := o.streamLocked(key, now)
+	s.lastActive = now
+
+	if _, dup := s.buffer[seq]; seq < s.next || dup {
+		o.counters.late.Add(1)
+		return
+	}
+	if seq == s.next {
+		o.deliverLocked(s, delivered, false)
+		o.flushLocked(s)
+		o.scheduleLocked(key, s)
+		return
+	}
+
+	s.buffer[seq] = &orderedBuffered{result: delivered, arrived: now}
+	if uint64(seq-s.next) >= uint64(o.config.Window) {
+		o.skipLocked(s, seq-uint32(o.config.Window)+1)
+	}
+	o.scheduleLocked(key, s)
+}
+
+// streamLocked returns the stream for key, creating it and, at MaxStreams,
+// dropping the least recently active one.
+func (o *OrderedConn) streamLocked(key orderedStreamKey, now time.Time) *orderedStream {
+	if s, ok := o.streams[key]; ok {
+		return s
+	}
+	if len(o.streams) >= o.config.MaxStreams {
+		var oldestKey orderedStreamKey
+		var oldest *orderedStream
+		for k, s := range o.streams {
+			if oldest == nil || s.lastActive.Before(oldest.lastActive) {
+				oldestKey, oldest = k, s
+			}
+		}
+		if oldest.timer != nil {
+			oldest.timer.Stop()
+		}
+		delete(o.streams, oldestKey)
+	}
+	s := &orderedStream{next: 1, buffer: make(map[uint32]*orderedBuffered), lastActive: now}
+	o.streams[key] = s
+	return s
+}
+
+// deliverLocked queues the datagram numbered s.next for Receive with the pending
+// gap. Queuing under o.mu keeps deliveries in order without blocking on Receive.
+func (o *OrderedConn) deliverLocked(s *orderedStream, result *ReceiveResult, reordered bool) {
+	msg := &OrderedMessage{Result: result, Seq: s.next, Gap: s.gap}
+	s.next++
+	s.gap = 0
+	o.ready = append(o.ready, msg)
+	signal(o.available)
+	o.counters.delivered.Add(1)
+	o.counters.skipped.Add(uint64(msg.Gap))
+	if reordered {
+		o.counters.reordered.Add(1)
+	}
+}
+
+// waitForReceive blocks while orderedQueueSize datagrams wait for Receive, so a
+// slow reader holds back the receive loop rather than every other caller.
+func (o *OrderedConn) waitForReceive() {
+	for {
+		o.mu.Lock()
+		n := len(o.ready)
+		o.mu.Unlock()
+		if n < orderedQueueSize {
+			return
+		}
+		select {
+		case <-o.taken:
+		case <-o.ctx.Done():
+			return
+		}
+	}
+}
+
+// signal wakes one waiter on ch without blocking.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// flushLocked delivers the buffered datagrams that follow s.next without gaps.
+func (o *OrderedConn) flushLocked(s *orderedStream) {
+	for {
+		b, ok := s.buffer[s.next]
+		if !ok {
+			return
+		}
+		delete(s.buffer, s.next)
+		o.deliverLocked(s, b.result, true)
+	}
+}
+
+// skipLocked gives up on the missing datagrams before target, delivering the
+// buffered ones among them, then flushes from target. It walks only the
+// buffered sequence numbers, so its cost does not depend on how far target is.
+func (o *OrderedConn) skipLocked(s *orderedStream, target uint32) {
+	var seqs []uint32
+	for seq := range s.buffer {
+		if seq < target {
+			seqs = append(seqs, seq)
+		}
+	}
+	slices.Sort(seqs)
+	for _, seq := range seqs {
+		b := s.buffer[seq]
+		delete(s.buffer, seq)
+		s.gap += seq - s.next
+		s.next = seq
+		o.deliverLocked(s, b.result, true)
+	}
+	if s.next < target {
+		s.gap += target - s.next
+		s.next = target
+	}
+	o.flushLocked(s)
+}
+
+// scheduleLocked arms the reorder timeout for the oldest buffered datagram.
+func (o *OrderedConn) scheduleLocked(key orderedStreamKey, s *orderedStream) {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	var oldest time.Time
+	for _, b := range s.buffer {
+		if oldest.IsZero() || b.arrived.Before(oldest) {
+			oldest = b.arrived
+		}
+	}
+	if oldest.IsZero() {
+		return
+	}
+	s.timer = time.AfterFunc(time.Until(oldest.Add(o.config.ReorderTimeout)), func() { o.expire(key, s) })
+}
+
+// expire skips to the newest buffered datagram that has waited ReorderTimeout.
+func (o *OrderedConn) expire(key orderedStreamKey, s *orderedStream) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.ctx.Err() != nil {
+		return
+	}
+	deadline := time.Now().Add(-o.config.ReorderTimeout)
+	var target uint32
+	for seq, b := range s.buffer {
+		if !b.arrived.After(deadline) && seq > target {
+			target = seq
+		}
+	}
+	if target != 0 {
+		o.skipLocked(s, target+1)
+	}
+	o.scheduleLocked(key, s)
+}
+
+// prune drops senders idle for IdleTimeout and streams idle for twice as long.
+func (o *OrderedConn) prune(now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for key, sender := range o.senders {
+		if now.Sub(sender.lastActive) >= o.config.IdleTimeout {
+			delete(o.senders, key)
+		}
+	}
+	for key, s := range o.streams {
+		if len(s.buffer) == 0 && now.Sub(s.lastActive) >= 2*o.config.IdleTimeout {
+			delete(o.streams, key)
+		}
+	}
+}
+
+// Receive blocks until the next in-order datagram is available, ctx is done,
+// or the connection is closed (net.ErrClosed).
+func (o *OrderedConn) Receive(ctx context.Context) (*OrderedMessage, error) {
+	for {
+		o.mu.Lock()
+		if len(o.ready) > 0 {
+			msg := o.ready[0]
+			o.ready[0] = nil
+			o.ready = o.ready[1:]
+			more := len(o.ready) > 0
+			o.mu.Unlock()
+			signal(o.taken)
+			if more {
+				signal(o.available)
+			}
+			return msg, nil
+		}
+		o.mu.Unlock()
+		select {
+		case <-o.available:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-o.ctx.Done():
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+// MaxPayloadSize returns the largest payload SendTo accepts.
+func (o *OrderedConn) MaxPayloadSize() int {
+	return o.conn.MaxPayloadSize() - OrderedOverhead
+}
+
+// Stats returns the connection's counters.
+func (o *OrderedConn) Stats() OrderedStats {
+	return OrderedStats{
+		Delivered: o.counters.delivered.Load(),
+		Reordered: o.counters.reordered.Load(),
+		Skipped:   o.counters.skipped.Load(),
+		Late:      o.counters.late.Load(),
+	}
+}
+
+// Close stops reorder timers and closes the underlying DatagramConn. Buffered
+// datagrams are discarded.
+func (o *OrderedConn) Close() error {
+	o.mu.Lock()
+	o.cancel()
+	for _, s := range o.streams {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+	}
+	o.mu.Unlock()
+	err := o.conn.Close()
+	o.wg.Wait()
+	return err
+}
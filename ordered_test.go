@@ -0,0 +1,199 @@
+package datagrams
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// orderedFrame builds an OrderedConn datagram.
+func orderedFrame(stream, seq uint32, payload string) []byte {
+	frame := make([]byte, OrderedOverhead)
+	binary.BigEndian.PutUint32(frame[0:4], stream)
+	binary.BigEndian.PutUint32(frame[4:8], seq)
+	return append(frame, payload...)
+}
+
+// newOrderedReceiver returns an OrderedConn over Raw and a function injecting frames into it.
+func newOrderedReceiver(t *testing.T, config *OrderedConfig) (*OrderedConn, func(stream, seq uint32, payload string)) {
+	t.Helper()
+	conn, err := NewDatagramConn(newMockSession(), 6000)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	o, err := NewOrderedConn(conn, config)
+	if err != nil {
+		t.Fatalf("NewOrderedConn() failed: %v", err)
+	}
+	t.Cleanup(func() { o.Close() })
+	inject := func(stream, seq uint32, payload string) {
+		conn.injectMessage(orderedFrame(stream, seq, payload), nil, ProtocolRaw, 6001, 6000)
+	}
+	return o, inject
+}
+
+// expectOrdered receives the next message and checks its payload and gap.
+func expectOrdered(t *testing.T, o *OrderedConn, payload string, gap uint32) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := o.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive() failed waiting for %q: %v", payload, err)
+	}
+	if string(msg.Result.Payload) != payload || msg.Gap != gap {
+		t.Errorf("Receive() = %q (seq %d, gap %d), want %q with gap %d", msg.Result.Payload, msg.Seq, msg.Gap, payload, gap)
+	}
+}
+
+// TestOrderedConn_SendTo tests sequence numbering end to end.
+func TestOrderedConn_SendTo(t *testing.T) {
+	receiver, _ := newOrderedReceiver(t, nil)
+	session := newMockSession()
+	conn, err := NewDatagramConn(session, 6001)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	sender, err := NewOrderedConn(conn, nil)
+	if err != nil {
+		t.Fatalf("NewOrderedConn() failed: %v", err)
+	}
+	defer sender.Close()
+	routeTo(session, receiver.Conn())
+
+	dest := validDestinationB64()
+	for _, payload := range []string{"a", "b", "c"} {
+		if err := sender.SendTo([]byte(payload), dest, 6000); err != nil {
+			t.Fatalf("SendTo() failed: %v", err)
+		}
+	}
+	if seq := binary.BigEndian.Uint32(session.lastPayload[4:8]); seq != 3 {
+		t.Errorf("third datagram has seq %d, want 3", seq)
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		expectOrdered(t, receiver, payload, 0)
+	}
+	if sender.MaxPayloadSize() != MaxI2NPSize-OrderedOverhead {
+		t.Errorf("MaxPayloadSize() = %d", sender.MaxPayloadSize())
+	}
+}
+
+// TestOrderedConn_Reorder tests that out-of-order datagrams are buffered and
+// streams are kept apart.
+func TestOrderedConn_Reorder(t *testing.T) {
+	o, inject := newOrderedReceiver(t, nil)
+	inject(7, 2, "second")
+	inject(9, 1, "other stream")
+	inject(7, 3, "third")
+	inject(7, 1, "first")
+
+	expectOrdered(t, o, "other stream", 0)
+	for _, payload := range []string{"first", "second", "third"} {
+		expectOrdered(t, o, payload, 0)
+	}
+	if stats := o.Stats(); stats.Delivered != 4 || stats.Reordered != 2 || stats.Skipped != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestOrderedConn_Window tests skipping when the buffer exceeds the window, and
+// dropping datagrams that arrive after being skipped.
+func TestOrderedConn_Window(t *testing.T) {
+	o, inject := newOrderedReceiver(t, &OrderedConfig{Window: 4, ReorderTimeout: time.Hour})
+	inject(1, 1, "1")
+	inject(1, 3, "3")
+	inject(1, 7, "7") // next is 2, 7 is 5 ahead: 2 and 3 are given up
+
+	expectOrdered(t, o, "1", 0)
+	expectOrdered(t, o, "3", 1)
+	inject(1, 2, "late")
+	for _, seq := range []uint32{4, 5, 6} {
+		inject(1, seq, string(rune('0'+seq)))
+	}
+	for _, payload := range []string{"4", "5", "6", "7"} {
+		expectOrdered(t, o, payload, 0)
+	}
+	if stats := o.Stats(); stats.Skipped != 1 || stats.Late != 1 || stats.Delivered != 6 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestOrderedConn_ReorderTimeout tests that missing datagrams are skipped after
+// the reorder timeout.
+func TestOrderedConn_ReorderTimeout(t *testing.T) {
+	o, inject := newOrderedReceiver(t, &OrderedConfig{ReorderTimeout: 20 * time.Millisecond})
+	inject(1, 1, "1")
+	inject(1, 4, "4")
+	inject(1, 5, "5")
+	start := time.Now()
+
+	expectOrdered(t, o, "1", 0)
+	expectOrdered(t, o, "4", 2)
+	expectOrdered(t, o, "5", 0)
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("gap skipped after %v, before the reorder timeout", elapsed)
+	}
+	o.mu.Lock()
+	for _, s := range o.streams {
+		if len(s.buffer) != 0 || s.timer != nil {
+			t.Error("stream should have an empty buffer and no timer")
+		}
+	}
+	o.mu.Unlock()
+}
+
+// TestOrderedConn_ForgedSequence tests that a datagram far ahead of the stream
+// is handled without walking the skipped sequence numbers, and that a slow
+// reader does not block senders.
+func TestOrderedConn_ForgedSequence(t *testing.T) {
+	o, inject := newOrderedReceiver(t, nil)
+	start := time.Now()
+	inject(1, 2, "2")
+	inject(1, 0xFFFFFFF0, "forged")
+	expectOrdered(t, o, "2", 1)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("forged sequence number took %v", elapsed)
+	}
+	o.mu.Lock()
+	next := o.streams[orderedStreamKey{port: 6001, stream: 1}].next
+	o.mu.Unlock()
+	if want := uint32(0xFFFFFFF0 - DefaultOrderedWindow + 1); next != want {
+		t.Errorf("stream skipped to %d, want %d", next, want)
+	}
+
+	for seq := uint32(1); seq <= orderedQueueSize+20; seq++ {
+		inject(2, seq, "x")
+	}
+	done := make(chan error, 1)
+	go func() { done <- o.SendTo([]byte("x"), validDestinationB64(), 6001) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("SendTo() failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendTo() blocked behind an unread receive queue")
+	}
+}
+
+// TestOrderedConn_MaxStreams tests that the least recently active stream is
+// dropped to make room for new ones.
+func TestOrderedConn_MaxStreams(t *testing.T) {
+	o, inject := newOrderedReceiver(t, &OrderedConfig{MaxStreams: 2, ReorderTimeout: time.Hour})
+	inject(1, 2, "a")
+	time.Sleep(time.Millisecond)
+	inject(2, 2, "b")
+	time.Sleep(time.Millisecond)
+	inject(3, 1, "c")
+	expectOrdered(t, o, "c", 0)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.streams) != 2 {
+		t.Errorf("%d streams kept, want 2", len(o.streams))
+	}
+	if _, ok := o.streams[orderedStreamKey{port: 6001, stream: 1}]; ok {
+		t.Error("least recently active stream was kept")
+	}
+}
@@ -0,0 +1,389 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPacerMaxDestinations bounds the per-destination buckets a Pacer keeps.
+const DefaultPacerMaxDestinations = 4096
+
+// ErrRateLimited is returned by sends that a Pacer in PacerReject mode would
+// have to delay.
+var ErrRateLimited = errors.New("send rate limit exceeded")
+
+// PacerMode selects what a Pacer does with sends over the rate limit.
+type PacerMode int
+
+const (
+	// PacerBlock delays sends until the rate limit admits them, or until the
+	// context, write deadline or connection ends the wait.
+	PacerBlock PacerMode = iota
+
+	// PacerReject fails sends over the rate limit with ErrRateLimited.
+	PacerReject
+)
+
+// RateLimit is a token bucket limit in bytes and datagrams per second. Zero
+// rates are unlimited.
+type RateLimit struct {
+	// BytesPerSecond limits envelope bytes handed to the router.
+	BytesPerSecond float64
+
+	// PacketsPerSecond limits datagrams handed to the router.
+	PacketsPerSecond float64
+
+	// BurstBytes and BurstPackets are the bucket sizes: how much may be sent at
+	// once after an idle period. Default: a tenth of a second of traffic, and at
+	// least one datagram. A datagram larger than BurstBytes is sent when the
+	// bucket is full and delays later sends accordingly.
+	BurstBytes   int
+	BurstPackets int
+}
+
+// PacerConfig configures a Pacer.
+type PacerConfig struct {
+	// Conn limits the total traffic of every connection using the Pacer.
+	Conn RateLimit
+
+	// PerDestination limits the traffic to each destination.
+	PerDestination RateLimit
+
+	// Mode selects blocking (the default) or rejecting sends over the limit.
+	Mode PacerMode
+
+	// MaxDestinations bounds the per-destination buckets kept; the least recently
+	// used are dropped past it. Default: DefaultPacerMaxDestinations.
+	MaxDestinations int
+}
+
+// PacerStats holds the counters of a Pacer.
+type PacerStats struct {
+	// Admitted counts sends the Pacer let through.
+	Admitted uint64
+
+	// Delayed counts admitted sends that had to wait, and Waited is their total wait.
+	Delayed uint64
+	Waited  time.Duration
+
+	// Rejected counts sends failed with ErrRateLimited.
+	Rejected uint64
+
+	// Abandoned counts sends whose context, deadline or connection ended the wait.
+	Abandoned uint64
+}
+
+// tokenBucket is one token bucket. A nil bucket is unlimited.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, defaultBurst float64, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if burst <= 0 {
+		b = max(defaultBurst, rate/10)
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: now}
+}
+
+// refill adds the tokens accrued since the last refill.
+func (b *tokenBucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// delay returns how long until n tokens may be taken. Requests larger than the
+// bucket may be taken once it is full.
+func (b *tokenBucket) delay(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	need := min(n, b.burst) - b.tokens
+	if need <= 0 {
+		return 0
+	}
+	return time.Duration(need / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+func (b *tokenBucket) full() bool {
+	return b == nil || b.tokens >= b.burst
+}
+
+// rateBuckets are the byte and packet buckets of one RateLimit.
+type rateBuckets struct {
+	bytes, packets *tokenBucket
+	lastUsed       time.Time
+}
+
+func newRateBuckets(limit RateLimit, now time.Time) *rateBuckets {
+	return &rateBuckets{
+		bytes:    newTokenBucket(limit.BytesPerSecond, limit.BurstBytes, MaxI2NPSize, now),
+		packets:  newTokenBucket(limit.PacketsPerSecond, limit.BurstPackets, 1, now),
+		lastUsed: now,
+	}
+}
+
+func (r *rateBuckets) refill(now time.Time) {
+	r.bytes.refill(now)
+	r.packets.refill(now)
+}
+
+func (r *rateBuckets) delay(size int) time.Duration {
+	return max(r.bytes.delay(float64(size)), r.packets.delay(1))
+}
+
+func (r *rateBuckets) take(size int, now time.Time) {
+	r.bytes.take(float64(size))
+	r.packets.take(1)
+	r.lastUsed = now
+}
+
+// Pacer spaces out sends with token buckets, in total and per destination, so
+// bursts do not overflow the router's outbound tunnel queues.
+//
+// Every datagram takes its envelope size from the byte buckets and one token
+// from the packet buckets. In PacerBlock mode sends wait for tokens; in
+// PacerReject mode they fail with ErrRateLimited instead.
+//
+// A Pacer is safe for concurrent use and may be shared between connections to
+// limit them together. Install it with [DatagramConn.SetPacer], or call Wait
+// directly before other sends.
+type Pacer struct {
+	config PacerConfig
+
+	// now returns the current time; replaced in tests.
+	now func() time.Time
+
+	mu    sync.Mutex
+	conn  *rateBuckets
+	dests map[string]*rateBuckets
+	stats PacerStats
+
+	// waiters counts the sends of each priority waiting for the shared buckets;
+	// changed is closed and replaced whenever one stops waiting.
+	waiters map[Priority]int
+	changed chan struct{}
+}
+
+// NewPacer creates a Pacer with the given configuration.
+func NewPacer(config PacerConfig) *Pacer {
+	if config.MaxDestinations <= 0 {
+		config.MaxDestinations = DefaultPacerMaxDestinations
+	}
+	now := time.Now()
+	return &Pacer{
+		config:  config,
+		now:     time.Now,
+		conn:    newRateBuckets(config.Conn, now),
+		dests:   make(map[string]*rateBuckets),
+		waiters: make(map[Priority]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// Wait admits a datagram of size envelope bytes to destination, waiting for
+// tokens in PacerBlock mode. It returns ErrRateLimited in PacerReject mode if the
+// datagram would have to wait, and ctx's error if ctx ends the wait.
+func (p *Pacer) Wait(ctx context.Context, destination string, size int) error {
+	return p.WaitPriority(ctx, destination, size, PriorityNormal)
+}
+
+// WaitPriority is Wait for a send of the given priority. While sends of higher
+// priority wait for the connection-wide buckets, sends of lower priority are not
+// admitted.
+func (p *Pacer) WaitPriority(ctx context.Context, destination string, size int, priority Priority) error {
+	var timer *time.Timer
+	var waitStart time.Time
+	waiting := false
+	for {
+		p.mu.Lock()
+		now := p.now()
+		dest := p.destinationLocked(destination, now)
+		p.conn.refill(now)
+		connDelay := p.conn.delay(size)
+		delay := connDelay
+		if dest != nil {
+			dest.refill(now)
+			delay = max(delay, dest.delay(size))
+		}
+		preempted := p.preemptedLocked(priority)
+		if delay == 0 && !preempted {
+			p.conn.take(size, now)
+			if dest != nil {
+				dest.take(size, now)
+			}
+			p.setWaitingLocked(priority, &waiting, false)
+			p.stats.Admitted++
+			if !waitStart.IsZero() {
+				p.stats.Delayed++
+				p.stats.Waited += now.Sub(waitStart)
+			}
+			p.mu.Unlock()
+			return nil
+		}
+		if p.config.Mode == PacerReject {
+			p.stats.Rejected++
+			p.mu.Unlock()
+			return ErrRateLimited
+		}
+		// Only sends held up by the shared buckets hold back lower priorities
+		p.setWaitingLocked(priority, &waiting, connDelay > 0)
+		changed := p.changed
+		p.mu.Unlock()
+
+		if waitStart.IsZero() {
+			waitStart = now
+		}
+		var expired <-chan time.Time
+		if delay > 0 {
+			if timer == nil {
+				timer = time.NewTimer(delay)
+				defer timer.Stop()
+			} else {
+				timer.Reset(delay)
+			}
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+		case <-changed:
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.setWaitingLocked(priority, &waiting, false)
+			p.stats.Abandoned++
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// preemptedLocked reports whether a send of higher priority than priority is
+// waiting. p.mu must be held.
+func (p *Pacer) preemptedLocked(priority Priority) bool {
+	for other, n := range p.waiters {
+		if other > priority && n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// setWaitingLocked records whether a send of priority is waiting, waking the
+// others when it stops. p.mu must be held.
+func (p *Pacer) setWaitingLocked(priority Priority, waiting *bool, now bool) {
+	if *waiting == now {
+		return
+	}
+	*waiting = now
+	if now {
+		p.waiters[priority]++
+		return
+	}
+	p.waiters[priority]--
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// destinationLocked returns the buckets for destination, or nil if destinations
+// are unlimited. p.mu must be held.
+func (p *Pacer) destinationLocked(destination string, now time.Time) *rateBuckets {
+	limit := p.config.PerDestination
+	if limit.BytesPerSecond <= 0 && limit.PacketsPerSecond <= 0 {
+		return nil
+	}
+	if b, ok := p.dests[destination]; ok {
+		return b
+	}
+	if len(p.dests) >= p.config.MaxDestinations {
+		// Full buckets behave like new ones, so dropping them changes nothing
+		var oldest string
+		for key, b := range p.dests {
+			b.refill(now)
+			if b.bytes.full() && b.packets.full() {
+				delete(p.dests, key)
+			} else if oldest == "" || b.lastUsed.Before(p.dests[oldest].lastUsed) {
+				oldest = key
+			}
+		}
+		if len(p.dests) >= p.config.MaxDestinations {
+			delete(p.dests, oldest)
+		}
+	}
+	b := newRateBuckets(limit, now)
+	p.dests[destination] = b
+	return b
+}
+
+// Stats returns a snapshot of the pacer counters.
+func (p *Pacer) Stats() PacerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// SetPacer installs a Pacer that Send and its wrappers wait on before
+// handing each datagram to the session. Blocked sends end when their context or
+// the write deadline ends, or when the connection is closed. Pass nil to send without pacing (the
+// default).
+//
+// Example:
+//
+//	conn.SetPacer(datagrams.NewPacer(datagrams.PacerConfig{
+//	    Conn:           datagrams.RateLimit{BytesPerSecond: 256 << 10},
+//	    PerDestination: datagrams.RateLimit{PacketsPerSecond: 50},
+//	}))
+func (d *DatagramConn) SetPacer(pacer *Pacer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pacer = pacer
+}
+
+// pace waits for pacer, if any, to admit an envelope of size bytes to
+// destination at priority, until ctx or deadline ends or the connection is
+// closed.
+func (d *DatagramConn) pace(ctx context.Context, pacer *Pacer, deadline time.Time, destination string, size int, priority Priority) error {
+	if pacer == nil {
+		return nil
+	}
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(d.ctx, cancel)
+	defer stop()
+	if !deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		waitCtx, cancelDeadline = context.WithDeadline(waitCtx, deadline)
+		defer cancelDeadline()
+	}
+	err := pacer.WaitPriority(waitCtx, destination, size, priority)
+	switch {
+	case err == nil || errors.Is(err, ErrRateLimited):
+		return err
+	case d.ctx.Err() != nil:
+		return net.ErrClosed
+	case ctx.Err() != nil:
+		return ctx.Err()
+	default:
+		return fmt.Errorf("write deadline exceeded: %w", err)
+	}
+}
@@ -0,0 +1,160 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClockPacer returns a PacerReject pacer whose clock advances only via the
+// returned function.
+func fakeClockPacer(config PacerConfig) (*Pacer, func(time.Duration)) {
+	config.Mode = PacerReject
+	p := NewPacer(config)
+	now := time.Unix(1700000000, 0)
+	p.now = func() time.Time { return now }
+	p.conn = newRateBuckets(config.Conn, now)
+	return p, func(d time.Duration) { now = now.Add(d) }
+}
+
+// TestPacer_PerDestination tests per-destination packet limits.
+func TestPacer_PerDestination(t *testing.T) {
+	p, advance := fakeClockPacer(PacerConfig{PerDestination: RateLimit{PacketsPerSecond: 10, BurstPackets: 2}})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := p.Wait(ctx, "a", 100); err != nil {
+			t.Fatalf("send %d: Wait() = %v", i, err)
+		}
+	}
+	if err := p.Wait(ctx, "a", 100); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("third send: Wait() = %v, want ErrRateLimited", err)
+	}
+	if err := p.Wait(ctx, "b", 100); err != nil {
+		t.Errorf("other destination: Wait() = %v", err)
+	}
+	advance(100 * time.Millisecond)
+	if err := p.Wait(ctx, "a", 100); err != nil {
+		t.Errorf("after refill: Wait() = %v", err)
+	}
+	if stats := p.Stats(); stats.Admitted != 4 || stats.Rejected != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestPacer_ConnBytes tests the total byte limit, including datagrams larger
+// than the bucket.
+func TestPacer_ConnBytes(t *testing.T) {
+	p, advance := fakeClockPacer(PacerConfig{Conn: RateLimit{BytesPerSecond: 1000, BurstBytes: 1000}})
+	ctx := context.Background()
+
+	if err := p.Wait(ctx, "a", 600); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	if err := p.Wait(ctx, "b", 600); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("over the bucket: Wait() = %v, want ErrRateLimited", err)
+	}
+	advance(600 * time.Millisecond) // full again
+	if err := p.Wait(ctx, "b", 5000); err != nil {
+		t.Errorf("large datagram on a full bucket: Wait() = %v", err)
+	}
+	advance(4 * time.Second) // still 1000 bytes in debt
+	if err := p.Wait(ctx, "a", 1); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("in debt: Wait() = %v, want ErrRateLimited", err)
+	}
+	advance(time.Second)
+	if err := p.Wait(ctx, "a", 1); err != nil {
+		t.Errorf("after repaying: Wait() = %v", err)
+	}
+}
+
+// TestPacer_MaxDestinations tests that per-destination buckets are bounded.
+func TestPacer_MaxDestinations(t *testing.T) {
+	p, advance := fakeClockPacer(PacerConfig{PerDestination: RateLimit{PacketsPerSecond: 1}, MaxDestinations: 3})
+	for i := 0; i < 10; i++ {
+		p.Wait(context.Background(), fmt.Sprint(i), 1)
+		advance(time.Millisecond)
+	}
+	if len(p.dests) != 3 {
+		t.Errorf("%d destinations tracked, want 3", len(p.dests))
+	}
+	if _, ok := p.dests["9"]; !ok {
+		t.Error("most recent destination was evicted")
+	}
+}
+
+// TestDatagramConn_SetPacer tests blocking sends, the write deadline and Close.
+func TestDatagramConn_SetPacer(t *testing.T) {
+	session := newMockSession()
+	conn, err := NewDatagramConn(session, 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	defer conn.Close()
+	pacer := NewPacer(PacerConfig{Conn: RateLimit{PacketsPerSecond: 100, BurstPackets: 1}})
+	conn.SetPacer(pacer)
+	dest := validDestinationB64()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := conn.SendTo([]byte("x"), dest, 9000); err != nil {
+			t.Fatalf("SendTo() failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("5 sends at 100/s took %v", elapsed)
+	}
+	if stats := pacer.Stats(); stats.Admitted != 5 || stats.Delayed != 4 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	conn.SetPacer(NewPacer(PacerConfig{Conn: RateLimit{PacketsPerSecond: 0.1, BurstPackets: 1}}))
+	if err := conn.SendTo([]byte("x"), dest, 9000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if err := conn.SendTo([]byte("x"), dest, 9000); err == nil || !strings.Contains(err.Error(), "write deadline exceeded") {
+		t.Errorf("SendTo() past the deadline = %v", err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	errs := make(chan error, 1)
+	go func() { errs <- conn.SendToWithOptions([]byte("x"), dest, 9000, nil) }()
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	if err := <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("SendTo() blocked during Close = %v, want net.ErrClosed", err)
+	}
+}
+
+// TestPacer_WaitPriority tests that a waiting high-priority send is admitted
+// before a low-priority one that started waiting earlier.
+func TestPacer_WaitPriority(t *testing.T) {
+	p := NewPacer(PacerConfig{Conn: RateLimit{PacketsPerSecond: 10, BurstPackets: 1}})
+	ctx := context.Background()
+	if err := p.Wait(ctx, "d", 1); err != nil {
+		t.Fatalf("Wait() failed: %v", err)
+	}
+
+	order := make(chan Priority, 2)
+	wait := func(priority Priority) {
+		if err := p.WaitPriority(ctx, "d", 1, priority); err != nil {
+			t.Errorf("WaitPriority(%d) failed: %v", priority, err)
+		}
+		order <- priority
+	}
+	go wait(PriorityLow)
+	time.Sleep(20 * time.Millisecond)
+	go wait(PriorityHigh)
+
+	if first, second := <-order, <-order; first != PriorityHigh || second != PriorityLow {
+		t.Errorf("admitted %d then %d, want high then low", first, second)
+	}
+	if stats := p.Stats(); stats.Admitted != 3 || stats.Delayed != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
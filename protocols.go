@@ -0,0 +1,72 @@
+package datagrams
+
+import (
+	"fmt"
+	"slices"
+)
+
+// NewDatagramConnWithProtocols creates a DatagramConn that sends as protocol and
+// receives protocol and every protocol in receive, so one port can serve, for
+// example, legacy Datagram1 clients alongside Datagram2 and Datagram3 ones.
+//
+// Each received datagram is parsed as the protocol it arrived with, which is
+// reported in ReceiveResult.Protocol. Datagrams of other protocols are rejected
+// rather than misparsed. Protocol 6 (streaming) cannot be bound.
+//
+// Example:
+//
+//	conn, err := datagrams.NewDatagramConnWithProtocols(session, 8080,
+//	    datagrams.ProtocolDatagram2, datagrams.ProtocolDatagram1, datagrams.ProtocolDatagram3)
+//	result, err := conn.ReceiveFromWithOptions()
+//	if result.Protocol == datagrams.ProtocolDatagram1 {
+//	    // legacy client
+//	}
+func NewDatagramConnWithProtocols(session I2CPSession, localPort uint16, protocol uint8, receive ...uint8) (*DatagramConn, error) {
+	for _, p := range receive {
+		if p == ProtocolStreaming {
+			return nil, fmt.Errorf("protocol %d (streaming) is reserved and cannot be used for datagrams", p)
+		}
+	}
+	conn, err := NewDatagramConnWithProtocol(session, localPort, protocol)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range receive {
+		if !slices.Contains(conn.protocols, p) {
+			conn.protocols = append(conn.protocols, p)
+		}
+	}
+	return conn, nil
+}
+
+// Protocols returns the protocols the connection receives, starting with the
+// protocol it sends as.
+func (d *DatagramConn) Protocols() []uint8 {
+	return slices.Clone(d.protocols)
+}
+
+// AcceptsProtocol reports whether the connection receives datagrams of protocol.
+func (d *DatagramConn) AcceptsProtocol(protocol uint8) bool {
+	return slices.Contains(d.protocols, protocol)
+}
+
+// receiveProtocol returns the protocol msg is parsed as, its own, or an error if
+// the connection does not receive it.
+func (d *DatagramConn) receiveProtocol(msg *receivedDatagram) (uint8, error) {
+	if !d.AcceptsProtocol(msg.protocol) {
+		return 0, fmt.Errorf("protocol %d is not bound to this connection (bound: %v)", msg.protocol, d.protocols)
+	}
+	return msg.protocol, nil
+}
+
+// processReceived runs msg through processDatagram as its own protocol.
+func (d *DatagramConn) processReceived(msg *receivedDatagram) (*ReceiveResult, error) {
+	if msg.processed {
+		return msg.result, msg.err
+	}
+	protocol, err := d.receiveProtocol(msg)
+	if err != nil {
+		return nil, err
+	}
+	return d.processDatagram(msg, protocol)
+}
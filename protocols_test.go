@@ -0,0 +1,129 @@
+package datagrams
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewDatagramConnWithProtocols tests the bound protocol set.
+func TestNewDatagramConnWithProtocols(t *testing.T) {
+	session := newMockSession()
+	conn, err := NewDatagramConnWithProtocols(session, 9300, ProtocolDatagram2, ProtocolDatagram1, ProtocolDatagram2, ProtocolDatagram3)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocols() failed: %v", err)
+	}
+	defer conn.Close()
+
+	if got, want := conn.Protocols(), []uint8{ProtocolDatagram2, ProtocolDatagram1, ProtocolDatagram3}; !slices.Equal(got, want) {
+		t.Errorf("Protocols() = %v, want %v", got, want)
+	}
+	if conn.Protocol() != ProtocolDatagram2 || !conn.AcceptsProtocol(ProtocolDatagram1) || conn.AcceptsProtocol(ProtocolRaw) {
+		t.Errorf("conn sends as %d, accepts Datagram1 %v, accepts Raw %v", conn.Protocol(), conn.AcceptsProtocol(ProtocolDatagram1), conn.AcceptsProtocol(ProtocolRaw))
+	}
+	if conn.HasSenderDestination() {
+		t.Error("HasSenderDestination() = true with Datagram3 bound")
+	}
+
+	if _, err := NewDatagramConnWithProtocols(session, 9301, ProtocolDatagram2, ProtocolStreaming); err == nil {
+		t.Error("NewDatagramConnWithProtocols() accepted the streaming protocol")
+	}
+}
+
+// TestDatagramConn_MultiProtocolReceive tests that each datagram is parsed as the
+// protocol it arrived with, through ReceiveFromWithOptions and port handlers, and
+// that unbound protocols are rejected.
+func TestDatagramConn_MultiProtocolReceive(t *testing.T) {
+	server, err := NewDatagramConnWithProtocols(newMockSession(), 9300, ProtocolDatagram2, ProtocolDatagram1, ProtocolDatagram3)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocols() failed: %v", err)
+	}
+	defer server.Close()
+	handled := make(chan *ReceiveResult, 3)
+	if err := server.RegisterResultHandler(80, func(r *ReceiveResult) { handled <- r }); err != nil {
+		t.Fatalf("RegisterResultHandler() failed: %v", err)
+	}
+
+	deliver := func(protocol uint8, srcPort, destPort uint16, payload []byte) {
+		server.injectMessage(append([]byte(nil), payload...), nil, protocol, srcPort, destPort)
+	}
+	clients := map[uint8]*DatagramConn{}
+	for _, protocol := range []uint8{ProtocolDatagram1, ProtocolDatagram2, ProtocolDatagram3} {
+		session := newMockSession()
+		session.deliver = deliver
+		client, err := NewDatagramConnWithProtocol(session, 9301, protocol)
+		if err != nil {
+			t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+		}
+		defer client.Close()
+		clients[protocol] = client
+	}
+	sendAll := func(port uint16) {
+		t.Helper()
+		for protocol, client := range clients {
+			if err := client.SendTo([]byte{protocol}, server.localDest.Base64(), port); err != nil {
+				t.Fatalf("SendTo() failed: %v", err)
+			}
+		}
+	}
+	check := func(via string, result *ReceiveResult) {
+		t.Helper()
+		protocol := result.Payload[0]
+		want := clients[protocol].localWire.hash()
+		if result.Protocol != protocol || result.FromAddr.DestinationHash != want {
+			t.Errorf("%s: datagram sent as %d received as %d from %x", via, protocol, result.Protocol, result.FromAddr.DestinationHash[:4])
+		}
+		if result.Authenticated != (protocol != ProtocolDatagram3) {
+			t.Errorf("%s: protocol %d Authenticated = %v", via, protocol, result.Authenticated)
+		}
+	}
+
+	// Handled datagrams first, since ReceiveFromWithOptions takes any queued datagram
+	sendAll(80)
+	for range clients {
+		select {
+		case result := <-handled:
+			check("handler", result)
+		case <-time.After(5 * time.Second):
+			t.Fatal("port handler not called")
+		}
+	}
+	sendAll(9300)
+	for range clients {
+		check("ReceiveFromWithOptions", receiveWithin(t, server, 5*time.Second))
+	}
+
+	server.injectMessage([]byte("raw"), nil, ProtocolRaw, 9301, 9300)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := server.ReceiveFromWithOptions(); err == nil || !strings.Contains(err.Error(), "not bound") {
+		t.Errorf("Raw datagram on a Datagram1/2/3 conn: error = %v, want not bound", err)
+	}
+}
+
+// TestDatagramConn_UnboundProtocol tests that a single-protocol conn rejects
+// datagrams of other protocols instead of misparsing them.
+func TestDatagramConn_UnboundProtocol(t *testing.T) {
+	raw, err := NewDatagramConn(newMockSession(), 9300)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	defer raw.Close()
+	sender := newMockSession()
+	sender.deliver = func(protocol uint8, srcPort, destPort uint16, payload []byte) {
+		raw.injectMessage(append([]byte(nil), payload...), nil, protocol, srcPort, destPort)
+	}
+	d2, err := NewDatagramConnWithProtocol(sender, 9301, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer d2.Close()
+
+	if err := d2.SendTo([]byte("hello"), raw.localDest.Base64(), 9300); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	raw.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if result, err := raw.ReceiveFromWithOptions(); err == nil {
+		t.Errorf("Raw conn received a Datagram2 envelope as %q", result.Payload)
+	}
+}
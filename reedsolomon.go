@@ -0,0 +1,206 @@
+package datagrams
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// GF(2^8) arithmetic with the polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d) and
+// generator 2, as used by most Reed-Solomon implementations.
+var gfExp, gfLog = func() (exp [512]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])*n%255]
+}
+
+// gfMulAdd sets dst[i] ^= c * src[i].
+func gfMulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	logC := int(gfLog[c])
+	for i, s := range src {
+		if s != 0 {
+			dst[i] ^= gfExp[logC+int(gfLog[s])]
+		}
+	}
+}
+
+// gfInvert inverts a square matrix in place by Gauss-Jordan elimination.
+func gfInvert(m [][]byte) error {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && m[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return errors.New("singular matrix")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+		if c := gfInv(m[col][col]); c != 1 {
+			for j := 0; j < n; j++ {
+				m[col][j] = gfMul(m[col][j], c)
+				inv[col][j] = gfMul(inv[col][j], c)
+			}
+		}
+		for row := 0; row < n; row++ {
+			if row != col && m[row][col] != 0 {
+				c := m[row][col]
+				gfMulAdd(m[row], m[col], c)
+				gfMulAdd(inv[row], inv[col], c)
+			}
+		}
+	}
+	copy(m, inv)
+	return nil
+}
+
+// MaxFECShards is the largest number of data plus parity shards in one
+// Reed-Solomon group, the size of GF(2^8).
+const MaxFECShards = 256
+
+// reedSolomon is a systematic Reed-Solomon erasure code over GF(2^8): data shards
+// are sent unchanged, and any data of the data+parity shards recover the rest.
+type reedSolomon struct {
+	data, parity int
+	matrix       [][]byte // (data+parity) x data; the top data rows are the identity
+}
+
+var reedSolomonCache sync.Map // [2]int{data, parity} -> *reedSolomon
+
+// newReedSolomon returns the code for the given shard counts. Codes are cached,
+// since groups of the same shape share them.
+func newReedSolomon(data, parity int) (*reedSolomon, error) {
+	if data < 1 || parity < 0 || data+parity > MaxFECShards {
+		return nil, fmt.Errorf("invalid shard counts: %d data, %d parity (at most %d in total)", data, parity, MaxFECShards)
+	}
+	shape := [2]int{data, parity}
+	if rs, ok := reedSolomonCache.Load(shape); ok {
+		return rs.(*reedSolomon), nil
+	}
+
+	// Multiply a Vandermonde matrix by the inverse of its top square, so the top
+	// rows become the identity and any data rows remain invertible.
+	total := data + parity
+	vandermonde := make([][]byte, total)
+	for r := range vandermonde {
+		vandermonde[r] = make([]byte, data)
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top := make([][]byte, data)
+	for r := range top {
+		top[r] = append([]byte(nil), vandermonde[r]...)
+	}
+	if err := gfInvert(top); err != nil {
+		return nil, err
+	}
+	matrix := make([][]byte, total)
+	for r := range matrix {
+		matrix[r] = make([]byte, data)
+		for c := 0; c < data; c++ {
+			for k := 0; k < data; k++ {
+				matrix[r][c] ^= gfMul(vandermonde[r][k], top[k][c])
+			}
+		}
+	}
+
+	rs, _ := reedSolomonCache.LoadOrStore(shape, &reedSolomon{data: data, parity: parity, matrix: matrix})
+	return rs.(*reedSolomon), nil
+}
+
+// encode returns the parity shards of data shards of equal length.
+func (rs *reedSolomon) encode(shards [][]byte) [][]byte {
+	size := len(shards[0])
+	parity := make([][]byte, rs.parity)
+	for i := range parity {
+		parity[i] = make([]byte, size)
+		for j, shard := range shards {
+			gfMulAdd(parity[i], shard, rs.matrix[rs.data+i][j])
+		}
+	}
+	return parity
+}
+
+// reconstruct fills the missing (nil) data shards of shards, which holds data
+// then parity shards of equal length. Returns an error if fewer than data shards
+// are present.
+func (rs *reedSolomon) reconstruct(shards [][]byte) error {
+	rows := make([]int, 0, rs.data)
+	missing := false
+	for i, shard := range shards {
+		if shard == nil {
+			missing = missing || i < rs.data
+			continue
+		}
+		if len(rows) < rs.data {
+			rows = append(rows, i)
+		}
+	}
+	if !missing {
+		return nil
+	}
+	if len(rows) < rs.data {
+		return fmt.Errorf("have %d of %d shards needed for recovery", len(rows), rs.data)
+	}
+
+	decode := make([][]byte, rs.data)
+	for i, row := range rows {
+		decode[i] = append([]byte(nil), rs.matrix[row]...)
+	}
+	if err := gfInvert(decode); err != nil {
+		return err
+	}
+	size := len(shards[rows[0]])
+	for j := 0; j < rs.data; j++ {
+		if shards[j] != nil {
+			continue
+		}
+		shard := make([]byte, size)
+		for i, row := range rows {
+			gfMulAdd(shard, shards[row], decode[j][i])
+		}
+		shards[j] = shard
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package datagrams
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// TestReedSolomon_Reconstruct tests recovering data from every subset of
+// shards with enough members.
+func TestReedSolomon_Reconstruct(t *testing.T) {
+	rs, err := newReedSolomon(4, 2)
+	if err != nil {
+		t.Fatalf("newReedSolomon() failed: %v", err)
+	}
+	data := make([][]byte, 4)
+	for i := range data {
+		data[i] = []byte(fmt.Sprintf("shard-%d-data", i))
+	}
+	all := append(append([][]byte(nil), data...), rs.encode(data)...)
+
+	for lost := 0; lost < 1<<6; lost++ {
+		shards := make([][]byte, len(all))
+		present := 0
+		for i := range all {
+			if lost&(1<<i) == 0 {
+				shards[i] = all[i]
+				present++
+			}
+		}
+		err := rs.reconstruct(shards)
+		if present < 4 {
+			if err == nil && lost&0xF != 0 {
+				t.Errorf("lost %06b: expected error with %d shards", lost, present)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("lost %06b: reconstruct() failed: %v", lost, err)
+		}
+		for i := range data {
+			if !bytes.Equal(shards[i], data[i]) {
+				t.Errorf("lost %06b: shard %d = %q, want %q", lost, i, shards[i], data[i])
+			}
+		}
+	}
+}
+
+// TestNewReedSolomon tests shard count validation and caching.
+func TestNewReedSolomon(t *testing.T) {
+	for _, shape := range [][2]int{{0, 1}, {1, -1}, {200, 57}} {
+		if _, err := newReedSolomon(shape[0], shape[1]); err == nil {
+			t.Errorf("newReedSolomon(%d, %d): expected error", shape[0], shape[1])
+		}
+	}
+	a, _ := newReedSolomon(200, 56)
+	b, _ := newReedSolomon(200, 56)
+	if a != b {
+		t.Error("expected codes of the same shape to be shared")
+	}
+}
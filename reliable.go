@@ -0,0 +1,636 @@
+package datagrams
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reliable frame types.
+const (
+	// reliableData is type(1) + stream(4) + seq(4) + done(4) + payload, where every
+	// sequence number up to done has been acknowledged or given up by the sender.
+	reliableData byte = 1
+	// reliableAck is type(1) + stream(4) + cumulative ack(4) + selective ack bitmap(4).
+	reliableAck byte = 2
+)
+
+const (
+	// ReliableOverhead is the per-datagram overhead of ReliableConn data frames:
+	// type(1) + stream(4) + sequence number(4) + done marker(4) = 13 bytes.
+	ReliableOverhead = 1 + 4 + 4 + 4
+
+	// reliableAckSize is the size of an ack frame.
+	reliableAckSize = 1 + 4 + 4 + 4
+
+	// reliableSackBits is the number of sequence numbers past the cumulative ack
+	// covered by the selective ack bitmap.
+	reliableSackBits = 32
+
+	// DefaultReliableInitialRTO is the retransmission timeout before the first RTT
+	// sample. I2P round trips cross four tunnels and typically take 1-3 seconds.
+	DefaultReliableInitialRTO = 3 * time.Second
+
+	// DefaultReliableMinRTO is the lower bound of the retransmission timeout.
+	DefaultReliableMinRTO = 500 * time.Millisecond
+
+	// DefaultReliableMaxRTO is the upper bound of the retransmission timeout.
+	DefaultReliableMaxRTO = 60 * time.Second
+
+	// DefaultReliableMaxRetries is the number of retransmissions before a send fails.
+	DefaultReliableMaxRetries = 8
+
+	// DefaultReliableMaxPending is the default number of unacknowledged messages a
+	// ReliableConn holds across all peers.
+	DefaultReliableMaxPending = 256
+
+	// DefaultReliableWindow is how far past the cumulative ack a receiver accepts
+	// sequence numbers.
+	DefaultReliableWindow = 1024
+
+	// DefaultReliableIdleTimeout is how long per-peer state is kept without traffic.
+	DefaultReliableIdleTimeout = 5 * time.Minute
+)
+
+// ErrDeliveryFailed is returned by SendReliable when a message was not
+// acknowledged after the maximum number of retransmissions.
+var ErrDeliveryFailed = errors.New("reliable: delivery failed after retransmissions")
+
+// ReliableConfig configures a ReliableConn. Zero fields take their defaults.
+type ReliableConfig struct {
+	// InitialRTO is the retransmission timeout before the first RTT sample.
+	InitialRTO time.Duration
+
+	// MinRTO and MaxRTO bound the retransmission timeout.
+	MinRTO time.Duration
+	MaxRTO time.Duration
+
+	// MaxRetries is the number of retransmissions before SendReliable fails
+	// with ErrDeliveryFailed.
+	MaxRetries int
+
+	// MaxPending bounds the retransmit queue: the number of unacknowledged messages
+	// across all peers. SendReliable blocks while the queue is full.
+	MaxPending int
+
+	// Window is how far past the cumulative ack a receiver accepts sequence numbers;
+	// later messages are dropped unacknowledged and retransmitted by the sender.
+	Window int
+
+	// IdleTimeout is how long a peer's sequence state is kept without traffic.
+	// Receivers keep state for twice as long, so a sender always starts a new
+	// stream before its receiver forgets the old one.
+	IdleTimeout time.Duration
+}
+
+func (c ReliableConfig) withDefaults() ReliableConfig {
+	if c.InitialRTO <= 0 {
+		c.InitialRTO = DefaultReliableInitialRTO
+	}
+	if c.MinRTO <= 0 {
+		c.MinRTO = DefaultReliableMinRTO
+	}
+	if c.MaxRTO <= 0 {
+		c.MaxRTO = DefaultReliableMaxRTO
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = DefaultReliableMaxRetries
+	}
+	if c.MaxPending <= 0 {
+		c.MaxPending = DefaultReliableMaxPending
+	}
+	if c.Window <= 0 {
+		c.Window = DefaultReliableWindow
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = DefaultReliableIdleTimeout
+	}
+	return c
+}
+
+// ReliableStats reports activity on a ReliableConn.
+type ReliableStats struct {
+	Sent        uint64 // messages first transmitted
+	Retransmits uint64 // retransmissions
+	Acked       uint64 // messages acknowledged
+	Failed      uint64 // messages that exhausted their retransmissions
+	Received    uint64 // messages delivered to Receive
+	Duplicates  uint64 // duplicate messages suppressed
+}
+
+type reliableCounters struct {
+	sent, retransmits, acked, failed, received, duplicates atomic.Uint64
+}
+
+// reliablePeerKey identifies a peer by destination and port.
+type reliablePeerKey struct {
+	dest string
+	port uint16
+}
+
+// reliablePending is an unacknowledged message.
+type reliablePending struct {
+	payload []byte
+	sentAt  time.Time
+	retries int
+	timer   *time.Timer
+	done    chan error // receives the outcome once
+}
+
+// reliableSender is the send state for one peer.
+type reliableSender struct {
+	stream     uint32 // random per stream, so receivers detect restarts
+	nextSeq    uint32
+	pending    map[uint32]*reliablePending
+	srtt       time.Duration // zero until the first sample
+	rttvar     time.Duration
+	rto        time.Duration
+	lastActive time.Time
+}
+
+// doneLocked returns the highest sequence number up to which no message is
+// pending, so receivers may skip messages the sender has given up on.
+// r.mu must be held.
+func (s *reliableSender) doneLocked() uint32 {
+	done := s.nextSeq - 1
+	for seq := range s.pending {
+		if seq <= done {
+			done = seq - 1
+		}
+	}
+	return done
+}
+
+// sample updates the RTO estimate with a round-trip time (RFC 6298).
+func (s *reliableSender) sample(rtt time.Duration, config ReliableConfig) {
+	if s.srtt == 0 {
+		s.srtt, s.rttvar = rtt, rtt/2
+	} else {
+		delta := s.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.rttvar = (3*s.rttvar + delta) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	s.rto = clampDuration(s.srtt+4*s.rttvar, config.MinRTO, config.MaxRTO)
+}
+
+// acked reports whether an ack with the given cumulative number and selective
+// bitmap covers seq.
+func acked(seq, cumulative, bitmap uint32) bool {
+	if seq <= cumulative {
+		return true
+	}
+	offset := seq - cumulative - 2 // bit 0 is cumulative+2; cumulative+1 is missing
+	return seq >= cumulative+2 && offset < reliableSackBits && bitmap&(1<<offset) != 0
+}
+
+func clampDuration(d, lo, hi time.Duration) time.Duration {
+	if d < lo {
+		return lo
+	}
+	if d > hi {
+		return hi
+	}
+	return d
+}
+
+// reliableReceiver is the receive state for one peer stream.
+type reliableReceiver struct {
+	stream     uint32
+	cumulative uint32              // every sequence number up to this was received
+	received   map[uint32]struct{} // received sequence numbers past cumulative
+	lastActive time.Time
+}
+
+// accept records seq, first skipping every sequence number up to done, which
+// the sender no longer retransmits. It returns whether seq is new and whether it
+// is inside the window; messages outside the window are neither recorded nor
+// acknowledged.
+func (r *reliableReceiver) accept(seq, done uint32, window int) (fresh, inWindow bool) {
+	if done > r.cumulative && done < seq {
+		for received := range r.received {
+			if received <= done {
+				delete(r.received, received)
+			}
+		}
+		r.cumulative = done
+		r.advance()
+	}
+	if seq == 0 || uint64(seq) > uint64(r.cumulative)+uint64(window) {
+		return false, false
+	}
+	if seq <= r.cumulative {
+		return false, true
+	}
+	if _, dup := r.received[seq]; dup {
+		return false, true
+	}
+	r.received[seq] = struct{}{}
+	r.advance()
+	return true, true
+}
+
+// advance moves the cumulative ack over contiguously received sequence numbers.
+func (r *reliableReceiver) advance() {
+	for {
+		if _, ok := r.received[r.cumulative+1]; !ok {
+			return
+		}
+		delete(r.received, r.cumulative+1)
+		r.cumulative++
+	}
+}
+
+// ack returns the cumulative ack and the selective ack bitmap.
+func (r *reliableReceiver) ack() (uint32, uint32) {
+	var bitmap uint32
+	for i := uint32(0); i < reliableSackBits; i++ {
+		if _, ok := r.received[r.cumulative+2+i]; ok {
+			bitmap |= 1 << i
+		}
+	}
+	return r.cumulative, bitmap
+}
+
+// ReliableConn adds acknowledged delivery to a DatagramConn.
+//
+// Each message carries a per-peer sequence number. Receivers acknowledge every
+// message with a cumulative ack plus a selective ack bitmap of the next 32
+// sequence numbers, and suppress duplicates. Each message also carries the
+// sequence number up to which the sender has nothing pending, so messages that
+// failed or were abandoned do not hold back the receiver's window, and a
+// restarted receiver resumes an existing stream. Senders retransmit unacknowledged
+// messages after a retransmission timeout estimated from round-trip times
+// (RFC 6298, with Karn's rule and exponential backoff), until MaxRetries.
+//
+// Messages are delivered to Receive in arrival order, not sequence order.
+// Acks are sent back to the sender's destination, so the underlying connection
+// must report full sender destinations: use Datagram1 or Datagram2. Messages
+// without one are dropped.
+//
+// ReliableConn owns the DatagramConn, which must not be read elsewhere, and
+// closes it on Close.
+type ReliableConn struct {
+	conn   *DatagramConn
+	config ReliableConfig
+	slots  chan struct{} // bounds the retransmit queue
+
+	mu        sync.Mutex
+	senders   map[reliablePeerKey]*reliableSender
+	receivers map[reliablePeerKey]*reliableReceiver
+
+	incoming chan *ReceiveResult
+	counters reliableCounters
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewReliableConn wraps conn in a ReliableConn. A nil config uses defaults.
+func NewReliableConn(conn *DatagramConn, config *ReliableConfig) (*ReliableConn, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("conn cannot be nil")
+	}
+	var cfg ReliableConfig
+	if config != nil {
+		cfg = *config
+	}
+	cfg = cfg.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &ReliableConn{
+		conn:      conn,
+		config:    cfg,
+		slots:     make(chan struct{}, cfg.MaxPending),
+		senders:   make(map[reliablePeerKey]*reliableSender),
+		receivers: make(map[reliablePeerKey]*reliableReceiver),
+		incoming:  make(chan *ReceiveResult, 100),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	r.wg.Add(2)
+	go pumpConn(&r.wg, conn, r.handle)
+	go tickUntil(r.ctx, &r.wg, cfg.IdleTimeout/2, r.prune)
+	return r, nil
+}
+
+// Conn returns the underlying DatagramConn.
+func (r *ReliableConn) Conn() *DatagramConn {
+	return r.conn
+}
+
+// SendReliable sends payload to addr and blocks until it is acknowledged, it fails
+// with ErrDeliveryFailed after MaxRetries retransmissions, ctx is done, or the
+// connection is closed. Errors sending the first transmission are returned
+// directly. A message abandoned because ctx is done may still be delivered.
+//
+// The payload may be at most MaxPayloadSize bytes and must not be modified until
+// SendReliable returns.
+func (r *ReliableConn) SendReliable(ctx context.Context, payload []byte, addr *I2PAddr) error {
+	if addr == nil || addr.Destination == "" {
+		return fmt.Errorf("reliable send requires a destination address")
+	}
+	if len(payload) > r.MaxPayloadSize() {
+		return fmt.Errorf("payload size %d exceeds maximum %d", len(payload), r.MaxPayloadSize())
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.ctx.Done():
+		return net.ErrClosed
+	}
+	defer func() { <-r.slots }()
+
+	key := reliablePeerKey{dest: addr.Destination, port: addr.Port}
+	r.mu.Lock()
+	if r.ctx.Err() != nil {
+		r.mu.Unlock()
+		return net.ErrClosed
+	}
+	sender, err := r.senderLocked(key)
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	seq := sender.nextSeq
+	sender.nextSeq++
+	frame := reliableDataFrame(sender.stream, seq, sender.doneLocked(), payload)
+	p := &reliablePending{
+		payload: payload,
+		sentAt:  time.Now(),
+		done:    make(chan error, 1),
+	}
+	sender.pending[seq] = p
+	p.timer = time.AfterFunc(sender.rto, func() { r.retransmit(key, sender, seq) })
+	r.mu.Unlock()
+
+	if err := r.conn.SendTo(frame, key.dest, key.port); err != nil {
+		r.abandon(sender, seq)
+		return err
+	}
+	r.counters.sent.Add(1)
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		r.abandon(sender, seq)
+		return ctx.Err()
+	case <-r.ctx.Done():
+		return net.ErrClosed
+	}
+}
+
+// senderLocked returns the send state for key, starting a new stream if needed.
+// r.mu must be held.
+func (r *ReliableConn) senderLocked(key reliablePeerKey) (*reliableSender, error) {
+	sender, ok := r.senders[key]
+	if !ok {
+		var stream [4]byte
+		if _, err := rand.Read(stream[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate stream id: %w", err)
+		}
+		sender = &reliableSender{
+			stream:  binary.BigEndian.Uint32(stream[:]),
+			nextSeq: 1,
+			pending: make(map[uint32]*reliablePending),
+			rto:     r.config.InitialRTO,
+		}
+		r.senders[key] = sender
+	}
+	sender.lastActive = time.Now()
+	return sender, nil
+}
+
+// abandon removes a pending message without reporting an outcome.
+func (r *ReliableConn) abandon(sender *reliableSender, seq uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := sender.pending[seq]; ok {
+		p.timer.Stop()
+		delete(sender.pending, seq)
+	}
+}
+
+// retransmit resends seq on timeout, or fails it after MaxRetries.
+func (r *ReliableConn) retransmit(key reliablePeerKey, sender *reliableSender, seq uint32) {
+	r.mu.Lock()
+	p, ok := sender.pending[seq]
+	if !ok || r.ctx.Err() != nil {
+		r.mu.Unlock()
+		return
+	}
+	if p.retries >= r.config.MaxRetries {
+		delete(sender.pending, seq)
+		r.mu.Unlock()
+		r.counters.failed.Add(1)
+		p.done <- ErrDeliveryFailed
+		return
+	}
+	// Back off the peer's RTO until a new sample arrives (RFC 6298 section 5.5)
+	p.retries++
+	sender.rto = clampDuration(2*sender.rto, r.config.MinRTO, r.config.MaxRTO)
+	p.timer = time.AfterFunc(sender.rto, func() { r.retransmit(key, sender, seq) })
+	frame := reliableDataFrame(sender.stream, seq, sender.doneLocked(), p.payload)
+	r.mu.Unlock()
+
+	r.counters.retransmits.Add(1)
+	r.conn.SendTo(frame, key.dest, key.port) // A failed send is retried on the next timeout
+}
+
+// handle dispatches a received datagram by frame type.
+func (r *ReliableConn) handle(result *ReceiveResult) {
+	if result.FromAddr.Destination == "" || len(result.Payload) < 1 {
+		return
+	}
+	key := reliablePeerKey{dest: result.FromAddr.Destination, port: result.SrcPort}
+	switch result.Payload[0] {
+	case reliableData:
+		r.handleData(key, result)
+	case reliableAck:
+		r.handleAck(key, result.Payload)
+	}
+}
+
+// handleData acknowledges a data frame and delivers it unless it is a duplicate.
+func (r *ReliableConn) handleData(key reliablePeerKey, result *ReceiveResult) {
+	if len(result.Payload) < ReliableOverhead {
+		return
+	}
+	stream := binary.BigEndian.Uint32(result.Payload[1:5])
+	seq := binary.BigEndian.Uint32(result.Payload[5:9])
+	done := binary.BigEndian.Uint32(result.Payload[9:13])
+
+	r.mu.Lock()
+	recv, ok := r.receivers[key]
+	if !ok || recv.stream != stream {
+		recv = &reliableReceiver{stream: stream, received: make(map[uint32]struct{})}
+		r.receivers[key] = recv
+	}
+	recv.lastActive = time.Now()
+	fresh, inWindow := recv.accept(seq, done, r.config.Window)
+	cumulative, bitmap := recv.ack()
+	r.mu.Unlock()
+
+	if !inWindow {
+		return
+	}
+	ack := make([]byte, reliableAckSize)
+	ack[0] = reliableAck
+	binary.BigEndian.PutUint32(ack[1:5], stream)
+	binary.BigEndian.PutUint32(ack[5:9], cumulative)
+	binary.BigEndian.PutUint32(ack[9:13], bitmap)
+	r.conn.SendTo(ack, key.dest, key.port)
+
+	if !fresh {
+		r.counters.duplicates.Add(1)
+		return
+	}
+	delivered := *result
+	delivered.Payload = result.Payload[ReliableOverhead:]
+	select {
+	case r.incoming <- &delivered:
+		r.counters.received.Add(1)
+	case <-r.ctx.Done():
+	}
+}
+
+// handleAck completes the pending messages an ack covers.
+func (r *ReliableConn) handleAck(key reliablePeerKey, frame []byte) {
+	if len(frame) < reliableAckSize {
+		return
+	}
+	stream := binary.BigEndian.Uint32(frame[1:5])
+	cumulative := binary.BigEndian.Uint32(frame[5:9])
+	bitmap := binary.BigEndian.Uint32(frame[9:13])
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sender, ok := r.senders[key]
+	if !ok || sender.stream != stream {
+		return
+	}
+	now := time.Now()
+	sender.lastActive = now
+	for seq, p := range sender.pending {
+		if !acked(seq, cumulative, bitmap) {
+			continue
+		}
+		p.timer.Stop()
+		delete(sender.pending, seq)
+		if p.retries == 0 {
+			// Karn's rule: retransmitted messages give ambiguous samples
+			sender.sample(now.Sub(p.sentAt), r.config)
+		}
+		r.counters.acked.Add(1)
+		p.done <- nil
+	}
+}
+
+// tickUntil calls fn every interval until ctx is done.
+func tickUntil(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, fn func(time.Time)) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			fn(now)
+		}
+	}
+}
+
+// prune drops senders idle for IdleTimeout and receivers idle for twice as long.
+func (r *ReliableConn) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, sender := range r.senders {
+		if len(sender.pending) == 0 && now.Sub(sender.lastActive) >= r.config.IdleTimeout {
+			delete(r.senders, key)
+		}
+	}
+	for key, recv := range r.receivers {
+		if now.Sub(recv.lastActive) >= 2*r.config.IdleTimeout {
+			delete(r.receivers, key)
+		}
+	}
+}
+
+// Receive blocks until a message arrives, ctx is done, or the connection is
+// closed (net.ErrClosed). The result's Payload excludes the reliable header.
+func (r *ReliableConn) Receive(ctx context.Context) (*ReceiveResult, error) {
+	select {
+	case result := <-r.incoming:
+		return result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-r.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// MaxPayloadSize returns the largest payload SendReliable accepts.
+func (r *ReliableConn) MaxPayloadSize() int {
+	return r.conn.MaxPayloadSize() - ReliableOverhead
+}
+
+// Stats returns the connection's counters.
+func (r *ReliableConn) Stats() ReliableStats {
+	return ReliableStats{
+		Sent:        r.counters.sent.Load(),
+		Retransmits: r.counters.retransmits.Load(),
+		Acked:       r.counters.acked.Load(),
+		Failed:      r.counters.failed.Load(),
+		Received:    r.counters.received.Load(),
+		Duplicates:  r.counters.duplicates.Load(),
+	}
+}
+
+// RTO returns the current retransmission timeout for addr, or InitialRTO for
+// peers without send state.
+func (r *ReliableConn) RTO(addr *I2PAddr) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sender, ok := r.senders[reliablePeerKey{dest: addr.Destination, port: addr.Port}]; ok {
+		return sender.rto
+	}
+	return r.config.InitialRTO
+}
+
+// Close stops retransmissions, fails waiting sends with net.ErrClosed and closes
+// the underlying DatagramConn.
+func (r *ReliableConn) Close() error {
+	r.mu.Lock()
+	r.cancel()
+	for _, sender := range r.senders {
+		for _, p := range sender.pending {
+			p.timer.Stop()
+		}
+	}
+	r.mu.Unlock()
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
+
+// reliableDataFrame builds a data frame.
+func reliableDataFrame(stream, seq, done uint32, payload []byte) []byte {
+	frame := make([]byte, ReliableOverhead, ReliableOverhead+len(payload))
+	frame[0] = reliableData
+	binary.BigEndian.PutUint32(frame[1:5], stream)
+	binary.BigEndian.PutUint32(frame[5:9], seq)
+	binary.BigEndian.PutUint32(frame[9:13], done)
+	return append(frame, payload...)
+}
@@ -0,0 +1,246 @@
+package datagrams
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dropSends wraps session's routing so that sends for which drop returns true are
+// lost. n counts sends from 1.
+func dropSends(session *mockSession, drop func(n int, payload []byte) bool) {
+	var mu sync.Mutex
+	n := 0
+	deliver := session.deliver
+	session.deliver = func(protocol uint8, srcPort, destPort uint16, payload []byte) {
+		mu.Lock()
+		n++
+		lost := drop(n, payload)
+		mu.Unlock()
+		if !lost {
+			deliver(protocol, srcPort, destPort, payload)
+		}
+	}
+}
+
+// reliablePair returns two ReliableConns over Datagram2 routed to each other,
+// with their sessions.
+func reliablePair(t *testing.T, config *ReliableConfig) (a, b *ReliableConn, sa, sb *mockSession) {
+	t.Helper()
+	ca, cb, sa, sb := connPair(t, ProtocolDatagram2, ProtocolDatagram2)
+	var err error
+	if a, err = NewReliableConn(ca, config); err != nil {
+		t.Fatalf("NewReliableConn() failed: %v", err)
+	}
+	if b, err = NewReliableConn(cb, config); err != nil {
+		t.Fatalf("NewReliableConn() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b, sa, sb
+}
+
+func addrOf(session *mockSession, port uint16) *I2PAddr {
+	return &I2PAddr{Destination: session.Destination().Base64(), Port: port}
+}
+
+// fastReliableConfig retransmits quickly for tests.
+var fastReliableConfig = &ReliableConfig{InitialRTO: 20 * time.Millisecond, MinRTO: 10 * time.Millisecond, MaxRTO: 100 * time.Millisecond}
+
+// TestReliableConn_SendReliable tests acknowledged delivery in both directions.
+func TestReliableConn_SendReliable(t *testing.T) {
+	a, b, sa, sb := reliablePair(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.SendReliable(ctx, []byte("ping"), addrOf(sb, pairPortB)); err != nil {
+		t.Fatalf("SendReliable() failed: %v", err)
+	}
+	result, err := b.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+	if string(result.Payload) != "ping" || !result.Authenticated || result.SrcPort != pairPortA {
+		t.Errorf("Receive() = %q, Authenticated = %v, SrcPort = %d", result.Payload, result.Authenticated, result.SrcPort)
+	}
+	if err := b.SendReliable(ctx, []byte("pong"), result.FromAddr); err != nil {
+		t.Fatalf("reply SendReliable() failed: %v", err)
+	}
+	if result, err := a.Receive(ctx); err != nil || string(result.Payload) != "pong" {
+		t.Errorf("Receive() = %v, %v", result, err)
+	}
+
+	stats := a.Stats()
+	if stats.Sent != 1 || stats.Acked != 1 || stats.Received != 1 || stats.Retransmits != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if rto := a.RTO(addrOf(sb, pairPortB)); rto != DefaultReliableMinRTO {
+		t.Errorf("RTO() = %v, want %v after a fast sample", rto, DefaultReliableMinRTO)
+	}
+	if rto := a.RTO(addrOf(sa, 1)); rto != DefaultReliableInitialRTO {
+		t.Errorf("RTO() for unknown peer = %v, want %v", rto, DefaultReliableInitialRTO)
+	}
+	if a.MaxPayloadSize() != a.Conn().MaxPayloadSize()-ReliableOverhead {
+		t.Errorf("MaxPayloadSize() = %d", a.MaxPayloadSize())
+	}
+}
+
+// TestReliableConn_Retransmit tests recovery from a lost message and a lost ack.
+func TestReliableConn_Retransmit(t *testing.T) {
+	a, b, sa, sb := reliablePair(t, fastReliableConfig)
+	dropSends(sa, func(n int, _ []byte) bool { return n == 1 }) // first data frame
+	dropSends(sb, func(n int, _ []byte) bool { return n == 2 }) // second ack
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, payload := range []string{"lost message", "lost ack"} {
+		if err := a.SendReliable(ctx, []byte(payload), addrOf(sb, pairPortB)); err != nil {
+			t.Fatalf("SendReliable(%q) failed: %v", payload, err)
+		}
+		if result, err := b.Receive(ctx); err != nil || string(result.Payload) != payload {
+			t.Fatalf("Receive() = %v, %v, want %q", result, err, payload)
+		}
+	}
+
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if result, err := b.Receive(short); err == nil {
+		t.Errorf("duplicate delivered: %q", result.Payload)
+	}
+	if stats := a.Stats(); stats.Retransmits < 2 || stats.Acked != 2 {
+		t.Errorf("sender stats = %+v", stats)
+	}
+	if stats := b.Stats(); stats.Received != 2 || stats.Duplicates < 1 {
+		t.Errorf("receiver stats = %+v", stats)
+	}
+}
+
+// TestReliableConn_Failure tests definitive failure, cancellation and Close.
+func TestReliableConn_Failure(t *testing.T) {
+	config := *fastReliableConfig
+	config.MaxRetries = 2
+	a, _, sa, sb := reliablePair(t, &config)
+	dropSends(sa, func(int, []byte) bool { return true })
+
+	err := a.SendReliable(context.Background(), []byte("void"), addrOf(sb, pairPortB))
+	if !errors.Is(err, ErrDeliveryFailed) {
+		t.Fatalf("SendReliable() error = %v, want ErrDeliveryFailed", err)
+	}
+	if stats := a.Stats(); stats.Failed != 1 || stats.Retransmits != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := a.SendReliable(ctx, []byte("void"), addrOf(sb, pairPortB)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendReliable() error = %v, want context.DeadlineExceeded", err)
+	}
+	if err := a.SendReliable(ctx, []byte("x"), &I2PAddr{Port: 1}); err == nil {
+		t.Error("expected error for address without destination")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- a.SendReliable(context.Background(), []byte("closing"), addrOf(sb, pairPortB)) }()
+	time.Sleep(5 * time.Millisecond)
+	a.Close()
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("SendReliable() during Close error = %v, want net.ErrClosed", err)
+	}
+	if !a.Conn().IsClosed() {
+		t.Error("Close() should close the DatagramConn")
+	}
+}
+
+// TestReliableConn_SkipLost tests that a message the sender gave up on does not
+// hold back later messages once more than Window have been sent.
+func TestReliableConn_SkipLost(t *testing.T) {
+	config := *fastReliableConfig
+	config.MaxRetries = 1
+	config.Window = 4
+	a, b, sa, sb := reliablePair(t, &config)
+	dropSends(sa, func(_ int, payload []byte) bool { return bytes.Contains(payload, []byte("lost")) })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.SendReliable(ctx, []byte("lost"), addrOf(sb, pairPortB)); !errors.Is(err, ErrDeliveryFailed) {
+		t.Fatalf("SendReliable() error = %v, want ErrDeliveryFailed", err)
+	}
+	for i := 0; i < 3*config.Window; i++ {
+		payload := fmt.Sprintf("message %d", i)
+		if err := a.SendReliable(ctx, []byte(payload), addrOf(sb, pairPortB)); err != nil {
+			t.Fatalf("SendReliable(%q) failed: %v", payload, err)
+		}
+		if result, err := b.Receive(ctx); err != nil || string(result.Payload) != payload {
+			t.Fatalf("Receive() = %v, %v, want %q", result, err, payload)
+		}
+	}
+}
+
+// TestReliableReceiver_Ack tests duplicate detection, the window and selective acks.
+func TestReliableReceiver_Ack(t *testing.T) {
+	r := &reliableReceiver{received: make(map[uint32]struct{})}
+	for _, seq := range []uint32{1, 3, 4, 6} {
+		if fresh, ok := r.accept(seq, 0, 8); !fresh || !ok {
+			t.Errorf("accept(%d) = %v, %v", seq, fresh, ok)
+		}
+	}
+	cumulative, bitmap := r.ack()
+	if cumulative != 1 || bitmap != 0b1011 { // 3, 4 and 6
+		t.Errorf("ack() = %d, %b", cumulative, bitmap)
+	}
+	for _, seq := range []uint32{1, 3, 0} {
+		if fresh, _ := r.accept(seq, 0, 8); fresh {
+			t.Errorf("accept(%d) should be a duplicate", seq)
+		}
+	}
+	if _, ok := r.accept(10, 0, 8); ok {
+		t.Error("accept() past the window should be rejected")
+	}
+	r.accept(2, 0, 8)
+	if cumulative, bitmap := r.ack(); cumulative != 4 || bitmap != 0b1 {
+		t.Errorf("ack() = %d, %b, want 4 and 1", cumulative, bitmap)
+	}
+
+	// The sender's done marker skips a lost message, or resumes after a restart
+	if fresh, ok := r.accept(7, 5, 8); !fresh || !ok {
+		t.Errorf("accept(7) past done 5 = %v, %v", fresh, ok)
+	}
+	if cumulative, _ := r.ack(); cumulative != 7 {
+		t.Errorf("ack() after done marker = %d, want 7", cumulative)
+	}
+	restarted := &reliableReceiver{received: make(map[uint32]struct{})}
+	if fresh, ok := restarted.accept(5000, 4999, 8); !fresh || !ok {
+		t.Errorf("restarted accept(5000) = %v, %v", fresh, ok)
+	}
+
+	for seq, want := range map[uint32]bool{1: true, 4: true, 5: false, 6: true, 7: false, 38: false} {
+		if got := acked(seq, 4, 0b1); got != want {
+			t.Errorf("acked(%d) = %v, want %v", seq, got, want)
+		}
+	}
+}
+
+// TestReliableSender_Sample tests RTO estimation.
+func TestReliableSender_Sample(t *testing.T) {
+	config := ReliableConfig{}.withDefaults()
+	s := &reliableSender{rto: config.InitialRTO}
+	s.sample(time.Second, config)
+	if s.srtt != time.Second || s.rttvar != 500*time.Millisecond || s.rto != 3*time.Second {
+		t.Errorf("first sample: srtt = %v, rttvar = %v, rto = %v", s.srtt, s.rttvar, s.rto)
+	}
+	s.sample(time.Second, config)
+	if s.rto != time.Second+4*375*time.Millisecond {
+		t.Errorf("second sample: rto = %v", s.rto)
+	}
+	s.sample(time.Hour, config)
+	if s.rto != config.MaxRTO {
+		t.Errorf("rto = %v, want capped at %v", s.rto, config.MaxRTO)
+	}
+}
@@ -0,0 +1,228 @@
+package datagrams
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReplayTimestampOption is the Datagram2 option key carrying the sender's send time
+// as decimal Unix seconds. When present, a ReplayCache rejects datagrams whose
+// timestamp falls outside its window, so replays cannot outlive the cache entries
+// that would otherwise catch them. OptionTimestamp is the typed key for it.
+const ReplayTimestampOption = "ts"
+
+// Default ReplayCache limits, used when the corresponding ReplayCacheConfig field is zero.
+const (
+	// DefaultReplayWindow is how long accepted datagrams are remembered.
+	DefaultReplayWindow = 5 * time.Minute
+
+	// DefaultReplayMaxEntries bounds the number of remembered datagrams.
+	// Each entry costs roughly 100 bytes, so the default is about 6.5 MB.
+	DefaultReplayMaxEntries = 65536
+)
+
+var (
+	// ErrReplayedDatagram is returned when an authenticated datagram has already
+	// been received from the same sender within the replay window.
+	ErrReplayedDatagram = errors.New("replayed datagram")
+
+	// ErrStaleDatagram is returned when an authenticated datagram's timestamp option
+	// lies outside the replay window, or is missing while timestamps are required.
+	ErrStaleDatagram = errors.New("datagram timestamp outside replay window")
+)
+
+// ReplayCacheConfig configures a ReplayCache. Zero values select the defaults.
+type ReplayCacheConfig struct {
+	// Window is how long an accepted datagram is remembered, and the maximum
+	// allowed clock difference for the timestamp option. Default: 5 minutes.
+	Window time.Duration
+
+	// MaxEntries bounds memory use. When full, the oldest entries are evicted
+	// before their window expires, which is counted in ReplayStats.Evicted.
+	// From then on, timestamped datagrams sent no later than the newest evicted
+	// one are rejected as stale. Default: 65536.
+	MaxEntries int
+
+	// RequireTimestamp rejects authenticated datagrams that do not carry the
+	// ReplayTimestampOption. Datagram1 cannot carry options, so enabling this
+	// rejects all Datagram1 traffic.
+	RequireTimestamp bool
+}
+
+// ReplayStats holds the counters of a ReplayCache.
+type ReplayStats struct {
+	// Accepted counts datagrams seen for the first time.
+	Accepted uint64
+
+	// Replayed counts datagrams rejected with ErrReplayedDatagram.
+	Replayed uint64
+
+	// Stale counts datagrams rejected with ErrStaleDatagram.
+	Stale uint64
+
+	// Evicted counts entries dropped early because MaxEntries was reached.
+	Evicted uint64
+}
+
+// replayKey identifies an authenticated datagram: the sender's destination hash
+// and a digest of the envelope signature.
+type replayKey struct {
+	sender    [32]byte
+	signature [32]byte
+}
+
+// replayEntry records when a replayKey was first seen, in arrival order, and
+// its timestamp option, which is zero if it had none.
+type replayEntry struct {
+	key  replayKey
+	seen time.Time
+	sent time.Time
+}
+
+// ReplayCache rejects authenticated datagrams (Datagram1 and Datagram2) that were
+// already received within a sliding time window. Datagram1 messages carry no
+// nonce, so a sender that repeats an identical payload produces an identical
+// signature and the repeat is rejected as a replay.
+//
+// Datagram2 binds its signature to the recipient's destination hash, which stops a
+// datagram from being redirected to another recipient but not from being replayed
+// to the same one. Datagram1 has no protection at all. A ReplayCache closes that gap
+// by remembering each sender hash and signature digest it has accepted.
+//
+// Memory is bounded by both the window and MaxEntries. Entries are evicted in arrival
+// order, so the cache costs O(1) per datagram. Datagrams carrying the
+// ReplayTimestampOption stay protected when MaxEntries forces early eviction: the
+// cache fails closed and rejects as stale any timestamp not after the newest
+// evicted one, which under sustained load also rejects some fresh datagrams.
+// Datagrams without a timestamp can be replayed once their entry is evicted.
+//
+// A ReplayCache is safe for concurrent use and may be shared between connections.
+// Install it with [DatagramConn.SetReplayCache].
+type ReplayCache struct {
+	window           time.Duration
+	maxEntries       int
+	requireTimestamp bool
+
+	// now returns the current time; replaced in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[replayKey]struct{}
+	queue   []replayEntry
+	stats   ReplayStats
+
+	// evictedSent is the newest timestamp among entries evicted before their
+	// window expired.
+	evictedSent time.Time
+}
+
+// NewReplayCache creates a ReplayCache with the given configuration.
+func NewReplayCache(config ReplayCacheConfig) *ReplayCache {
+	if config.Window <= 0 {
+		config.Window = DefaultReplayWindow
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultReplayMaxEntries
+	}
+	return &ReplayCache{
+		window:           config.Window,
+		maxEntries:       config.MaxEntries,
+		requireTimestamp: config.RequireTimestamp,
+		now:              time.Now,
+		entries:          make(map[replayKey]struct{}),
+	}
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *ReplayCache) Stats() ReplayStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Len returns the number of datagrams currently remembered.
+func (c *ReplayCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// check records an authenticated datagram and reports whether it is a replay.
+// options may be nil; a malformed timestamp option is an error but not a replay.
+func (c *ReplayCache) check(sender [32]byte, signature []byte, options *Options) error {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sent, err := c.checkTimestamp(now, options)
+	if err != nil {
+		return err
+	}
+
+	c.expire(now)
+
+	key := replayKey{sender: sender, signature: sha256.Sum256(signature)}
+	if _, seen := c.entries[key]; seen {
+		c.stats.Replayed++
+		return ErrReplayedDatagram
+	}
+
+	for len(c.queue) >= c.maxEntries {
+		if c.queue[0].sent.After(c.evictedSent) {
+			c.evictedSent = c.queue[0].sent
+		}
+		delete(c.entries, c.queue[0].key)
+		c.queue = c.queue[1:]
+		c.stats.Evicted++
+	}
+	c.entries[key] = struct{}{}
+	c.queue = append(c.queue, replayEntry{key: key, seen: now, sent: sent})
+	c.stats.Accepted++
+	return nil
+}
+
+// checkTimestamp validates the optional timestamp option against the window and
+// the evicted entries, returning the timestamp or zero if there is none.
+// Must be called with c.mu held.
+func (c *ReplayCache) checkTimestamp(now time.Time, options *Options) (time.Time, error) {
+	if options == nil || !options.Has(ReplayTimestampOption) {
+		if c.requireTimestamp {
+			c.stats.Stale++
+			return time.Time{}, fmt.Errorf("%w: missing %q option", ErrStaleDatagram, ReplayTimestampOption)
+		}
+		return time.Time{}, nil
+	}
+
+	sent, _, err := OptionTimestamp.Get(options)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if skew := now.Sub(sent); skew > c.window || skew < -c.window {
+		c.stats.Stale++
+		return time.Time{}, fmt.Errorf("%w: sent %s ago", ErrStaleDatagram, skew.Round(time.Second))
+	}
+	if !c.evictedSent.IsZero() && !sent.After(c.evictedSent) {
+		// An earlier datagram with this timestamp may have been evicted early
+		c.stats.Stale++
+		return time.Time{}, fmt.Errorf("%w: not after an entry evicted early", ErrStaleDatagram)
+	}
+	return sent, nil
+}
+
+// expire drops entries older than the window. Must be called with c.mu held.
+func (c *ReplayCache) expire(now time.Time) {
+	cutoff := now.Add(-c.window)
+	n := 0
+	for n < len(c.queue) && c.queue[n].seen.Before(cutoff) {
+		delete(c.entries, c.queue[n].key)
+		n++
+	}
+	if n > 0 {
+		// Copy down instead of reslicing so the backing array does not grow unbounded
+		c.queue = append(c.queue[:0], c.queue[n:]...)
+	}
+}
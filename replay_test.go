@@ -0,0 +1,279 @@
+package datagrams
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestReplayCache creates a ReplayCache driven by a controllable clock.
+func newTestReplayCache(config ReplayCacheConfig) (*ReplayCache, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	c := NewReplayCache(config)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+// TestNewReplayCache_Defaults tests that zero config values select the defaults.
+func TestNewReplayCache_Defaults(t *testing.T) {
+	c := NewReplayCache(ReplayCacheConfig{})
+	if c.window != DefaultReplayWindow {
+		t.Errorf("window = %v, want %v", c.window, DefaultReplayWindow)
+	}
+	if c.maxEntries != DefaultReplayMaxEntries {
+		t.Errorf("maxEntries = %d, want %d", c.maxEntries, DefaultReplayMaxEntries)
+	}
+}
+
+// TestReplayCache_Duplicate tests that the same sender and signature are rejected twice.
+func TestReplayCache_Duplicate(t *testing.T) {
+	c, _ := newTestReplayCache(ReplayCacheConfig{})
+	sender := [32]byte{1}
+	sig := []byte("signature")
+
+	if err := c.check(sender, sig, nil); err != nil {
+		t.Fatalf("first check failed: %v", err)
+	}
+	if err := c.check(sender, sig, nil); !errors.Is(err, ErrReplayedDatagram) {
+		t.Errorf("second check error = %v, want ErrReplayedDatagram", err)
+	}
+
+	// Same signature from another sender, or another signature, is not a replay
+	if err := c.check([32]byte{2}, sig, nil); err != nil {
+		t.Errorf("other sender rejected: %v", err)
+	}
+	if err := c.check(sender, []byte("other"), nil); err != nil {
+		t.Errorf("other signature rejected: %v", err)
+	}
+
+	stats := c.Stats()
+	if stats.Accepted != 3 || stats.Replayed != 1 {
+		t.Errorf("stats = %+v, want Accepted=3 Replayed=1", stats)
+	}
+	if c.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", c.Len())
+	}
+}
+
+// TestReplayCache_WindowExpiry tests that entries are forgotten after the window.
+func TestReplayCache_WindowExpiry(t *testing.T) {
+	c, now := newTestReplayCache(ReplayCacheConfig{Window: time.Minute})
+	sender := [32]byte{1}
+
+	if err := c.check(sender, []byte("a"), nil); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	*now = now.Add(30 * time.Second)
+	if err := c.check(sender, []byte("b"), nil); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+
+	*now = now.Add(31 * time.Second)
+	if err := c.check(sender, []byte("c"), nil); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2 after expiring the first entry", c.Len())
+	}
+	if err := c.check(sender, []byte("a"), nil); err != nil {
+		t.Errorf("expired entry still rejected: %v", err)
+	}
+	if err := c.check(sender, []byte("b"), nil); !errors.Is(err, ErrReplayedDatagram) {
+		t.Errorf("entry within window error = %v, want ErrReplayedDatagram", err)
+	}
+}
+
+// TestReplayCache_MaxEntries tests that memory is bounded by evicting the oldest entries.
+func TestReplayCache_MaxEntries(t *testing.T) {
+	c, _ := newTestReplayCache(ReplayCacheConfig{MaxEntries: 3})
+	sender := [32]byte{1}
+
+	for i := 0; i < 5; i++ {
+		if err := c.check(sender, []byte{byte(i)}, nil); err != nil {
+			t.Fatalf("check %d failed: %v", i, err)
+		}
+	}
+	if c.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", c.Len())
+	}
+	if stats := c.Stats(); stats.Evicted != 2 {
+		t.Errorf("Evicted = %d, want 2", stats.Evicted)
+	}
+	if err := c.check(sender, []byte{4}, nil); !errors.Is(err, ErrReplayedDatagram) {
+		t.Errorf("newest entry error = %v, want ErrReplayedDatagram", err)
+	}
+}
+
+// TestReplayCache_EvictionFailsClosed tests that a timestamped datagram evicted
+// before its window expired cannot be replayed.
+func TestReplayCache_EvictionFailsClosed(t *testing.T) {
+	c, now := newTestReplayCache(ReplayCacheConfig{Window: time.Minute, MaxEntries: 2})
+	sender := [32]byte{1}
+	ts := func(d time.Duration) *Options {
+		return NewOptions(map[string]string{
+			ReplayTimestampOption: strconv.FormatInt(now.Add(d).Unix(), 10),
+		})
+	}
+
+	for i, sent := range []time.Duration{-30 * time.Second, -20 * time.Second, -10 * time.Second} {
+		if err := c.check(sender, []byte{byte(i)}, ts(sent)); err != nil {
+			t.Fatalf("check %d failed: %v", i, err)
+		}
+	}
+	if err := c.check(sender, []byte{0}, ts(-30*time.Second)); !errors.Is(err, ErrStaleDatagram) {
+		t.Errorf("replay of evicted datagram error = %v, want ErrStaleDatagram", err)
+	}
+	if err := c.check(sender, []byte{3}, ts(-40*time.Second)); !errors.Is(err, ErrStaleDatagram) {
+		t.Errorf("datagram older than eviction error = %v, want ErrStaleDatagram", err)
+	}
+	if err := c.check(sender, []byte{4}, ts(0)); err != nil {
+		t.Errorf("datagram newer than eviction error = %v", err)
+	}
+}
+
+// TestReplayCache_Timestamp tests validation of the timestamp option.
+func TestReplayCache_Timestamp(t *testing.T) {
+	c, now := newTestReplayCache(ReplayCacheConfig{Window: time.Minute})
+	ts := func(d time.Duration) *Options {
+		return NewOptions(map[string]string{
+			ReplayTimestampOption: strconv.FormatInt(now.Add(d).Unix(), 10),
+		})
+	}
+
+	tests := []struct {
+		name    string
+		options *Options
+		wantErr error
+	}{
+		{"current", ts(0), nil},
+		{"slightly old", ts(-50 * time.Second), nil},
+		{"too old", ts(-2 * time.Minute), ErrStaleDatagram},
+		{"too far in future", ts(2 * time.Minute), ErrStaleDatagram},
+		{"absent", nil, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.check([32]byte{1}, []byte{byte(i)}, tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	malformed := NewOptions(map[string]string{ReplayTimestampOption: "yesterday"})
+	err := c.check([32]byte{1}, []byte("malformed"), malformed)
+	if err == nil || errors.Is(err, ErrStaleDatagram) {
+		t.Errorf("malformed timestamp error = %v, want parse error", err)
+	}
+
+	if stats := c.Stats(); stats.Stale != 2 {
+		t.Errorf("Stale = %d, want 2", stats.Stale)
+	}
+}
+
+// TestReplayCache_RequireTimestamp tests rejecting datagrams without a timestamp.
+func TestReplayCache_RequireTimestamp(t *testing.T) {
+	c, now := newTestReplayCache(ReplayCacheConfig{RequireTimestamp: true})
+
+	if err := c.check([32]byte{1}, []byte("a"), nil); !errors.Is(err, ErrStaleDatagram) {
+		t.Errorf("missing timestamp error = %v, want ErrStaleDatagram", err)
+	}
+	opts := NewOptions(map[string]string{ReplayTimestampOption: strconv.FormatInt(now.Unix(), 10)})
+	if err := c.check([32]byte{1}, []byte("a"), opts); err != nil {
+		t.Errorf("timestamped datagram rejected: %v", err)
+	}
+}
+
+// TestDatagramConn_ReplayCache_Datagram2 tests that replayed Datagram2 envelopes
+// are rejected on every receive path once a cache is installed.
+func TestDatagramConn_ReplayCache_Datagram2(t *testing.T) {
+	receiver := newMockSession()
+	conn, err := NewDatagramConnWithProtocol(receiver, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	targetHash, _ := destinationHash(receiver.Destination())
+	envelope, err := buildDatagram2EnvelopeWithOptions([]byte("once"), newMockSession(), targetHash, nil)
+	if err != nil {
+		t.Fatalf("buildDatagram2EnvelopeWithOptions() failed: %v", err)
+	}
+
+	// Without a cache, replays are delivered
+	for i := 0; i < 2; i++ {
+		conn.injectMessage(envelope, nil, ProtocolDatagram2, 9000, 8080)
+		if _, _, _, err := conn.ReceiveFrom(); err != nil {
+			t.Fatalf("ReceiveFrom() without cache failed: %v", err)
+		}
+	}
+
+	cache := NewReplayCache(ReplayCacheConfig{})
+	conn.SetReplayCache(cache)
+
+	conn.injectMessage(envelope, nil, ProtocolDatagram2, 9000, 8080)
+	if _, err := conn.ReceiveFromWithOptions(); err != nil {
+		t.Fatalf("first ReceiveFromWithOptions() failed: %v", err)
+	}
+
+	conn.injectMessage(envelope, nil, ProtocolDatagram2, 9000, 8080)
+	if _, _, _, err := conn.ReceiveFrom(); !errors.Is(err, ErrReplayedDatagram) {
+		t.Errorf("ReceiveFrom() error = %v, want ErrReplayedDatagram", err)
+	}
+	conn.injectMessage(envelope, nil, ProtocolDatagram2, 9000, 8080)
+	if _, _, err := conn.ReceiveFromWithAddr(); !errors.Is(err, ErrReplayedDatagram) {
+		t.Errorf("ReceiveFromWithAddr() error = %v, want ErrReplayedDatagram", err)
+	}
+
+	if stats := cache.Stats(); stats.Accepted != 1 || stats.Replayed != 2 {
+		t.Errorf("stats = %+v, want Accepted=1 Replayed=2", stats)
+	}
+}
+
+// TestDatagramConn_ReplayCache_Datagram1 tests replay rejection for Datagram1.
+func TestDatagramConn_ReplayCache_Datagram1(t *testing.T) {
+	conn, err := NewDatagramConnWithProtocol(newMockSession(), 8080, ProtocolDatagram1)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReplayCache(NewReplayCache(ReplayCacheConfig{}))
+
+	envelope, err := buildDatagram1Envelope([]byte("once"), newMockSession())
+	if err != nil {
+		t.Fatalf("buildDatagram1Envelope() failed: %v", err)
+	}
+
+	conn.injectMessage(envelope, nil, ProtocolDatagram1, 9000, 8080)
+	if _, _, err := conn.ReceiveFromWithAddr(); err != nil {
+		t.Fatalf("first ReceiveFromWithAddr() failed: %v", err)
+	}
+	conn.injectMessage(envelope, nil, ProtocolDatagram1, 9000, 8080)
+	if _, err := conn.ReceiveFromWithOptions(); !errors.Is(err, ErrReplayedDatagram) {
+		t.Errorf("ReceiveFromWithOptions() error = %v, want ErrReplayedDatagram", err)
+	}
+}
+
+// TestDatagramConn_ReplayCache_TamperedNotRecorded tests that envelopes failing
+// signature verification do not consume replay cache entries.
+func TestDatagramConn_ReplayCache_TamperedNotRecorded(t *testing.T) {
+	conn, err := NewDatagramConnWithProtocol(newMockSession(), 8080, ProtocolDatagram1)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+	cache := NewReplayCache(ReplayCacheConfig{})
+	conn.SetReplayCache(cache)
+
+	envelope, _ := buildDatagram1Envelope([]byte("payload"), newMockSession())
+	envelope[len(envelope)-1] ^= 0xFF
+
+	conn.injectMessage(envelope, nil, ProtocolDatagram1, 9000, 8080)
+	if _, _, _, err := conn.ReceiveFrom(); err == nil || errors.Is(err, ErrReplayedDatagram) {
+		t.Errorf("ReceiveFrom() error = %v, want verification failure", err)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", cache.Len())
+	}
+}
@@ -21,7 +21,11 @@ import (
 //   - port: The destination port number to listen on (0-65535)
 //   - handler: Function called when a datagram arrives on this port
 //   - payload: The datagram payload bytes (after envelope parsing)
-//   - from: The I2P destination of the sender (may be nil for Raw datagrams)
+//   - from: The I2P destination of the sender (nil for Raw datagrams without
+//     sender metadata, Datagram3, and ML-DSA senders)
+//
+// Datagrams are decoded and verified by the same pipeline as ReceiveFrom before the
+// handler is called; datagrams that fail to parse or verify are dropped.
 //
 // Returns an error if:
 //   - The connection is closed
@@ -37,7 +41,25 @@ func (d *DatagramConn) RegisterPort(port uint16, handler func([]byte, *i2cp.Dest
 	if handler == nil {
 		return fmt.Errorf("handler cannot be nil")
 	}
+	return d.registerHandler(port, func(result *ReceiveResult) { handler(result.Payload, result.From) })
+}
 
+// RegisterResultHandler registers a handler for datagrams received on a specific
+// port, like RegisterPort, but passes the full ReceiveResult. Use it when the
+// handler needs the sender's address and source port to reply, or the protocol,
+// options and authentication metadata.
+//
+// Returns an error if the connection is closed, the port is already registered,
+// or the handler is nil.
+func (d *DatagramConn) RegisterResultHandler(port uint16, handler func(*ReceiveResult)) error {
+	if handler == nil {
+		return fmt.Errorf("handler cannot be nil")
+	}
+	return d.registerHandler(port, handler)
+}
+
+// registerHandler installs a port handler and starts the receive loop.
+func (d *DatagramConn) registerHandler(port uint16, handler func(*ReceiveResult)) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -101,25 +123,33 @@ func (d *DatagramConn) UnregisterPort(port uint16) error {
 //
 // Returns an error if the connection is closed or the queue is full.
 func (d *DatagramConn) injectMessage(payload []byte, from *i2cp.Destination, protocol uint8, srcPort, destPort uint16) error {
+	// Hold the read lock while queuing so SetVerifyPolicy cannot stop the
+	// parallel workers between choosing verifyQueue and sending to it
 	d.mu.RLock()
-	closed := d.closed
-	d.mu.RUnlock()
+	defer d.mu.RUnlock()
 
-	if closed {
+	if d.closed {
 		return net.ErrClosed
 	}
 
+	// Under VerifyParallel, datagrams reach recvQueue through the verify workers
+	queue := d.recvQueue
+	if d.verifyPolicy.Mode == VerifyParallel {
+		queue = d.verifyQueue
+	}
+
 	msg := &receivedDatagram{
-		payload:  payload,
-		from:     from,
-		protocol: protocol,
-		srcPort:  srcPort,
-		destPort: destPort,
+		payload:    payload,
+		from:       from,
+		protocol:   protocol,
+		srcPort:    srcPort,
+		destPort:   destPort,
+		receivedAt: time.Now(),
 	}
 
 	// Non-blocking send to queue
 	select {
-	case d.recvQueue <- msg:
+	case queue <- msg:
 		return nil
 	default:
 		return fmt.Errorf("receive queue full")
@@ -166,9 +196,11 @@ func (d *DatagramConn) receiveLoop() {
 			d.mu.RUnlock()
 
 			if exists && handler != nil {
-				// Handler registered - dispatch to it
+				// Handler registered - parse and dispatch to it. The envelope goes through
+				// the same pipeline as ReceiveFrom, in the handler goroutine so signature
+				// verification does not block the loop. Invalid datagrams are dropped.
 				d.wg.Add(1)
-				go func(h func([]byte, *i2cp.Destination), payload []byte, from *i2cp.Destination) {
+				go func(h func(*ReceiveResult), msg *receivedDatagram) {
 					defer d.wg.Done()
 					defer func() {
 						// Recover from panics in user handlers to prevent crashing receive loop
@@ -177,8 +209,12 @@ func (d *DatagramConn) receiveLoop() {
 							// For now, silently recover to keep receive loop running
 						}
 					}()
-					h(payload, from)
-				}(handler, msg.payload, msg.from)
+					result, err := d.processReceived(msg)
+					if err != nil {
+						return
+					}
+					h(result)
+				}(handler, msg)
 			} else {
 				// No handler - put message back in queue for manual receive
 				// Check if connection is closed first
@@ -0,0 +1,501 @@
+package datagrams
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RPC frame types.
+const (
+	// rpcRequest is type(1) + call id(4) + method length(1) + method + body.
+	rpcRequest byte = 1
+	// rpcResponse is type(1) + call id(4) + status(1) + body.
+	rpcResponse byte = 2
+)
+
+// RPC response statuses.
+const (
+	rpcStatusOK            byte = 0
+	rpcStatusError         byte = 1 // body is the error message
+	rpcStatusUnknownMethod byte = 2
+)
+
+const (
+	// RPCRequestOverhead is the per-request overhead of RPCConn, excluding the
+	// method name: type(1) + call id(4) + method length(1) = 6 bytes.
+	RPCRequestOverhead = 1 + 4 + 1
+
+	// RPCResponseOverhead is the per-response overhead of RPCConn:
+	// type(1) + call id(4) + status(1) = 6 bytes.
+	RPCResponseOverhead = 1 + 4 + 1
+
+	// MaxRPCMethodLength is the longest method name.
+	MaxRPCMethodLength = 255
+
+	// DefaultRPCTimeout is how long a call waits for a response by default before
+	// resending the request.
+	DefaultRPCTimeout = 5 * time.Second
+
+	// DefaultRPCAttempts is the default number of times a request is sent.
+	DefaultRPCAttempts = 3
+
+	// DefaultRPCMaxOutstanding is the default number of calls in flight at once.
+	DefaultRPCMaxOutstanding = 256
+
+	// DefaultRPCMaxConcurrent is the default number of handlers running at once.
+	DefaultRPCMaxConcurrent = 64
+
+	// DefaultRPCHandlerTimeout is the default deadline of a handler's context.
+	DefaultRPCHandlerTimeout = 30 * time.Second
+
+	// DefaultRPCResponseCacheTTL is how long responses are kept by default to
+	// answer retried requests without running the handler again.
+	DefaultRPCResponseCacheTTL = time.Minute
+
+	// DefaultRPCMaxCachedResponses is the default cap on cached responses.
+	DefaultRPCMaxCachedResponses = 4096
+)
+
+var (
+	// ErrRPCTimeout is returned by Call when no response arrived after every attempt.
+	ErrRPCTimeout = errors.New("rpc: no response")
+
+	// ErrRPCUnknownMethod is returned by Call when the server has no handler
+	// registered for the method.
+	ErrRPCUnknownMethod = errors.New("rpc: unknown method")
+
+	// ErrRPCRemote wraps the error message returned by a server's handler.
+	ErrRPCRemote = errors.New("rpc: remote error")
+)
+
+// RPCHandler serves one method. req.Payload is the request body; the returned
+// bytes are sent back as the response body. A returned error is sent back as
+// its message and surfaces from Call wrapped in ErrRPCRemote. ctx is canceled
+// after HandlerTimeout or when the RPCConn is closed.
+type RPCHandler func(ctx context.Context, req *ReceiveResult) ([]byte, error)
+
+// RPCConfig configures an RPCConn. Zero fields take their defaults.
+type RPCConfig struct {
+	// Timeout is how long a call waits for a response before resending the request.
+	Timeout time.Duration
+
+	// Attempts is the number of times a request is sent before Call fails with
+	// ErrRPCTimeout. Use 1 to disable retries.
+	Attempts int
+
+	// MaxOutstanding bounds the calls in flight. Call blocks while it is reached.
+	MaxOutstanding int
+
+	// MaxConcurrent bounds the handlers running at once. Requests arriving while
+	// it is reached are dropped, and callers retry them.
+	MaxConcurrent int
+
+	// HandlerTimeout is the deadline of a handler's context.
+	HandlerTimeout time.Duration
+
+	// ResponseCacheTTL is how long a response is kept to answer retries of the
+	// same call. Choose it longer than the callers' Timeout*Attempts.
+	ResponseCacheTTL time.Duration
+
+	// MaxCachedResponses caps the cached responses. Past it, retried requests may
+	// run their handler again.
+	MaxCachedResponses int
+}
+
+func (c RPCConfig) withDefaults() RPCConfig {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultRPCTimeout
+	}
+	if c.Attempts <= 0 {
+		c.Attempts = DefaultRPCAttempts
+	}
+	if c.MaxOutstanding <= 0 {
+		c.MaxOutstanding = DefaultRPCMaxOutstanding
+	}
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = DefaultRPCMaxConcurrent
+	}
+	if c.HandlerTimeout <= 0 {
+		c.HandlerTimeout = DefaultRPCHandlerTimeout
+	}
+	if c.ResponseCacheTTL <= 0 {
+		c.ResponseCacheTTL = DefaultRPCResponseCacheTTL
+	}
+	if c.MaxCachedResponses <= 0 {
+		c.MaxCachedResponses = DefaultRPCMaxCachedResponses
+	}
+	return c
+}
+
+// RPCStats reports activity on an RPCConn.
+type RPCStats struct {
+	Calls    uint64 // calls made
+	Retries  uint64 // requests resent after Timeout
+	Timeouts uint64 // calls that failed with ErrRPCTimeout
+	Served   uint64 // requests answered by a handler
+	Replayed uint64 // retried requests answered from the response cache
+	Dropped  uint64 // requests dropped as malformed, unanswerable or over MaxConcurrent
+}
+
+type rpcCounters struct {
+	calls, retries, timeouts, served, replayed, dropped atomic.Uint64
+}
+
+// rpcCallKey identifies a call from one client.
+type rpcCallKey struct {
+	dest string
+	port uint16
+	id   uint32
+}
+
+// rpcCall is a call waiting for its response.
+type rpcCall struct {
+	dest string
+	port uint16
+	done chan []byte // receives the response frame once
+}
+
+// rpcCached is a served or in-progress request.
+type rpcCached struct {
+	frame   []byte // nil while the handler runs
+	expires time.Time
+}
+
+// RPCConn is a request/response layer over a DatagramConn.
+//
+// Call sends a request carrying a method name and a correlation id, resends it
+// after Timeout until Attempts is exhausted, and returns the body of the
+// response with the same id from the called destination. Servers dispatch
+// requests to handlers registered with Register and reply to the sender's
+// FromAddr. Responses are cached for ResponseCacheTTL, so a retried request is
+// answered again without running its handler twice.
+//
+// Replies need the sender's destination, so the underlying connection must
+// report one: use Datagram1 or Datagram2. Requests without one are dropped.
+//
+// RPCConn owns the DatagramConn, which must not be read elsewhere, and closes it
+// on Close.
+type RPCConn struct {
+	conn     *DatagramConn
+	config   RPCConfig
+	slots    chan struct{} // bounds outstanding calls
+	handlers chan struct{} // bounds running handlers
+	nextID   atomic.Uint32
+
+	mu        sync.Mutex
+	methods   map[string]RPCHandler
+	calls     map[uint32]*rpcCall
+	responses map[rpcCallKey]*rpcCached
+
+	counters rpcCounters
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewRPCConn wraps conn in an RPCConn. A nil config uses defaults.
+func NewRPCConn(conn *DatagramConn, config *RPCConfig) (*RPCConn, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("conn cannot be nil")
+	}
+	var cfg RPCConfig
+	if config != nil {
+		cfg = *config
+	}
+	cfg = cfg.withDefaults()
+
+	var seed [4]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate call id: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &RPCConn{
+		conn:      conn,
+		config:    cfg,
+		slots:     make(chan struct{}, cfg.MaxOutstanding),
+		handlers:  make(chan struct{}, cfg.MaxConcurrent),
+		methods:   make(map[string]RPCHandler),
+		calls:     make(map[uint32]*rpcCall),
+		responses: make(map[rpcCallKey]*rpcCached),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	r.nextID.Store(binary.BigEndian.Uint32(seed[:]))
+	r.wg.Add(2)
+	go pumpConn(&r.wg, conn, r.handle)
+	go tickUntil(r.ctx, &r.wg, cfg.ResponseCacheTTL/2, r.prune)
+	return r, nil
+}
+
+// Conn returns the underlying DatagramConn.
+func (r *RPCConn) Conn() *DatagramConn {
+	return r.conn
+}
+
+// Register serves method with handler. Returns an error if the method name is
+// empty or longer than MaxRPCMethodLength, or already registered.
+func (r *RPCConn) Register(method string, handler RPCHandler) error {
+	if method == "" || len(method) > MaxRPCMethodLength {
+		return fmt.Errorf("method name must be 1-%d bytes, got %d", MaxRPCMethodLength, len(method))
+	}
+	if handler == nil {
+		return fmt.Errorf("handler cannot be nil")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.methods[method]; exists {
+		return fmt.Errorf("method %q is already registered", method)
+	}
+	r.methods[method] = handler
+	return nil
+}
+
+// Unregister stops serving method. Later requests for it fail with
+// ErrRPCUnknownMethod.
+func (r *RPCConn) Unregister(method string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.methods, method)
+}
+
+// MaxRequestSize returns the largest request body Call accepts for method.
+func (r *RPCConn) MaxRequestSize(method string) int {
+	return r.conn.MaxPayloadSize() - RPCRequestOverhead - len(method)
+}
+
+// Call sends a request for method to addr and returns the response body. It
+// fails with ErrRPCTimeout if no response arrived after Attempts requests,
+// ErrRPCUnknownMethod if the server does not serve method, an error wrapping
+// ErrRPCRemote if the handler failed, ctx's error, or net.ErrClosed. Errors
+// sending the first request are returned directly. Call blocks while
+// MaxOutstanding calls are in flight.
+func (r *RPCConn) Call(ctx context.Context, addr *I2PAddr, method string, req []byte) ([]byte, error) {
+	if addr == nil || addr.Destination == "" {
+		return nil, fmt.Errorf("rpc call requires a destination address")
+	}
+	if method == "" || len(method) > MaxRPCMethodLength {
+		return nil, fmt.Errorf("method name must be 1-%d bytes, got %d", MaxRPCMethodLength, len(method))
+	}
+	if len(req) > r.MaxRequestSize(method) {
+		return nil, fmt.Errorf("request size %d exceeds maximum %d", len(req), r.MaxRequestSize(method))
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-r.ctx.Done():
+		return nil, net.ErrClosed
+	}
+	defer func() { <-r.slots }()
+
+	id := r.nextID.Add(1)
+	call := &rpcCall{dest: addr.Destination, port: addr.Port, done: make(chan []byte, 1)}
+	r.mu.Lock()
+	r.calls[id] = call
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.calls, id)
+		r.mu.Unlock()
+	}()
+
+	frame := make([]byte, RPCRequestOverhead, RPCRequestOverhead+len(method)+len(req))
+	frame[0] = rpcRequest
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	frame[5] = byte(len(method))
+	frame = append(append(frame, method...), req...)
+
+	r.counters.calls.Add(1)
+	timer := time.NewTimer(r.config.Timeout)
+	defer timer.Stop()
+	for attempt := 0; attempt < r.config.Attempts; attempt++ {
+		if attempt > 0 {
+			r.counters.retries.Add(1)
+			timer.Reset(r.config.Timeout)
+		}
+		// A failed resend is treated as a lost request
+		if err := r.conn.SendTo(frame, addr.Destination, addr.Port); err != nil && attempt == 0 {
+			return nil, err
+		}
+		select {
+		case response := <-call.done:
+			return parseRPCResponse(method, response)
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.ctx.Done():
+			return nil, net.ErrClosed
+		}
+	}
+	r.counters.timeouts.Add(1)
+	return nil, ErrRPCTimeout
+}
+
+// parseRPCResponse returns the body of a successful response or the error it carries.
+func parseRPCResponse(method string, frame []byte) ([]byte, error) {
+	body := frame[RPCResponseOverhead:]
+	switch frame[5] {
+	case rpcStatusOK:
+		return body, nil
+	case rpcStatusUnknownMethod:
+		return nil, fmt.Errorf("%w: %q", ErrRPCUnknownMethod, method)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrRPCRemote, body)
+	}
+}
+
+// handle dispatches a received datagram by frame type.
+func (r *RPCConn) handle(result *ReceiveResult) {
+	if len(result.Payload) < RPCRequestOverhead || result.FromAddr.Destination == "" {
+		r.counters.dropped.Add(1)
+		return
+	}
+	switch result.Payload[0] {
+	case rpcRequest:
+		r.handleRequest(result)
+	case rpcResponse:
+		r.handleResponse(result)
+	default:
+		r.counters.dropped.Add(1)
+	}
+}
+
+// handleResponse completes the call a response answers, if it came from the
+// called destination and port.
+func (r *RPCConn) handleResponse(result *ReceiveResult) {
+	id := binary.BigEndian.Uint32(result.Payload[1:5])
+	r.mu.Lock()
+	call, ok := r.calls[id]
+	if ok && (call.dest != result.FromAddr.Destination || call.port != result.SrcPort) {
+		ok = false
+	}
+	if ok {
+		delete(r.calls, id)
+	}
+	r.mu.Unlock()
+	if ok {
+		call.done <- result.Payload
+	}
+}
+
+// handleRequest answers a request from the response cache, or runs its handler.
+func (r *RPCConn) handleRequest(result *ReceiveResult) {
+	frame := result.Payload
+	id := binary.BigEndian.Uint32(frame[1:5])
+	methodEnd := RPCRequestOverhead + int(frame[5])
+	if frame[5] == 0 || len(frame) < methodEnd {
+		r.counters.dropped.Add(1)
+		return
+	}
+	method := string(frame[RPCRequestOverhead:methodEnd])
+	key := rpcCallKey{dest: result.FromAddr.Destination, port: result.SrcPort, id: id}
+
+	r.mu.Lock()
+	if cached, ok := r.responses[key]; ok {
+		response := cached.frame
+		r.mu.Unlock()
+		if response != nil {
+			r.counters.replayed.Add(1)
+			r.conn.SendTo(response, key.dest, key.port)
+		}
+		return
+	}
+	handler, ok := r.methods[method]
+	if !ok {
+		r.mu.Unlock()
+		r.conn.SendTo(rpcResponseFrame(id, rpcStatusUnknownMethod, nil), key.dest, key.port)
+		return
+	}
+	select {
+	case r.handlers <- struct{}{}:
+	default:
+		r.mu.Unlock()
+		r.counters.dropped.Add(1)
+		return
+	}
+	cached := &rpcCached{}
+	r.responses[key] = cached
+	r.mu.Unlock()
+
+	req := *result
+	req.Payload = frame[methodEnd:]
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() { <-r.handlers }()
+		ctx, cancel := context.WithTimeout(r.ctx, r.config.HandlerTimeout)
+		body, err := handler(ctx, &req)
+		cancel()
+
+		response := rpcResponseFrame(id, rpcStatusOK, body)
+		if err != nil {
+			response = rpcResponseFrame(id, rpcStatusError, []byte(err.Error()))
+		}
+		if max := r.conn.MaxPayloadSize(); len(response) > max {
+			response = rpcResponseFrame(id, rpcStatusError, []byte(fmt.Sprintf("response size %d exceeds maximum %d", len(body), max-RPCResponseOverhead)))
+		}
+
+		r.mu.Lock()
+		if len(r.responses) > r.config.MaxCachedResponses {
+			delete(r.responses, key)
+		} else {
+			cached.frame = response
+			cached.expires = time.Now().Add(r.config.ResponseCacheTTL)
+		}
+		r.mu.Unlock()
+
+		if r.conn.SendTo(response, key.dest, key.port) == nil {
+			r.counters.served.Add(1)
+		}
+	}()
+}
+
+// prune drops expired cached responses.
+func (r *RPCConn) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, cached := range r.responses {
+		if cached.frame != nil && now.After(cached.expires) {
+			delete(r.responses, key)
+		}
+	}
+}
+
+// Stats returns the connection's counters.
+func (r *RPCConn) Stats() RPCStats {
+	return RPCStats{
+		Calls:    r.counters.calls.Load(),
+		Retries:  r.counters.retries.Load(),
+		Timeouts: r.counters.timeouts.Load(),
+		Served:   r.counters.served.Load(),
+		Replayed: r.counters.replayed.Load(),
+		Dropped:  r.counters.dropped.Load(),
+	}
+}
+
+// Close fails waiting calls with net.ErrClosed, cancels running handlers, waits
+// for them and closes the underlying DatagramConn.
+func (r *RPCConn) Close() error {
+	r.cancel()
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
+
+// rpcResponseFrame builds a response frame.
+func rpcResponseFrame(id uint32, status byte, body []byte) []byte {
+	frame := make([]byte, RPCResponseOverhead, RPCResponseOverhead+len(body))
+	frame[0] = rpcResponse
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	frame[5] = status
+	return append(frame, body...)
+}
@@ -0,0 +1,154 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rpcPair returns a client and a server RPCConn over Datagram2 routed to each
+// other, with their sessions.
+func rpcPair(t *testing.T, config *RPCConfig) (client, server *RPCConn, sc, ss *mockSession) {
+	t.Helper()
+	cc, cs, sc, ss := connPair(t, ProtocolDatagram2, ProtocolDatagram2)
+	var err error
+	if client, err = NewRPCConn(cc, config); err != nil {
+		t.Fatalf("NewRPCConn() failed: %v", err)
+	}
+	if server, err = NewRPCConn(cs, config); err != nil {
+		t.Fatalf("NewRPCConn() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server, sc, ss
+}
+
+// TestRPCConn_Call tests successful, failing and unknown methods.
+func TestRPCConn_Call(t *testing.T) {
+	client, server, sc, ss := rpcPair(t, nil)
+	server.Register("echo", func(_ context.Context, req *ReceiveResult) ([]byte, error) {
+		if !req.Authenticated || req.FromAddr.Destination != sc.Destination().Base64() {
+			return nil, errors.New("unexpected sender")
+		}
+		return req.Payload, nil
+	})
+	server.Register("fail", func(context.Context, *ReceiveResult) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addr := addrOf(ss, pairPortB)
+
+	if resp, err := client.Call(ctx, addr, "echo", []byte("hello")); err != nil || string(resp) != "hello" {
+		t.Errorf("Call(echo) = %q, %v", resp, err)
+	}
+	if _, err := client.Call(ctx, addr, "fail", nil); !errors.Is(err, ErrRPCRemote) || err.Error() != "rpc: remote error: boom" {
+		t.Errorf("Call(fail) error = %v, want ErrRPCRemote with the handler's message", err)
+	}
+	if _, err := client.Call(ctx, addr, "missing", nil); !errors.Is(err, ErrRPCUnknownMethod) {
+		t.Errorf("Call(missing) error = %v, want ErrRPCUnknownMethod", err)
+	}
+	if stats := server.Stats(); stats.Served != 2 {
+		t.Errorf("server stats = %+v", stats)
+	}
+}
+
+// TestRPCConn_Retry tests that lost requests and responses are retried, and that
+// a retried request is answered from the cache without running the handler again.
+func TestRPCConn_Retry(t *testing.T) {
+	client, server, sc, ss := rpcPair(t, &RPCConfig{Timeout: 30 * time.Millisecond})
+	dropSends(sc, func(n int, _ []byte) bool { return n == 1 }) // first request
+	dropSends(ss, func(n int, _ []byte) bool { return n == 1 }) // first response
+	var runs atomic.Int32
+	server.Register("count", func(context.Context, *ReceiveResult) ([]byte, error) {
+		runs.Add(1)
+		return []byte("ok"), nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if resp, err := client.Call(ctx, addrOf(ss, pairPortB), "count", nil); err != nil || string(resp) != "ok" {
+		t.Fatalf("Call() = %q, %v", resp, err)
+	}
+	if runs.Load() != 1 {
+		t.Errorf("handler ran %d times, want 1", runs.Load())
+	}
+	if stats := client.Stats(); stats.Retries != 2 {
+		t.Errorf("client stats = %+v", stats)
+	}
+	if stats := server.Stats(); stats.Replayed != 1 {
+		t.Errorf("server stats = %+v", stats)
+	}
+}
+
+// TestRPCConn_Timeout tests failure after every attempt is lost.
+func TestRPCConn_Timeout(t *testing.T) {
+	client, _, sc, ss := rpcPair(t, &RPCConfig{Timeout: 10 * time.Millisecond, Attempts: 2})
+	dropSends(sc, func(int, []byte) bool { return true })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Call(ctx, addrOf(ss, pairPortB), "echo", nil); !errors.Is(err, ErrRPCTimeout) {
+		t.Errorf("Call() error = %v, want ErrRPCTimeout", err)
+	}
+	if stats := client.Stats(); stats.Calls != 1 || stats.Retries != 1 || stats.Timeouts != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestRPCConn_Limits tests MaxOutstanding and failing pending calls on Close.
+func TestRPCConn_Limits(t *testing.T) {
+	client, server, _, ss := rpcPair(t, &RPCConfig{MaxOutstanding: 1, MaxConcurrent: 1, Timeout: time.Hour})
+	started := make(chan struct{}, 1)
+	server.Register("block", func(ctx context.Context, _ *ReceiveResult) ([]byte, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	addr := addrOf(ss, pairPortB)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), addr, "block", nil)
+		errs <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.Call(ctx, addr, "block", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call() over MaxOutstanding error = %v, want context.DeadlineExceeded", err)
+	}
+
+	client.Close()
+	if err := <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("pending Call() error = %v, want net.ErrClosed", err)
+	}
+}
+
+// TestRPCConn_Register tests method name validation.
+func TestRPCConn_Register(t *testing.T) {
+	_, server, _, _ := rpcPair(t, nil)
+	handler := func(context.Context, *ReceiveResult) ([]byte, error) { return nil, nil }
+	if err := server.Register("", handler); err == nil {
+		t.Error("expected error for empty method name")
+	}
+	if err := server.Register(string(make([]byte, MaxRPCMethodLength+1)), handler); err == nil {
+		t.Error("expected error for long method name")
+	}
+	if err := server.Register("m", handler); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if err := server.Register("m", handler); err == nil {
+		t.Error("expected error for duplicate method")
+	}
+	server.Unregister("m")
+	if err := server.Register("m", handler); err != nil {
+		t.Errorf("Register() after Unregister failed: %v", err)
+	}
+}
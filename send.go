@@ -0,0 +1,247 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	i2cp "github.com/go-i2p/go-i2cp"
+)
+
+// ErrUnsupportedSendOption is returned by Send when the session cannot honour a
+// SendOptions field.
+var ErrUnsupportedSendOption = errors.New("send option not supported by session")
+
+// Priority orders sends competing for a Pacer. Waiting sends of higher priority
+// are admitted before those of lower priority; I2CP itself has no priorities.
+type Priority int8
+
+const (
+	// PriorityLow sends yield to every other waiting send.
+	PriorityLow Priority = -1
+
+	// PriorityNormal is the priority of SendTo and of sends without SendOptions.
+	PriorityNormal Priority = 0
+
+	// PriorityHigh sends are admitted ahead of all other waiting sends.
+	PriorityHigh Priority = 1
+)
+
+// I2CPExpiringSession is implemented by sessions that can send with an
+// expiration (I2CP SendMessageExpires), such as *i2cp.Session. Send uses it for
+// SendOptions.Expiration.
+type I2CPExpiringSession interface {
+	SendMessageExpiresWithContext(ctx context.Context, destination *i2cp.Destination, protocol uint8, srcPort, destPort uint16, payload *i2cp.Stream, flags uint16, expirationSeconds uint64) error
+}
+
+// Verify that *i2cp.Session implements I2CPExpiringSession at compile time.
+var _ I2CPExpiringSession = (*i2cp.Session)(nil)
+
+// SendOptions are per-message settings for Send. The zero value sends like
+// SendTo.
+//
+// There is no per-message compression setting: go-i2cp gzips every I2CP payload
+// at the default level, and the gzip header is where I2CP carries the ports and
+// protocol, so compression can be neither skipped nor tuned per message.
+type SendOptions struct {
+	// Protocol overrides the connection's protocol for this send. Zero uses the
+	// connection's protocol; in auto mode, the one for its default Requirements.
+	Protocol uint8
+
+	// Options are the I2P Mapping options carried by Datagram2, Datagram3 and
+	// custom codecs that support them. Ignored by other protocols.
+	Options *Options
+
+	// Nonce is the I2CP message nonce, which identifies the message in delivery
+	// status reports. Zero requests no status report. It cannot be combined with
+	// Expiration, since I2CP SendMessageExpires chooses its own nonce.
+	Nonce uint32
+
+	// Expiration is how long the router may try to deliver the datagram before
+	// dropping it, rounded up to whole seconds. Zero uses the router's default.
+	// Requires an I2CPExpiringSession.
+	Expiration time.Duration
+
+	// Priority orders this send against others waiting for the connection's Pacer.
+	Priority Priority
+}
+
+// Send sends payload to addr with per-message options. A nil opts sends like
+// SendTo. SendTo, SendToWithOptions, SendToWithRequirements and WriteTo are
+// wrappers around Send.
+//
+// ctx bounds the wait for the Pacer and the hand-off to the session, together
+// with the write deadline. addr must carry a full destination; its Port is the
+// destination port.
+//
+// Example:
+//
+//	err := conn.Send(ctx, payload, addr, &datagrams.SendOptions{
+//	    Protocol:   datagrams.ProtocolDatagram2,
+//	    Expiration: 30 * time.Second,
+//	    Priority:   datagrams.PriorityHigh,
+//	})
+//
+// Returns an error if:
+//   - The connection is closed
+//   - addr has no full destination, or the destination is invalid
+//   - The protocol override is the streaming protocol
+//   - The payload exceeds the maximum size for the protocol and options
+//   - Nonce is combined with Expiration, or the session cannot send with an
+//     expiration (ErrUnsupportedSendOption)
+//   - ctx ends or the write deadline expires, including while waiting for the Pacer
+//   - The Pacer rejects the send (ErrRateLimited)
+//   - The underlying I2CP session fails to send
+func (d *DatagramConn) Send(ctx context.Context, payload []byte, addr *I2PAddr, opts *SendOptions) error {
+	var o SendOptions
+	if opts != nil {
+		o = *opts
+	}
+	protocol := o.Protocol
+	if protocol == 0 {
+		protocol = d.Protocol()
+	}
+
+	d.mu.RLock()
+	closed := d.closed
+	deadline := d.writeDeadline
+	session := d.session
+	localPort := d.localPort
+	pacer := d.pacer
+	d.mu.RUnlock()
+
+	if closed {
+		return net.ErrClosed
+	}
+	if addr == nil || addr.Destination == "" {
+		return fmt.Errorf("invalid destination: address has no full destination")
+	}
+	if protocol == ProtocolStreaming {
+		return fmt.Errorf("protocol %d (streaming) is reserved and cannot be used for datagrams", protocol)
+	}
+
+	var expires I2CPExpiringSession
+	if o.Expiration > 0 {
+		if o.Nonce != 0 {
+			// I2CP SendMessageExpires chooses its own nonce
+			return fmt.Errorf("nonce with expiration: %w", ErrUnsupportedSendOption)
+		}
+		var ok bool
+		if expires, ok = session.(I2CPExpiringSession); !ok {
+			return fmt.Errorf("expiration: %w", ErrUnsupportedSendOption)
+		}
+	}
+
+	// Validate payload size, accounting for options
+	maxSize := d.maxPayloadSize(protocol)
+	if o.Options != nil && !o.Options.IsEmpty() {
+		optionsOverhead := o.Options.Len()
+		effectiveMax := maxSize - optionsOverhead
+		if effectiveMax < 0 {
+			return fmt.Errorf("options too large: %d bytes, maximum payload %d", optionsOverhead, maxSize)
+		}
+		if len(payload) > effectiveMax {
+			return fmt.Errorf("payload size %d exceeds maximum %d for protocol %d with options (%d bytes)", len(payload), effectiveMax, protocol, optionsOverhead)
+		}
+	} else if len(payload) > maxSize {
+		return fmt.Errorf("payload size %d exceeds maximum %d for protocol %d", len(payload), maxSize, protocol)
+	}
+
+	// Check write deadline
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		return fmt.Errorf("write deadline exceeded")
+	}
+
+	// Parse destination from base64 string
+	// Note: go-i2cp NewDestinationFromBase64 requires a Crypto object
+	crypto := i2cp.NewCrypto()
+	dest, err := i2cp.NewDestinationFromBase64(addr.Destination, crypto)
+	if err != nil {
+		return fmt.Errorf("invalid destination: %w", err)
+	}
+
+	envelope, err := d.buildEnvelope(protocol, payload, dest, addr.Port, o.Options)
+	if err != nil {
+		return err
+	}
+
+	if err := d.pace(ctx, pacer, deadline, addr.Destination, len(envelope), o.Priority); err != nil {
+		return err
+	}
+
+	// Send via I2CP
+	stream := i2cp.NewStream(envelope)
+	sendCtx := ctx
+	if !deadline.IsZero() {
+		if time.Until(deadline) <= 0 {
+			return fmt.Errorf("write deadline exceeded")
+		}
+		var cancel context.CancelFunc
+		sendCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	expirationSeconds := uint64((o.Expiration + time.Second - 1) / time.Second)
+
+	switch {
+	case expires != nil:
+		err = expires.SendMessageExpiresWithContext(sendCtx, dest, protocol, localPort, addr.Port, stream, 0, expirationSeconds)
+	case sendCtx.Done() != nil:
+		err = session.SendMessageWithContext(sendCtx, dest, protocol, localPort, addr.Port, stream, o.Nonce)
+	default:
+		err = session.SendMessage(dest, protocol, localPort, addr.Port, stream, o.Nonce)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send datagram: %w", err)
+	}
+	return nil
+}
+
+// buildEnvelope frames payload as protocol, with options where the protocol
+// carries them.
+func (d *DatagramConn) buildEnvelope(protocol uint8, payload []byte, dest *i2cp.Destination, port uint16, options *Options) ([]byte, error) {
+	switch protocol {
+	case ProtocolRaw:
+		// Raw datagrams have no envelope and no options, send payload directly
+		return payload, nil
+
+	case ProtocolDatagram3:
+		// Datagram3: fromhash(32) + flags(2) + [options] + payload
+		if err := d.OptionsLimits().check(options); err != nil {
+			return nil, err
+		}
+		envelope, err := encodeDatagram3(payload, d.localWire.hash(), options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build Datagram3 envelope: %w", err)
+		}
+		return envelope, nil
+
+	case ProtocolDatagram1:
+		// Datagram1: from dest(387+) + signature(40+) + payload; no options
+		envelope, err := d.buildDatagram1(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build Datagram1 envelope: %w", err)
+		}
+		return envelope, nil
+
+	case ProtocolDatagram2:
+		// Datagram2: from dest(387+) + flags(2) + [options] + [offline_sig] + payload + signature(40+)
+		// The target destination hash binds the signature for replay prevention
+		if err := d.OptionsLimits().check(options); err != nil {
+			return nil, err
+		}
+		targetDestHash, err := destinationHash(dest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute target destination hash: %w", err)
+		}
+		envelope, err := d.buildDatagram2(payload, targetDestHash, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build Datagram2 envelope: %w", err)
+		}
+		return envelope, nil
+
+	default:
+		return d.encodeCustom(protocol, payload, dest, port, options)
+	}
+}
@@ -0,0 +1,107 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	i2cp "github.com/go-i2p/go-i2cp"
+)
+
+// expiringSession is a mockSession that supports expiring sends.
+type expiringSession struct {
+	*mockSession
+	lastExpiration uint64
+}
+
+func (e *expiringSession) SendMessageExpiresWithContext(ctx context.Context, destination *i2cp.Destination, protocol uint8, srcPort, destPort uint16, payload *i2cp.Stream, flags uint16, expirationSeconds uint64) error {
+	e.lastExpiration = expirationSeconds
+	return e.SendMessageWithContext(ctx, destination, protocol, srcPort, destPort, payload, 0)
+}
+
+// TestDatagramConn_Send tests the per-message protocol override and nonce, and
+// the options a plain session cannot honour.
+func TestDatagramConn_Send(t *testing.T) {
+	session := newMockSession()
+	conn, err := NewDatagramConn(session, 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	defer conn.Close()
+	addr := &I2PAddr{Destination: validDestinationB64(), Port: 9000}
+	ctx := context.Background()
+
+	if err := conn.Send(ctx, []byte("hello"), addr, &SendOptions{Protocol: ProtocolDatagram2, Nonce: 42}); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if session.lastProtocol != ProtocolDatagram2 || session.lastNonce != 42 || session.lastDestPort != 9000 {
+		t.Errorf("sent protocol %d nonce %d port %d, want %d 42 9000", session.lastProtocol, session.lastNonce, session.lastDestPort, ProtocolDatagram2)
+	}
+	if len(session.lastPayload) < MinDatagram2Overhead {
+		t.Errorf("Datagram2 send carried a %d-byte envelope", len(session.lastPayload))
+	}
+	if err := conn.Send(ctx, []byte("hello"), addr, nil); err != nil || session.lastProtocol != ProtocolRaw {
+		t.Errorf("Send(nil options) = %v as protocol %d, want Raw", err, session.lastProtocol)
+	}
+
+	for name, opts := range map[string]*SendOptions{
+		"expiration": {Expiration: time.Minute},
+	} {
+		if err := conn.Send(ctx, []byte("x"), addr, opts); !errors.Is(err, ErrUnsupportedSendOption) {
+			t.Errorf("Send(%s) on a plain session = %v, want ErrUnsupportedSendOption", name, err)
+		}
+	}
+	if err := conn.Send(ctx, []byte("x"), addr, &SendOptions{Protocol: ProtocolStreaming}); err == nil {
+		t.Error("Send() accepted the streaming protocol")
+	}
+	if err := conn.Send(ctx, []byte("x"), &I2PAddr{DestinationHash: [32]byte{1}}, nil); err == nil {
+		t.Error("Send() accepted an address without a destination")
+	}
+	if err := conn.Send(ctx, make([]byte, conn.maxPayloadSize(ProtocolDatagram2)+1), addr, &SendOptions{Protocol: ProtocolDatagram2}); err == nil {
+		t.Error("Send() accepted a payload over the Datagram2 limit")
+	}
+}
+
+// TestDatagramConn_SendExpiration tests that expirations reach sessions that
+// support them, and that they cannot be combined with a nonce.
+func TestDatagramConn_SendExpiration(t *testing.T) {
+	session := &expiringSession{mockSession: newMockSession()}
+	conn, err := NewDatagramConn(session, 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	defer conn.Close()
+	addr := &I2PAddr{Destination: validDestinationB64(), Port: 9000}
+	ctx := context.Background()
+
+	if err := conn.Send(ctx, []byte("x"), addr, &SendOptions{Expiration: 1500 * time.Millisecond}); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if session.lastExpiration != 2 {
+		t.Errorf("expiring send: expiration %d, want 2", session.lastExpiration)
+	}
+	if err := conn.Send(ctx, []byte("x"), addr, &SendOptions{Expiration: time.Second, Nonce: 7}); !errors.Is(err, ErrUnsupportedSendOption) {
+		t.Errorf("Send() with nonce and expiration = %v, want ErrUnsupportedSendOption", err)
+	}
+}
+
+// TestDatagramConn_SendContext tests that the context ends a wait for the Pacer.
+func TestDatagramConn_SendContext(t *testing.T) {
+	conn, err := NewDatagramConn(newMockSession(), 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetPacer(NewPacer(PacerConfig{Conn: RateLimit{PacketsPerSecond: 0.1, BurstPackets: 1}}))
+	addr := &I2PAddr{Destination: validDestinationB64(), Port: 9000}
+
+	if err := conn.Send(context.Background(), []byte("x"), addr, nil); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := conn.Send(ctx, []byte("x"), addr, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send() past its context = %v, want context.DeadlineExceeded", err)
+	}
+}
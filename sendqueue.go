@@ -0,0 +1,345 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultSendQueueSize bounds the sends a send queue holds.
+	DefaultSendQueueSize = 256
+
+	// DefaultSendWorkers is the number of goroutines draining a send queue.
+	DefaultSendWorkers = 4
+)
+
+// ErrSendQueueFull is returned by SendAsync when the queue is full and the
+// queue rejects rather than waits.
+var ErrSendQueueFull = errors.New("send queue full")
+
+// SendQueueConfig configures the queue behind SendAsync.
+type SendQueueConfig struct {
+	// Size bounds the sends waiting in the queue. Default: DefaultSendQueueSize.
+	Size int
+
+	// Workers is the number of goroutines taking sends from the queue, which is
+	// also how many sends are built, signed and handed to the session at once.
+	// Default: DefaultSendWorkers.
+	Workers int
+
+	// Reject fails SendAsync with ErrSendQueueFull when the queue is full. By
+	// default SendAsync waits for room, pushing back on the caller.
+	Reject bool
+}
+
+func (c SendQueueConfig) withDefaults() SendQueueConfig {
+	if c.Size <= 0 {
+		c.Size = DefaultSendQueueSize
+	}
+	if c.Workers <= 0 {
+		c.Workers = DefaultSendWorkers
+	}
+	return c
+}
+
+// SendQueueStats holds the counters of a connection's send queue.
+type SendQueueStats struct {
+	// Pending is the number of sends waiting in the queue.
+	Pending int
+
+	// Sent and Failed count completed sends by outcome.
+	Sent   uint64
+	Failed uint64
+}
+
+// asyncSend is one queued SendAsync call.
+type asyncSend struct {
+	ctx     context.Context
+	payload []byte
+	addr    *I2PAddr
+	opts    *SendOptions
+	done    func(error)
+}
+
+// sendQueue feeds queued sends to a pool of workers calling Send.
+type sendQueue struct {
+	conn   *DatagramConn
+	config SendQueueConfig
+	items  chan *asyncSend
+	wg     sync.WaitGroup
+
+	// abort fails the sends still queued once a Shutdown's context ends.
+	abort       context.Context
+	abortCancel context.CancelFunc
+
+	// mu guards closed. stop is closed with it to release SendAsync calls
+	// waiting for room, which enqueuing counts; items is closed after they leave.
+	mu         sync.Mutex
+	closed     bool
+	stop       chan struct{}
+	enqueuing  sync.WaitGroup
+	closeItems sync.Once
+
+	// callbacks holds done calls for the callback goroutine, which runs them
+	// outside the workers so a callback may close the connection. callbackMu
+	// guards callbacks and drained; callbacksReady signals the goroutine.
+	callbackMu     sync.Mutex
+	callbacks      []func()
+	drained        bool
+	callbacksReady chan struct{}
+
+	sent   atomic.Uint64
+	failed atomic.Uint64
+}
+
+func newSendQueue(conn *DatagramConn, config SendQueueConfig) *sendQueue {
+	config = config.withDefaults()
+	q := &sendQueue{
+		conn:   conn,
+		config: config,
+		items:  make(chan *asyncSend, config.Size),
+		stop:   make(chan struct{}),
+
+		callbacksReady: make(chan struct{}, 1),
+	}
+	q.abort, q.abortCancel = context.WithCancel(context.Background())
+	for i := 0; i < config.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	go q.runCallbacks()
+	return q
+}
+
+// work sends queued datagrams until the queue is closed and drained.
+func (q *sendQueue) work() {
+	defer q.wg.Done()
+	for item := range q.items {
+		err := q.send(item)
+		if err != nil {
+			q.failed.Add(1)
+		} else {
+			q.sent.Add(1)
+		}
+		if item.done != nil {
+			done := item.done
+			q.callback(func() { done(err) })
+		}
+	}
+}
+
+// callback queues fn for the callback goroutine.
+func (q *sendQueue) callback(fn func()) {
+	q.callbackMu.Lock()
+	q.callbacks = append(q.callbacks, fn)
+	q.callbackMu.Unlock()
+	signal(q.callbacksReady)
+}
+
+// runCallbacks runs queued callbacks in order until the workers have exited
+// and every callback has run.
+func (q *sendQueue) runCallbacks() {
+	for {
+		q.callbackMu.Lock()
+		batch, drained := q.callbacks, q.drained
+		q.callbacks = nil
+		q.callbackMu.Unlock()
+
+		for _, fn := range batch {
+			fn()
+		}
+		if len(batch) == 0 {
+			if drained {
+				return
+			}
+			<-q.callbacksReady
+		}
+	}
+}
+
+// send sends item, unless the queue was aborted.
+func (q *sendQueue) send(item *asyncSend) error {
+	if q.abort.Err() != nil {
+		return net.ErrClosed
+	}
+	ctx, cancel := context.WithCancel(item.ctx)
+	defer cancel()
+	stop := context.AfterFunc(q.abort, cancel)
+	defer stop()
+	err := q.conn.Send(ctx, item.payload, item.addr, item.opts)
+	if err != nil && q.abort.Err() != nil && item.ctx.Err() == nil {
+		return net.ErrClosed
+	}
+	return err
+}
+
+// enqueue adds item, waiting for room unless the queue rejects.
+func (q *sendQueue) enqueue(item *asyncSend) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return net.ErrClosed
+	}
+	q.enqueuing.Add(1)
+	q.mu.Unlock()
+	defer q.enqueuing.Done()
+
+	select {
+	case q.items <- item:
+		return nil
+	default:
+	}
+	if q.config.Reject {
+		return ErrSendQueueFull
+	}
+	select {
+	case q.items <- item:
+		return nil
+	case <-q.stop:
+		return net.ErrClosed
+	case <-item.ctx.Done():
+		return item.ctx.Err()
+	}
+}
+
+// flush stops accepting sends and waits until the queued ones complete. If ctx
+// ends first, the remaining sends fail with net.ErrClosed. It does not wait for
+// their callbacks, which may themselves be closing the connection.
+func (q *sendQueue) flush(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+	}
+	q.mu.Unlock()
+	q.enqueuing.Wait()
+	q.closeItems.Do(func() { close(q.items) })
+
+	drained := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		q.callbackMu.Lock()
+		q.drained = true
+		q.callbackMu.Unlock()
+		signal(q.callbacksReady)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		q.abortCancel()
+		<-drained
+		return ctx.Err()
+	}
+}
+
+// SetSendQueue configures the queue behind SendAsync. It must be called before
+// the first SendAsync, which otherwise creates a queue with the default
+// configuration.
+//
+// Returns an error if the connection is closed or its queue already exists.
+func (d *DatagramConn) SetSendQueue(config SendQueueConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return net.ErrClosed
+	}
+	if d.sendQueue != nil {
+		return fmt.Errorf("send queue already started")
+	}
+	d.sendQueue = newSendQueue(d, config)
+	return nil
+}
+
+// SendAsync queues a send and returns without waiting for it; a worker sends it
+// with [DatagramConn.Send] later and passes the result to done, which may be nil.
+// Callbacks run one at a time, in completion order, on a goroutine separate from
+// the workers; a slow callback delays the ones after it but not the sends. done
+// may close the connection. payload must not be modified until done is called.
+//
+// When the queue is full, SendAsync waits for room until ctx ends, or fails
+// with ErrSendQueueFull if the queue rejects (see SetSendQueue). ctx also bounds
+// the queued send itself. Close and Shutdown send every queued datagram before
+// closing the connection, but may return before the last callbacks have run.
+//
+// done is not called when SendAsync returns an error.
+//
+// Example:
+//
+//	for _, peer := range subscribers {
+//	    err := conn.SendAsync(ctx, update, peer, nil, func(err error) {
+//	        if err != nil {
+//	            log.Printf("send to %s: %v", peer, err)
+//	        }
+//	    })
+//	    if err != nil {
+//	        return err
+//	    }
+//	}
+//
+// Returns an error if:
+//   - The connection is closed or closing (net.ErrClosed)
+//   - The queue is full and rejects (ErrSendQueueFull)
+//   - ctx ends while waiting for room
+func (d *DatagramConn) SendAsync(ctx context.Context, payload []byte, addr *I2PAddr, opts *SendOptions, done func(error)) error {
+	q, err := d.sendQueueOrDefault()
+	if err != nil {
+		return err
+	}
+	return q.enqueue(&asyncSend{ctx: ctx, payload: payload, addr: addr, opts: opts, done: done})
+}
+
+// sendQueueOrDefault returns the connection's send queue, creating one with the
+// default configuration if needed.
+func (d *DatagramConn) sendQueueOrDefault() (*sendQueue, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, net.ErrClosed
+	}
+	if d.sendQueue == nil {
+		d.sendQueue = newSendQueue(d, SendQueueConfig{})
+	}
+	return d.sendQueue, nil
+}
+
+// SendQueueStats returns the counters of the send queue, which are zero before
+// the first SendAsync.
+func (d *DatagramConn) SendQueueStats() SendQueueStats {
+	d.mu.RLock()
+	q := d.sendQueue
+	d.mu.RUnlock()
+	if q == nil {
+		return SendQueueStats{}
+	}
+	return SendQueueStats{
+		Pending: len(q.items),
+		Sent:    q.sent.Load(),
+		Failed:  q.failed.Load(),
+	}
+}
+
+// Shutdown closes the connection like Close, but stops waiting for queued
+// SendAsync sends when ctx ends; those not yet sent then fail with
+// net.ErrClosed, and Shutdown returns ctx's error.
+func (d *DatagramConn) Shutdown(ctx context.Context) error {
+	err := d.flushSendQueue(ctx)
+	d.Close()
+	return err
+}
+
+// flushSendQueue flushes the send queue, if any, until ctx ends.
+func (d *DatagramConn) flushSendQueue(ctx context.Context) error {
+	d.mu.RLock()
+	q := d.sendQueue
+	d.mu.RUnlock()
+	if q == nil {
+		return nil
+	}
+	return q.flush(ctx)
+}
@@ -0,0 +1,174 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDatagramConn_SendAsync tests that queued sends complete through their
+// callbacks and that Close sends every queued datagram first.
+func TestDatagramConn_SendAsync(t *testing.T) {
+	session := newMockSession()
+	var delivered atomic.Int32
+	session.deliver = func(protocol uint8, srcPort, destPort uint16, payload []byte) {
+		time.Sleep(time.Millisecond)
+		delivered.Add(1)
+	}
+	conn, err := NewDatagramConn(session, 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	if err := conn.SetSendQueue(SendQueueConfig{Size: 8, Workers: 2}); err != nil {
+		t.Fatalf("SetSendQueue() failed: %v", err)
+	}
+	if err := conn.SetSendQueue(SendQueueConfig{}); err == nil {
+		t.Error("SetSendQueue() replaced a started queue")
+	}
+	addr := &I2PAddr{Destination: validDestinationB64(), Port: 9000}
+
+	const sends = 50
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for i := 0; i < sends; i++ {
+		wg.Add(1)
+		err := conn.SendAsync(context.Background(), []byte("x"), addr, nil, func(err error) {
+			if err != nil {
+				failed.Add(1)
+			}
+			wg.Done()
+		})
+		if err != nil {
+			t.Fatalf("SendAsync() failed: %v", err)
+		}
+	}
+	conn.Close()
+	if n := delivered.Load(); n != sends {
+		t.Errorf("%d of %d queued datagrams sent before Close returned", n, sends)
+	}
+	wg.Wait()
+	if stats := conn.SendQueueStats(); failed.Load() != 0 || stats.Sent != sends || stats.Failed != 0 || stats.Pending != 0 {
+		t.Errorf("%d callbacks failed, stats = %+v", failed.Load(), stats)
+	}
+	if err := conn.SendAsync(context.Background(), []byte("x"), addr, nil, nil); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("SendAsync() after Close = %v, want net.ErrClosed", err)
+	}
+}
+
+// blockedQueueConn returns a conn with a one-slot queue and one worker whose
+// first send blocks until release is closed, and the queue filled behind it.
+func blockedQueueConn(t *testing.T, reject bool) (conn *DatagramConn, addr *I2PAddr, release chan struct{}, queued chan error) {
+	t.Helper()
+	session := newMockSession()
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	session.deliver = func(protocol uint8, srcPort, destPort uint16, payload []byte) {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+	}
+	conn, err := NewDatagramConn(session, 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	if err := conn.SetSendQueue(SendQueueConfig{Size: 1, Workers: 1, Reject: reject}); err != nil {
+		t.Fatalf("SetSendQueue() failed: %v", err)
+	}
+	addr = &I2PAddr{Destination: validDestinationB64(), Port: 9000}
+	queued = make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		if err := conn.SendAsync(context.Background(), []byte("x"), addr, nil, func(err error) { queued <- err }); err != nil {
+			t.Fatalf("SendAsync() failed: %v", err)
+		}
+		if i == 0 {
+			<-entered
+		}
+	}
+	return conn, addr, release, queued
+}
+
+// TestDatagramConn_SendAsyncBackpressure tests full queues in both modes.
+func TestDatagramConn_SendAsyncBackpressure(t *testing.T) {
+	conn, addr, release, queued := blockedQueueConn(t, true)
+	if err := conn.SendAsync(context.Background(), []byte("x"), addr, nil, nil); !errors.Is(err, ErrSendQueueFull) {
+		t.Errorf("SendAsync() on a full rejecting queue = %v, want ErrSendQueueFull", err)
+	}
+	close(release)
+	conn.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-queued; err != nil {
+			t.Errorf("queued send failed: %v", err)
+		}
+	}
+
+	conn, addr, release, _ = blockedQueueConn(t, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := conn.SendAsync(ctx, []byte("x"), addr, nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendAsync() on a full queue = %v, want context.DeadlineExceeded", err)
+	}
+	close(release)
+	conn.Close()
+}
+
+// TestDatagramConn_Shutdown tests that Shutdown fails the sends still queued
+// when its context ends.
+func TestDatagramConn_Shutdown(t *testing.T) {
+	conn, _, release, queued := blockedQueueConn(t, false)
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := conn.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() = %v, want context.DeadlineExceeded", err)
+	}
+	if !conn.IsClosed() {
+		t.Error("Shutdown() left the connection open")
+	}
+	if first, second := <-queued, <-queued; first != nil || !errors.Is(second, net.ErrClosed) {
+		t.Errorf("in-flight send = %v, queued send = %v, want nil and net.ErrClosed", first, second)
+	}
+}
+
+// TestDatagramConn_SendAsyncCloseFromCallback tests that a done callback may
+// close the connection while sends are still queued.
+func TestDatagramConn_SendAsyncCloseFromCallback(t *testing.T) {
+	conn, err := NewDatagramConn(newMockSession(), 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	if err := conn.SetSendQueue(SendQueueConfig{Size: 8, Workers: 1}); err != nil {
+		t.Fatalf("SetSendQueue() failed: %v", err)
+	}
+	addr := &I2PAddr{Destination: validDestinationB64(), Port: 9000}
+
+	closed := make(chan struct{})
+	results := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		first := i == 0
+		err := conn.SendAsync(context.Background(), []byte("x"), addr, nil, func(err error) {
+			if first {
+				conn.Close()
+				close(closed)
+			}
+			results <- err
+		})
+		if err != nil {
+			t.Fatalf("SendAsync() failed: %v", err)
+		}
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() from a done callback did not return")
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("queued send failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package datagrams
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/mldsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	i2cp "github.com/go-i2p/go-i2cp"
+)
+
+// ErrOfflineSignatureExpired is returned when an OfflineSigner is installed or
+// used after its offline signature has expired, since receivers would drop every
+// envelope it signs.
+var ErrOfflineSignatureExpired = errors.New("offline signature has expired")
+
+// Signer signs authenticated datagram envelopes (Datagram1 and Datagram2).
+//
+// Signer is a crypto.Signer that also reports its I2P signature type, so the private
+// key never has to be in this process: it can live in an HSM, a remote signing daemon,
+// or a separate privileged process. Sign is called with the complete message to sign
+// and crypto.Hash(0) as opts, since Ed25519 and ML-DSA sign messages directly.
+// Public must return an ed25519.PublicKey, a *mldsa.PublicKey, or any key with a
+// Bytes() []byte method returning the raw I2P public key encoding.
+//
+// ed25519.PrivateKey and *mldsa.PrivateKey can be adapted with [NewSigner].
+// Install a Signer on a connection with [DatagramConn.SetSigner].
+type Signer interface {
+	crypto.Signer
+
+	// SigType returns the I2P signature type of the key (see the SigType constants).
+	SigType() uint16
+}
+
+// OfflineSigner is a Signer holding a transient key authorized by the destination
+// through an offline signature (LS2 offline keys). Envelopes signed by an OfflineSigner
+// carry the offline signature block, so they can only be sent as Datagram2.
+type OfflineSigner interface {
+	Signer
+
+	// OfflineSignature returns the block in which the destination authorizes the
+	// transient key returned by Public.
+	OfflineSignature() *OfflineSignature
+}
+
+// keySigner adapts a crypto.Signer with a known signature type to Signer.
+type keySigner struct {
+	crypto.Signer
+	sigType uint16
+}
+
+func (s *keySigner) SigType() uint16 { return s.sigType }
+
+// NewSigner adapts a crypto.Signer to Signer, deriving the signature type from its
+// public key. Supported keys are ed25519.PrivateKey and *mldsa.PrivateKey, or any
+// crypto.Signer whose public key is an ed25519.PublicKey or *mldsa.PublicKey.
+//
+// Example:
+//
+//	_, priv, _ := ed25519.GenerateKey(nil)
+//	signer, err := datagrams.NewSigner(priv)
+func NewSigner(key crypto.Signer) (Signer, error) {
+	if key == nil {
+		return nil, fmt.Errorf("signer key is nil")
+	}
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		return &keySigner{Signer: key, sigType: SigTypeEd25519}, nil
+	case *mldsa.PublicKey:
+		switch pub.Parameters() {
+		case mldsa.MLDSA44():
+			return &keySigner{Signer: key, sigType: SigTypeMLDSA44}, nil
+		case mldsa.MLDSA65():
+			return &keySigner{Signer: key, sigType: SigTypeMLDSA65}, nil
+		case mldsa.MLDSA87():
+			return &keySigner{Signer: key, sigType: SigTypeMLDSA87}, nil
+		}
+		return nil, fmt.Errorf("unsupported ML-DSA parameters: %s", pub.Parameters())
+	default:
+		return nil, fmt.Errorf("unsupported signer public key type %T", pub)
+	}
+}
+
+// offlineSigner pairs a transient Signer with its offline signature block.
+type offlineSigner struct {
+	Signer
+	offline *OfflineSignature
+}
+
+func (s *offlineSigner) OfflineSignature() *OfflineSignature { return s.offline }
+
+// NewOfflineSigner combines a transient key with the offline signature that authorizes it.
+// Returns an error if the transient key does not match the block's sigtype and public key.
+// The authorization itself is checked against the destination by DatagramConn.SetSigner.
+func NewOfflineSigner(transient Signer, offline *OfflineSignature) (OfflineSigner, error) {
+	if transient == nil || offline == nil {
+		return nil, fmt.Errorf("transient signer and offline signature are required")
+	}
+	if transient.SigType() != offline.TransientSigType {
+		return nil, fmt.Errorf("transient signer sigtype %d does not match offline signature sigtype %d", transient.SigType(), offline.TransientSigType)
+	}
+	pub, err := signerPublicKeyBytes(transient)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pub, offline.TransientPublicKey) {
+		return nil, fmt.Errorf("transient signer public key does not match offline signature")
+	}
+	return &offlineSigner{Signer: transient, offline: offline}, nil
+}
+
+// signerPublicKeyBytes returns the raw I2P encoding of a signer's public key.
+func signerPublicKeyBytes(s Signer) ([]byte, error) {
+	switch pub := s.Public().(type) {
+	case ed25519.PublicKey:
+		return pub, nil
+	case interface{ Bytes() []byte }:
+		return pub.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported signer public key type %T", pub)
+	}
+}
+
+// sessionSigner signs with a session's Ed25519 key pair through go-i2cp.
+type sessionSigner struct {
+	keyPair *i2cp.Ed25519KeyPair
+}
+
+func (s *sessionSigner) Public() crypto.PublicKey { return s.keyPair.PublicKey() }
+
+func (s *sessionSigner) SigType() uint16 { return SigTypeEd25519 }
+
+func (s *sessionSigner) Sign(_ io.Reader, message []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return s.keyPair.Sign(message)
+}
+
+// newEnvelopeKey validates a Signer against the sender destination and builds the
+// envelopeKey used to sign envelopes.
+//
+// A plain Signer must hold the destination's own signing key. An OfflineSigner's
+// offline signature must be valid, unexpired and signed by the destination's key.
+func newEnvelopeKey(dest *wireDestination, signer Signer) (*envelopeKey, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("signer is nil")
+	}
+
+	if offSigner, ok := signer.(OfflineSigner); ok {
+		offline := offSigner.OfflineSignature()
+		if offline == nil {
+			return nil, fmt.Errorf("offline signer has no offline signature")
+		}
+		if offline.IsExpired() {
+			return nil, fmt.Errorf("%w (expired at %s)", ErrOfflineSignatureExpired, offline.Expires)
+		}
+		if err := offline.verifyWithKey(dest.sigType, dest.signingPublicKey); err != nil {
+			return nil, fmt.Errorf("offline signature not valid for destination: %w", err)
+		}
+		if _, err := NewOfflineSigner(signer, offline); err != nil {
+			return nil, err
+		}
+		return &envelopeKey{dest: dest, offline: offline, signer: signer}, nil
+	}
+
+	if signer.SigType() != dest.sigType {
+		return nil, fmt.Errorf("signer sigtype %d does not match destination sigtype %d", signer.SigType(), dest.sigType)
+	}
+	pub, err := signerPublicKeyBytes(signer)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pub, dest.signingPublicKey) {
+		return nil, fmt.Errorf("signer public key does not match destination signing key")
+	}
+	return &envelopeKey{dest: dest, signer: signer}, nil
+}
+
+// signMessage signs message with a Signer. Ed25519 and ML-DSA sign messages directly,
+// so no hash function is passed.
+func signMessage(signer Signer, message []byte) ([]byte, error) {
+	return signer.Sign(rand.Reader, message, crypto.Hash(0))
+}
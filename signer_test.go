@@ -0,0 +1,336 @@
+package datagrams
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/mldsa"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSigner is a Signer that records the messages it signs and can be made to fail,
+// standing in for an HSM or remote signing daemon.
+type fakeSigner struct {
+	key     crypto.Signer
+	sigType uint16
+	signed  [][]byte
+	err     error
+}
+
+func (f *fakeSigner) Public() crypto.PublicKey { return f.key.Public() }
+
+func (f *fakeSigner) SigType() uint16 { return f.sigType }
+
+func (f *fakeSigner) Sign(r io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.signed = append(f.signed, append([]byte(nil), message...))
+	return f.key.Sign(r, message, opts)
+}
+
+// mustSigner adapts a private key to Signer, failing the test on error.
+func mustSigner(t *testing.T, key crypto.Signer) Signer {
+	t.Helper()
+	s, err := NewSigner(key)
+	if err != nil {
+		t.Fatalf("NewSigner() failed: %v", err)
+	}
+	return s
+}
+
+// sessionFakeSigner returns a fakeSigner holding the mock session's destination key.
+func sessionFakeSigner(t *testing.T, session *mockSession) *fakeSigner {
+	t.Helper()
+	keyPair, err := session.SigningKeyPair()
+	if err != nil {
+		t.Fatalf("SigningKeyPair() failed: %v", err)
+	}
+	return &fakeSigner{key: keyPair.PrivateKey(), sigType: SigTypeEd25519}
+}
+
+// TestNewSigner tests sigtype detection for supported key types.
+func TestNewSigner(t *testing.T) {
+	_, edPriv, _ := ed25519.GenerateKey(nil)
+	mldsa65, _ := mldsa.GenerateKey(mldsa.MLDSA65())
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		sigType uint16
+	}{
+		{"Ed25519", edPriv, SigTypeEd25519},
+		{"ML-DSA-65", mldsa65, SigTypeMLDSA65},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := mustSigner(t, tt.key)
+			if s.SigType() != tt.sigType {
+				t.Errorf("SigType() = %d, want %d", s.SigType(), tt.sigType)
+			}
+			msg := []byte("message")
+			sig, err := signMessage(s, msg)
+			if err != nil {
+				t.Fatalf("signMessage() failed: %v", err)
+			}
+			pub, err := signerPublicKeyBytes(s)
+			if err != nil {
+				t.Fatalf("signerPublicKeyBytes() failed: %v", err)
+			}
+			if !verifySignatureForSigType(tt.sigType, pub, msg, sig) {
+				t.Error("signature does not verify")
+			}
+		})
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := NewSigner(ecKey); err == nil {
+		t.Error("expected error for unsupported ECDSA key")
+	}
+	if _, err := NewSigner(nil); err == nil {
+		t.Error("expected error for nil key")
+	}
+}
+
+// TestNewOfflineSigner tests that the transient key must match the offline block.
+func TestNewOfflineSigner(t *testing.T) {
+	transientPub, transientPriv, _ := ed25519.GenerateKey(nil)
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	offline := &OfflineSignature{
+		Expires:            time.Now().Add(time.Hour),
+		TransientSigType:   SigTypeEd25519,
+		TransientPublicKey: transientPub,
+	}
+
+	if _, err := NewOfflineSigner(mustSigner(t, transientPriv), offline); err != nil {
+		t.Errorf("NewOfflineSigner() failed: %v", err)
+	}
+	if _, err := NewOfflineSigner(mustSigner(t, otherPriv), offline); err == nil {
+		t.Error("expected error for mismatched transient key")
+	}
+	mldsaKey, _ := mldsa.GenerateKey(mldsa.MLDSA44())
+	if _, err := NewOfflineSigner(mustSigner(t, mldsaKey), offline); err == nil {
+		t.Error("expected error for mismatched transient sigtype")
+	}
+	if _, err := NewOfflineSigner(nil, offline); err == nil {
+		t.Error("expected error for nil signer")
+	}
+}
+
+// TestDatagramConn_SetSigner tests that authenticated sends go through the installed Signer.
+func TestDatagramConn_SetSigner(t *testing.T) {
+	sender := newMockSession()
+	receiver := newMockSession()
+	receiverB64 := receiver.Destination().Base64()
+
+	conn, err := NewDatagramConnWithProtocol(sender, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	signer := sessionFakeSigner(t, sender)
+	if err := conn.SetSigner(signer); err != nil {
+		t.Fatalf("SetSigner() failed: %v", err)
+	}
+
+	if err := conn.SendTo([]byte("via signer"), receiverB64, 9000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	if len(signer.signed) != 1 {
+		t.Fatalf("signer called %d times, want 1", len(signer.signed))
+	}
+	d2, err := openEnvelope(ProtocolDatagram2, sender.lastPayload, targetHashOf(t, receiver))
+	if err != nil {
+		t.Fatalf("openEnvelope() failed: %v", err)
+	}
+	if string(d2.Payload) != "via signer" {
+		t.Errorf("payload = %q, want %q", d2.Payload, "via signer")
+	}
+	if d2.sender.hash() != targetHashOf(t, sender) {
+		t.Error("sender hash mismatch")
+	}
+
+	// Signing errors are reported to the caller
+	signer.err = errors.New("hsm offline")
+	if err := conn.SendTo([]byte("fail"), receiverB64, 9000); err == nil || !strings.Contains(err.Error(), "hsm offline") {
+		t.Errorf("SendTo() error = %v, want signer error", err)
+	}
+
+	// Removing the signer falls back to the session key pair
+	if err := conn.SetSigner(nil); err != nil {
+		t.Fatalf("SetSigner(nil) failed: %v", err)
+	}
+	if err := conn.SendTo([]byte("session"), receiverB64, 9000); err != nil {
+		t.Fatalf("SendTo() after SetSigner(nil) failed: %v", err)
+	}
+}
+
+// targetHashOf returns the destination hash of a mock session.
+func targetHashOf(t *testing.T, session *mockSession) [32]byte {
+	t.Helper()
+	h, err := destinationHash(session.Destination())
+	if err != nil {
+		t.Fatalf("destinationHash() failed: %v", err)
+	}
+	return h
+}
+
+// TestDatagramConn_SetSigner_Datagram1 tests Datagram1 signing through a Signer.
+func TestDatagramConn_SetSigner_Datagram1(t *testing.T) {
+	sender := newMockSession()
+	conn, err := NewDatagramConnWithProtocol(sender, 8080, ProtocolDatagram1)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	signer := sessionFakeSigner(t, sender)
+	if err := conn.SetSigner(signer); err != nil {
+		t.Fatalf("SetSigner() failed: %v", err)
+	}
+	if err := conn.SendTo([]byte("legacy"), newMockSession().Destination().Base64(), 9000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	if len(signer.signed) != 1 || string(signer.signed[0]) != "legacy" {
+		t.Errorf("signed messages = %q, want [legacy]", signer.signed)
+	}
+	if _, err := openEnvelope(ProtocolDatagram1, sender.lastPayload, [32]byte{}); err != nil {
+		t.Errorf("openEnvelope() failed: %v", err)
+	}
+}
+
+// TestDatagramConn_SetSigner_Mismatch tests that signers for other keys are rejected.
+func TestDatagramConn_SetSigner_Mismatch(t *testing.T) {
+	conn, err := NewDatagramConnWithProtocol(newMockSession(), 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	if err := conn.SetSigner(mustSigner(t, otherPriv)); err == nil {
+		t.Error("expected error for signer with a different key")
+	}
+	mldsaKey, _ := mldsa.GenerateKey(mldsa.MLDSA44())
+	if err := conn.SetSigner(mustSigner(t, mldsaKey)); err == nil {
+		t.Error("expected error for signer with a different sigtype")
+	}
+}
+
+// TestDatagramConn_SetSigner_Offline tests Datagram2 sending for an offline session
+// through an OfflineSigner holding an ML-DSA-44 transient key.
+func TestDatagramConn_SetSigner_Offline(t *testing.T) {
+	sender := newMockSession()
+	sender.offline = true
+	receiver := newMockSession()
+	receiverB64 := receiver.Destination().Base64()
+
+	conn, err := NewDatagramConnWithProtocol(sender, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Without the transient key, offline sessions cannot send Datagram2
+	if err := conn.SendTo([]byte("x"), receiverB64, 9000); err == nil {
+		t.Fatal("expected error for offline session without signer")
+	}
+
+	transient, _ := mldsa.GenerateKey(mldsa.MLDSA44())
+	offline := &OfflineSignature{
+		Expires:            time.Now().Add(time.Hour),
+		TransientSigType:   SigTypeMLDSA44,
+		TransientPublicKey: transient.PublicKey().Bytes(),
+	}
+	destKeys, _ := sender.SigningKeyPair()
+	offline.Signature, _ = destKeys.Sign(offline.signedData())
+
+	offSigner, err := NewOfflineSigner(mustSigner(t, transient), offline)
+	if err != nil {
+		t.Fatalf("NewOfflineSigner() failed: %v", err)
+	}
+	if err := conn.SetSigner(offSigner); err != nil {
+		t.Fatalf("SetSigner() failed: %v", err)
+	}
+
+	if want := MaxI2NPSize - (391 + 2 + offline.Len() + mldsa.MLDSA44SignatureSize); conn.MaxPayloadSize() != want {
+		t.Errorf("MaxPayloadSize() = %d, want %d", conn.MaxPayloadSize(), want)
+	}
+
+	if err := conn.SendTo([]byte("offline"), receiverB64, 9000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	d2, err := openEnvelope(ProtocolDatagram2, sender.lastPayload, targetHashOf(t, receiver))
+	if err != nil {
+		t.Fatalf("openEnvelope() failed: %v", err)
+	}
+	if d2.OfflineSignature == nil || d2.OfflineSignature.TransientSigType != SigTypeMLDSA44 {
+		t.Error("expected ML-DSA-44 offline signature in envelope")
+	}
+
+	// Datagram1 cannot carry the offline block
+	if _, err := encodeDatagram1([]byte("x"), conn.signingKey); err == nil {
+		t.Error("expected Datagram1 to reject offline signer")
+	}
+
+	// An authorization not signed by the destination is rejected
+	forged := *offline
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	forged.Signature = ed25519.Sign(otherPriv, offline.signedData())
+	forgedSigner, _ := NewOfflineSigner(mustSigner(t, transient), &forged)
+	if err := conn.SetSigner(forgedSigner); err == nil {
+		t.Error("expected error for forged offline signature")
+	}
+}
+
+// TestDatagramConn_SetSigner_OfflineExpired tests that expired offline signatures
+// are rejected on install, and fail Datagram2 sends once they expire in use.
+func TestDatagramConn_SetSigner_OfflineExpired(t *testing.T) {
+	sender := newMockSession()
+	sender.offline = true
+	conn, err := NewDatagramConnWithProtocol(sender, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer conn.Close()
+	destKeys, _ := sender.SigningKeyPair()
+	transientPub, transientPriv, _ := ed25519.GenerateKey(nil)
+	signerExpiring := func(expires time.Time) OfflineSigner {
+		offline := &OfflineSignature{
+			Expires:            expires,
+			TransientSigType:   SigTypeEd25519,
+			TransientPublicKey: transientPub,
+		}
+		offline.Signature, _ = destKeys.Sign(offline.signedData())
+		signer, err := NewOfflineSigner(mustSigner(t, transientPriv), offline)
+		if err != nil {
+			t.Fatalf("NewOfflineSigner() failed: %v", err)
+		}
+		return signer
+	}
+
+	if err := conn.SetSigner(signerExpiring(time.Now().Add(-time.Minute))); !errors.Is(err, ErrOfflineSignatureExpired) {
+		t.Errorf("SetSigner(expired) = %v, want ErrOfflineSignatureExpired", err)
+	}
+
+	signer := signerExpiring(time.Now().Add(time.Hour))
+	if err := conn.SetSigner(signer); err != nil {
+		t.Fatalf("SetSigner() failed: %v", err)
+	}
+	receiverB64 := newMockSession().Destination().Base64()
+	if err := conn.SendTo([]byte("fresh"), receiverB64, 9000); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	// Simulate the connection outliving its offline signature
+	signer.OfflineSignature().Expires = time.Now().Add(-time.Second)
+	if err := conn.SendTo([]byte("stale"), receiverB64, 9000); !errors.Is(err, ErrOfflineSignatureExpired) {
+		t.Errorf("SendTo() after expiry = %v, want ErrOfflineSignatureExpired", err)
+	}
+}
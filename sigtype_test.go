@@ -0,0 +1,78 @@
+package datagrams
+
+import (
+	"crypto/ed25519"
+	"crypto/mldsa"
+	"testing"
+)
+
+// TestVerifySignatureForSigType_Ed25519 tests Ed25519 verification through the sigtype table.
+func TestVerifySignatureForSigType_Ed25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	message := []byte("hello")
+	sig := ed25519.Sign(priv, message)
+
+	if !verifySignatureForSigType(SigTypeEd25519, pub, message, sig) {
+		t.Error("expected valid Ed25519 signature to verify")
+	}
+	if verifySignatureForSigType(SigTypeEd25519, pub, []byte("other"), sig) {
+		t.Error("expected signature over different message to fail")
+	}
+	if verifySignatureForSigType(SigTypeRedDSAEd25519, pub, message, sig) {
+		t.Error("expected unsupported sigtype to fail verification")
+	}
+}
+
+// TestVerifySignatureForSigType_MLDSA tests verification for each ML-DSA parameter set.
+func TestVerifySignatureForSigType_MLDSA(t *testing.T) {
+	testCases := []struct {
+		name    string
+		sigType uint16
+		params  mldsa.Parameters
+	}{
+		{"ML-DSA-44", SigTypeMLDSA44, mldsa.MLDSA44()},
+		{"ML-DSA-65", SigTypeMLDSA65, mldsa.MLDSA65()},
+		{"ML-DSA-87", SigTypeMLDSA87, mldsa.MLDSA87()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := mldsa.GenerateKey(tc.params)
+			if err != nil {
+				t.Fatalf("failed to generate key: %v", err)
+			}
+			message := []byte("post-quantum datagram")
+			sig, err := key.Sign(nil, message, nil)
+			if err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			pub := key.PublicKey().Bytes()
+
+			if len(pub) != publicKeyLengthForSigType(tc.sigType) {
+				t.Errorf("public key length = %d, want %d", len(pub), publicKeyLengthForSigType(tc.sigType))
+			}
+			if len(sig) != signatureLengthForSigType(tc.sigType) {
+				t.Errorf("signature length = %d, want %d", len(sig), signatureLengthForSigType(tc.sigType))
+			}
+			if !verifySignatureForSigType(tc.sigType, pub, message, sig) {
+				t.Error("expected valid ML-DSA signature to verify")
+			}
+			if verifySignatureForSigType(tc.sigType, pub, []byte("tampered"), sig) {
+				t.Error("expected signature over different message to fail")
+			}
+			if verifySignatureForSigType(tc.sigType, pub, message, sig[:len(sig)-1]) {
+				t.Error("expected truncated signature to fail")
+			}
+			if !isMLDSASigType(tc.sigType) {
+				t.Errorf("isMLDSASigType(%d) = false, want true", tc.sigType)
+			}
+		})
+	}
+
+	if isMLDSASigType(SigTypeEd25519) {
+		t.Error("isMLDSASigType(Ed25519) = true, want false")
+	}
+}
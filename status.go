@@ -0,0 +1,281 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	i2cp "github.com/go-i2p/go-i2cp"
+)
+
+// DefaultDeliveryTimeout is how long a Delivery waits for a final router status
+// before failing with ErrDeliveryTimeout.
+const DefaultDeliveryTimeout = 2 * time.Minute
+
+// ErrDeliveryTimeout is returned by Delivery.Wait when the router reported no
+// final status in time, for example because the session's
+// i2cp.messageReliability is "none".
+var ErrDeliveryTimeout = errors.New("no delivery status received")
+
+// DeliveryStatus is an I2CP MessageStatus report for a tracked send.
+type DeliveryStatus struct {
+	// Nonce identifies the send, as assigned by SendTracked.
+	Nonce uint32
+
+	// MessageID is the router's identifier for the message.
+	MessageID uint32
+
+	// Code is the I2CP status code, one of the i2cp.MSG_STATUS_* constants.
+	Code uint8
+
+	// Size is the message size the router reported.
+	Size uint32
+
+	// At is when the report arrived.
+	At time.Time
+}
+
+// Final reports whether no further status follows: every code except
+// "accepted", which the router sends when it takes the message in.
+func (s DeliveryStatus) Final() bool {
+	return s.Code != i2cp.MSG_STATUS_ACCEPTED && s.Code != i2cp.MSG_STATUS_AVAILABLE
+}
+
+// Succeeded reports whether the status is a success, including "accepted".
+func (s DeliveryStatus) Succeeded() bool {
+	return i2cp.IsMessageStatusSuccess(s.Code)
+}
+
+// Err returns nil for success statuses and a *DeliveryError otherwise.
+func (s DeliveryStatus) Err() error {
+	if s.Succeeded() {
+		return nil
+	}
+	return &DeliveryError{Status: s}
+}
+
+// String returns the status code and its category, such as "9 (retriable)".
+func (s DeliveryStatus) String() string {
+	return fmt.Sprintf("%d (%s)", s.Code, i2cp.GetMessageStatusCategory(s.Code))
+}
+
+// DeliveryError is a router-side delivery failure of a tracked send.
+type DeliveryError struct {
+	Status DeliveryStatus
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("delivery failed with status %s", e.Status)
+}
+
+// Retriable reports whether the failure is transient, such as a full queue or
+// tunnels still building, so that resending later may succeed.
+func (e *DeliveryError) Retriable() bool {
+	return i2cp.IsMessageStatusRetriable(e.Status.Code)
+}
+
+// Delivery is the pending outcome of a send made with SendTracked. It completes
+// at the first final status, after DefaultDeliveryTimeout, or when the
+// connection closes.
+type Delivery struct {
+	nonce  uint32
+	sentAt time.Time
+	done   chan struct{}
+	timer  *time.Timer
+
+	mu     sync.Mutex
+	status DeliveryStatus
+	has    bool
+	err    error
+}
+
+// Nonce returns the I2CP nonce of the send.
+func (f *Delivery) Nonce() uint32 {
+	return f.nonce
+}
+
+// SentAt returns when the send was handed to the session.
+func (f *Delivery) SentAt() time.Time {
+	return f.sentAt
+}
+
+// Done returns a channel closed when the delivery completes.
+func (f *Delivery) Done() <-chan struct{} {
+	return f.done
+}
+
+// Status returns the latest status reported for the send, if any.
+func (f *Delivery) Status() (DeliveryStatus, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status, f.has
+}
+
+// Wait waits until the delivery completes or ctx ends, and returns the latest
+// status with nil for success, a *DeliveryError for a router-side failure,
+// ErrDeliveryTimeout, or net.ErrClosed if the connection closed first.
+func (f *Delivery) Wait(ctx context.Context) (DeliveryStatus, error) {
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		status, _ := f.Status()
+		return status, ctx.Err()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status, f.err
+}
+
+// deliveryTracker matches MessageStatus reports to pending Deliveries by nonce.
+// The zero value is ready for use.
+type deliveryTracker struct {
+	mu      sync.Mutex
+	next    uint32
+	closed  bool
+	pending map[uint32]*Delivery
+}
+
+// add registers a new Delivery under an unused non-zero nonce. The first nonce
+// is random, so that connections sharing a session rarely collide.
+func (t *deliveryTracker) add(timeout time.Duration) (*Delivery, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, net.ErrClosed
+	}
+	if t.pending == nil {
+		t.pending = make(map[uint32]*Delivery)
+		t.next = rand.Uint32()
+	}
+	for {
+		t.next++
+		if _, taken := t.pending[t.next]; t.next != 0 && !taken {
+			break
+		}
+	}
+	f := &Delivery{nonce: t.next, done: make(chan struct{})}
+	f.timer = time.AfterFunc(timeout, func() { t.complete(f.nonce, nil, ErrDeliveryTimeout) })
+	t.pending[f.nonce] = f
+	return f, nil
+}
+
+// complete ends the Delivery of nonce with status, if non-nil, and err.
+func (t *deliveryTracker) complete(nonce uint32, status *DeliveryStatus, err error) bool {
+	t.mu.Lock()
+	f, ok := t.pending[nonce]
+	if ok {
+		delete(t.pending, nonce)
+	}
+	t.mu.Unlock()
+	if !ok {
+		return false
+	}
+	f.timer.Stop()
+	f.mu.Lock()
+	if status != nil {
+		f.status, f.has = *status, true
+	}
+	f.err = err
+	f.mu.Unlock()
+	close(f.done)
+	return true
+}
+
+// update records a status for nonce, completing its Delivery if it is final.
+func (t *deliveryTracker) update(status DeliveryStatus) bool {
+	if status.Final() {
+		return t.complete(status.Nonce, &status, status.Err())
+	}
+	t.mu.Lock()
+	f, ok := t.pending[status.Nonce]
+	t.mu.Unlock()
+	if ok {
+		f.mu.Lock()
+		f.status, f.has = status, true
+		f.mu.Unlock()
+	}
+	return ok
+}
+
+// close fails every pending Delivery with net.ErrClosed and refuses new ones.
+func (t *deliveryTracker) close() {
+	t.mu.Lock()
+	t.closed = true
+	nonces := make([]uint32, 0, len(t.pending))
+	for nonce := range t.pending {
+		nonces = append(nonces, nonce)
+	}
+	t.mu.Unlock()
+	for _, nonce := range nonces {
+		t.complete(nonce, nil, net.ErrClosed)
+	}
+}
+
+// SendTracked sends like Send under a nonce assigned by the connection, and
+// returns a Delivery that completes with the router's MessageStatus reports for
+// it. Failures before the hand-off to the session are returned directly.
+//
+// Status reports reach the connection only through HandleMessageStatus, which
+// the session's OnMessageStatus callback must call. opts.Nonce must be zero, and
+// opts.Expiration is not supported, since I2CP SendMessageExpires chooses its
+// own nonce.
+//
+// Example:
+//
+//	delivery, err := conn.SendTracked(ctx, payload, addr, nil)
+//	if err != nil {
+//	    return err // local failure
+//	}
+//	if _, err := delivery.Wait(ctx); err != nil {
+//	    var de *datagrams.DeliveryError
+//	    if errors.As(err, &de) && de.Retriable() {
+//	        // resend later
+//	    }
+//	}
+func (d *DatagramConn) SendTracked(ctx context.Context, payload []byte, addr *I2PAddr, opts *SendOptions) (*Delivery, error) {
+	var o SendOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Nonce != 0 {
+		return nil, fmt.Errorf("SendTracked assigns the nonce: SendOptions.Nonce must be zero")
+	}
+	f, err := d.deliveries.add(DefaultDeliveryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	f.sentAt = time.Now()
+	o.Nonce = f.nonce
+	if err := d.Send(ctx, payload, addr, &o); err != nil {
+		d.deliveries.complete(f.nonce, nil, err)
+		return nil, err
+	}
+	return f, nil
+}
+
+// HandleMessageStatus delivers an I2CP MessageStatus report to the send that
+// SendTracked made with nonce, and reports whether one was pending. Call it from
+// the session's OnMessageStatus callback, offering each report to every
+// connection sharing the session:
+//
+//	callbacks := i2cp.SessionCallbacks{
+//	    OnMessageStatus: func(_ *i2cp.Session, messageID uint32, status i2cp.SessionMessageStatus, size, nonce uint32) {
+//	        conn.HandleMessageStatus(messageID, status, size, nonce)
+//	    },
+//	}
+func (d *DatagramConn) HandleMessageStatus(messageID uint32, status uint8, size, nonce uint32) bool {
+	if nonce == 0 {
+		return false
+	}
+	return d.deliveries.update(DeliveryStatus{
+		Nonce:     nonce,
+		MessageID: messageID,
+		Code:      status,
+		Size:      size,
+		At:        time.Now(),
+	})
+}
@@ -0,0 +1,111 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	i2cp "github.com/go-i2p/go-i2cp"
+)
+
+// TestDatagramConn_SendTracked tests that status reports complete the Delivery
+// of the send carrying their nonce.
+func TestDatagramConn_SendTracked(t *testing.T) {
+	session := newMockSession()
+	conn, err := NewDatagramConn(session, 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	defer conn.Close()
+	addr := &I2PAddr{Destination: validDestinationB64(), Port: 9000}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ok, err := conn.SendTracked(ctx, []byte("ok"), addr, nil)
+	if err != nil {
+		t.Fatalf("SendTracked() failed: %v", err)
+	}
+	if ok.Nonce() == 0 || session.lastNonce != ok.Nonce() {
+		t.Fatalf("delivery nonce %d, sent with nonce %d", ok.Nonce(), session.lastNonce)
+	}
+	failed, err := conn.SendTracked(ctx, []byte("failed"), addr, nil)
+	if err != nil {
+		t.Fatalf("SendTracked() failed: %v", err)
+	}
+	if failed.Nonce() == ok.Nonce() {
+		t.Fatalf("two sends tracked under nonce %d", ok.Nonce())
+	}
+
+	if !conn.HandleMessageStatus(1, i2cp.MSG_STATUS_ACCEPTED, 2, ok.Nonce()) {
+		t.Fatal("HandleMessageStatus() did not match a pending send")
+	}
+	select {
+	case <-ok.Done():
+		t.Fatal("delivery completed on an accepted status")
+	default:
+	}
+	if status, has := ok.Status(); !has || status.Code != i2cp.MSG_STATUS_ACCEPTED || status.MessageID != 1 {
+		t.Errorf("Status() = %+v, %v", status, has)
+	}
+	conn.HandleMessageStatus(1, i2cp.MSG_STATUS_BEST_EFFORT_SUCCESS, 2, ok.Nonce())
+	if status, err := ok.Wait(ctx); err != nil || status.Code != i2cp.MSG_STATUS_BEST_EFFORT_SUCCESS {
+		t.Errorf("Wait() = %v, %v, want success", status, err)
+	}
+
+	conn.HandleMessageStatus(2, i2cp.MSG_STATUS_NO_LEASESET, 6, failed.Nonce())
+	var de *DeliveryError
+	if _, err := failed.Wait(ctx); !errors.As(err, &de) || de.Status.Code != i2cp.MSG_STATUS_NO_LEASESET || de.Retriable() {
+		t.Errorf("Wait() = %v, want a non-retriable no-leaseset DeliveryError", err)
+	}
+	if conn.HandleMessageStatus(2, i2cp.MSG_STATUS_NO_LEASESET, 6, failed.Nonce()) {
+		t.Error("HandleMessageStatus() matched a completed send")
+	}
+	if _, err := conn.SendTracked(ctx, []byte("x"), addr, &SendOptions{Nonce: 5}); err == nil {
+		t.Error("SendTracked() accepted a caller nonce")
+	}
+}
+
+// TestDatagramConn_SendTrackedFailures tests local send failures, Close and the
+// delivery timeout.
+func TestDatagramConn_SendTrackedFailures(t *testing.T) {
+	session := newMockSession()
+	conn, err := NewDatagramConn(session, 8080)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	addr := &I2PAddr{Destination: validDestinationB64(), Port: 9000}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session.sendError = errors.New("router gone")
+	if _, err := conn.SendTracked(ctx, []byte("x"), addr, nil); err == nil {
+		t.Error("SendTracked() hid a session failure")
+	}
+	if n := len(conn.deliveries.pending); n != 0 {
+		t.Errorf("%d deliveries pending after a failed send", n)
+	}
+	session.sendError = nil
+
+	pending, err := conn.SendTracked(ctx, []byte("x"), addr, nil)
+	if err != nil {
+		t.Fatalf("SendTracked() failed: %v", err)
+	}
+	conn.Close()
+	if _, err := pending.Wait(ctx); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Wait() after Close = %v, want net.ErrClosed", err)
+	}
+	if _, err := conn.SendTracked(ctx, []byte("x"), addr, nil); err == nil {
+		t.Error("SendTracked() succeeded after Close")
+	}
+
+	var tracker deliveryTracker
+	f, err := tracker.add(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("add() failed: %v", err)
+	}
+	if _, err := f.Wait(ctx); !errors.Is(err, ErrDeliveryTimeout) {
+		t.Errorf("Wait() without status = %v, want ErrDeliveryTimeout", err)
+	}
+}
@@ -0,0 +1,624 @@
+package datagrams
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Upgrade frame types. Every frame starts with the type byte and the token.
+const (
+	// upgradeHello is sent by the client as a Datagram2: token + application payload.
+	upgradeHello byte = 1
+	// upgradeAccept is sent by the server as a Raw datagram: token + lifetime (uint32 ms).
+	upgradeAccept byte = 2
+	// upgradeData is sent in either direction as a Raw datagram: token + application payload.
+	upgradeData byte = 3
+)
+
+const (
+	// UpgradeTokenSize is the length of an upgrade token in bytes.
+	UpgradeTokenSize = 16
+
+	// UpgradeOverhead is the per-datagram overhead of upgraded Raw traffic:
+	// frame type(1) + token(16) = 17 bytes.
+	UpgradeOverhead = 1 + UpgradeTokenSize
+
+	// DefaultUpgradeTokenLifetime is how long the server honours a token.
+	DefaultUpgradeTokenLifetime = 10 * time.Minute
+
+	// DefaultUpgradeRetryInterval is how often a client resends an unanswered hello.
+	DefaultUpgradeRetryInterval = 2 * time.Second
+
+	// DefaultUpgradeMaxPeers is the default number of tokens an UpgradeServer holds.
+	DefaultUpgradeMaxPeers = 1024
+)
+
+// ErrUpgradePeerUnknown is returned by UpgradeServer.SendTo for senders without a
+// valid token.
+var ErrUpgradePeerUnknown = errors.New("upgrade: unknown or expired peer")
+
+// UpgradeToken identifies an upgraded session in Raw datagrams.
+type UpgradeToken [UpgradeTokenSize]byte
+
+// UpgradeConfig configures an UpgradeServer or UpgradeClient.
+// Zero fields take their defaults.
+type UpgradeConfig struct {
+	// TokenLifetime is how long a server honours a token after accepting it.
+	// The server announces it to clients in its accepts. Server only.
+	// Zero means DefaultUpgradeTokenLifetime.
+	TokenLifetime time.Duration
+
+	// RenewBefore is how long before expiry a client renews its token with a new
+	// authenticated hello. Client only. Zero means a quarter of the lifetime.
+	RenewBefore time.Duration
+
+	// RetryInterval is how often a client resends an unanswered hello.
+	// Client only. Zero means DefaultUpgradeRetryInterval.
+	RetryInterval time.Duration
+
+	// MaxPeers is the maximum number of live tokens a server holds; hellos for new
+	// tokens are dropped while it is full. Each sender holds at most two tokens,
+	// its latest and the one that token renews. Server only. Zero means
+	// DefaultUpgradeMaxPeers.
+	MaxPeers int
+}
+
+func (c UpgradeConfig) withDefaults() UpgradeConfig {
+	if c.TokenLifetime <= 0 {
+		c.TokenLifetime = DefaultUpgradeTokenLifetime
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = DefaultUpgradeRetryInterval
+	}
+	if c.MaxPeers <= 0 {
+		c.MaxPeers = DefaultUpgradeMaxPeers
+	}
+	return c
+}
+
+// UpgradePeer identifies the authenticated sender behind a token.
+type UpgradePeer struct {
+	// Hash is the SHA-256 hash of the peer's destination, verified by the hello.
+	Hash [32]byte
+
+	// Addr is the peer's destination and source port from the hello.
+	Addr *I2PAddr
+
+	// Expires is when the peer's latest token expires.
+	Expires time.Time
+}
+
+// UpgradeMessage is an application datagram received by an UpgradeServer.
+type UpgradeMessage struct {
+	// Payload is the application data.
+	Payload []byte
+
+	// Peer is the authenticated sender.
+	Peer UpgradePeer
+
+	// Upgraded is true if the payload arrived as a Raw datagram under a token,
+	// false if it was carried by the authenticated hello itself.
+	Upgraded bool
+}
+
+// upgradeFrame builds a frame of the given type.
+func upgradeFrame(kind byte, token UpgradeToken, body []byte) []byte {
+	frame := make([]byte, 0, UpgradeOverhead+len(body))
+	frame = append(frame, kind)
+	frame = append(frame, token[:]...)
+	return append(frame, body...)
+}
+
+// parseUpgradeFrame splits a frame into its type, token and body.
+func parseUpgradeFrame(data []byte) (byte, UpgradeToken, []byte, error) {
+	var token UpgradeToken
+	if len(data) < UpgradeOverhead {
+		return 0, token, nil, fmt.Errorf("upgrade frame too short: %d bytes", len(data))
+	}
+	copy(token[:], data[1:UpgradeOverhead])
+	return data[0], token, data[UpgradeOverhead:], nil
+}
+
+// newUpgradeToken returns a random token.
+func newUpgradeToken() (UpgradeToken, error) {
+	var token UpgradeToken
+	if _, err := rand.Read(token[:]); err != nil {
+		return token, fmt.Errorf("failed to generate upgrade token: %w", err)
+	}
+	return token, nil
+}
+
+// upgradeBinding binds a token to the authenticated sender that presented it.
+type upgradeBinding struct {
+	token   UpgradeToken
+	hash    [32]byte
+	addr    *I2PAddr
+	expires time.Time
+
+	// previous is the sender's token this one renews, kept until the next renewal.
+	previous *upgradeBinding
+}
+
+func (b *upgradeBinding) peer() UpgradePeer {
+	return UpgradePeer{Hash: b.hash, Addr: b.addr, Expires: b.expires}
+}
+
+// UpgradeServer implements the server side of the authenticated-then-raw pattern
+// from SPEC.md "Application Design".
+//
+// A client sends a Datagram2 hello carrying a random token. Once the signature is
+// verified, the server binds the token to the sender's destination hash and
+// replies with a Raw accept carrying the same token. Until the token expires,
+// both sides exchange Raw datagrams prefixed with the token, and the server
+// attributes them to the authenticated sender at 17 bytes of overhead instead of
+// the ~457 of Datagram2.
+//
+// Raw datagrams are end-to-end encrypted but not signed, so a token is a bearer
+// secret: anyone who learns it can send as the peer until it expires. Tokens are
+// 128-bit random values and only travel between the two endpoints.
+//
+// UpgradeServer owns both connections and closes them on Close.
+type UpgradeServer struct {
+	auth   *DatagramConn
+	raw    *DatagramConn
+	config UpgradeConfig
+
+	mu     sync.Mutex
+	tokens map[UpgradeToken]*upgradeBinding
+	peers  map[[32]byte]*upgradeBinding // latest binding per sender
+
+	incoming chan *UpgradeMessage
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewUpgradeServer creates an UpgradeServer receiving hellos on auth, which must
+// use ProtocolDatagram2, and upgraded traffic on raw, which must use ProtocolRaw.
+// Both are usually bound to the same session and port. A nil config uses defaults.
+func NewUpgradeServer(auth, raw *DatagramConn, config *UpgradeConfig) (*UpgradeServer, error) {
+	if err := checkUpgradeConns(auth, raw); err != nil {
+		return nil, err
+	}
+	var cfg UpgradeConfig
+	if config != nil {
+		cfg = *config
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &UpgradeServer{
+		auth:     auth,
+		raw:      raw,
+		config:   cfg.withDefaults(),
+		tokens:   make(map[UpgradeToken]*upgradeBinding),
+		peers:    make(map[[32]byte]*upgradeBinding),
+		incoming: make(chan *UpgradeMessage, 100),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	s.wg.Add(2)
+	go pumpConn(&s.wg, auth, s.handleHello)
+	go pumpConn(&s.wg, raw, s.handleData)
+	return s, nil
+}
+
+// checkUpgradeConns validates the connection pair of an upgrade endpoint.
+func checkUpgradeConns(auth, raw *DatagramConn) error {
+	if auth == nil || raw == nil {
+		return fmt.Errorf("upgrade connections cannot be nil")
+	}
+	if auth.Protocol() != ProtocolDatagram2 {
+		return fmt.Errorf("upgrade hello connection must use Datagram2, got protocol %d", auth.Protocol())
+	}
+	if raw.Protocol() != ProtocolRaw {
+		return fmt.Errorf("upgrade data connection must use Raw, got protocol %d", raw.Protocol())
+	}
+	return nil
+}
+
+// pumpConn feeds datagrams received on conn to handle until conn is closed.
+// Datagrams that fail to decode or verify are skipped.
+func pumpConn(wg *sync.WaitGroup, conn *DatagramConn, handle func(*ReceiveResult)) {
+	defer wg.Done()
+	for {
+		result, err := conn.ReceiveFromWithOptions()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err == nil {
+			handle(result)
+		}
+	}
+}
+
+// handleHello binds the token of a verified hello and answers with an accept.
+func (s *UpgradeServer) handleHello(result *ReceiveResult) {
+	if !result.Authenticated {
+		return
+	}
+	kind, token, body, err := parseUpgradeFrame(result.Payload)
+	if err != nil || kind != upgradeHello {
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	binding, exists := s.tokens[token]
+	switch {
+	case exists && binding.hash != result.FromHash:
+		s.mu.Unlock()
+		return // Token bound to another sender
+	case !exists:
+		// A sender holds its latest token and the one it renews, no more
+		latest := s.peers[result.FromHash]
+		if latest != nil && latest.previous != nil {
+			s.removeLocked(latest.previous)
+			latest.previous = nil
+		}
+		if len(s.tokens) >= s.config.MaxPeers {
+			s.pruneLocked(now)
+		}
+		if len(s.tokens) >= s.config.MaxPeers {
+			s.mu.Unlock()
+			return
+		}
+		binding = &upgradeBinding{token: token, hash: result.FromHash, previous: latest}
+		s.tokens[token] = binding
+	}
+	// A repeated hello for a bound token is a retry after a lost accept
+	binding.addr = result.FromAddr
+	binding.expires = now.Add(s.config.TokenLifetime)
+	if !exists || s.peers[binding.hash] == nil {
+		s.peers[binding.hash] = binding
+	}
+	peer := binding.peer()
+	s.mu.Unlock()
+
+	var lifetime [4]byte
+	binary.BigEndian.PutUint32(lifetime[:], uint32(s.config.TokenLifetime.Milliseconds()))
+	s.raw.SendTo(upgradeFrame(upgradeAccept, token, lifetime[:]), peer.Addr.Destination, peer.Addr.Port)
+
+	if len(body) > 0 {
+		s.deliver(&UpgradeMessage{Payload: body, Peer: peer})
+	}
+}
+
+// handleData attributes a Raw data frame to the peer its token is bound to.
+func (s *UpgradeServer) handleData(result *ReceiveResult) {
+	kind, token, body, err := parseUpgradeFrame(result.Payload)
+	if err != nil || kind != upgradeData {
+		return
+	}
+	s.mu.Lock()
+	binding, ok := s.tokens[token]
+	if ok && !time.Now().Before(binding.expires) {
+		s.removeLocked(binding)
+		ok = false
+	}
+	var peer UpgradePeer
+	if ok {
+		peer = binding.peer()
+	}
+	s.mu.Unlock()
+	if ok {
+		s.deliver(&UpgradeMessage{Payload: body, Peer: peer, Upgraded: true})
+	}
+}
+
+func (s *UpgradeServer) deliver(msg *UpgradeMessage) {
+	select {
+	case s.incoming <- msg:
+	case <-s.ctx.Done():
+	}
+}
+
+// pruneLocked removes expired bindings. s.mu must be held.
+func (s *UpgradeServer) pruneLocked(now time.Time) {
+	for _, binding := range s.tokens {
+		if !now.Before(binding.expires) {
+			s.removeLocked(binding)
+		}
+	}
+}
+
+// removeLocked removes one binding. s.mu must be held.
+func (s *UpgradeServer) removeLocked(binding *upgradeBinding) {
+	if s.tokens[binding.token] == binding {
+		delete(s.tokens, binding.token)
+	}
+	if s.peers[binding.hash] == binding {
+		delete(s.peers, binding.hash)
+	}
+}
+
+// Receive blocks until an application datagram arrives from an authenticated
+// peer, ctx is done, or the server is closed (net.ErrClosed).
+func (s *UpgradeServer) Receive(ctx context.Context) (*UpgradeMessage, error) {
+	select {
+	case msg := <-s.incoming:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// SendTo sends payload as a Raw datagram to the peer with the given destination
+// hash, using the peer's latest token. Returns ErrUpgradePeerUnknown if the peer
+// has no valid token.
+func (s *UpgradeServer) SendTo(payload []byte, peerHash [32]byte) error {
+	s.mu.Lock()
+	binding, ok := s.peers[peerHash]
+	if ok && !time.Now().Before(binding.expires) {
+		s.removeLocked(binding)
+		ok = false
+	}
+	var token UpgradeToken
+	var addr *I2PAddr
+	if ok {
+		token, addr = binding.token, binding.addr
+	}
+	s.mu.Unlock()
+	if !ok {
+		return ErrUpgradePeerUnknown
+	}
+	return s.raw.SendTo(upgradeFrame(upgradeData, token, payload), addr.Destination, addr.Port)
+}
+
+// Peers returns the peers holding a valid token.
+func (s *UpgradeServer) Peers() []UpgradePeer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	peers := make([]UpgradePeer, 0, len(s.peers))
+	for _, binding := range s.peers {
+		peers = append(peers, binding.peer())
+	}
+	return peers
+}
+
+// Revoke invalidates all tokens of the peer with the given destination hash.
+// The peer must send a new authenticated hello to be heard again.
+func (s *UpgradeServer) Revoke(peerHash [32]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, binding := range s.tokens {
+		if binding.hash == peerHash {
+			s.removeLocked(binding)
+		}
+	}
+}
+
+// Close stops the server and closes both connections.
+func (s *UpgradeServer) Close() error {
+	s.cancel()
+	s.auth.Close()
+	s.raw.Close()
+	s.wg.Wait()
+	return nil
+}
+
+// UpgradeClient implements the client side of the authenticated-then-raw pattern.
+// See UpgradeServer for the protocol.
+//
+// Send carries payloads in authenticated hellos until the server accepts a token,
+// then as Raw datagrams. When the token nears expiry, Send keeps using it while
+// a hello with a fresh token renews the session.
+//
+// UpgradeClient owns both connections and closes them on Close.
+type UpgradeClient struct {
+	auth   *DatagramConn
+	raw    *DatagramConn
+	server *I2PAddr
+	config UpgradeConfig
+
+	mu         sync.Mutex
+	current    UpgradeToken
+	expires    time.Time     // zero until the first accept
+	lifetime   time.Duration // announced by the server's last accept
+	previous   UpgradeToken
+	prevExpiry time.Time
+	pending    UpgradeToken
+	pendingAt  time.Time // when the pending hello was last sent; zero if none
+	accepted   chan struct{}
+
+	incoming chan []byte
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewUpgradeClient creates an UpgradeClient sending hellos on auth, which must use
+// ProtocolDatagram2, and upgraded traffic on raw, which must use ProtocolRaw, to
+// the server at addr. A nil config uses defaults.
+func NewUpgradeClient(auth, raw *DatagramConn, server *I2PAddr, config *UpgradeConfig) (*UpgradeClient, error) {
+	if err := checkUpgradeConns(auth, raw); err != nil {
+		return nil, err
+	}
+	if server == nil || server.Destination == "" {
+		return nil, fmt.Errorf("upgrade server address must include a destination")
+	}
+	var cfg UpgradeConfig
+	if config != nil {
+		cfg = *config
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &UpgradeClient{
+		auth:     auth,
+		raw:      raw,
+		server:   server,
+		config:   cfg.withDefaults(),
+		accepted: make(chan struct{}),
+		incoming: make(chan []byte, 100),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	c.wg.Add(1)
+	go pumpConn(&c.wg, raw, c.handleRaw)
+	return c, nil
+}
+
+// renewBefore returns the renewal margin for a token of the given lifetime.
+func (c *UpgradeClient) renewBefore(lifetime time.Duration) time.Duration {
+	if c.config.RenewBefore > 0 {
+		return c.config.RenewBefore
+	}
+	return lifetime / 4
+}
+
+// Upgrade sends hellos until the server accepts a token or ctx is done.
+// It returns immediately if the client holds a token that does not need renewal.
+func (c *UpgradeClient) Upgrade(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.fresh(time.Now()) {
+			c.mu.Unlock()
+			return nil
+		}
+		accepted := c.accepted
+		err := c.sendHelloLocked(nil)
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(c.config.RetryInterval)
+		select {
+		case <-accepted:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-c.ctx.Done():
+			timer.Stop()
+			return net.ErrClosed
+		}
+		timer.Stop()
+	}
+}
+
+// fresh reports whether the current token is valid and outside its renewal
+// margin. c.mu must be held.
+func (c *UpgradeClient) fresh(now time.Time) bool {
+	return !c.expires.IsZero() && now.Before(c.expires.Add(-c.renewBefore(c.lifetime)))
+}
+
+// sendHelloLocked sends an authenticated hello carrying payload. An unanswered
+// hello's token is reused so that retries bind the same token. c.mu must be held.
+func (c *UpgradeClient) sendHelloLocked(payload []byte) error {
+	if c.pendingAt.IsZero() {
+		token, err := newUpgradeToken()
+		if err != nil {
+			return err
+		}
+		c.pending = token
+	}
+	c.pendingAt = time.Now()
+	return c.auth.SendTo(upgradeFrame(upgradeHello, c.pending, payload), c.server.Destination, c.server.Port)
+}
+
+// Send sends payload to the server: as a Raw datagram while the client holds a
+// valid token, otherwise inside an authenticated hello. A token within its
+// renewal margin is still used, and a hello renewing it is sent at most once per
+// RetryInterval.
+func (c *UpgradeClient) Send(payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.expires.IsZero() || !now.Before(c.expires) {
+		return c.sendHelloLocked(payload)
+	}
+	if !c.fresh(now) && (c.pendingAt.IsZero() || now.Sub(c.pendingAt) >= c.config.RetryInterval) {
+		if err := c.sendHelloLocked(nil); err != nil {
+			return err
+		}
+	}
+	return c.raw.SendTo(upgradeFrame(upgradeData, c.current, payload), c.server.Destination, c.server.Port)
+}
+
+// handleRaw processes accepts and data frames from the server.
+func (c *UpgradeClient) handleRaw(result *ReceiveResult) {
+	kind, token, body, err := parseUpgradeFrame(result.Payload)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	switch kind {
+	case upgradeAccept:
+		if c.pendingAt.IsZero() || token != c.pending || len(body) < 4 {
+			c.mu.Unlock()
+			return
+		}
+		// Expiry counts from the hello, so the client never outlives the server's binding
+		lifetime := time.Duration(binary.BigEndian.Uint32(body)) * time.Millisecond
+		c.previous, c.prevExpiry = c.current, c.expires
+		c.current, c.expires = token, c.pendingAt.Add(lifetime)
+		c.lifetime = lifetime
+		c.pendingAt = time.Time{}
+		close(c.accepted)
+		c.accepted = make(chan struct{})
+		c.mu.Unlock()
+
+	case upgradeData:
+		valid := (token == c.current && now.Before(c.expires)) ||
+			(token == c.previous && now.Before(c.prevExpiry))
+		c.mu.Unlock()
+		if valid {
+			select {
+			case c.incoming <- body:
+			case <-c.ctx.Done():
+			}
+		}
+
+	default:
+		c.mu.Unlock()
+	}
+}
+
+// Receive blocks until the server sends a payload under a valid token, ctx is
+// done, or the client is closed (net.ErrClosed).
+func (c *UpgradeClient) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case payload := <-c.incoming:
+		return payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Upgraded reports whether the client holds a valid token.
+func (c *UpgradeClient) Upgraded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.expires.IsZero() && time.Now().Before(c.expires)
+}
+
+// Expires returns when the current token expires, or the zero time before the
+// first accept.
+func (c *UpgradeClient) Expires() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expires
+}
+
+// Close stops the client and closes both connections.
+func (c *UpgradeClient) Close() error {
+	c.cancel()
+	c.auth.Close()
+	c.raw.Close()
+	c.wg.Wait()
+	return nil
+}
@@ -0,0 +1,302 @@
+package datagrams
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// upgradePair returns a server and a client routed to each other.
+func upgradePair(t *testing.T, config *UpgradeConfig) (*UpgradeServer, *UpgradeClient, *mockSession) {
+	t.Helper()
+	serverAuth, clientAuth, serverSession, clientSession := connPair(t, ProtocolDatagram2, ProtocolDatagram2)
+	serverRaw, clientRaw := rawConn(t, serverSession, pairPortA), rawConn(t, clientSession, pairPortB)
+	routeTo(serverSession, clientAuth, clientRaw)
+	routeTo(clientSession, serverAuth, serverRaw)
+
+	server, err := NewUpgradeServer(serverAuth, serverRaw, config)
+	if err != nil {
+		t.Fatalf("NewUpgradeServer() failed: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	addr := &I2PAddr{Destination: serverSession.Destination().Base64(), Port: pairPortA}
+	client, err := NewUpgradeClient(clientAuth, clientRaw, addr, config)
+	if err != nil {
+		t.Fatalf("NewUpgradeClient() failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client, clientSession
+}
+
+// rawConn returns a Raw conn on session and port, closed when the test ends.
+func rawConn(t *testing.T, session *mockSession, port uint16) *DatagramConn {
+	t.Helper()
+	conn, err := NewDatagramConn(session, port)
+	if err != nil {
+		t.Fatalf("NewDatagramConn() failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func receiveUpgrade(t *testing.T, server *UpgradeServer) *UpgradeMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := server.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+	return msg
+}
+
+// TestUpgrade_Handshake tests the hello/accept exchange and Raw traffic in both directions.
+func TestUpgrade_Handshake(t *testing.T) {
+	server, client, clientSession := upgradePair(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Upgrade(ctx); err != nil {
+		t.Fatalf("Upgrade() failed: %v", err)
+	}
+	if !client.Upgraded() || time.Until(client.Expires()) > DefaultUpgradeTokenLifetime {
+		t.Errorf("Upgraded() = %v, Expires() = %v", client.Upgraded(), client.Expires())
+	}
+
+	if err := client.Send([]byte("raw hello")); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if clientSession.lastProtocol != ProtocolRaw || len(clientSession.lastPayload) != UpgradeOverhead+len("raw hello") {
+		t.Errorf("sent protocol %d with %d bytes, want Raw", clientSession.lastProtocol, len(clientSession.lastPayload))
+	}
+	msg := receiveUpgrade(t, server)
+	clientHash := targetHashOf(t, clientSession)
+	if string(msg.Payload) != "raw hello" || !msg.Upgraded || msg.Peer.Hash != clientHash {
+		t.Errorf("Receive() = %q, Upgraded = %v, peer %x", msg.Payload, msg.Upgraded, msg.Peer.Hash[:4])
+	}
+	if msg.Peer.Addr.Port != pairPortB || msg.Peer.Addr.Destination != clientSession.Destination().Base64() {
+		t.Errorf("peer addr = %v", msg.Peer.Addr)
+	}
+	if peers := server.Peers(); len(peers) != 1 || peers[0].Hash != clientHash {
+		t.Errorf("Peers() = %v", peers)
+	}
+
+	if err := server.SendTo([]byte("reply"), clientHash); err != nil {
+		t.Fatalf("SendTo() failed: %v", err)
+	}
+	payload, err := client.Receive(ctx)
+	if err != nil || string(payload) != "reply" {
+		t.Errorf("client Receive() = %q, %v", payload, err)
+	}
+}
+
+// TestUpgrade_HelloCarriesPayload tests that Send before an upgrade uses an
+// authenticated hello and upgrades the client.
+func TestUpgrade_HelloCarriesPayload(t *testing.T) {
+	server, client, clientSession := upgradePair(t, nil)
+
+	if err := client.Send([]byte("first")); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if clientSession.lastProtocol != ProtocolDatagram2 {
+		t.Errorf("first Send() used protocol %d, want Datagram2", clientSession.lastProtocol)
+	}
+	msg := receiveUpgrade(t, server)
+	if string(msg.Payload) != "first" || msg.Upgraded {
+		t.Errorf("Receive() = %q, Upgraded = %v", msg.Payload, msg.Upgraded)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.Upgraded() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := client.Send([]byte("second")); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if msg := receiveUpgrade(t, server); string(msg.Payload) != "second" || !msg.Upgraded {
+		t.Errorf("Receive() = %q, Upgraded = %v", msg.Payload, msg.Upgraded)
+	}
+}
+
+// TestUpgradeServer_Tokens tests unknown, hijacked, revoked and expired tokens.
+func TestUpgradeServer_Tokens(t *testing.T) {
+	server, client, clientSession := upgradePair(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Upgrade(ctx); err != nil {
+		t.Fatalf("Upgrade() failed: %v", err)
+	}
+	clientHash := targetHashOf(t, clientSession)
+
+	// Unknown tokens are dropped
+	unknown, _ := newUpgradeToken()
+	server.raw.injectMessage(upgradeFrame(upgradeData, unknown, []byte("spoof")), nil, ProtocolRaw, pairPortB, pairPortA)
+
+	// A hello presenting a bound token from another sender does not rebind it
+	client.mu.Lock()
+	token := client.current
+	client.mu.Unlock()
+	other := newMockSession()
+	otherAuth, err := NewDatagramConnWithProtocol(other, 7002, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer otherAuth.Close()
+	routeTo(other, server.auth)
+	otherAuth.SendTo(upgradeFrame(upgradeHello, token, []byte("hijack")), validDestinationB64(), pairPortA)
+
+	client.Send([]byte("genuine"))
+	if msg := receiveUpgrade(t, server); string(msg.Payload) != "genuine" || msg.Peer.Hash != clientHash {
+		t.Errorf("Receive() = %q from %x, want genuine from client", msg.Payload, msg.Peer.Hash[:4])
+	}
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if msg, err := server.Receive(short); err == nil {
+		t.Errorf("unexpected datagram %q from %x", msg.Payload, msg.Peer.Hash[:4])
+	}
+
+	// Revoked and expired peers are unknown
+	server.Revoke(clientHash)
+	if err := server.SendTo([]byte("x"), clientHash); !errors.Is(err, ErrUpgradePeerUnknown) {
+		t.Errorf("SendTo() after Revoke error = %v, want ErrUpgradePeerUnknown", err)
+	}
+	client.mu.Lock()
+	client.expires = time.Now().Add(-time.Second)
+	client.mu.Unlock()
+	if client.Upgraded() {
+		t.Error("client with an expired token reports Upgraded")
+	}
+	if err := client.Upgrade(ctx); err != nil {
+		t.Fatalf("Upgrade() after expiry failed: %v", err)
+	}
+	server.mu.Lock()
+	for _, binding := range server.tokens {
+		binding.expires = time.Now().Add(-time.Second)
+	}
+	server.mu.Unlock()
+	if err := server.SendTo([]byte("x"), clientHash); !errors.Is(err, ErrUpgradePeerUnknown) {
+		t.Errorf("SendTo() after expiry error = %v, want ErrUpgradePeerUnknown", err)
+	}
+	if peers := server.Peers(); len(peers) != 0 {
+		t.Errorf("Peers() = %v, want none", peers)
+	}
+}
+
+// TestUpgradeClient_Renewal tests that a token in its renewal margin is replaced
+// while traffic continues.
+func TestUpgradeClient_Renewal(t *testing.T) {
+	server, client, _ := upgradePair(t, &UpgradeConfig{TokenLifetime: time.Hour, RenewBefore: 2 * time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client.Send([]byte("hello"))
+	receiveUpgrade(t, server)
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.Upgraded() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	client.mu.Lock()
+	first := client.current
+	client.mu.Unlock()
+
+	// Every token is inside the renewal margin, so Send renews while using it
+	if err := client.Send([]byte("renewing")); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if msg := receiveUpgrade(t, server); string(msg.Payload) != "renewing" || !msg.Upgraded {
+		t.Errorf("Receive() = %q, Upgraded = %v", msg.Payload, msg.Upgraded)
+	}
+	for time.Now().Before(deadline) {
+		client.mu.Lock()
+		renewed := client.current != first
+		client.mu.Unlock()
+		if renewed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	client.mu.Lock()
+	second, previous := client.current, client.previous
+	client.mu.Unlock()
+	if second == first || previous != first {
+		t.Fatal("token was not renewed")
+	}
+
+	// Replies under the previous token are still accepted
+	server.mu.Lock()
+	binding := server.tokens[first]
+	server.mu.Unlock()
+	server.raw.SendTo(upgradeFrame(upgradeData, first, []byte("old")), binding.addr.Destination, binding.addr.Port)
+	if payload, err := client.Receive(ctx); err != nil || string(payload) != "old" {
+		t.Errorf("Receive() = %q, %v", payload, err)
+	}
+}
+
+// TestUpgrade_Errors tests constructor validation and Close.
+func TestUpgrade_Errors(t *testing.T) {
+	session := newMockSession()
+	auth, err := NewDatagramConnWithProtocol(session, pairPortA, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	raw := rawConn(t, session, pairPortA)
+	if _, err := NewUpgradeServer(raw, auth, nil); err == nil {
+		t.Error("expected error for swapped connections")
+	}
+	if _, err := NewUpgradeClient(auth, raw, &I2PAddr{Port: pairPortA}, nil); err == nil {
+		t.Error("expected error for server address without destination")
+	}
+
+	server, err := NewUpgradeServer(auth, raw, nil)
+	if err != nil {
+		t.Fatalf("NewUpgradeServer() failed: %v", err)
+	}
+	server.Close()
+	if !auth.IsClosed() || !raw.IsClosed() {
+		t.Error("Close() should close both connections")
+	}
+	if _, err := server.Receive(context.Background()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Receive() after Close error = %v, want net.ErrClosed", err)
+	}
+}
+
+// TestUpgradeServer_TokensPerSender tests that one sender cannot fill the token
+// table: each new token replaces the sender's older ones but the latest.
+func TestUpgradeServer_TokensPerSender(t *testing.T) {
+	server, client, clientSession := upgradePair(t, &UpgradeConfig{MaxPeers: 3})
+	clientHash := targetHashOf(t, clientSession)
+	var tokens []UpgradeToken
+	for i := 0; i < 5; i++ {
+		token, _ := newUpgradeToken()
+		tokens = append(tokens, token)
+		client.auth.SendTo(upgradeFrame(upgradeHello, token, []byte{byte(i)}), server.auth.localDest.Base64(), pairPortA)
+		if msg := receiveUpgrade(t, server); msg.Payload[0] != byte(i) {
+			t.Fatalf("Receive() = %v, want hello %d", msg.Payload, i)
+		}
+	}
+
+	server.mu.Lock()
+	held := len(server.tokens)
+	_, renewed := server.tokens[tokens[3]]
+	latest := server.peers[clientHash]
+	server.mu.Unlock()
+	if held != 2 || !renewed || latest == nil || latest.token != tokens[4] {
+		t.Errorf("sender holds %d tokens (renewed kept %v), want the latest two", held, renewed)
+	}
+
+	// Another sender still finds room
+	other := newMockSession()
+	otherAuth, err := NewDatagramConnWithProtocol(other, 7002, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	defer otherAuth.Close()
+	routeTo(other, server.auth)
+	token, _ := newUpgradeToken()
+	otherAuth.SendTo(upgradeFrame(upgradeHello, token, []byte("other")), server.auth.localDest.Base64(), pairPortA)
+	if msg := receiveUpgrade(t, server); string(msg.Payload) != "other" {
+		t.Errorf("Receive() = %q, want the other sender's hello", msg.Payload)
+	}
+}
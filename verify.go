@@ -0,0 +1,464 @@
+package datagrams
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+
+	"filippo.io/edwards25519"
+)
+
+// DefaultVerifyBatchSize is the default maximum number of envelopes a
+// VerifyParallel worker verifies together.
+const DefaultVerifyBatchSize = 64
+
+// Signature verification failures reported by Envelope.Verify and DatagramConn.
+var (
+	errOfflineNotAuthorized          = errors.New("offline signature verification failed: destination did not authorize this transient key")
+	errDatagram1Signature            = errors.New("Datagram1 signature verification failed")
+	errDatagram2Signature            = errors.New("Datagram2 signature verification failed (possible replay attack or wrong recipient)")
+	errDatagram2OfflineAuthorization = fmt.Errorf("Datagram2 offline signature authorization failed: %w", errOfflineNotAuthorized)
+)
+
+// VerifyMode selects how a DatagramConn checks Datagram1 and Datagram2 signatures.
+type VerifyMode int
+
+const (
+	// VerifyInline verifies each envelope in the goroutine that receives it.
+	// This is the default.
+	VerifyInline VerifyMode = iota
+
+	// VerifyParallel verifies envelopes in a pool of worker goroutines before they
+	// reach ReceiveFrom or port handlers. Each worker takes up to BatchSize queued
+	// envelopes and checks their Ed25519 signatures with a single batch verification,
+	// falling back to individual verification when the batch fails.
+	VerifyParallel
+
+	// VerifyNone delivers envelopes without checking signatures, offline signature
+	// authorizations or the Datagram2 recipient binding. Results are marked
+	// Authenticated=false. Use it only behind a trusted relay that has already
+	// verified the datagrams; anyone can forge the sender otherwise.
+	VerifyNone
+)
+
+// String returns the mode name.
+func (m VerifyMode) String() string {
+	switch m {
+	case VerifyInline:
+		return "inline"
+	case VerifyParallel:
+		return "parallel"
+	case VerifyNone:
+		return "none"
+	default:
+		return fmt.Sprintf("VerifyMode(%d)", int(m))
+	}
+}
+
+// VerifyPolicy configures signature verification for a DatagramConn.
+// Install it with DatagramConn.SetVerifyPolicy.
+type VerifyPolicy struct {
+	// Mode selects inline, parallel or no verification.
+	Mode VerifyMode
+
+	// Workers is the number of VerifyParallel workers.
+	// Zero means runtime.GOMAXPROCS(0).
+	Workers int
+
+	// BatchSize is the maximum number of envelopes a VerifyParallel worker verifies
+	// at once. Zero means DefaultVerifyBatchSize.
+	BatchSize int
+}
+
+// VerifyStats reports signature verification activity on a DatagramConn.
+type VerifyStats struct {
+	// Verified counts Datagram1/Datagram2 envelopes whose signatures verified.
+	Verified uint64
+
+	// Failed counts envelopes rejected because a signature did not verify.
+	Failed uint64
+
+	// Unverified counts envelopes delivered under VerifyNone.
+	Unverified uint64
+
+	// Batches counts Ed25519 batch verifications of two or more signatures.
+	Batches uint64
+
+	// BatchFallbacks counts batches that failed and were verified individually.
+	BatchFallbacks uint64
+
+	// DestinationCacheHits and DestinationCacheMisses count sender destination
+	// lookups in the destination cache.
+	DestinationCacheHits   uint64
+	DestinationCacheMisses uint64
+}
+
+// verifyCounters holds the atomic counters behind VerifyStats.
+type verifyCounters struct {
+	verified       atomic.Uint64
+	failed         atomic.Uint64
+	unverified     atomic.Uint64
+	batches        atomic.Uint64
+	batchFallbacks atomic.Uint64
+}
+
+// signatureCheck is one signature that must verify for an envelope to be accepted.
+type signatureCheck struct {
+	sigType   uint16
+	publicKey []byte
+	message   []byte
+	signature []byte
+
+	// failure is the error reported when the signature does not verify.
+	failure error
+}
+
+// verify checks the signature on its own.
+func (c *signatureCheck) verify() bool {
+	return verifySignatureForSigType(c.sigType, c.publicKey, c.message, c.signature)
+}
+
+// SetVerifyPolicy changes how Datagram1 and Datagram2 signatures are checked.
+// The policy applies to datagrams received after the call.
+//
+// Switching to VerifyParallel starts policy.Workers worker goroutines, which stop
+// when the policy changes again or the connection is closed. Envelopes already
+// queued for the old workers are still verified by them.
+//
+// Example:
+//
+//	err := conn.SetVerifyPolicy(datagrams.VerifyPolicy{Mode: datagrams.VerifyParallel})
+//
+// Returns an error if the mode is unknown or the connection is closed.
+func (d *DatagramConn) SetVerifyPolicy(policy VerifyPolicy) error {
+	switch policy.Mode {
+	case VerifyInline, VerifyParallel, VerifyNone:
+	default:
+		return fmt.Errorf("unknown verify mode: %s", policy.Mode)
+	}
+	if policy.Workers <= 0 {
+		policy.Workers = runtime.GOMAXPROCS(0)
+	}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = DefaultVerifyBatchSize
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return net.ErrClosed
+	}
+
+	if d.verifyCancel != nil {
+		d.verifyCancel()
+		d.verifyCancel = nil
+	}
+	d.verifyPolicy = policy
+	if policy.Mode == VerifyParallel {
+		ctx, cancel := context.WithCancel(d.ctx)
+		d.verifyCancel = cancel
+		for i := 0; i < policy.Workers; i++ {
+			d.wg.Add(1)
+			go d.verifyWorker(ctx, policy.BatchSize)
+		}
+	}
+	return nil
+}
+
+// VerifyPolicy returns the current verification policy, with defaults filled in.
+func (d *DatagramConn) VerifyPolicy() VerifyPolicy {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.verifyPolicy
+}
+
+// SetDestinationCacheSize sets how many sender destinations are cached so repeat
+// Datagram1/Datagram2 senders skip re-parsing. The default is
+// DefaultDestinationCacheSize; zero or a negative size disables the cache.
+// Cached destinations are discarded.
+func (d *DatagramConn) SetDestinationCacheSize(size int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.destCache = newDestinationCache(size)
+}
+
+// VerifyStats returns signature verification and destination cache counters.
+func (d *DatagramConn) VerifyStats() VerifyStats {
+	d.mu.RLock()
+	cache := d.destCache
+	d.mu.RUnlock()
+
+	hits, misses := cache.stats()
+	return VerifyStats{
+		Verified:               d.verifyCounters.verified.Load(),
+		Failed:                 d.verifyCounters.failed.Load(),
+		Unverified:             d.verifyCounters.unverified.Load(),
+		Batches:                d.verifyCounters.batches.Load(),
+		BatchFallbacks:         d.verifyCounters.batchFallbacks.Load(),
+		DestinationCacheHits:   hits,
+		DestinationCacheMisses: misses,
+	}
+}
+
+// verifyWorker verifies batches of queued datagrams and forwards them, with their
+// results attached, to recvQueue. It exits when ctx is canceled: immediately if the
+// connection closed, otherwise after draining verifyQueue.
+func (d *DatagramConn) verifyWorker(ctx context.Context, batchSize int) {
+	defer d.wg.Done()
+
+	batch := make([]*receivedDatagram, 0, batchSize)
+	for {
+		var msg *receivedDatagram
+		select {
+		case msg = <-d.verifyQueue:
+		case <-ctx.Done():
+			if d.ctx.Err() != nil {
+				return
+			}
+			select {
+			case msg = <-d.verifyQueue:
+			default:
+				return
+			}
+		}
+
+		batch = append(batch[:0], msg)
+	fill:
+		for len(batch) < batchSize {
+			select {
+			case msg := <-d.verifyQueue:
+				batch = append(batch, msg)
+			default:
+				break fill
+			}
+		}
+
+		d.processBatch(batch)
+		for _, msg := range batch {
+			select {
+			case d.recvQueue <- msg:
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// processBatch runs a batch of datagrams through the receive pipeline, verifying all
+// their signatures together, and stores each outcome on its datagram.
+func (d *DatagramConn) processBatch(batch []*receivedDatagram) {
+	envs := make([]*Envelope, len(batch))
+	var checks []*signatureCheck
+	var owners []int
+	for i, msg := range batch {
+		protocol, err := d.receiveProtocol(msg)
+		if err != nil || (protocol != ProtocolDatagram1 && protocol != ProtocolDatagram2) {
+			msg.result, msg.err = d.processReceived(msg)
+			msg.processed = true
+			continue
+		}
+		env, msgChecks, err := d.unmarshalAuthenticated(msg.payload, protocol, true)
+		if err != nil {
+			msg.err = fmt.Errorf("failed to parse %s envelope: %w", datagramName(protocol), err)
+			msg.processed = true
+			continue
+		}
+		envs[i] = env
+		for j := range msgChecks {
+			checks = append(checks, &msgChecks[j])
+			owners = append(owners, i)
+		}
+	}
+
+	// Checks of one envelope are in Verify order, so the first failure is reported
+	failures := make([]error, len(batch))
+	for n, ok := range d.verifySignatures(checks) {
+		if !ok && failures[owners[n]] == nil {
+			failures[owners[n]] = checks[n].failure
+		}
+	}
+
+	for i, msg := range batch {
+		if msg.processed {
+			continue
+		}
+		protocol := msg.protocol
+		if failures[i] != nil {
+			d.verifyCounters.failed.Add(1)
+			msg.err = fmt.Errorf("failed to parse %s envelope: %w", datagramName(protocol), failures[i])
+		} else {
+			d.verifyCounters.verified.Add(1)
+			msg.result, msg.err = d.authenticatedResult(msg, envs[i], protocol, true)
+		}
+		msg.processed = true
+	}
+}
+
+// unmarshalAuthenticated decodes a Datagram1/Datagram2 envelope through the
+// destination cache. When verify is set it also returns the signatures to check,
+// bound to the local destination for Datagram2.
+func (d *DatagramConn) unmarshalAuthenticated(data []byte, protocol uint8, verify bool) (*Envelope, []signatureCheck, error) {
+	d.mu.RLock()
+	cache := d.destCache
+	limits := d.optionsLimits
+	d.mu.RUnlock()
+
+	var env *Envelope
+	var err error
+	var targetHash [32]byte
+	if protocol == ProtocolDatagram1 {
+		env, err = unmarshalDatagram1(data, cache.parse)
+	} else {
+		env, err = unmarshalDatagram2(data, cache.parse, limits)
+		targetHash = d.localWire.hash()
+	}
+	if err != nil || !verify {
+		return env, nil, err
+	}
+	checks, err := env.signatureChecks(targetHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return env, checks, nil
+}
+
+// verifySignatures verifies checks and reports which succeeded. Ed25519 signatures
+// are batch verified; other sigtypes are verified individually.
+func (d *DatagramConn) verifySignatures(checks []*signatureCheck) []bool {
+	results := make([]bool, len(checks))
+	var ed25519Checks []*signatureCheck
+	var ed25519Index []int
+	for i, c := range checks {
+		if c.sigType == SigTypeEd25519 {
+			ed25519Checks = append(ed25519Checks, c)
+			ed25519Index = append(ed25519Index, i)
+			continue
+		}
+		results[i] = c.verify()
+	}
+
+	if len(ed25519Checks) > 1 {
+		d.verifyCounters.batches.Add(1)
+	}
+	batchResults, fellBack := verifyEd25519Batch(ed25519Checks)
+	if fellBack {
+		d.verifyCounters.batchFallbacks.Add(1)
+	}
+	for n, ok := range batchResults {
+		results[ed25519Index[n]] = ok
+	}
+	return results
+}
+
+// verifyEd25519Batch verifies Ed25519 signatures with one multi-scalar
+// multiplication, checking
+//
+//	[8](Σ z_i·R_i + Σ (z_i·k_i)·A_i − (Σ z_i·s_i)·B) = 0
+//
+// for random 128-bit z_i. If the batch equation fails, every signature is verified
+// individually to find the bad ones, and fellBack is true.
+//
+// Signatures that the individual check rejects for encoding reasons (invalid public
+// key, non-canonical R or s) are rejected before batching. The batch equation is
+// cofactored, so it can accept a signature that crypto/ed25519 rejects only when
+// the signer deliberately adds small-order components to its own key or nonce;
+// third parties cannot produce such signatures.
+func verifyEd25519Batch(checks []*signatureCheck) (results []bool, fellBack bool) {
+	results = make([]bool, len(checks))
+	if len(checks) < 2 {
+		for i, c := range checks {
+			results[i] = c.verify()
+		}
+		return results, false
+	}
+
+	scalars := make([]*edwards25519.Scalar, 0, 2*len(checks)+1)
+	points := make([]*edwards25519.Point, 0, 2*len(checks)+1)
+	sSum := edwards25519.NewScalar()
+	batched := make([]int, 0, len(checks))
+	for i, c := range checks {
+		terms, ok := ed25519BatchTerms(c)
+		if !ok {
+			continue // results[i] stays false
+		}
+		sSum.MultiplyAdd(terms.z, terms.s, sSum)
+		scalars = append(scalars, terms.z, new(edwards25519.Scalar).Multiply(terms.z, terms.k))
+		points = append(points, terms.r, terms.a)
+		batched = append(batched, i)
+	}
+	if len(batched) == 0 {
+		return results, false
+	}
+
+	scalars = append(scalars, new(edwards25519.Scalar).Negate(sSum))
+	points = append(points, edwards25519.NewGeneratorPoint())
+	sum := new(edwards25519.Point).VarTimeMultiScalarMult(scalars, points)
+	if sum.MultByCofactor(sum).Equal(edwards25519.NewIdentityPoint()) == 1 {
+		for _, i := range batched {
+			results[i] = true
+		}
+		return results, false
+	}
+
+	for _, i := range batched {
+		results[i] = checks[i].verify()
+	}
+	return results, true
+}
+
+// ed25519Terms are the decoded values of one signature in a batch.
+type ed25519Terms struct {
+	a, r    *edwards25519.Point
+	s, k, z *edwards25519.Scalar
+}
+
+// ed25519BatchTerms decodes a signature for batch verification. Returns false if
+// crypto/ed25519 would reject it for its encoding alone.
+func ed25519BatchTerms(c *signatureCheck) (ed25519Terms, bool) {
+	if len(c.publicKey) != 32 || len(c.signature) != Ed25519SignatureLength {
+		return ed25519Terms{}, false
+	}
+	a, err := new(edwards25519.Point).SetBytes(c.publicKey)
+	if err != nil {
+		return ed25519Terms{}, false
+	}
+	r, err := new(edwards25519.Point).SetBytes(c.signature[:32])
+	if err != nil || !bytes.Equal(r.Bytes(), c.signature[:32]) {
+		return ed25519Terms{}, false
+	}
+	s, err := new(edwards25519.Scalar).SetCanonicalBytes(c.signature[32:])
+	if err != nil {
+		return ed25519Terms{}, false
+	}
+
+	h := sha512.New()
+	h.Write(c.signature[:32])
+	h.Write(c.publicKey)
+	h.Write(c.message)
+	k, err := new(edwards25519.Scalar).SetUniformBytes(h.Sum(nil))
+	if err != nil {
+		return ed25519Terms{}, false
+	}
+
+	var zBytes [32]byte
+	rand.Read(zBytes[:16]) // never fails; a 128-bit value is a canonical scalar
+	z, err := new(edwards25519.Scalar).SetCanonicalBytes(zBytes[:])
+	if err != nil {
+		return ed25519Terms{}, false
+	}
+	return ed25519Terms{a: a, r: r, s: s, k: k, z: z}, true
+}
+
+// datagramName returns the name used in error messages for an authenticated protocol.
+func datagramName(protocol uint8) string {
+	if protocol == ProtocolDatagram1 {
+		return "Datagram1"
+	}
+	return "Datagram2"
+}
@@ -0,0 +1,257 @@
+package datagrams
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// ed25519Checks returns n valid Ed25519 signature checks from distinct keys.
+func ed25519Checks(t *testing.T, n int) []*signatureCheck {
+	t.Helper()
+	checks := make([]*signatureCheck, n)
+	for i := range checks {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatalf("GenerateKey() failed: %v", err)
+		}
+		msg := []byte{byte(i), 'm', 's', 'g'}
+		checks[i] = &signatureCheck{
+			sigType:   SigTypeEd25519,
+			publicKey: pub,
+			message:   msg,
+			signature: ed25519.Sign(priv, msg),
+			failure:   errDatagram1Signature,
+		}
+	}
+	return checks
+}
+
+// TestVerifyEd25519Batch tests batch verification against individual verification.
+func TestVerifyEd25519Batch(t *testing.T) {
+	checks := ed25519Checks(t, 8)
+
+	results, fellBack := verifyEd25519Batch(checks)
+	if fellBack {
+		t.Error("valid batch should not fall back")
+	}
+	for i, ok := range results {
+		if !ok {
+			t.Errorf("signature %d rejected", i)
+		}
+	}
+
+	// A bad signature fails the batch and is found individually
+	checks[3].message = []byte("tampered")
+	results, fellBack = verifyEd25519Batch(checks)
+	if !fellBack {
+		t.Error("batch with a bad signature should fall back")
+	}
+	for i, ok := range results {
+		if ok != (i != 3) {
+			t.Errorf("signature %d: ok = %v", i, ok)
+		}
+	}
+
+	// Encodings crypto/ed25519 rejects are excluded before batching
+	checks = ed25519Checks(t, 4)
+	nonCanonical := append([]byte(nil), checks[1].signature...)
+	for i := 32; i < 64; i++ {
+		nonCanonical[i] = 0xFF
+	}
+	checks[1].signature = nonCanonical
+	checks[2].publicKey = make([]byte, 31)
+	results, fellBack = verifyEd25519Batch(checks)
+	if fellBack {
+		t.Error("encoding rejects should not cause a fallback")
+	}
+	want := []bool{true, false, false, true}
+	for i, ok := range results {
+		if ok != want[i] {
+			t.Errorf("signature %d: ok = %v, want %v", i, ok, want[i])
+		}
+		if ok != checks[i].verify() {
+			t.Errorf("signature %d: batch result differs from individual verification", i)
+		}
+	}
+}
+
+// verifyTestConns returns a Datagram2 receiver and a function building envelopes
+// from a sender to it.
+func verifyTestConns(t *testing.T) (*DatagramConn, func(payload string) []byte) {
+	t.Helper()
+	sender := newMockSession()
+	receiver := newMockSession()
+	conn, err := NewDatagramConnWithProtocol(receiver, 8080, ProtocolDatagram2)
+	if err != nil {
+		t.Fatalf("NewDatagramConnWithProtocol() failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	target := targetHashOf(t, receiver)
+	build := func(payload string) []byte {
+		envelope, err := buildDatagram2EnvelopeWithOptions([]byte(payload), sender, target, nil)
+		if err != nil {
+			t.Fatalf("buildDatagram2EnvelopeWithOptions() failed: %v", err)
+		}
+		return envelope
+	}
+	return conn, build
+}
+
+// TestDatagramConn_VerifyInline tests the default policy and destination cache.
+func TestDatagramConn_VerifyInline(t *testing.T) {
+	conn, build := verifyTestConns(t)
+	if conn.VerifyPolicy().Mode != VerifyInline {
+		t.Errorf("default mode = %s, want inline", conn.VerifyPolicy().Mode)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn.injectMessage(build("hello"), nil, ProtocolDatagram2, 9000, 8080)
+		result, err := conn.ReceiveFromWithOptions()
+		if err != nil {
+			t.Fatalf("ReceiveFromWithOptions() failed: %v", err)
+		}
+		if !result.Authenticated || result.From == nil {
+			t.Errorf("Authenticated = %v, From = %v", result.Authenticated, result.From)
+		}
+	}
+
+	stats := conn.VerifyStats()
+	if stats.Verified != 3 || stats.DestinationCacheMisses != 1 || stats.DestinationCacheHits != 2 {
+		t.Errorf("stats = %+v, want 3 verified, 1 miss, 2 hits", stats)
+	}
+
+	conn.SetDestinationCacheSize(0)
+	conn.injectMessage(build("uncached"), nil, ProtocolDatagram2, 9000, 8080)
+	if _, err := conn.ReceiveFromWithOptions(); err != nil {
+		t.Fatalf("ReceiveFromWithOptions() without cache failed: %v", err)
+	}
+	if stats := conn.VerifyStats(); stats.DestinationCacheHits != 0 || stats.DestinationCacheMisses != 0 {
+		t.Errorf("disabled cache stats = %+v", stats)
+	}
+}
+
+// TestDatagramConn_VerifyParallel tests that the worker pool verifies and forwards datagrams.
+func TestDatagramConn_VerifyParallel(t *testing.T) {
+	conn, build := verifyTestConns(t)
+	if err := conn.SetVerifyPolicy(VerifyPolicy{Mode: VerifyParallel, Workers: 2, BatchSize: 4}); err != nil {
+		t.Fatalf("SetVerifyPolicy() failed: %v", err)
+	}
+	if p := conn.VerifyPolicy(); p.Workers != 2 || p.BatchSize != 4 {
+		t.Errorf("VerifyPolicy() = %+v", p)
+	}
+
+	tampered := build("tampered")
+	tampered[len(tampered)-1] ^= 0xFF
+	for i := 0; i < 9; i++ {
+		conn.injectMessage(build("valid"), nil, ProtocolDatagram2, 9000, 8080)
+	}
+	conn.injectMessage(tampered, nil, ProtocolDatagram2, 9000, 8080)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	valid, failed := 0, 0
+	for i := 0; i < 10; i++ {
+		result, err := conn.ReceiveFromWithOptions()
+		switch {
+		case err != nil:
+			failed++
+		case result.Authenticated && string(result.Payload) == "valid":
+			valid++
+		default:
+			t.Errorf("unexpected result: %+v", result)
+		}
+	}
+	if valid != 9 || failed != 1 {
+		t.Errorf("valid = %d, failed = %d, want 9 and 1", valid, failed)
+	}
+	if stats := conn.VerifyStats(); stats.Verified != 9 || stats.Failed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Switching back to inline still delivers datagrams
+	if err := conn.SetVerifyPolicy(VerifyPolicy{Mode: VerifyInline}); err != nil {
+		t.Fatalf("SetVerifyPolicy() failed: %v", err)
+	}
+	conn.injectMessage(build("inline"), nil, ProtocolDatagram2, 9000, 8080)
+	if payload, _, err := conn.ReceiveFromWithAddr(); err != nil || string(payload) != "inline" {
+		t.Errorf("ReceiveFromWithAddr() = %q, %v", payload, err)
+	}
+}
+
+// TestDatagramConn_ProcessBatch tests batch verification of queued datagrams.
+func TestDatagramConn_ProcessBatch(t *testing.T) {
+	conn, build := verifyTestConns(t)
+
+	batch := make([]*receivedDatagram, 5)
+	for i := range batch {
+		batch[i] = &receivedDatagram{payload: build("batched"), protocol: ProtocolDatagram2, srcPort: 9000, destPort: 8080}
+	}
+	batch[2].payload[len(batch[2].payload)-Ed25519SignatureLength-1] ^= 0xFF // payload byte
+	batch[4].payload = []byte("short")
+
+	conn.processBatch(batch)
+	for i, msg := range batch {
+		if !msg.processed {
+			t.Fatalf("datagram %d not processed", i)
+		}
+		if wantErr := i == 2 || i == 4; (msg.err != nil) != wantErr {
+			t.Errorf("datagram %d: err = %v, want error %v", i, msg.err, wantErr)
+		}
+	}
+	if !errors.Is(batch[2].err, errDatagram2Signature) {
+		t.Errorf("tampered datagram error = %v, want signature failure", batch[2].err)
+	}
+
+	// The stored outcome is returned without processing again
+	result, err := conn.processDatagram(batch[0], ProtocolDatagram2)
+	if err != nil || result != batch[0].result {
+		t.Errorf("processDatagram() = %v, %v, want stored result", result, err)
+	}
+
+	stats := conn.VerifyStats()
+	if stats.Batches != 1 || stats.BatchFallbacks != 1 || stats.Verified != 3 || stats.Failed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestDatagramConn_VerifyNone tests unverified delivery.
+func TestDatagramConn_VerifyNone(t *testing.T) {
+	conn, build := verifyTestConns(t)
+	if err := conn.SetVerifyPolicy(VerifyPolicy{Mode: VerifyNone}); err != nil {
+		t.Fatalf("SetVerifyPolicy() failed: %v", err)
+	}
+
+	forged := build("forged")
+	forged[len(forged)-1] ^= 0xFF
+	conn.injectMessage(forged, nil, ProtocolDatagram2, 9000, 8080)
+	result, err := conn.ReceiveFromWithOptions()
+	if err != nil {
+		t.Fatalf("ReceiveFromWithOptions() failed: %v", err)
+	}
+	if result.Authenticated || string(result.Payload) != "forged" {
+		t.Errorf("Authenticated = %v, Payload = %q", result.Authenticated, result.Payload)
+	}
+	if stats := conn.VerifyStats(); stats.Unverified != 1 || stats.Verified != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestDatagramConn_SetVerifyPolicy_Errors tests invalid policies and closed connections.
+func TestDatagramConn_SetVerifyPolicy_Errors(t *testing.T) {
+	conn, _ := verifyTestConns(t)
+	if err := conn.SetVerifyPolicy(VerifyPolicy{Mode: VerifyMode(42)}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+	if err := conn.SetVerifyPolicy(VerifyPolicy{Mode: VerifyParallel}); err != nil {
+		t.Fatalf("SetVerifyPolicy() failed: %v", err)
+	}
+
+	// Close stops the workers
+	conn.Close()
+	if err := conn.SetVerifyPolicy(VerifyPolicy{Mode: VerifyInline}); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("SetVerifyPolicy() after Close error = %v, want net.ErrClosed", err)
+	}
+}